@@ -1,12 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/TOomaAh/GateKeeper/internal/config"
 	"github.com/TOomaAh/GateKeeper/internal/gatekeeper"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const defaultConfigPath = "./config.yaml"
@@ -14,8 +18,14 @@ const defaultConfigPath = "./config.yaml"
 func main() {
 	// Parse command line flags
 	configPath := flag.String("config", defaultConfigPath, "Path to configuration file")
+	hashPassword := flag.Bool("hash-password", false, "Read a password from stdin and print its bcrypt hash for dashboard users")
 	flag.Parse()
 
+	if *hashPassword {
+		printPasswordHash()
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.LoadConfiguration(*configPath)
 	if err != nil {
@@ -36,3 +46,19 @@ func main() {
 
 	os.Exit(0)
 }
+
+// printPasswordHash reads a password on stdin and prints its bcrypt hash
+func printPasswordHash() {
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Fatalf("Failed to read password: %v", err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(strings.TrimRight(password, "\r\n")), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatalf("Failed to hash password: %v", err)
+	}
+
+	fmt.Println(string(hash))
+}
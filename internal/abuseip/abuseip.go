@@ -7,13 +7,29 @@ import (
 	"io"
 	"net"
 	"net/http"
-
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
 	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/reputation"
 )
 
 const (
-	// AbuseIPDBAPIURL is the base URL of the AbuseIPDB API
-	AbuseIPDBAPIURL = "https://api.abuseipdb.com/api/v2/check"
+	// AbuseIPDBBaseURL is the base URL of the AbuseIPDB API
+	AbuseIPDBBaseURL = "https://api.abuseipdb.com/api/v2"
+	// AbuseIPDBAPIURL is the URL of the AbuseIPDB check endpoint
+	AbuseIPDBAPIURL = AbuseIPDBBaseURL + "/check"
+	// DefaultMaxAgeInDays is how far back reports are taken into account
+	DefaultMaxAgeInDays = 30
+	// MaxMaxAgeInDays is the oldest report age accepted by the API
+	MaxMaxAgeInDays = 365
+	// DefaultTimeout is the default timeout of API requests
+	DefaultTimeout = 10 * time.Second
 )
 
 var (
@@ -21,70 +37,158 @@ var (
 	ErrEmptyAPIKey = errors.New("abuseipdb: API key is empty")
 	// ErrInvalidResponse is returned when the API response is invalid
 	ErrInvalidResponse = errors.New("abuseipdb: invalid API response")
+	// ErrInvalidMaxAge is returned when maxAgeInDays is out of range
+	ErrInvalidMaxAge = errors.New("abuseipdb: max_age_in_days must be between 1 and 365")
+	// ErrRateLimited is returned when the quota of the API key is exhausted
+	ErrRateLimited = errors.New("abuseipdb: rate limit exceeded")
 )
 
-// Client manages requests to the AbuseIPDB API
+// Client manages requests to the AbuseIPDB API. Requests are spread
+// over its keys according to the selection strategy.
 type Client struct {
-	apiKey     string
-	httpClient *http.Client
+	keys         []*apiKey
+	selection    string
+	maxAgeInDays int
+	url          string
+	httpClient   *http.Client
+
+	mu    sync.Mutex
+	next  int
+	usage reputation.UsageStore
 }
 
-// Response represents the AbuseIPDB API response
+// Response represents the AbuseIPDB API response, in verbose mode
 type Response struct {
 	Data struct {
-		AbuseConfidenceScore int    `json:"abuseConfidenceScore"`
-		CountryCode          string `json:"countryCode"`
-		IsWhitelisted        bool   `json:"isWhitelisted"`
-		TotalReports         int    `json:"totalReports"`
+		AbuseConfidenceScore int        `json:"abuseConfidenceScore"`
+		CountryCode          string     `json:"countryCode"`
+		IsWhitelisted        bool       `json:"isWhitelisted"`
+		TotalReports         int        `json:"totalReports"`
+		NumDistinctUsers     int        `json:"numDistinctUsers"`
+		LastReportedAt       *time.Time `json:"lastReportedAt"`
+		UsageType            string     `json:"usageType"`
+		ISP                  string     `json:"isp"`
+		Domain               string     `json:"domain"`
+		IsTor                bool       `json:"isTor"`
+		Reports              []Report   `json:"reports"`
 	} `json:"data"`
 }
 
-// NewClient creates a new AbuseIPDB client
-func NewClient(apiKey string) (*Client, error) {
-	if apiKey == "" {
+// Report is a single abuse report, listed in verbose mode
+type Report struct {
+	ReportedAt time.Time `json:"reportedAt"`
+	Categories []int     `json:"categories"`
+}
+
+// NewClient creates a new AbuseIPDB client. Reports older than
+// maxAgeInDays are ignored, 0 means DefaultMaxAgeInDays.
+func NewClient(apiKey string, maxAgeInDays int) (*Client, error) {
+	return NewClientWithKeys([]config.APIKeyConfig{{Key: apiKey}}, config.KeyRoundRobin, maxAgeInDays)
+}
+
+// NewClientWithKeys creates a client using several API keys, picked
+// round-robin or least used first
+func NewClientWithKeys(keys []config.APIKeyConfig, selection string, maxAgeInDays int) (*Client, error) {
+	if len(keys) == 0 {
 		return nil, ErrEmptyAPIKey
 	}
 
-	return &Client{
-		apiKey:     apiKey,
-		httpClient: &http.Client{},
-	}, nil
+	if maxAgeInDays == 0 {
+		maxAgeInDays = DefaultMaxAgeInDays
+	}
+	if maxAgeInDays < 1 || maxAgeInDays > MaxMaxAgeInDays {
+		return nil, ErrInvalidMaxAge
+	}
+
+	c := &Client{
+		selection:    selection,
+		maxAgeInDays: maxAgeInDays,
+		url:          AbuseIPDBBaseURL,
+		httpClient:   &http.Client{Timeout: DefaultTimeout},
+		usage:        newMemoryUsage(),
+	}
+	for _, k := range keys {
+		if k.Key == "" {
+			return nil, ErrEmptyAPIKey
+		}
+		name := k.Name
+		if name == "" {
+			name = keyName(k.Key)
+		}
+		c.keys = append(c.keys, &apiKey{name: name, key: k.Key, dailyLimit: k.DailyLimit})
+	}
+
+	return c, nil
+}
+
+// SetURL changes the base URL of the API
+func (c *Client) SetURL(baseURL string) {
+	c.url = strings.TrimSuffix(baseURL, "/")
 }
 
-// Check verifies the reputation score of an IP address
-func (c *Client) Check(ip string) (domain.IPScore, string, error) {
+// SetTimeout sets the timeout of API requests
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.httpClient.Timeout = timeout
+}
 
+// lookup queries the API for an IP address
+func (c *Client) lookup(ip string) (*Response, error) {
 	if i := net.ParseIP(ip); i.IsPrivate() || i.IsLoopback() {
-		return 0, "", fmt.Errorf("abuseipdb: ip is private")
-	}
-
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s?ipAddress=%s", AbuseIPDBAPIURL, ip), nil)
-	if err != nil {
-		return 0, "", fmt.Errorf("abuseipdb: failed to create request: %w", err)
+		return nil, fmt.Errorf("abuseipdb: ip is private")
 	}
 
-	req.Header.Set("Key", c.apiKey)
-	req.Header.Set("Accept", "application/json")
+	query := url.Values{}
+	query.Set("ipAddress", ip)
+	query.Set("maxAgeInDays", strconv.Itoa(c.maxAgeInDays))
+	query.Set("verbose", "")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(endpointCheck, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, c.url+"/check?"+query.Encode(), nil)
+	})
 	if err != nil {
-		return 0, "", fmt.Errorf("abuseipdb: API request failed: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, "", fmt.Errorf("abuseipdb: API returned status %d", resp.StatusCode)
+		return nil, fmt.Errorf("abuseipdb: API returned status %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, "", fmt.Errorf("abuseipdb: failed to read response: %w", err)
+		return nil, fmt.Errorf("abuseipdb: failed to read response: %w", err)
 	}
 
 	var result Response
 	if err := json.Unmarshal(body, &result); err != nil {
-		return 0, "", fmt.Errorf("abuseipdb: failed to parse response: %w", err)
+		return nil, fmt.Errorf("abuseipdb: failed to parse response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Details extracts the details of an IP from a response
+func (r *Response) Details() *domain.AbuseDetails {
+	details := &domain.AbuseDetails{
+		ISP:           r.Data.ISP,
+		Domain:        r.Data.Domain,
+		UsageType:     r.Data.UsageType,
+		IsTor:         r.Data.IsTor,
+		TotalReports:  r.Data.TotalReports,
+		DistinctUsers: r.Data.NumDistinctUsers,
+	}
+	if r.Data.LastReportedAt != nil {
+		details.LastReportedAt = *r.Data.LastReportedAt
+	}
+
+	for _, report := range r.Data.Reports {
+		for _, category := range report.Categories {
+			if !slices.Contains(details.Categories, category) {
+				details.Categories = append(details.Categories, category)
+			}
+		}
 	}
+	slices.Sort(details.Categories)
 
-	return domain.IPScore(result.Data.AbuseConfidenceScore), result.Data.CountryCode, nil
+	return details
 }
@@ -0,0 +1,294 @@
+package abuseip
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/reputation"
+)
+
+// fakeAPI is an AbuseIPDB server recording the key of each request
+type fakeAPI struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func newFakeAPI(t *testing.T, handler http.HandlerFunc) *fakeAPI {
+	t.Helper()
+
+	f := &fakeAPI{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.keys = append(f.keys, r.Header.Get("Key"))
+		f.mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// requests returns the key of each request received so far
+func (f *fakeAPI) requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return slices.Clone(f.keys)
+}
+
+// newTestClient returns a client of the fake API using the given keys,
+// named after themselves
+func newTestClient(t *testing.T, api *fakeAPI, selection string, keys ...config.APIKeyConfig) *Client {
+	t.Helper()
+
+	for i := range keys {
+		keys[i].Name = keys[i].Key
+	}
+	c, err := NewClientWithKeys(keys, selection, 0)
+	if err != nil {
+		t.Fatalf("NewClientWithKeys() error = %v", err)
+	}
+	c.SetURL(api.URL + "/")
+	return c
+}
+
+func keys(names ...string) []config.APIKeyConfig {
+	keys := make([]config.APIKeyConfig, len(names))
+	for i, name := range names {
+		keys[i] = config.APIKeyConfig{Key: name}
+	}
+	return keys
+}
+
+// writeCheck answers a check request with the given score
+func writeCheck(w http.ResponseWriter, score int) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"data":{"abuseConfidenceScore":%d,"countryCode":"FR"}}`, score)
+}
+
+// writeError answers with an API error, blaming parameter if not empty
+func writeError(w http.ResponseWriter, status int, detail, parameter string) {
+	source := ""
+	if parameter != "" {
+		source = fmt.Sprintf(`,"source":{"parameter":%q}`, parameter)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"errors":[{"detail":%q,"status":%d%s}]}`, detail, status, source)
+}
+
+func TestNewClientWithKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []config.APIKeyConfig
+		maxAge  int
+		wantErr error
+	}{
+		{name: "default max age", keys: keys("a"), maxAge: 0},
+		{name: "max age", keys: keys("a", "b"), maxAge: MaxMaxAgeInDays},
+		{name: "no key", keys: nil, wantErr: ErrEmptyAPIKey},
+		{name: "empty key", keys: keys("a", ""), wantErr: ErrEmptyAPIKey},
+		{name: "negative max age", keys: keys("a"), maxAge: -1, wantErr: ErrInvalidMaxAge},
+		{name: "max age too old", keys: keys("a"), maxAge: MaxMaxAgeInDays + 1, wantErr: ErrInvalidMaxAge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClientWithKeys(tt.keys, config.KeyRoundRobin, tt.maxAge)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewClientWithKeys() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.maxAge == 0 && c.maxAgeInDays != DefaultMaxAgeInDays {
+				t.Errorf("maxAgeInDays = %d, want %d", c.maxAgeInDays, DefaultMaxAgeInDays)
+			}
+			if len(c.keys) != len(tt.keys) {
+				t.Errorf("%d keys, want %d", len(c.keys), len(tt.keys))
+			}
+		})
+	}
+}
+
+func TestKeyName(t *testing.T) {
+	c, err := NewClient("secret", 0)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if name := c.keys[0].name; name == "secret" || len(name) != 8 {
+		t.Errorf("key name = %q, want a short hash", name)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.Method != http.MethodGet || r.URL.Path != "/check" {
+			t.Errorf("request = %s %s, want GET /check", r.Method, r.URL.Path)
+		}
+		if got := query.Get("ipAddress"); got != "203.0.113.7" {
+			t.Errorf("ipAddress = %q", got)
+		}
+		if got := query.Get("maxAgeInDays"); got != "30" {
+			t.Errorf("maxAgeInDays = %q, want 30", got)
+		}
+		if !query.Has("verbose") {
+			t.Error("verbose mode not requested")
+		}
+		if got := r.Header.Get("Accept"); got != "application/json" {
+			t.Errorf("Accept = %q", got)
+		}
+		fmt.Fprint(w, `{"data":{
+			"abuseConfidenceScore": 87,
+			"countryCode": "NL",
+			"isWhitelisted": false,
+			"totalReports": 12,
+			"numDistinctUsers": 4,
+			"lastReportedAt": "2024-05-01T10:00:00+00:00",
+			"usageType": "Data Center/Web Hosting/Transit",
+			"isp": "Example Hosting",
+			"domain": "example.net",
+			"isTor": true,
+			"reports": [
+				{"reportedAt": "2024-05-01T10:00:00+00:00", "categories": [22, 18]},
+				{"reportedAt": "2024-04-30T10:00:00+00:00", "categories": [18, 14]}
+			]
+		}}`)
+	})
+	c := newTestClient(t, api, config.KeyRoundRobin, keys("a")...)
+
+	resp, err := c.lookup("203.0.113.7")
+	if err != nil {
+		t.Fatalf("lookup() error = %v", err)
+	}
+	if got := api.requests(); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("keys = %v, want [a]", got)
+	}
+	if resp.Data.AbuseConfidenceScore != 87 || resp.Data.CountryCode != "NL" {
+		t.Errorf("score = %d, country = %q", resp.Data.AbuseConfidenceScore, resp.Data.CountryCode)
+	}
+
+	details := resp.Details()
+	if details.ISP != "Example Hosting" || details.Domain != "example.net" || !details.IsTor {
+		t.Errorf("details = %+v", details)
+	}
+	if details.TotalReports != 12 || details.DistinctUsers != 4 || details.LastReportedAt.IsZero() {
+		t.Errorf("details = %+v", details)
+	}
+	if want := []int{14, 18, 22}; !slices.Equal(details.Categories, want) {
+		t.Errorf("categories = %v, want %v", details.Categories, want)
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		ip      string
+		handler http.HandlerFunc
+	}{
+		{name: "private", ip: "192.168.1.1"},
+		{name: "loopback", ip: "127.0.0.1"},
+		{
+			name: "server error",
+			ip:   "203.0.113.7",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+		},
+		{
+			name: "invalid body",
+			ip:   "203.0.113.7",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "{")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := tt.handler
+			if handler == nil {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					t.Error("private address sent to the API")
+				}
+			}
+			api := newFakeAPI(t, handler)
+			c := newTestClient(t, api, config.KeyRoundRobin, keys("a")...)
+
+			if _, err := c.lookup(tt.ip); err == nil {
+				t.Error("lookup() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestProvider(t *testing.T) {
+	threshold := func(v int) *int { return &v }
+
+	tests := []struct {
+		name          string
+		score         int
+		threshold     *int
+		wantMalicious bool
+	}{
+		{name: "default threshold", score: config.DefaultPolicyThreshold, wantMalicious: false},
+		{name: "above default threshold", score: config.DefaultPolicyThreshold + 1, wantMalicious: true},
+		{name: "configured threshold", score: 60, threshold: threshold(50), wantMalicious: true},
+		{name: "below configured threshold", score: 40, threshold: threshold(50), wantMalicious: false},
+		{name: "zero threshold", score: 1, threshold: threshold(0), wantMalicious: true},
+		{name: "clean with zero threshold", score: 0, threshold: threshold(0), wantMalicious: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				writeCheck(w, tt.score)
+			})
+			provider, err := reputation.New(&config.ProviderConfig{
+				Name:      "abuseipdb",
+				Type:      "abuseipdb",
+				APIKey:    "a",
+				URL:       api.URL,
+				Threshold: tt.threshold,
+			})
+			if err != nil {
+				t.Fatalf("reputation.New() error = %v", err)
+			}
+
+			result, err := provider.Check("203.0.113.7")
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if result.Score != domain.IPScore(tt.score) || result.Country != "FR" {
+				t.Errorf("result = %+v", result)
+			}
+			if result.Malicious != tt.wantMalicious {
+				t.Errorf("Malicious = %t, want %t", result.Malicious, tt.wantMalicious)
+			}
+		})
+	}
+}
+
+func TestProviderRateLimited(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusTooManyRequests, "Daily rate limit of 1000 requests exceeded for this endpoint.", "")
+	})
+	provider, err := reputation.New(&config.ProviderConfig{Name: "abuseipdb", Type: "abuseipdb", APIKey: "a", URL: api.URL})
+	if err != nil {
+		t.Fatalf("reputation.New() error = %v", err)
+	}
+
+	_, err = provider.Check("203.0.113.7")
+	if !errors.Is(err, reputation.ErrRateLimited) || !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Check() error = %v, want a rate limit", err)
+	}
+}
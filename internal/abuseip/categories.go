@@ -0,0 +1,73 @@
+package abuseip
+
+import "strconv"
+
+// AbuseIPDB report categories, see https://www.abuseipdb.com/categories
+const (
+	CategoryDNSCompromise = 1
+	CategoryDNSPoisoning  = 2
+	CategoryFraudOrders   = 3
+	CategoryDDoSAttack    = 4
+	CategoryFTPBruteForce = 5
+	CategoryPingOfDeath   = 6
+	CategoryPhishing      = 7
+	CategoryFraudVoIP     = 8
+	CategoryOpenProxy     = 9
+	CategoryWebSpam       = 10
+	CategoryEmailSpam     = 11
+	CategoryBlogSpam      = 12
+	CategoryVPNIP         = 13
+	CategoryPortScan      = 14
+	CategoryHacking       = 15
+	CategorySQLInjection  = 16
+	CategorySpoofing      = 17
+	CategoryBruteForce    = 18
+	CategoryBadWebBot     = 19
+	CategoryExploitedHost = 20
+	CategoryWebAppAttack  = 21
+	CategorySSH           = 22
+	CategoryIoTTargeted   = 23
+)
+
+var categoryNames = map[int]string{
+	CategoryDNSCompromise: "DNS Compromise",
+	CategoryDNSPoisoning:  "DNS Poisoning",
+	CategoryFraudOrders:   "Fraud Orders",
+	CategoryDDoSAttack:    "DDoS Attack",
+	CategoryFTPBruteForce: "FTP Brute-Force",
+	CategoryPingOfDeath:   "Ping of Death",
+	CategoryPhishing:      "Phishing",
+	CategoryFraudVoIP:     "Fraud VoIP",
+	CategoryOpenProxy:     "Open Proxy",
+	CategoryWebSpam:       "Web Spam",
+	CategoryEmailSpam:     "Email Spam",
+	CategoryBlogSpam:      "Blog Spam",
+	CategoryVPNIP:         "VPN IP",
+	CategoryPortScan:      "Port Scan",
+	CategoryHacking:       "Hacking",
+	CategorySQLInjection:  "SQL Injection",
+	CategorySpoofing:      "Spoofing",
+	CategoryBruteForce:    "Brute-Force",
+	CategoryBadWebBot:     "Bad Web Bot",
+	CategoryExploitedHost: "Exploited Host",
+	CategoryWebAppAttack:  "Web App Attack",
+	CategorySSH:           "SSH",
+	CategoryIoTTargeted:   "IoT Targeted",
+}
+
+// CategoryName returns the name of a report category
+func CategoryName(id int) string {
+	if name, ok := categoryNames[id]; ok {
+		return name
+	}
+	return "Category " + strconv.Itoa(id)
+}
+
+// CategoryNames returns the names of report categories
+func CategoryNames(ids []int) []string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = CategoryName(id)
+	}
+	return names
+}
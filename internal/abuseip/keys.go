@@ -0,0 +1,265 @@
+package abuseip
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/reputation"
+)
+
+// KeyDisableDuration is how long a key rejected by the API is skipped
+const KeyDisableDuration = 1 * time.Hour
+
+// ErrKeyRejected is returned when every key was rejected by the API
+var ErrKeyRejected = errors.New("abuseipdb: API key rejected")
+
+// apiKey is a key of the pool, with its own quota
+type apiKey struct {
+	name       string
+	key        string
+	dailyLimit int
+	quotas     quotas
+
+	mu            sync.Mutex
+	disabledUntil time.Time
+}
+
+// keyName identifies an unnamed key by a hash, which does not leak it
+func keyName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// usageName returns the name of the lookup counter of the key
+func (k *apiKey) usageName() string {
+	return "abuseipdb_check:" + k.name
+}
+
+// budget returns the daily limit passed to ConsumeUsage
+func (k *apiKey) budget() int {
+	if k.dailyLimit == 0 {
+		return math.MaxInt32
+	}
+	return k.dailyLimit
+}
+
+// available returns an error while the key cannot be used on an endpoint
+func (k *apiKey) available(endpoint string, now time.Time) error {
+	k.mu.Lock()
+	disabledUntil := k.disabledUntil
+	k.mu.Unlock()
+
+	if now.Before(disabledUntil) {
+		return fmt.Errorf("%w: key %s disabled until %s", ErrKeyRejected, k.name, disabledUntil.Format(time.RFC3339))
+	}
+	return k.quotas.wait(endpoint, now)
+}
+
+// disable skips the key for KeyDisableDuration
+func (k *apiKey) disable(now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.disabledUntil = now.Add(KeyDisableDuration)
+	log.Printf("AbuseIPDB key %s rejected, disabled until %s", k.name, k.disabledUntil.Format(time.RFC3339))
+}
+
+// SetUsageStore persists the lookup counters of the keys, so that their
+// daily budgets survive restarts
+func (c *Client) SetUsageStore(store reputation.UsageStore) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.usage = store
+}
+
+func (c *Client) usageStore() reputation.UsageStore {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.usage
+}
+
+// acquire picks the key of the next request, skipping the tried ones.
+// Lookups are counted against the daily budget of the key.
+func (c *Client) acquire(endpoint string, tried map[*apiKey]bool) (*apiKey, error) {
+	now := time.Now()
+	usage := c.usageStore()
+
+	// Rate limits prevail, so that a temporary shortage of quota is not
+	// mistaken for a failure of the API
+	var rejected, limited error
+	for _, key := range c.order(usage, now) {
+		// Tried keys are checked too, so that the error tells why the
+		// last of them failed
+		if err := key.available(endpoint, now); errors.Is(err, ErrKeyRejected) {
+			rejected = err
+			continue
+		} else if err != nil {
+			limited = err
+			continue
+		}
+		if tried[key] {
+			continue
+		}
+
+		if endpoint == endpointCheck {
+			ok, usageErr := usage.ConsumeUsage(key.usageName(), now, key.budget())
+			if usageErr != nil {
+				// Budgets are best effort: a database error must not stop lookups
+				log.Printf("Failed to count usage of AbuseIPDB key %s: %v", key.name, usageErr)
+			} else if !ok {
+				limited = fmt.Errorf("%w: daily budget of key %s spent", ErrRateLimited, key.name)
+				continue
+			}
+		}
+
+		return key, nil
+	}
+
+	switch {
+	case limited != nil:
+		return nil, limited
+	case rejected != nil:
+		return nil, rejected
+	default:
+		return nil, ErrRateLimited
+	}
+}
+
+// order returns the keys in the order they should be tried
+func (c *Client) order(usage reputation.UsageStore, now time.Time) []*apiKey {
+	if len(c.keys) == 1 {
+		return c.keys
+	}
+
+	if c.selection == config.KeyLeastUsed {
+		keys := slices.Clone(c.keys)
+		used := make(map[*apiKey]int, len(keys))
+		for _, key := range keys {
+			used[key], _ = usage.GetUsage(key.usageName(), now)
+		}
+		slices.SortStableFunc(keys, func(a, b *apiKey) int { return used[a] - used[b] })
+		return keys
+	}
+
+	c.mu.Lock()
+	start := c.next % len(c.keys)
+	c.next++
+	c.mu.Unlock()
+
+	return slices.Concat(c.keys[start:], c.keys[:start])
+}
+
+// send sends a request with an available key, failing over to the next
+// key when one is rejected (401) or rate limited (429). newRequest builds
+// a fresh request for each attempt.
+func (c *Client) send(endpoint string, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	tried := make(map[*apiKey]bool, len(c.keys))
+	for {
+		key, err := c.acquire(endpoint, tried)
+		if err != nil {
+			return nil, err
+		}
+		tried[key] = true
+
+		req, err := newRequest()
+		if err != nil {
+			return nil, fmt.Errorf("abuseipdb: failed to create request: %w", err)
+		}
+		req.Header.Set("Key", key.key)
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("abuseipdb: %s request failed: %w", endpoint, err)
+		}
+		key.quotas.update(endpoint, resp.Header)
+
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			resp.Body.Close()
+			key.disable(time.Now())
+			continue
+		case http.StatusTooManyRequests:
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			// The same status is used for the per-IP report cooldown
+			if parseAPIError(body).isCooldown(endpoint) {
+				return nil, ErrReportCooldown
+			}
+			key.quotas.backoff(endpoint, key.name, resp.Header, time.Now())
+			continue
+		case http.StatusOK:
+			key.quotas.succeed(endpoint)
+		}
+
+		return resp, nil
+	}
+}
+
+// Quotas returns the lookup quota and usage of each key
+func (c *Client) Quotas() []reputation.Quota {
+	now := time.Now()
+	usage := c.usageStore()
+
+	quotas := make([]reputation.Quota, len(c.keys))
+	for i, key := range c.keys {
+		quota := key.quotas.quota(endpointCheck, now)
+		quota.Key = key.name
+		quota.Budget = key.dailyLimit
+		quota.Used, _ = usage.GetUsage(key.usageName(), now)
+		if quota.Budget > 0 && quota.Used >= quota.Budget {
+			quota.Exhausted = true
+		}
+
+		key.mu.Lock()
+		if now.Before(key.disabledUntil) {
+			quota.DisabledUntil = key.disabledUntil
+			quota.Exhausted = true
+		}
+		key.mu.Unlock()
+
+		quotas[i] = quota
+	}
+	return quotas
+}
+
+// memoryUsage counts usage in memory until a UsageStore is set
+type memoryUsage struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newMemoryUsage() *memoryUsage {
+	return &memoryUsage{counts: make(map[string]int)}
+}
+
+func (m *memoryUsage) ConsumeUsage(name string, t time.Time, limit int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := name + "/" + t.UTC().Format(time.DateOnly)
+	if m.counts[key] >= limit {
+		return false, nil
+	}
+	m.counts[key]++
+	return true, nil
+}
+
+func (m *memoryUsage) GetUsage(name string, t time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counts[name+"/"+t.UTC().Format(time.DateOnly)], nil
+}
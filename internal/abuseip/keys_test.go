@@ -0,0 +1,236 @@
+package abuseip
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+// failingUsage is a UsageStore whose database is unavailable
+type failingUsage struct{}
+
+func (failingUsage) ConsumeUsage(string, time.Time, int) (bool, error) {
+	return false, errors.New("database is locked")
+}
+
+func (failingUsage) GetUsage(string, time.Time) (int, error) {
+	return 0, errors.New("database is locked")
+}
+
+// statusByKey answers with the status of the key of the request, and
+// with a clean check result for the others
+func statusByKey(statuses map[string]int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch status := statuses[r.Header.Get("Key")]; status {
+		case 0:
+			writeCheck(w, 0)
+		case http.StatusTooManyRequests:
+			writeError(w, status, "Daily rate limit of 1000 requests exceeded for this endpoint.", "")
+		default:
+			writeError(w, status, "Authentication failed. Your API key is either missing, incorrect, or revoked.", "")
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses map[string]int
+		// lookups is the number of successful lookups
+		lookups int
+		want    []string
+		wantErr error
+		check   func(t *testing.T, c *Client)
+	}{
+		{
+			name:     "rejected key",
+			statuses: map[string]int{"a": http.StatusUnauthorized},
+			lookups:  3,
+			// The rejected key is skipped once disabled
+			want: []string{"a", "b", "b", "b"},
+			check: func(t *testing.T, c *Client) {
+				quota := c.Quotas()[0]
+				if quota.DisabledUntil.Before(time.Now().Add(KeyDisableDuration-time.Minute)) || !quota.Exhausted {
+					t.Errorf("quota = %+v, want the key disabled", quota)
+				}
+			},
+		},
+		{
+			name:     "rate limited key",
+			statuses: map[string]int{"a": http.StatusTooManyRequests},
+			lookups:  3,
+			want:     []string{"a", "b", "b", "b"},
+			check: func(t *testing.T, c *Client) {
+				quota := c.Quotas()[0]
+				if quota.RetryAt.Before(time.Now()) || quota.RetryAt.After(time.Now().Add(MinBackoff)) || !quota.Exhausted {
+					t.Errorf("quota = %+v, want the key backing off", quota)
+				}
+				if !c.Quotas()[1].RetryAt.IsZero() {
+					t.Error("healthy key backing off")
+				}
+			},
+		},
+		{
+			name:     "every key rejected",
+			statuses: map[string]int{"a": http.StatusUnauthorized, "b": http.StatusUnauthorized},
+			want:     []string{"a", "b"},
+			wantErr:  ErrKeyRejected,
+		},
+		{
+			name:     "every key rate limited",
+			statuses: map[string]int{"a": http.StatusTooManyRequests, "b": http.StatusTooManyRequests},
+			want:     []string{"a", "b"},
+			wantErr:  ErrRateLimited,
+		},
+		{
+			// A shortage of quota is temporary, unlike a rejected key
+			name:     "rate limits prevail",
+			statuses: map[string]int{"a": http.StatusUnauthorized, "b": http.StatusTooManyRequests},
+			want:     []string{"a", "b"},
+			wantErr:  ErrRateLimited,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, statusByKey(tt.statuses))
+			c := newTestClient(t, api, config.KeyRoundRobin, keys("a", "b")...)
+
+			for range tt.lookups {
+				if _, err := c.lookup("203.0.113.7"); err != nil {
+					t.Fatalf("lookup() error = %v", err)
+				}
+			}
+			if tt.wantErr != nil {
+				for range 2 {
+					if _, err := c.lookup("203.0.113.7"); !errors.Is(err, tt.wantErr) {
+						t.Fatalf("lookup() error = %v, want %v", err, tt.wantErr)
+					}
+				}
+			}
+
+			if got := api.requests(); !slices.Equal(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+			if tt.check != nil {
+				tt.check(t, c)
+			}
+		})
+	}
+}
+
+func TestKeySelection(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection string
+		used      map[string]int
+		want      []string
+	}{
+		{
+			name:      "round robin",
+			selection: config.KeyRoundRobin,
+			want:      []string{"a", "b", "c", "a", "b", "c"},
+		},
+		{
+			name:      "least used",
+			selection: config.KeyLeastUsed,
+			used:      map[string]int{"a": 2, "c": 1},
+			// Ties are broken in the configured order
+			want: []string{"b", "b", "c", "a", "b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, statusByKey(nil))
+			c := newTestClient(t, api, tt.selection, keys("a", "b", "c")...)
+
+			usage := newMemoryUsage()
+			for name, used := range tt.used {
+				for range used {
+					usage.ConsumeUsage("abuseipdb_check:"+name, time.Now(), used)
+				}
+			}
+			c.SetUsageStore(usage)
+
+			for range tt.want {
+				if _, err := c.lookup("203.0.113.7"); err != nil {
+					t.Fatalf("lookup() error = %v", err)
+				}
+			}
+			if got := api.requests(); !slices.Equal(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDailyBudget(t *testing.T) {
+	api := newFakeAPI(t, statusByKey(nil))
+	store := newMemoryUsage()
+
+	c := newTestClient(t, api, config.KeyRoundRobin, config.APIKeyConfig{Key: "a", DailyLimit: 2})
+	c.SetUsageStore(store)
+	for range 2 {
+		if _, err := c.lookup("203.0.113.7"); err != nil {
+			t.Fatalf("lookup() error = %v", err)
+		}
+	}
+	if _, err := c.lookup("203.0.113.7"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("lookup() error = %v, want ErrRateLimited", err)
+	}
+
+	quota := c.Quotas()[0]
+	if quota.Used != 2 || quota.Budget != 2 || !quota.Exhausted {
+		t.Errorf("quota = %+v, want the budget spent", quota)
+	}
+
+	// The budget is persisted, so a restart does not reset it
+	restarted := newTestClient(t, api, config.KeyRoundRobin, config.APIKeyConfig{Key: "a", DailyLimit: 2})
+	restarted.SetUsageStore(store)
+	if _, err := restarted.lookup("203.0.113.7"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("lookup() after a restart error = %v, want ErrRateLimited", err)
+	}
+	if got := len(api.requests()); got != 2 {
+		t.Errorf("%d requests, want 2", got)
+	}
+
+	// Reports do not count against the lookup budget
+	if err := restarted.CanReport(); err != nil {
+		t.Errorf("CanReport() error = %v", err)
+	}
+}
+
+func TestDailyBudgetFailover(t *testing.T) {
+	api := newFakeAPI(t, statusByKey(nil))
+	c := newTestClient(t, api, config.KeyRoundRobin,
+		config.APIKeyConfig{Key: "a", DailyLimit: 1},
+		config.APIKeyConfig{Key: "b"},
+	)
+
+	for range 3 {
+		if _, err := c.lookup("203.0.113.7"); err != nil {
+			t.Fatalf("lookup() error = %v", err)
+		}
+	}
+	if got, want := api.requests(), []string{"a", "b", "b"}; !slices.Equal(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
+
+func TestUsageStoreFailure(t *testing.T) {
+	api := newFakeAPI(t, statusByKey(nil))
+	c := newTestClient(t, api, config.KeyRoundRobin, config.APIKeyConfig{Key: "a", DailyLimit: 1})
+	c.SetUsageStore(failingUsage{})
+
+	// Budgets are best effort, lookups go on without the database
+	for range 2 {
+		if _, err := c.lookup("203.0.113.7"); err != nil {
+			t.Fatalf("lookup() error = %v", err)
+		}
+	}
+}
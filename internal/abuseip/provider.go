@@ -0,0 +1,77 @@
+package abuseip
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/reputation"
+)
+
+func init() {
+	reputation.Register("abuseipdb", func(cfg *config.ProviderConfig) (reputation.Provider, error) {
+		keys := cfg.APIKeys
+		if len(keys) == 0 {
+			keys = []config.APIKeyConfig{{Key: cfg.APIKey}}
+		}
+
+		client, err := NewClientWithKeys(keys, cfg.KeySelection, cfg.MaxAgeInDays)
+		if err != nil {
+			return nil, err
+		}
+		client.SetTimeout(cfg.Timeout)
+		if cfg.URL != "" {
+			client.SetURL(cfg.URL)
+		}
+		return &Provider{
+			name:      cfg.Name,
+			client:    client,
+			threshold: domain.IPScore(cfg.MaliciousThreshold()),
+		}, nil
+	})
+}
+
+// Provider exposes the AbuseIPDB client as a reputation provider
+type Provider struct {
+	name      string
+	client    *Client
+	threshold domain.IPScore
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) Quotas() []reputation.Quota {
+	return p.client.Quotas()
+}
+
+func (p *Provider) SetUsageStore(store reputation.UsageStore) {
+	p.client.SetUsageStore(store)
+}
+
+func (p *Provider) Check(ip string) (domain.ReputationResult, error) {
+	resp, err := p.client.lookup(ip)
+	if errors.Is(err, ErrRateLimited) {
+		return domain.ReputationResult{}, fmt.Errorf("%w: %w", reputation.ErrRateLimited, err)
+	}
+	if err != nil {
+		return domain.ReputationResult{}, err
+	}
+
+	score := domain.IPScore(resp.Data.AbuseConfidenceScore)
+	details := fmt.Sprintf("%d report(s) from %d user(s)", resp.Data.TotalReports, resp.Data.NumDistinctUsers)
+	if resp.Data.IsWhitelisted {
+		details += ", whitelisted"
+	}
+
+	return domain.ReputationResult{
+		Score:       score,
+		Malicious:   score > p.threshold,
+		Country:     resp.Data.CountryCode,
+		Details:     details,
+		Whitelisted: resp.Data.IsWhitelisted,
+		Abuse:       resp.Details(),
+	}, nil
+}
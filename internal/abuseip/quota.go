@@ -0,0 +1,151 @@
+package abuseip
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/reputation"
+)
+
+const (
+	// MinBackoff is the first delay after a 429 response without Retry-After
+	MinBackoff = 1 * time.Minute
+	// MaxBackoff caps the delay between retries after 429 responses
+	MaxBackoff = 1 * time.Hour
+)
+
+// API endpoints, which have separate quotas
+const (
+	endpointCheck  = "check"
+	endpointReport = "report"
+)
+
+// quotas tracks the rate limit of each endpoint from the X-RateLimit
+// response headers, and the backoff after 429 responses
+type quotas struct {
+	mu        sync.Mutex
+	endpoints map[string]*quotaState
+}
+
+type quotaState struct {
+	limit     int
+	remaining int
+	resetAt   time.Time
+	retryAt   time.Time
+	backoffs  int
+}
+
+func (q *quotas) state(endpoint string) *quotaState {
+	if q.endpoints == nil {
+		q.endpoints = make(map[string]*quotaState)
+	}
+	s, ok := q.endpoints[endpoint]
+	if !ok {
+		s = &quotaState{remaining: -1}
+		q.endpoints[endpoint] = s
+	}
+	return s
+}
+
+// wait returns ErrRateLimited while the quota of an endpoint is exhausted
+// or a backoff is running, so that no request is wasted
+func (q *quotas) wait(endpoint string, now time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := q.state(endpoint)
+	if now.Before(s.retryAt) {
+		return fmt.Errorf("%w, retrying after %s", ErrRateLimited, s.retryAt.Format(time.RFC3339))
+	}
+	if s.remaining == 0 && now.Before(s.resetAt) {
+		return fmt.Errorf("%w, quota resets at %s", ErrRateLimited, s.resetAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// update records the quota reported by a response
+func (q *quotas) update(endpoint string, header http.Header) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := q.state(endpoint)
+	if limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit")); err == nil {
+		s.limit = limit
+	}
+	if remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining")); err == nil {
+		s.remaining = remaining
+	}
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		s.resetAt = time.Unix(reset, 0)
+	}
+}
+
+// succeed ends the backoff of an endpoint
+func (q *quotas) succeed(endpoint string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.state(endpoint).backoffs = 0
+}
+
+// backoff delays the next requests to an endpoint after a 429 response,
+// by Retry-After if present and exponentially otherwise
+func (q *quotas) backoff(endpoint, key string, header http.Header, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := q.state(endpoint)
+	s.backoffs++
+	s.remaining = 0
+
+	delay, ok := retryAfter(header, now)
+	if !ok {
+		s.retryAt = now.Add(min(MinBackoff<<(s.backoffs-1), MaxBackoff))
+	} else {
+		// Retry-After prevails over the reset time of the quota
+		s.retryAt = now.Add(delay)
+		s.resetAt = s.retryAt
+	}
+
+	log.Printf("AbuseIPDB %s quota of key %s exhausted, backing off until %s", endpoint, key, s.retryAt.Format(time.RFC3339))
+}
+
+// quota returns the quota of an endpoint, as far as the API reported it
+func (q *quotas) quota(endpoint string, now time.Time) reputation.Quota {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := q.state(endpoint)
+	quota := reputation.Quota{
+		Limit:     s.limit,
+		ResetAt:   s.resetAt,
+		Exhausted: now.Before(s.retryAt) || (s.remaining == 0 && now.Before(s.resetAt)),
+	}
+	if s.remaining >= 0 {
+		remaining := s.remaining
+		quota.Remaining = &remaining
+	}
+	if now.Before(s.retryAt) {
+		quota.RetryAt = s.retryAt
+	}
+	return quota
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
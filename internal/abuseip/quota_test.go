@@ -0,0 +1,186 @@
+package abuseip
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+func TestQuotaHeaders(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	remaining := 1
+
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		remaining--
+		w.Header().Set("X-RateLimit-Limit", "1000")
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		writeCheck(w, 0)
+	})
+	c := newTestClient(t, api, config.KeyRoundRobin, keys("a")...)
+
+	if _, err := c.lookup("203.0.113.7"); err != nil {
+		t.Fatalf("lookup() error = %v", err)
+	}
+
+	quota := c.Quotas()[0]
+	if quota.Key != "a" || quota.Limit != 1000 || !quota.ResetAt.Equal(reset) {
+		t.Errorf("quota = %+v", quota)
+	}
+	if quota.Remaining == nil || *quota.Remaining != 0 || !quota.Exhausted {
+		t.Errorf("quota = %+v, want it exhausted", quota)
+	}
+	if quota.Used != 1 {
+		t.Errorf("used = %d, want 1", quota.Used)
+	}
+
+	// The exhausted quota is known, so no request is wasted until it resets
+	if _, err := c.lookup("203.0.113.7"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("lookup() error = %v, want ErrRateLimited", err)
+	}
+	if got := len(api.requests()); got != 1 {
+		t.Errorf("%d requests, want 1", got)
+	}
+
+	// Reports have their own quota
+	if err := c.CanReport(); err != nil {
+		t.Errorf("CanReport() error = %v", err)
+	}
+}
+
+func TestQuotaReset(t *testing.T) {
+	now := time.Now()
+
+	var q quotas
+	q.update(endpointCheck, http.Header{
+		"X-Ratelimit-Remaining": {"0"},
+		"X-Ratelimit-Reset":     {strconv.FormatInt(now.Add(time.Minute).Unix(), 10)},
+	})
+	if err := q.wait(endpointCheck, now); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("wait() error = %v, want ErrRateLimited", err)
+	}
+	if err := q.wait(endpointCheck, now.Add(2*time.Minute)); err != nil {
+		t.Errorf("wait() after the reset error = %v", err)
+	}
+	if quota := q.quota(endpointCheck, now.Add(2*time.Minute)); quota.Exhausted {
+		t.Errorf("quota = %+v, want it available after the reset", quota)
+	}
+
+	// Until a response reports it, the remaining quota is unknown
+	if quota := q.quota(endpointReport, now); quota.Remaining != nil || quota.Exhausted {
+		t.Errorf("quota = %+v, want it unknown", quota)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "missing", value: ""},
+		{name: "seconds", value: "120", want: 2 * time.Minute, wantOK: true},
+		{name: "zero", value: "0", want: 0, wantOK: true},
+		{name: "negative", value: "-5"},
+		{name: "garbage", value: "soon"},
+		{name: "date", value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second, wantOK: true},
+		{name: "past date", value: now.Add(-time.Hour).Format(http.TimeFormat), want: 0, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+
+			got, ok := retryAfter(header, now)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("retryAfter(%q) = %s, %t, want %s, %t", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	var q quotas
+	want := []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute,
+		16 * time.Minute, 32 * time.Minute, MaxBackoff, MaxBackoff,
+	}
+	for i, delay := range want {
+		q.backoff(endpointCheck, "a", http.Header{}, now)
+
+		quota := q.quota(endpointCheck, now)
+		if got := quota.RetryAt.Sub(now); got != delay {
+			t.Errorf("backoff %d = %s, want %s", i+1, got, delay)
+		}
+		if !quota.Exhausted {
+			t.Errorf("backoff %d: quota not exhausted", i+1)
+		}
+	}
+
+	if err := q.wait(endpointCheck, now.Add(MaxBackoff-time.Second)); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("wait() during the backoff error = %v, want ErrRateLimited", err)
+	}
+	if err := q.wait(endpointCheck, now.Add(MaxBackoff)); err != nil {
+		t.Errorf("wait() after the backoff error = %v", err)
+	}
+	if err := q.wait(endpointReport, now); err != nil {
+		t.Errorf("wait() on another endpoint error = %v", err)
+	}
+
+	// A success starts the exponential backoff over
+	q.succeed(endpointCheck)
+	q.backoff(endpointCheck, "a", http.Header{}, now)
+	if got := q.quota(endpointCheck, now).RetryAt.Sub(now); got != MinBackoff {
+		t.Errorf("backoff after a success = %s, want %s", got, MinBackoff)
+	}
+
+	// Retry-After prevails over the exponential delay and the quota reset
+	q.backoff(endpointCheck, "a", http.Header{"Retry-After": {"30"}}, now)
+	quota := q.quota(endpointCheck, now)
+	if got := quota.RetryAt.Sub(now); got != 30*time.Second {
+		t.Errorf("backoff with Retry-After = %s, want 30s", got)
+	}
+	if !quota.ResetAt.Equal(quota.RetryAt) {
+		t.Errorf("reset = %s, want %s", quota.ResetAt, quota.RetryAt)
+	}
+}
+
+func TestBackoffFromResponse(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		writeError(w, http.StatusTooManyRequests, "Daily rate limit of 1000 requests exceeded for this endpoint.", "")
+	})
+	c := newTestClient(t, api, config.KeyRoundRobin, keys("a")...)
+
+	before := time.Now()
+	if _, err := c.lookup("203.0.113.7"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("lookup() error = %v, want ErrRateLimited", err)
+	}
+
+	quota := c.Quotas()[0]
+	if quota.RetryAt.Before(before.Add(time.Minute)) || quota.RetryAt.After(time.Now().Add(time.Minute)) {
+		t.Errorf("retry at %s, want a minute from now", quota.RetryAt)
+	}
+	if !quota.Exhausted {
+		t.Error("quota not exhausted")
+	}
+
+	if _, err := c.lookup("203.0.113.7"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("lookup() error = %v, want ErrRateLimited", err)
+	}
+	if got := len(api.requests()); got != 1 {
+		t.Errorf("%d requests, want 1", got)
+	}
+}
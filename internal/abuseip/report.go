@@ -0,0 +1,138 @@
+package abuseip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// ReportCooldown is how long AbuseIPDB refuses new reports of an IP
+	// from the same user
+	ReportCooldown = 15 * time.Minute
+	// MaxCommentLength is the longest comment accepted by the report endpoint
+	MaxCommentLength = 1024
+)
+
+var (
+	// ErrReportCooldown is returned when the IP was reported less than
+	// ReportCooldown ago
+	ErrReportCooldown = errors.New("abuseipdb: IP already reported in the last 15 minutes")
+)
+
+// CanReport returns an error while no key can send a report
+func (c *Client) CanReport() error {
+	now := time.Now()
+
+	var err error
+	for _, key := range c.keys {
+		if err = key.available(endpointReport, now); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Report reports an IP address for the given categories
+func (c *Client) Report(ip string, categories []int, comment string) error {
+	ids := make([]string, len(categories))
+	for i, category := range categories {
+		ids[i] = strconv.Itoa(category)
+	}
+
+	form := url.Values{}
+	form.Set("ip", ip)
+	form.Set("categories", strings.Join(ids, ","))
+	form.Set("comment", comment)
+
+	resp, err := c.send(endpointReport, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, c.url+"/report", strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		return req, err
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("abuseipdb: report returned status %d: %s", resp.StatusCode, parseAPIError(body).Detail)
+}
+
+// apiError is an error of an API response
+type apiError struct {
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+	Source struct {
+		Parameter string `json:"parameter"`
+	} `json:"source"`
+}
+
+// parseAPIError extracts the first error of an API response
+func parseAPIError(body []byte) apiError {
+	var result struct {
+		Errors []apiError `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || len(result.Errors) == 0 {
+		return apiError{Detail: strings.TrimSpace(string(body))}
+	}
+	return result.Errors[0]
+}
+
+// isCooldown reports whether a 429 response of the report endpoint is
+// the per-IP cooldown, which blames the ip parameter, rather than the
+// exhaustion of the quota of the key
+func (e apiError) isCooldown(endpoint string) bool {
+	return endpoint == endpointReport && e.Source.Parameter == "ip"
+}
+
+var (
+	ipv4Pattern   = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+	ipv6Pattern   = regexp.MustCompile(`\b[0-9a-fA-F]{0,4}(:[0-9a-fA-F]{0,4}){2,7}\b`)
+	emailPattern  = regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`)
+	secretPattern = regexp.MustCompile(`(?i)((?:pass(?:word|wd)?|pwd|token|secret|api_?key|key|auth\w*|session\w*|sid|cookie)=)[^&\s]*`)
+)
+
+// SanitizeComment strips data that must not be published in a report:
+// IP addresses, which may be ours, email addresses and credentials found
+// in query strings. Control characters are removed and the comment is
+// truncated to MaxCommentLength.
+func SanitizeComment(comment string) string {
+	comment = strings.Map(func(r rune) rune {
+		if r == '\n' || !unicode.IsControl(r) {
+			return r
+		}
+		return -1
+	}, comment)
+
+	comment = secretPattern.ReplaceAllString(comment, "${1}[redacted]")
+	comment = emailPattern.ReplaceAllString(comment, "[email]")
+	comment = ipv4Pattern.ReplaceAllString(comment, "[ip]")
+	comment = ipv6Pattern.ReplaceAllStringFunc(comment, func(match string) string {
+		// Times and other colon separated tokens are not addresses
+		if _, err := netip.ParseAddr(match); err != nil {
+			return match
+		}
+		return "[ip]"
+	})
+
+	if len(comment) > MaxCommentLength {
+		comment = strings.ToValidUTF8(comment[:MaxCommentLength], "")
+	}
+	return strings.TrimSpace(comment)
+}
@@ -0,0 +1,215 @@
+package abuseip
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+const cooldownDetail = "You can only report the same IP address (`203.0.113.7`) once in 15 minutes."
+
+func TestReport(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/report" {
+			t.Errorf("request = %s %s, want POST /report", r.Method, r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("ParseForm() error = %v", err)
+		}
+		if got := r.PostForm.Get("ip"); got != "203.0.113.7" {
+			t.Errorf("ip = %q", got)
+		}
+		if got := r.PostForm.Get("categories"); got != "18,22" {
+			t.Errorf("categories = %q, want 18,22", got)
+		}
+		if got := r.PostForm.Get("comment"); got != "SSH brute force" {
+			t.Errorf("comment = %q", got)
+		}
+		w.Write([]byte(`{"data":{"ipAddress":"203.0.113.7","abuseConfidenceScore":52}}`))
+	})
+	c := newTestClient(t, api, config.KeyRoundRobin, keys("a")...)
+
+	if err := c.Report("203.0.113.7", []int{CategoryBruteForce, CategorySSH}, "SSH brute force"); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if got := c.Quotas()[0].Used; got != 0 {
+		t.Errorf("used = %d, want reports not counted", got)
+	}
+}
+
+func TestReportErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		detail    string
+		parameter string
+		wantErr   error
+		// wantRequests is the number of requests, each key tried once
+		wantRequests int
+		wantCanErr   error
+	}{
+		{
+			name:         "cooldown",
+			status:       http.StatusTooManyRequests,
+			detail:       cooldownDetail,
+			parameter:    "ip",
+			wantErr:      ErrReportCooldown,
+			wantRequests: 1,
+		},
+		{
+			// The cooldown is told apart by the error, not its wording
+			name:         "reworded cooldown",
+			status:       http.StatusTooManyRequests,
+			detail:       "Already reported.",
+			parameter:    "ip",
+			wantErr:      ErrReportCooldown,
+			wantRequests: 1,
+		},
+		{
+			name:         "rate limit",
+			status:       http.StatusTooManyRequests,
+			detail:       "Daily rate limit of 1000 requests exceeded for this endpoint. Try again in 15 minutes.",
+			wantErr:      ErrRateLimited,
+			wantRequests: 2,
+			wantCanErr:   ErrRateLimited,
+		},
+		{
+			name:         "rejected",
+			status:       http.StatusUnauthorized,
+			detail:       "Authentication failed.",
+			wantErr:      ErrKeyRejected,
+			wantRequests: 2,
+			wantCanErr:   ErrKeyRejected,
+		},
+		{
+			name:         "invalid",
+			status:       http.StatusUnprocessableEntity,
+			detail:       "The categories field is required.",
+			parameter:    "categories",
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, tt.status, tt.detail, tt.parameter)
+			})
+			c := newTestClient(t, api, config.KeyRoundRobin, keys("a", "b")...)
+
+			err := c.Report("203.0.113.7", []int{CategoryBruteForce}, "")
+			if err == nil {
+				t.Fatal("Report() succeeded, want an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Report() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !strings.Contains(err.Error(), tt.detail) {
+				t.Errorf("Report() error = %v, want the API error", err)
+			}
+			if got := len(api.requests()); got != tt.wantRequests {
+				t.Errorf("%d requests, want %d", got, tt.wantRequests)
+			}
+
+			if err := c.CanReport(); !errors.Is(err, tt.wantCanErr) {
+				t.Errorf("CanReport() error = %v, want %v", err, tt.wantCanErr)
+			}
+			// Lookups have their own quota
+			if err := c.keys[0].quotas.wait(endpointCheck, time.Now()); err != nil {
+				t.Errorf("lookup quota error = %v", err)
+			}
+		})
+	}
+}
+
+func TestParseAPIError(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		endpoint     string
+		wantDetail   string
+		wantCooldown bool
+	}{
+		{
+			name:         "cooldown",
+			body:         `{"errors":[{"detail":"` + cooldownDetail + `","status":429,"source":{"parameter":"ip"}}]}`,
+			endpoint:     endpointReport,
+			wantDetail:   cooldownDetail,
+			wantCooldown: true,
+		},
+		{
+			name:       "cooldown error on lookups",
+			body:       `{"errors":[{"detail":"` + cooldownDetail + `","status":429,"source":{"parameter":"ip"}}]}`,
+			endpoint:   endpointCheck,
+			wantDetail: cooldownDetail,
+		},
+		{
+			name:       "rate limit",
+			body:       `{"errors":[{"detail":"Daily rate limit of 1000 requests exceeded.","status":429}]}`,
+			endpoint:   endpointReport,
+			wantDetail: "Daily rate limit of 1000 requests exceeded.",
+		},
+		{
+			name:       "first error",
+			body:       `{"errors":[{"detail":"first","status":422},{"detail":"second","status":422}]}`,
+			endpoint:   endpointReport,
+			wantDetail: "first",
+		},
+		{name: "no errors", body: `{"errors":[]}`, endpoint: endpointReport, wantDetail: `{"errors":[]}`},
+		{name: "plain text", body: " Too Many Attempts.\n", endpoint: endpointReport, wantDetail: "Too Many Attempts."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseAPIError([]byte(tt.body))
+			if got.Detail != tt.wantDetail {
+				t.Errorf("detail = %q, want %q", got.Detail, tt.wantDetail)
+			}
+			if cooldown := got.isCooldown(tt.endpoint); cooldown != tt.wantCooldown {
+				t.Errorf("isCooldown() = %t, want %t", cooldown, tt.wantCooldown)
+			}
+		})
+	}
+}
+
+func TestSanitizeComment(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		want    string
+	}{
+		{name: "plain", comment: "SSH brute force", want: "SSH brute force"},
+		{name: "ipv4", comment: "GET / from 203.0.113.7 to 10.0.0.1", want: "GET / from [ip] to [ip]"},
+		{name: "ipv6", comment: "from 2001:db8::1", want: "from [ip]"},
+		{name: "time", comment: "at 10:42:07", want: "at 10:42:07"},
+		{name: "email", comment: "login admin@example.com failed", want: "login [email] failed"},
+		{
+			name:    "credentials",
+			comment: "GET /login?user=root&password=hunter2&token=abc&page=1",
+			want:    "GET /login?user=root&password=[redacted]&token=[redacted]&page=1",
+		},
+		{name: "control characters", comment: "GET /\x00\x1b[31m\r\nnext", want: "GET /[31m\nnext"},
+		{name: "spaces", comment: "  padded \n", want: "padded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeComment(tt.comment); got != tt.want {
+				t.Errorf("SanitizeComment(%q) = %q, want %q", tt.comment, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeCommentLength(t *testing.T) {
+	// The limit falls in the middle of a two byte rune
+	got := SanitizeComment("a" + strings.Repeat("é", MaxCommentLength))
+	if len(got) != MaxCommentLength-1 || !utf8.ValidString(got) {
+		t.Errorf("comment of %d bytes, valid UTF-8 %t", len(got), utf8.ValidString(got))
+	}
+}
@@ -0,0 +1,88 @@
+package blocklist
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/netlist"
+	"github.com/TOomaAh/GateKeeper/internal/reputation"
+)
+
+// ErrEmptyPath is returned when a blocklist has no file configured
+var ErrEmptyPath = errors.New("blocklist: path is empty")
+
+func init() {
+	reputation.Register("blocklist", func(cfg *config.ProviderConfig) (reputation.Provider, error) {
+		if cfg.URL != "" {
+			return NewFeed(cfg, nil)
+		}
+		return NewFile(cfg)
+	})
+}
+
+// File is a reputation provider backed by a local list of IPs and CIDR
+// prefixes, reloaded when the file changes. Listed IPs get the configured
+// score, others a score of 0.
+type File struct {
+	name      string
+	path      string
+	score     domain.IPScore
+	threshold domain.IPScore
+	list      *netlist.WatchedFile
+}
+
+// NewFile loads a blocklist file. The score defaults to the highest one.
+func NewFile(cfg *config.ProviderConfig) (*File, error) {
+	if cfg.Path == "" {
+		return nil, ErrEmptyPath
+	}
+
+	list, err := netlist.WatchFile(cfg.Path, netlist.DefaultReloadInterval)
+	if err != nil {
+		return nil, fmt.Errorf("blocklist: %w", err)
+	}
+	log.Printf("Loaded %d blocklist prefix(es) from %s", list.Len(), cfg.Path)
+
+	score := domain.IPScore(cfg.Score)
+	if score == 0 {
+		score = domain.ScoreHigh
+	}
+
+	return &File{
+		name:      cfg.Name,
+		path:      cfg.Path,
+		score:     score,
+		threshold: domain.IPScore(cfg.MaliciousThreshold()),
+		list:      list,
+	}, nil
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+// Local reports that the file answers without any remote call
+func (f *File) Local() bool {
+	return true
+}
+
+func (f *File) Check(ip string) (domain.ReputationResult, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return domain.ReputationResult{}, fmt.Errorf("blocklist: %w", err)
+	}
+
+	if !f.list.Contains(addr) {
+		return domain.ReputationResult{}, nil
+	}
+
+	return domain.ReputationResult{
+		Score:     f.score,
+		Malicious: f.score > f.threshold,
+		Details:   "listed in " + f.path,
+	}, nil
+}
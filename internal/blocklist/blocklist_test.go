@@ -0,0 +1,87 @@
+package blocklist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// writeList writes a blocklist file in a temporary directory
+func writeList(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestFileCheck(t *testing.T) {
+	path := writeList(t, `# Local blocklist
+192.0.2.1
+198.51.100.0/24 ; scanners
+2001:db8::/32
+
+`)
+	f, err := NewFile(&config.ProviderConfig{Name: "local", Path: path})
+	if err != nil {
+		t.Fatalf("NewFile() error = %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want domain.IPScore
+	}{
+		{ip: "192.0.2.1", want: domain.ScoreHigh},
+		{ip: "192.0.2.2", want: 0},
+		{ip: "198.51.100.42", want: domain.ScoreHigh},
+		{ip: "2001:db8::1", want: domain.ScoreHigh},
+		{ip: "::ffff:192.0.2.1", want: domain.ScoreHigh},
+		{ip: "203.0.113.1", want: 0},
+	}
+	for _, tt := range tests {
+		result, err := f.Check(tt.ip)
+		if err != nil {
+			t.Fatalf("Check(%s) error = %v", tt.ip, err)
+		}
+		if result.Score != tt.want || result.Malicious != (tt.want > domain.ScoreThreshold) {
+			t.Errorf("Check(%s) = %+v, want score %d", tt.ip, result, tt.want)
+		}
+	}
+
+	if _, err := f.Check("not an ip"); err == nil {
+		t.Error("Check() succeeded with an invalid address")
+	}
+}
+
+func TestFileScore(t *testing.T) {
+	path := writeList(t, "192.0.2.1\n")
+	f, err := NewFile(&config.ProviderConfig{Name: "local", Path: path, Score: 50})
+	if err != nil {
+		t.Fatalf("NewFile() error = %v", err)
+	}
+
+	result, err := f.Check("192.0.2.1")
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if result.Score != 50 || result.Malicious {
+		t.Errorf("Check() = %+v, want score 50, not malicious", result)
+	}
+}
+
+func TestNewFileErrors(t *testing.T) {
+	if _, err := NewFile(&config.ProviderConfig{Name: "local"}); err != ErrEmptyPath {
+		t.Errorf("NewFile() without path error = %v, want ErrEmptyPath", err)
+	}
+	if _, err := NewFile(&config.ProviderConfig{Name: "local", Path: filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		t.Error("NewFile() succeeded with a missing file")
+	}
+	if _, err := NewFile(&config.ProviderConfig{Name: "local", Path: writeList(t, "192.0.2.1\n192.0.2.300\n")}); err == nil {
+		t.Error("NewFile() succeeded with a malformed line")
+	}
+}
@@ -0,0 +1,364 @@
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/netlist"
+)
+
+// Feed formats
+const (
+	// FormatText lists one address or prefix per line, optionally followed
+	// by other fields. Comments start with '#' or ';'. It covers plain IP
+	// and CIDR lists, FireHOL netsets and the Spamhaus DROP and EDROP lists.
+	FormatText = "text"
+	// FormatSpamhausJSON is the JSON lines version of the Spamhaus DROP lists
+	FormatSpamhausJSON = "spamhaus_json"
+)
+
+const (
+	// DefaultRefresh is how often feeds are downloaded
+	DefaultRefresh = 1 * time.Hour
+	// DefaultFeedDirectory is where feeds are cached when no path is set
+	DefaultFeedDirectory = "./feeds"
+	// MaxFeedSize is the largest feed accepted
+	MaxFeedSize = 64 << 20
+)
+
+var (
+	// ErrUnknownFormat is returned for an unsupported feed format
+	ErrUnknownFormat = errors.New("blocklist: unknown feed format")
+	// ErrEmptyFeed is returned when a feed has no valid entry, which
+	// usually means an error page was served instead of the list
+	ErrEmptyFeed = errors.New("blocklist: feed has no valid entry")
+)
+
+// Feed is a reputation provider backed by a remote blocklist, downloaded
+// every refresh interval. Downloads are conditional, using the ETag and
+// Last-Modified of the previous one. The list is cached on disk, so that
+// it is available at startup before the first download.
+type Feed struct {
+	name      string
+	url       string
+	path      string
+	format    string
+	score     domain.IPScore
+	threshold domain.IPScore
+	client    *http.Client
+
+	set atomic.Pointer[netlist.PrefixSet]
+
+	mu   sync.Mutex
+	meta feedMeta
+}
+
+// feedMeta holds the validators of the cached copy of a feed
+type feedMeta struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// NewFeed loads the cached copy of a feed, downloads it and refreshes it
+// in the background. A failed download is logged and retried at the next
+// refresh. The client defaults to one with the configured timeout; file://
+// URLs are supported.
+func NewFeed(cfg *config.ProviderConfig, client *http.Client) (*Feed, error) {
+	format := cfg.Format
+	if format == "" {
+		format = FormatText
+	}
+	if format != FormatText && format != FormatSpamhausJSON {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+
+	path := cfg.Path
+	if path == "" {
+		path = filepath.Join(DefaultFeedDirectory, cfg.Name+".list")
+	}
+
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+		client = &http.Client{Timeout: cfg.Timeout, Transport: transport}
+	}
+
+	score := domain.IPScore(cfg.Score)
+	if score == 0 {
+		score = domain.ScoreHigh
+	}
+
+	f := &Feed{
+		name:      cfg.Name,
+		url:       cfg.URL,
+		path:      path,
+		format:    format,
+		score:     score,
+		threshold: domain.IPScore(cfg.MaliciousThreshold()),
+		client:    client,
+	}
+	f.set.Store(netlist.NewPrefixSet())
+
+	if err := f.loadCache(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to load cached blocklist %s: %v", f.name, err)
+	}
+
+	if err := f.Refresh(); err != nil {
+		log.Printf("Failed to download blocklist %s, using %d cached prefix(es): %v", f.name, f.Len(), err)
+	}
+
+	refresh := cfg.Refresh
+	if refresh == 0 {
+		refresh = DefaultRefresh
+	}
+	go f.refreshLoop(refresh)
+
+	return f, nil
+}
+
+func (f *Feed) Name() string {
+	return f.name
+}
+
+// Local reports that the feed answers without any remote call
+func (f *Feed) Local() bool {
+	return true
+}
+
+// Len returns the number of prefixes currently loaded
+func (f *Feed) Len() int {
+	return f.set.Load().Len()
+}
+
+func (f *Feed) Check(ip string) (domain.ReputationResult, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return domain.ReputationResult{}, fmt.Errorf("blocklist: %w", err)
+	}
+
+	if !f.set.Load().Contains(addr) {
+		return domain.ReputationResult{}, nil
+	}
+
+	return domain.ReputationResult{
+		Score:     f.score,
+		Malicious: f.score > f.threshold,
+		Details:   "listed in " + f.name,
+	}, nil
+}
+
+// Refresh downloads the feed if it changed since the last download
+func (f *Feed) Refresh() error {
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return fmt.Errorf("blocklist: failed to create request: %w", err)
+	}
+
+	f.mu.Lock()
+	meta := f.meta
+	f.mu.Unlock()
+
+	if meta.ETag != "" {
+		req.Header.Set("If-None-Match", meta.ETag)
+	}
+	if meta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("blocklist: request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		meta.FetchedAt = time.Now()
+		f.setMeta(meta)
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("blocklist: %s returned status %d", f.url, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxFeedSize+1))
+	if err != nil {
+		return fmt.Errorf("blocklist: failed to read feed: %w", err)
+	}
+	if len(body) > MaxFeedSize {
+		return fmt.Errorf("blocklist: feed larger than %d bytes", MaxFeedSize)
+	}
+
+	set, skipped, err := Parse(bytes.NewReader(body), f.format)
+	if err != nil {
+		return err
+	}
+	f.set.Store(set)
+	log.Printf("Downloaded %d prefix(es) from blocklist %s (%d invalid line(s) skipped)", set.Len(), f.name, skipped)
+
+	meta = feedMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	}
+	f.setMeta(meta)
+
+	if err := f.saveCache(body); err != nil {
+		log.Printf("Failed to cache blocklist %s: %v", f.name, err)
+	}
+	return nil
+}
+
+func (f *Feed) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := f.Refresh(); err != nil {
+			log.Printf("Failed to refresh blocklist %s, keeping previous entries: %v", f.name, err)
+		}
+	}
+}
+
+func (f *Feed) setMeta(meta feedMeta) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.meta = meta
+}
+
+// loadCache loads the cached copy of the feed and its validators. The
+// validators are only trusted along with the list they describe.
+func (f *Feed) loadCache() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	set, _, err := Parse(file, f.format)
+	if err != nil {
+		return err
+	}
+	f.set.Store(set)
+	log.Printf("Loaded %d cached prefix(es) of blocklist %s from %s", set.Len(), f.name, f.path)
+
+	data, err := os.ReadFile(f.path + ".meta")
+	if err != nil {
+		return nil
+	}
+
+	var meta feedMeta
+	if err := json.Unmarshal(data, &meta); err == nil {
+		f.setMeta(meta)
+	}
+	return nil
+}
+
+// saveCache writes the feed and its validators, replacing the previous
+// copy atomically
+func (f *Feed) saveCache(body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+
+	if err := writeFile(f.path, body); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	meta, err := json.Marshal(f.meta)
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return writeFile(f.path+".meta", meta)
+}
+
+// writeFile writes data to a temporary file renamed over path
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Parse reads a blocklist in the given format. Invalid lines are skipped
+// and counted, but a list without any valid entry is an error.
+func Parse(r io.Reader, format string) (*netlist.PrefixSet, int, error) {
+	set := netlist.NewPrefixSet()
+	skipped := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, ok := parseLine(scanner.Text(), format)
+		if !ok {
+			continue
+		}
+
+		prefix, err := netlist.ParsePrefix(entry)
+		if err != nil {
+			skipped++
+			continue
+		}
+		set.Add(prefix)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("blocklist: failed to read feed: %w", err)
+	}
+	if set.Len() == 0 && skipped > 0 {
+		return nil, skipped, ErrEmptyFeed
+	}
+
+	return set, skipped, nil
+}
+
+// parseLine extracts the address or prefix of a line. It returns false
+// for lines without any entry, such as comments.
+func parseLine(line, format string) (string, bool) {
+	if format == FormatSpamhausJSON {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return "", false
+		}
+
+		var record struct {
+			CIDR string `json:"cidr"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			// Counted as an invalid line
+			return line, true
+		}
+		// The last line holds the metadata of the list
+		return record.CIDR, record.CIDR != ""
+	}
+
+	if idx := strings.IndexAny(line, "#;"); idx >= 0 {
+		line = line[:idx]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", false
+	}
+	return fields[0], true
+}
@@ -0,0 +1,338 @@
+package blocklist
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+// fakeFeed serves a blocklist with validators and answers conditional
+// requests with 304 when the list did not change
+type fakeFeed struct {
+	mu           sync.Mutex
+	body         string
+	etag         string
+	lastModified string
+	status       int
+	requests     []http.Header
+	notModified  int
+}
+
+func (f *fakeFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r.Header.Clone())
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		return
+	}
+
+	if (f.etag != "" && r.Header.Get("If-None-Match") == f.etag) ||
+		(f.lastModified != "" && r.Header.Get("If-Modified-Since") == f.lastModified) {
+		f.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if f.etag != "" {
+		w.Header().Set("ETag", f.etag)
+	}
+	if f.lastModified != "" {
+		w.Header().Set("Last-Modified", f.lastModified)
+	}
+	w.Write([]byte(f.body))
+}
+
+func (f *fakeFeed) update(body, etag, lastModified string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.body, f.etag, f.lastModified = body, etag, lastModified
+}
+
+func (f *fakeFeed) setStatus(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.status = status
+}
+
+// lastRequest returns the headers of the last request received
+func (f *fakeFeed) lastRequest(t *testing.T) http.Header {
+	t.Helper()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.requests) == 0 {
+		t.Fatal("no request received")
+	}
+	return f.requests[len(f.requests)-1]
+}
+
+func newTestFeed(t *testing.T, url, path string) *Feed {
+	t.Helper()
+
+	feed, err := NewFeed(&config.ProviderConfig{
+		Name:    "test",
+		URL:     url,
+		Path:    path,
+		Timeout: time.Second,
+		Refresh: time.Hour,
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewFeed() error = %v", err)
+	}
+	return feed
+}
+
+func listed(t *testing.T, feed *Feed, ip string) bool {
+	t.Helper()
+
+	result, err := feed.Check(ip)
+	if err != nil {
+		t.Fatalf("Check(%s) error = %v", ip, err)
+	}
+	return result.Score > 0
+}
+
+func TestFeedConditionalRefresh(t *testing.T) {
+	tests := []struct {
+		name         string
+		etag         string
+		lastModified string
+	}{
+		{name: "etag", etag: `"v1"`},
+		{name: "last-modified", lastModified: "Mon, 01 Jan 2024 10:00:00 GMT"},
+		{name: "both", etag: `"v1"`, lastModified: "Mon, 01 Jan 2024 10:00:00 GMT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeFeed{}
+			api.update("192.0.2.0/24\n", tt.etag, tt.lastModified)
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			feed := newTestFeed(t, srv.URL, filepath.Join(t.TempDir(), "test.list"))
+			first := api.lastRequest(t)
+			if first.Get("If-None-Match") != "" || first.Get("If-Modified-Since") != "" {
+				t.Errorf("first download is conditional: %v", first)
+			}
+			if !listed(t, feed, "192.0.2.1") {
+				t.Fatal("192.0.2.1 not listed after the download")
+			}
+
+			if err := feed.Refresh(); err != nil {
+				t.Fatalf("Refresh() error = %v", err)
+			}
+			second := api.lastRequest(t)
+			if got := second.Get("If-None-Match"); got != tt.etag {
+				t.Errorf("If-None-Match = %q, want %q", got, tt.etag)
+			}
+			if got := second.Get("If-Modified-Since"); got != tt.lastModified {
+				t.Errorf("If-Modified-Since = %q, want %q", got, tt.lastModified)
+			}
+			if api.notModified != 1 {
+				t.Fatalf("%d 304 response(s), want 1", api.notModified)
+			}
+			if !listed(t, feed, "192.0.2.1") {
+				t.Error("entries dropped on a 304 response")
+			}
+
+			// A new version replaces the list
+			api.update("198.51.100.0/24\n", `"v2"`, "Tue, 02 Jan 2024 10:00:00 GMT")
+			if err := feed.Refresh(); err != nil {
+				t.Fatalf("Refresh() error = %v", err)
+			}
+			if listed(t, feed, "192.0.2.1") || !listed(t, feed, "198.51.100.1") {
+				t.Error("list not replaced by the new version")
+			}
+		})
+	}
+}
+
+func TestFeedKeepsEntriesOnFailure(t *testing.T) {
+	api := &fakeFeed{}
+	api.update("192.0.2.0/24\n", `"v1"`, "")
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	feed := newTestFeed(t, srv.URL, filepath.Join(t.TempDir(), "test.list"))
+
+	api.setStatus(http.StatusInternalServerError)
+	if err := feed.Refresh(); err == nil {
+		t.Error("Refresh() succeeded on a 500 response")
+	}
+	if !listed(t, feed, "192.0.2.1") {
+		t.Error("entries dropped after a failed download")
+	}
+
+	// An error page served with 200 has no valid entry
+	api.setStatus(0)
+	api.update("<html>\n<body>Service unavailable</body>\n</html>\n", `"v2"`, "")
+	if err := feed.Refresh(); !errors.Is(err, ErrEmptyFeed) {
+		t.Errorf("Refresh() error = %v, want ErrEmptyFeed", err)
+	}
+	if !listed(t, feed, "192.0.2.1") {
+		t.Error("entries replaced by an error page")
+	}
+}
+
+func TestFeedCache(t *testing.T) {
+	api := &fakeFeed{}
+	api.update("192.0.2.0/24\n", `"v1"`, "")
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "feeds", "test.list")
+	newTestFeed(t, srv.URL, path)
+	if _, err := os.Stat(path + ".meta"); err != nil {
+		t.Fatalf("validators not cached: %v", err)
+	}
+
+	// The cached list is available at startup and its validators are
+	// sent with the first download
+	feed := newTestFeed(t, srv.URL, path)
+	if got := api.lastRequest(t).Get("If-None-Match"); got != `"v1"` {
+		t.Errorf("If-None-Match = %q, want the cached ETag", got)
+	}
+	if !listed(t, feed, "192.0.2.1") {
+		t.Error("cached entries not loaded")
+	}
+
+	// The cache is used when the feed is unreachable
+	srv.Close()
+	feed = newTestFeed(t, srv.URL, path)
+	if !listed(t, feed, "192.0.2.1") {
+		t.Error("cached entries not used while the feed is unreachable")
+	}
+}
+
+func TestFeedFileURL(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	if err := os.WriteFile(source, []byte("192.0.2.1\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	feed := newTestFeed(t, "file://"+filepath.ToSlash(source), filepath.Join(dir, "test.list"))
+	if !listed(t, feed, "192.0.2.1") {
+		t.Error("entries of the file:// feed not loaded")
+	}
+}
+
+func TestNewFeedUnknownFormat(t *testing.T) {
+	_, err := NewFeed(&config.ProviderConfig{Name: "test", URL: "http://192.0.2.1/", Format: "xml"}, nil)
+	if !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("NewFeed() error = %v, want ErrUnknownFormat", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		input       string
+		listed      []string
+		notListed   []string
+		wantLen     int
+		wantSkipped int
+		wantErr     error
+	}{
+		{
+			name:   "text",
+			format: FormatText,
+			input: `# FireHOL style header
+; Spamhaus style header
+192.0.2.1
+198.51.100.0/24 ; SBL123456
+  203.0.113.7   # inline comment
+
+2001:db8::/32
+10.0.0.1/8 extra fields are ignored
+`,
+			listed:    []string{"192.0.2.1", "198.51.100.200", "203.0.113.7", "2001:db8::1", "10.1.2.3"},
+			notListed: []string{"192.0.2.2", "203.0.113.8", "2001:db9::1"},
+			wantLen:   5,
+		},
+		{
+			name:        "malformed lines skipped",
+			format:      FormatText,
+			input:       "192.0.2.1\n192.0.2.300\n198.51.100.0/33\nnot-an-ip\n",
+			listed:      []string{"192.0.2.1"},
+			wantLen:     1,
+			wantSkipped: 3,
+		},
+		{
+			name:    "comments only",
+			format:  FormatText,
+			input:   "# empty list\n; nothing here\n\n",
+			wantLen: 0,
+		},
+		{
+			name:    "no valid entry",
+			format:  FormatText,
+			input:   "<html>\n<body>Not found</body>\n",
+			wantErr: ErrEmptyFeed,
+		},
+		{
+			name:   "spamhaus json",
+			format: FormatSpamhausJSON,
+			input: `{"cidr":"192.0.2.0/24","sblid":"SBL1","rir":"arin"}
+{"cidr":"2001:db8::/32","sblid":"SBL2","rir":"ripencc"}
+
+{"type":"metadata","timestamp":1704067200,"size":2,"records":2}
+`,
+			listed:    []string{"192.0.2.1", "2001:db8::1"},
+			notListed: []string{"198.51.100.1"},
+			wantLen:   2,
+		},
+		{
+			name:        "spamhaus json with invalid lines",
+			format:      FormatSpamhausJSON,
+			input:       "{\"cidr\":\"192.0.2.0/24\"}\nnot json\n{\"cidr\":\"192.0.2.0/40\"}\n",
+			listed:      []string{"192.0.2.1"},
+			wantLen:     1,
+			wantSkipped: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, skipped, err := Parse(strings.NewReader(tt.input), tt.format)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			if set.Len() != tt.wantLen || skipped != tt.wantSkipped {
+				t.Errorf("Parse() = %d prefix(es), %d skipped, want %d, %d", set.Len(), skipped, tt.wantLen, tt.wantSkipped)
+			}
+			for _, ip := range tt.listed {
+				if !set.ContainsString(ip) {
+					t.Errorf("%s not listed", ip)
+				}
+			}
+			for _, ip := range tt.notListed {
+				if set.ContainsString(ip) {
+					t.Errorf("%s listed", ip)
+				}
+			}
+		})
+	}
+}
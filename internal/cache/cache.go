@@ -1,97 +1,341 @@
 package cache
 
 import (
+	"container/list"
 	"sync"
 	"time"
 
+	"github.com/TOomaAh/GateKeeper/internal/database"
 	"github.com/TOomaAh/GateKeeper/internal/domain"
 )
 
 const (
-	// DefaultCleanupInterval defines the cache cleanup frequency
-	DefaultCleanupInterval = 10 * time.Minute
+	// DefaultMaxEntries is the default number of cached IPs
+	DefaultMaxEntries = 10000
+	// DefaultNegativeTTL is how long an IP missing from the store is remembered
+	DefaultNegativeTTL = 1 * time.Minute
 )
 
-// IPCache manages a thread-safe cache of IP information with TTL
-type IPCache struct {
-	mu      sync.RWMutex
-	entries map[string]*domain.IPInfo
-	ttl     time.Duration
+// Store is a bounded LRU cache in front of a database.Store. Reads go
+// through the cache, writes go to the store and update the cached entry.
+// Misses are cached too, for a shorter time, so that a flood of requests
+// for an unknown IP only reaches the store once. Methods not overridden
+// here are passed to the store as is.
+type Store struct {
+	database.Store
+
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	pending map[string]*pendingRead
+	stats   Stats
+}
+
+type entry struct {
+	ip      string
+	info    *domain.IPInfo // nil for a cached miss
+	expires time.Time
+}
+
+// pendingRead tracks the Get calls reading an IP from the store. Writes
+// bump its generation, so that a value read before them is not cached.
+// Only IPs being read have one, which keeps the map small.
+type pendingRead struct {
+	readers    int
+	generation uint64
+}
+
+// Stats contains cache counters
+type Stats struct {
+	Entries      int    `json:"entries"`
+	MaxEntries   int    `json:"max_entries"`
+	Hits         uint64 `json:"hits"`
+	Misses       uint64 `json:"misses"`
+	NegativeHits uint64 `json:"negative_hits"`
+	Evictions    uint64 `json:"evictions"`
+}
+
+// New wraps a store. Entries are valid for ttl after their reputation
+// check, like in the store.
+func New(store database.Store, ttl, negativeTTL time.Duration, maxEntries int) *Store {
+	return &Store{
+		Store:       store,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		lru:         list.New(),
+		entries:     make(map[string]*list.Element),
+		pending:     make(map[string]*pendingRead),
+	}
 }
 
-// NewIPCache creates a new cache with a given TTL
-func NewIPCache(ttl time.Duration) *IPCache {
-	cache := &IPCache{
-		entries: make(map[string]*domain.IPInfo),
-		ttl:     ttl,
+// Get returns the entry of an IP from the cache, or from the store on a miss
+func (c *Store) Get(ip string) (*domain.IPInfo, bool) {
+	c.mu.Lock()
+	if e, ok := c.lookup(ip); ok {
+		if e.info == nil {
+			c.stats.NegativeHits++
+			c.mu.Unlock()
+			return nil, false
+		}
+		c.stats.Hits++
+		info := copyInfo(e.info)
+		c.mu.Unlock()
+		return info, true
+	}
+	c.stats.Misses++
+	pending, ok := c.pending[ip]
+	if !ok {
+		pending = &pendingRead{}
+		c.pending[ip] = pending
 	}
+	pending.readers++
+	generation := pending.generation
+	c.mu.Unlock()
 
-	go cache.cleanupLoop()
+	info, ok := c.Store.Get(ip)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return cache
+	pending.readers--
+	if pending.readers == 0 {
+		delete(c.pending, ip)
+	}
+
+	// A concurrent write may have changed the entry in the meantime, in
+	// which case the value read may be stale
+	if pending.generation != generation {
+		return info, ok
+	}
+	if _, cached := c.lookup(ip); !cached {
+		if ok {
+			c.add(ip, copyInfo(info), info.Timestamp.Add(c.ttl))
+		} else {
+			c.add(ip, nil, time.Now().Add(c.negativeTTL))
+		}
+	}
+
+	return info, ok
 }
 
-// Get retrieves an entry from the cache if it exists and is not expired
-func (c *IPCache) Get(ip string) (*domain.IPInfo, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+// Lookup returns the entry of an IP from the cache only
+func (c *Store) Lookup(ip string) (*domain.IPInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	entry, exists := c.entries[ip]
-	if !exists {
+	e, ok := c.lookup(ip)
+	if !ok || e.info == nil {
+		c.stats.Misses++
 		return nil, false
 	}
 
-	if time.Since(entry.Timestamp) > c.ttl {
-		return nil, false
+	c.stats.Hits++
+	return copyInfo(e.info), true
+}
+
+// Set writes an entry to the store and caches it. The cached blocked
+// state is merged like the store does; without a cached state the entry
+// is dropped so that the next Get reads it back.
+func (c *Store) Set(info *domain.IPInfo) error {
+	if err := c.Store.Set(info); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bump(info.Address)
+
+	e, ok := c.lookup(info.Address)
+	if !ok || e.info == nil {
+		c.remove(info.Address)
+		return nil
 	}
 
-	return entry, true
+	cached := copyInfo(info)
+	if cached.Timestamp.IsZero() {
+		cached.Timestamp = time.Now()
+	}
+	cached.BlockedInFW = e.info.BlockedInFW || info.BlockedInFW
+	cached.BlockedAt = e.info.BlockedAt
+	cached.ManualUnblock = e.info.ManualUnblock
+	c.add(info.Address, cached, cached.Timestamp.Add(c.ttl))
+
+	return nil
+}
+
+// Remember caches an entry without writing it to the store, for IPs that
+// are not worth persisting
+func (c *Store) Remember(info *domain.IPInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := copyInfo(info)
+	if cached.Timestamp.IsZero() {
+		cached.Timestamp = time.Now()
+	}
+	c.add(info.Address, cached, cached.Timestamp.Add(c.ttl))
 }
 
-// Set adds or updates an entry in the cache
-func (c *IPCache) Set(info *domain.IPInfo) {
+// Delete removes an entry from the store and the cache
+func (c *Store) Delete(ip string) error {
+	err := c.Store.Delete(ip)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.bump(ip)
+	c.remove(ip)
 
-	info.Timestamp = time.Now()
-	c.entries[info.Address] = info
+	return err
 }
 
-// MarkBlocked marks an IP as blocked in the firewall
-func (c *IPCache) MarkBlocked(ip string) {
+// MarkBlocked flags an IP as blocked in the store and the cache
+func (c *Store) MarkBlocked(ip string) error {
+	if err := c.Store.MarkBlocked(ip); err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.bump(ip)
 
-	if entry, exists := c.entries[ip]; exists {
-		entry.BlockedInFW = true
+	if e, ok := c.lookup(ip); ok && e.info != nil {
+		if !e.info.BlockedInFW || e.info.BlockedAt.IsZero() {
+			e.info.BlockedAt = time.Now()
+		}
+		e.info.BlockedInFW = true
+		e.info.ManualUnblock = false
 	}
+
+	return nil
 }
 
-// Delete removes an entry from the cache
-func (c *IPCache) Delete(ip string) {
+// MarkUnblocked clears the blocked flag in the store and the cache
+func (c *Store) MarkUnblocked(ip string) error {
+	if err := c.Store.MarkUnblocked(ip); err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.bump(ip)
 
-	delete(c.entries, ip)
+	if e, ok := c.lookup(ip); ok && e.info != nil {
+		e.info.BlockedInFW = false
+	}
+
+	return nil
 }
 
-func (c *IPCache) cleanupLoop() {
-	ticker := time.NewTicker(DefaultCleanupInterval)
-	defer ticker.Stop()
+// MarkManualUnblock records a manual unblock in the store and the cache
+func (c *Store) MarkManualUnblock(ip string) error {
+	if err := c.Store.MarkManualUnblock(ip); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bump(ip)
 
-	for range ticker.C {
-		c.cleanup()
+	if e, ok := c.lookup(ip); ok && e.info != nil {
+		e.info.ManualUnblock = true
 	}
+
+	return nil
 }
 
-func (c *IPCache) cleanup() {
+// ClearPayloadPath removes the references to a deleted payload file from
+// the store and the cache
+func (c *Store) ClearPayloadPath(path string) error {
+	if err := c.Store.ClearPayloadPath(path); err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	for ip := range c.pending {
+		c.bump(ip)
+	}
 
-	now := time.Now()
-	for ip, entry := range c.entries {
-		if now.Sub(entry.Timestamp) > c.ttl {
-			delete(c.entries, ip)
+	for _, el := range c.entries {
+		if e := el.Value.(*entry); e.info != nil && e.info.PayloadPath == path {
+			e.info.PayloadPath = ""
 		}
 	}
+
+	return nil
+}
+
+// Stats returns the cache counters
+func (c *Store) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.MaxEntries = c.maxEntries
+	return stats
+}
+
+// lookup returns the live entry of an IP and marks it as recently used.
+// Expired entries are removed.
+func (c *Store) lookup(ip string) (*entry, bool) {
+	el, ok := c.entries[ip]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, ip)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+// add inserts or replaces an entry, evicting the least recently used
+// entries beyond the size bound
+func (c *Store) add(ip string, info *domain.IPInfo, expires time.Time) {
+	if el, ok := c.entries[ip]; ok {
+		e := el.Value.(*entry)
+		e.info = info
+		e.expires = expires
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[ip] = c.lru.PushFront(&entry{ip: ip, info: info, expires: expires})
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).ip)
+		c.stats.Evictions++
+	}
+}
+
+// bump invalidates the values of an IP being read from the store
+func (c *Store) bump(ip string) {
+	if pending, ok := c.pending[ip]; ok {
+		pending.generation++
+	}
+}
+
+func (c *Store) remove(ip string) {
+	if el, ok := c.entries[ip]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, ip)
+	}
+}
+
+func copyInfo(info *domain.IPInfo) *domain.IPInfo {
+	cached := *info
+	return &cached
 }
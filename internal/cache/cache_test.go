@@ -0,0 +1,329 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// slowStore holds the next Get after reading the store, until released
+type slowStore struct {
+	*database.MemoryStore
+
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) Get(ip string) (*domain.IPInfo, bool) {
+	info, ok := s.MemoryStore.Get(ip)
+	if s.reading != nil {
+		close(s.reading)
+		s.reading = nil
+		<-s.release
+	}
+	return info, ok
+}
+
+func newTestStore(maxEntries int) (*Store, *database.MemoryStore) {
+	db := database.NewMemoryStore(time.Hour)
+	return New(db, time.Hour, time.Hour, maxEntries), db
+}
+
+func TestGet(t *testing.T) {
+	c, db := newTestStore(10)
+	db.Set(&domain.IPInfo{Address: "192.0.2.1", Score: 80})
+
+	for range 2 {
+		info, ok := c.Get("192.0.2.1")
+		if !ok || info.Score != 80 {
+			t.Fatalf("Get() = %+v, %t", info, ok)
+		}
+		// Callers get a copy of the cached entry
+		info.Score = 0
+	}
+
+	// The cache answers without the store
+	db.Delete("192.0.2.1")
+	if info, ok := c.Get("192.0.2.1"); !ok || info.Score != 80 {
+		t.Errorf("Get() = %+v, %t, want the cached entry", info, ok)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 || stats.MaxEntries != 10 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	c, db := newTestStore(2)
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		db.Set(&domain.IPInfo{Address: ip})
+	}
+
+	c.Get("192.0.2.1")
+	c.Get("192.0.2.2")
+	// 192.0.2.1 is now the most recently used
+	c.Get("192.0.2.1")
+	c.Get("192.0.2.3")
+
+	tests := []struct {
+		ip         string
+		wantCached bool
+	}{
+		{ip: "192.0.2.1", wantCached: true},
+		{ip: "192.0.2.2", wantCached: false},
+		{ip: "192.0.2.3", wantCached: true},
+	}
+	for _, tt := range tests {
+		if _, ok := c.Lookup(tt.ip); ok != tt.wantCached {
+			t.Errorf("Lookup(%s) cached = %t, want %t", tt.ip, ok, tt.wantCached)
+		}
+	}
+
+	stats := c.Stats()
+	if stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("stats = %+v, want 2 entries and 1 eviction", stats)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c, _ := newTestStore(10)
+
+	// Entries expire ttl after their reputation check
+	c.Remember(&domain.IPInfo{Address: "192.0.2.1", Timestamp: time.Now().Add(-2 * time.Hour)})
+	c.Remember(&domain.IPInfo{Address: "192.0.2.2"})
+
+	if _, ok := c.Lookup("192.0.2.1"); ok {
+		t.Error("expired entry returned")
+	}
+	if _, ok := c.Lookup("192.0.2.2"); !ok {
+		t.Error("remembered entry missing")
+	}
+	if entries := c.Stats().Entries; entries != 1 {
+		t.Errorf("%d entries, want the expired one removed", entries)
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	db := database.NewMemoryStore(time.Hour)
+	c := New(db, time.Hour, 50*time.Millisecond, 10)
+
+	if _, ok := c.Get("192.0.2.1"); ok {
+		t.Fatal("Get() found an unknown IP")
+	}
+
+	// The miss is remembered, the store is not asked again
+	db.Set(&domain.IPInfo{Address: "192.0.2.1"})
+	if _, ok := c.Get("192.0.2.1"); ok {
+		t.Error("Get() did not remember the miss")
+	}
+	if _, ok := c.Lookup("192.0.2.1"); ok {
+		t.Error("Lookup() returned a cached miss")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := c.Get("192.0.2.1"); !ok {
+		t.Error("Get() still remembers the miss after the negative TTL")
+	}
+
+	stats := c.Stats()
+	if stats.NegativeHits != 1 || stats.Misses != 3 {
+		t.Errorf("stats = %+v, want 1 negative hit and 3 misses", stats)
+	}
+}
+
+func TestSetMerge(t *testing.T) {
+	blockedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name        string
+		cached      *domain.IPInfo
+		set         domain.IPInfo
+		wantBlocked bool
+		wantAt      time.Time
+		wantManual  bool
+	}{
+		{
+			// A new check does not unblock an IP
+			name:        "blocked",
+			cached:      &domain.IPInfo{BlockedInFW: true, BlockedAt: blockedAt},
+			set:         domain.IPInfo{Score: 90},
+			wantBlocked: true,
+			wantAt:      blockedAt,
+		},
+		{
+			name:        "newly blocked",
+			cached:      &domain.IPInfo{},
+			set:         domain.IPInfo{Score: 90, BlockedInFW: true},
+			wantBlocked: true,
+		},
+		{
+			// The time and manual unblock are only changed by the Mark methods
+			name:       "manually unblocked",
+			cached:     &domain.IPInfo{BlockedAt: blockedAt, ManualUnblock: true},
+			set:        domain.IPInfo{Score: 90, BlockedAt: time.Now()},
+			wantAt:     blockedAt,
+			wantManual: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestStore(10)
+			cached := *tt.cached
+			cached.Address = "192.0.2.1"
+			c.Remember(&cached)
+
+			set := tt.set
+			set.Address = "192.0.2.1"
+			if err := c.Set(&set); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			info, ok := c.Lookup("192.0.2.1")
+			if !ok {
+				t.Fatal("Lookup() missed the entry")
+			}
+			if info.Score != 90 || info.Timestamp.IsZero() {
+				t.Errorf("entry = %+v, want the new check", info)
+			}
+			if info.BlockedInFW != tt.wantBlocked || !info.BlockedAt.Equal(tt.wantAt) || info.ManualUnblock != tt.wantManual {
+				t.Errorf("blocked = %t at %s, manual = %t, want %t at %s, %t",
+					info.BlockedInFW, info.BlockedAt, info.ManualUnblock, tt.wantBlocked, tt.wantAt, tt.wantManual)
+			}
+		})
+	}
+}
+
+func TestSetUncached(t *testing.T) {
+	c, db := newTestStore(10)
+	db.Set(&domain.IPInfo{Address: "192.0.2.1"})
+	db.MarkBlocked("192.0.2.1")
+
+	// Without a cached state, the entry is read back from the store
+	if err := c.Set(&domain.IPInfo{Address: "192.0.2.1", Score: 90}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, ok := c.Lookup("192.0.2.1"); ok {
+		t.Error("uncached entry cached by Set()")
+	}
+	if info, ok := c.Get("192.0.2.1"); !ok || info.Score != 90 || !info.BlockedInFW {
+		t.Errorf("Get() = %+v, %t, want the merged entry of the store", info, ok)
+	}
+
+	// A cached miss is dropped as well
+	c.Get("192.0.2.2")
+	c.Set(&domain.IPInfo{Address: "192.0.2.2"})
+	if _, ok := c.Get("192.0.2.2"); !ok {
+		t.Error("Get() returned the cached miss after Set()")
+	}
+}
+
+func TestMarks(t *testing.T) {
+	c, _ := newTestStore(10)
+	if err := c.Set(&domain.IPInfo{Address: "192.0.2.1"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	c.Get("192.0.2.1")
+
+	c.MarkBlocked("192.0.2.1")
+	info, _ := c.Lookup("192.0.2.1")
+	if !info.BlockedInFW || info.BlockedAt.IsZero() {
+		t.Errorf("entry = %+v, want it blocked", info)
+	}
+	blockedAt := info.BlockedAt
+
+	// Blocking again keeps the time of the first block
+	c.MarkBlocked("192.0.2.1")
+	if info, _ := c.Lookup("192.0.2.1"); !info.BlockedAt.Equal(blockedAt) {
+		t.Errorf("blocked at %s, want %s", info.BlockedAt, blockedAt)
+	}
+
+	c.MarkUnblocked("192.0.2.1")
+	c.MarkManualUnblock("192.0.2.1")
+	if info, _ := c.Lookup("192.0.2.1"); info.BlockedInFW || !info.ManualUnblock {
+		t.Errorf("entry = %+v, want it manually unblocked", info)
+	}
+
+	c.MarkBlocked("192.0.2.1")
+	if info, _ := c.Lookup("192.0.2.1"); info.ManualUnblock {
+		t.Error("blocking did not clear the manual unblock")
+	}
+}
+
+// TestGetRace checks that a value read from the store before a write is
+// not cached after it
+func TestGetRace(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(c *Store) error
+		check func(t *testing.T, info *domain.IPInfo, ok bool)
+	}{
+		{
+			name:  "set",
+			write: func(c *Store) error { return c.Set(&domain.IPInfo{Address: "192.0.2.1", Score: 90}) },
+			check: func(t *testing.T, info *domain.IPInfo, ok bool) {
+				if !ok || info.Score != 90 {
+					t.Errorf("Get() = %+v, %t, want the new score", info, ok)
+				}
+			},
+		},
+		{
+			name:  "delete",
+			write: func(c *Store) error { return c.Delete("192.0.2.1") },
+			check: func(t *testing.T, info *domain.IPInfo, ok bool) {
+				if ok {
+					t.Errorf("Get() = %+v, want the entry deleted", info)
+				}
+			},
+		},
+		{
+			name:  "mark blocked",
+			write: func(c *Store) error { return c.MarkBlocked("192.0.2.1") },
+			check: func(t *testing.T, info *domain.IPInfo, ok bool) {
+				if !ok || !info.BlockedInFW {
+					t.Errorf("Get() = %+v, %t, want the entry blocked", info, ok)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &slowStore{
+				MemoryStore: database.NewMemoryStore(time.Hour),
+				reading:     make(chan struct{}),
+				release:     make(chan struct{}),
+			}
+			db.Set(&domain.IPInfo{Address: "192.0.2.1", Score: 10})
+			c := New(db, time.Hour, time.Hour, 10)
+
+			reading := db.reading
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				if info, ok := c.Get("192.0.2.1"); !ok || info.Score != 10 {
+					t.Errorf("racing Get() = %+v, %t, want the old entry", info, ok)
+				}
+			}()
+
+			<-reading
+			if err := tt.write(c); err != nil {
+				t.Fatalf("write error = %v", err)
+			}
+			close(db.release)
+			<-done
+
+			if _, ok := c.Lookup("192.0.2.1"); ok {
+				t.Error("stale entry cached")
+			}
+			if len(c.pending) != 0 {
+				t.Errorf("%d pending reads left", len(c.pending))
+			}
+			info, ok := c.Get("192.0.2.1")
+			tt.check(t, info, ok)
+		})
+	}
+}
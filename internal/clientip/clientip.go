@@ -0,0 +1,141 @@
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/netlist"
+)
+
+// Extractor finds the address of the client behind a request. A single
+// forwarding header is honoured, and only when the connecting peer is a
+// trusted proxy, otherwise anybody could spoof their address by sending
+// it. Proxies pass the other headers sent by clients through unchanged.
+type Extractor struct {
+	trusted *netlist.PrefixSet
+	header  string
+}
+
+// New creates an extractor trusting the given proxies to set header, one
+// of the config.Header* values. A nil or empty set means forwarding
+// headers are always ignored.
+func New(trusted *netlist.PrefixSet, header string) *Extractor {
+	if header == "" {
+		header = config.HeaderXForwardedFor
+	}
+	return &Extractor{trusted: trusted, header: header}
+}
+
+// FromRequest returns the client address of the request. For requests
+// coming from a trusted proxy it is read from the configured header.
+// Address chains of X-Forwarded-For and Forwarded (RFC 7239) are walked
+// right-to-left and the first address that is not a trusted proxy is the
+// client.
+func (e *Extractor) FromRequest(r *http.Request) string {
+	peer, ok := parseHostPort(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+
+	if !e.isTrusted(peer) {
+		return peer.String()
+	}
+
+	switch e.header {
+	case config.HeaderForwarded:
+		if chain := forwardedChain(r.Header.Values("Forwarded")); len(chain) > 0 {
+			return e.walk(chain, peer).String()
+		}
+	case config.HeaderXForwardedFor:
+		if chain := xffChain(r.Header.Values("X-Forwarded-For")); len(chain) > 0 {
+			return e.walk(chain, peer).String()
+		}
+	default:
+		if addr, ok := parseHostPort(r.Header.Get(e.header)); ok {
+			return addr.String()
+		}
+	}
+
+	return peer.String()
+}
+
+// walk returns the rightmost untrusted address of the chain. If an entry
+// cannot be parsed the walk stops at the last valid hop, and if every hop
+// is trusted the leftmost one is returned.
+func (e *Extractor) walk(chain []string, peer netip.Addr) netip.Addr {
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseHostPort(chain[i])
+		if !ok {
+			return client
+		}
+
+		client = addr
+		if !e.isTrusted(addr) {
+			return client
+		}
+	}
+	return client
+}
+
+func (e *Extractor) isTrusted(addr netip.Addr) bool {
+	return e.trusted.Contains(addr)
+}
+
+// xffChain splits X-Forwarded-For header values into a single chain
+func xffChain(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				chain = append(chain, entry)
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedChain extracts the "for" parameters of Forwarded header values,
+// for example: for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"
+func forwardedChain(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(key, "for") {
+					continue
+				}
+				// Obfuscated identifiers and "unknown" keep their place in
+				// the chain so that the walk stops on them
+				chain = append(chain, strings.Trim(strings.TrimSpace(val), `"`))
+			}
+		}
+	}
+	return chain
+}
+
+// parseHostPort parses an address with an optional port:
+// "192.168.1.1", "192.168.1.1:12345", "::1", "[::1]" or "[::1]:12345"
+func parseHostPort(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return netip.Addr{}, false
+	}
+
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	} else {
+		value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap().WithZone(""), true
+}
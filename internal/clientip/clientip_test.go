@@ -0,0 +1,271 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/netlist"
+)
+
+func TestFromRequest(t *testing.T) {
+	trusted, err := netlist.Parse([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("netlist.Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+		header  string
+		trusted *netlist.PrefixSet
+		want    string
+	}{
+		// Direct connections
+		{name: "no header", remote: "198.51.100.7:4242", want: "198.51.100.7"},
+		{name: "ipv6 peer", remote: "[2001:db8::7]:4242", want: "2001:db8::7"},
+		{name: "ipv6 peer with zone", remote: "[fe80::1%eth0]:4242", want: "fe80::1"},
+		{name: "mapped ipv4 peer", remote: "[::ffff:198.51.100.7]:4242", want: "198.51.100.7"},
+		{name: "peer without port", remote: "198.51.100.7", want: "198.51.100.7"},
+		{name: "unparsable peer", remote: "@", want: "@"},
+
+		// Spoofing attempts from untrusted peers
+		{
+			name:    "untrusted xff",
+			remote:  "198.51.100.7:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "untrusted forwarded",
+			remote:  "198.51.100.7:4242",
+			headers: map[string][]string{"Forwarded": {"for=203.0.113.1"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "untrusted real ip",
+			remote:  "198.51.100.7:4242",
+			headers: map[string][]string{"X-Real-Ip": {"203.0.113.1"}, "Cf-Connecting-Ip": {"203.0.113.2"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "no trusted proxy configured",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1"}},
+			trusted: netlist.NewPrefixSet(),
+			want:    "10.0.0.1",
+		},
+
+		// X-Forwarded-For through trusted proxies
+		{
+			name:    "single hop",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1"}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "multi hop",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1, 10.0.0.3, 10.0.0.2"}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "multi hop over several headers",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1", "10.0.0.2"}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "client prepends a spoofed address",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"1.1.1.1, 203.0.113.1, 10.0.0.2"}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "every hop trusted",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			want:    "10.0.0.3",
+		},
+		{
+			name:    "hop with port",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1:5555"}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "ipv6 hops",
+			remote:  "[fd00::1]:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"2001:db8::1, [fd00::2]:80"}},
+			want:    "2001:db8::1",
+		},
+		{
+			name:    "ipv6 hop with zone",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"fe80::1%25eth0, fe80::2%eth0"}},
+			want:    "fe80::2",
+		},
+		{
+			name:    "malformed last hop",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1, garbage"}},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "malformed hop behind a trusted one",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.1, garbage, 10.0.0.2"}},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "empty entries",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {" , 203.0.113.1 ,, "}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "only commas",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Forwarded-For": {",,"}},
+			want:    "10.0.0.1",
+		},
+
+		// Forwarded (RFC 7239)
+		{
+			name:    "forwarded",
+			remote:  "10.0.0.1:4242",
+			header:  config.HeaderForwarded,
+			headers: map[string][]string{"Forwarded": {"for=192.0.2.60;proto=http;by=203.0.113.43"}},
+			want:    "192.0.2.60",
+		},
+		{
+			name:    "forwarded ipv6 with port",
+			remote:  "10.0.0.1:4242",
+			header:  config.HeaderForwarded,
+			headers: map[string][]string{"Forwarded": {`For="[2001:db8:cafe::17]:4711"`}},
+			want:    "2001:db8:cafe::17",
+		},
+		{
+			name:    "forwarded multi hop",
+			remote:  "10.0.0.1:4242",
+			header:  config.HeaderForwarded,
+			headers: map[string][]string{"Forwarded": {"for=192.0.2.43, for=10.0.0.2", "for=10.0.0.3"}},
+			want:    "192.0.2.43",
+		},
+		{
+			name:    "forwarded obfuscated hop",
+			remote:  "10.0.0.1:4242",
+			header:  config.HeaderForwarded,
+			headers: map[string][]string{"Forwarded": {"for=192.0.2.43, for=_hidden, for=10.0.0.2"}},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "forwarded unknown hop",
+			remote:  "10.0.0.1:4242",
+			header:  config.HeaderForwarded,
+			headers: map[string][]string{"Forwarded": {"for=unknown"}},
+			want:    "10.0.0.1",
+		},
+		// Headers other than the configured one are ignored, since proxies
+		// pass them through from the client
+		{
+			name:   "forwarded ignored when reading xff",
+			remote: "10.0.0.1:4242",
+			headers: map[string][]string{
+				"Forwarded":       {"for=192.0.2.60"},
+				"X-Forwarded-For": {"203.0.113.1"},
+			},
+			want: "203.0.113.1",
+		},
+		{
+			name:    "forwarded alone ignored when reading xff",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"Forwarded": {"for=192.0.2.60"}},
+			want:    "10.0.0.1",
+		},
+		{
+			name:   "xff ignored when reading forwarded",
+			remote: "10.0.0.1:4242",
+			header: config.HeaderForwarded,
+			headers: map[string][]string{
+				"Forwarded":       {"proto=https;by=10.0.0.2"},
+				"X-Forwarded-For": {"203.0.113.1"},
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name:    "real ip ignored when reading xff",
+			remote:  "10.0.0.1:4242",
+			headers: map[string][]string{"X-Real-Ip": {"203.0.113.1"}, "Cf-Connecting-Ip": {"203.0.113.2"}},
+			want:    "10.0.0.1",
+		},
+
+		// Single address headers
+		{
+			name:    "real ip",
+			remote:  "10.0.0.1:4242",
+			header:  config.HeaderXRealIP,
+			headers: map[string][]string{"X-Real-Ip": {"203.0.113.1"}},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "cloudflare",
+			remote:  "10.0.0.1:4242",
+			header:  config.HeaderCFConnectingIP,
+			headers: map[string][]string{"Cf-Connecting-Ip": {"2001:db8::9"}},
+			want:    "2001:db8::9",
+		},
+		{
+			name:    "cloudflare ignores real ip",
+			remote:  "10.0.0.1:4242",
+			header:  config.HeaderCFConnectingIP,
+			headers: map[string][]string{"X-Real-Ip": {"203.0.113.1"}, "X-Forwarded-For": {"203.0.113.2"}},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "malformed real ip",
+			remote:  "10.0.0.1:4242",
+			header:  config.HeaderXRealIP,
+			headers: map[string][]string{"X-Real-Ip": {"not an ip"}, "Cf-Connecting-Ip": {"203.0.113.2"}},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "untrusted configured header",
+			remote:  "198.51.100.7:4242",
+			header:  config.HeaderXRealIP,
+			headers: map[string][]string{"X-Real-Ip": {"203.0.113.1"}},
+			want:    "198.51.100.7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := trusted
+			if tt.trusted != nil {
+				set = tt.trusted
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for key, values := range tt.headers {
+				r.Header[key] = values
+			}
+
+			if got := New(set, tt.header).FromRequest(r); got != tt.want {
+				t.Errorf("FromRequest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromRequestWithoutTrustedProxies(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:4242"
+	r.Header.Set("X-Forwarded-For", "203.0.113.1")
+
+	if got := New(nil, "").FromRequest(r); got != "10.0.0.1" {
+		t.Errorf("FromRequest() = %q, want the peer address", got)
+	}
+}
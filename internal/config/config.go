@@ -4,21 +4,51 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Configuration struct {
-	Notifications NotificationConfig `yaml:"notifications"`
-	Unifi         []UnifiConfig      `yaml:"unifi"`
-	AbuseIP       AbuseIPConfig      `yaml:"abuseip"`
-	RateLimit     RateLimitConfig    `yaml:"ratelimit,omitempty"`
-	Database      DatabaseConfig     `yaml:"database,omitempty"`
-	Payload       PayloadConfig      `yaml:"payload,omitempty"`
-	Dashboard     DashboardConfig    `yaml:"dashboard,omitempty"`
-	ExcludedIPs   []string           `yaml:"excluded_ips,omitempty"`
+	Notifications   NotificationConfig `yaml:"notifications"`
+	Unifi           []UnifiConfig      `yaml:"unifi"`
+	Firewalls       []FirewallConfig   `yaml:"firewalls,omitempty"`
+	Blocking        BlockingConfig     `yaml:"blocking,omitempty"`
+	AbuseIP         AbuseIPConfig      `yaml:"abuseip"`
+	Reputation      ReputationConfig   `yaml:"reputation,omitempty"`
+	GeoIP           GeoIPConfig        `yaml:"geoip,omitempty"`
+	Policy          PolicyConfig       `yaml:"policy,omitempty"`
+	RateLimit       RateLimitConfig    `yaml:"ratelimit,omitempty"`
+	Database        DatabaseConfig     `yaml:"database,omitempty"`
+	Payload         PayloadConfig      `yaml:"payload,omitempty"`
+	Dashboard       DashboardConfig    `yaml:"dashboard,omitempty"`
+	ExcludedIPs     []string           `yaml:"excluded_ips,omitempty"`
+	ExcludedIPsFile string             `yaml:"excluded_ips_file,omitempty"`
+	ClientIP        ClientIPConfig     `yaml:"client_ip,omitempty"`
+	Listeners       []ListenerConfig   `yaml:"listeners,omitempty"`
+	TLSFingerprints []TLSFingerprint   `yaml:"tls_fingerprints,omitempty"`
+	Retention       RetentionConfig    `yaml:"retention,omitempty"`
+	Cache           CacheConfig        `yaml:"cache,omitempty"`
+	Pipeline        PipelineConfig     `yaml:"pipeline,omitempty"`
 }
 
+// ClientIPConfig sets how the client address is found behind reverse
+// proxies. Header is only read on requests from TrustedProxies.
+type ClientIPConfig struct {
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+	Header         string   `yaml:"header,omitempty"`
+}
+
+// Client address headers
+const (
+	HeaderXForwardedFor  = "x-forwarded-for"
+	HeaderForwarded      = "forwarded"
+	HeaderXRealIP        = "x-real-ip"
+	HeaderCFConnectingIP = "cf-connecting-ip"
+)
+
 type NotificationConfig struct {
 	TelegramNotification []TelegramNotificationConfig `yaml:"telegram"`
 }
@@ -35,17 +65,310 @@ type UnifiConfig struct {
 	Password string `yaml:"password"`
 }
 
+// FirewallConfig selects and configures a firewall backend
+type FirewallConfig struct {
+	Type  string      `yaml:"type"`
+	Name  string      `yaml:"name,omitempty"`
+	Unifi UnifiConfig `yaml:"unifi,omitempty"`
+	Set   SetConfig   `yaml:"set,omitempty"`
+}
+
+// SetConfig configures the local nftables and ipset backends
+type SetConfig struct {
+	Family  string        `yaml:"family,omitempty"`
+	Table   string        `yaml:"table,omitempty"`
+	IPv4    string        `yaml:"ipv4,omitempty"`
+	IPv6    string        `yaml:"ipv6,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	Create  bool          `yaml:"create,omitempty"`
+}
+
+// BlockingConfig controls how long IPs stay blocked in the firewalls
+type BlockingConfig struct {
+	Duration          time.Duration `yaml:"duration,omitempty"`
+	ReconcileInterval time.Duration `yaml:"reconcile_interval,omitempty"`
+}
+
+// ReputationConfig lists the reputation providers and how their scores
+// are combined
+type ReputationConfig struct {
+	Mode      string           `yaml:"mode,omitempty"`
+	Providers []ProviderConfig `yaml:"providers,omitempty"`
+}
+
+// ProviderConfig configures a reputation provider. Fields not used by a
+// provider type are ignored.
+type ProviderConfig struct {
+	Type    string        `yaml:"type"`
+	Name    string        `yaml:"name,omitempty"`
+	APIKey  string        `yaml:"api_key,omitempty"`
+	URL     string        `yaml:"url,omitempty"`
+	Path    string        `yaml:"path,omitempty"`
+	Score   int           `yaml:"score,omitempty"`
+	Weight  float64       `yaml:"weight,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// Format of a blocklist feed: text (addresses and prefixes, including
+	// FireHOL and Spamhaus DROP lists) or spamhaus_json
+	Format string `yaml:"format,omitempty"`
+	// Refresh is how often a blocklist feed is downloaded
+	Refresh time.Duration `yaml:"refresh,omitempty"`
+
+	// FailureThreshold is the number of consecutive failures that opens
+	// the circuit breaker, negative to disable it
+	FailureThreshold int `yaml:"failure_threshold,omitempty"`
+	// BreakerCooldown is how long the breaker stays open before a new try
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown,omitempty"`
+
+	// MaxAgeInDays limits the age of the AbuseIPDB reports considered
+	MaxAgeInDays int `yaml:"max_age_in_days,omitempty"`
+	// APIKeys spreads the AbuseIPDB lookups over several keys, picked
+	// according to KeySelection
+	APIKeys      []APIKeyConfig `yaml:"api_keys,omitempty"`
+	KeySelection string         `yaml:"key_selection,omitempty"`
+
+	// Threshold is policy.threshold, set at load time. Providers flag the
+	// IPs scoring above it as malicious.
+	Threshold *int `yaml:"-"`
+}
+
+// MaliciousThreshold returns the score above which the provider flags an
+// IP as malicious, DefaultPolicyThreshold if unset
+func (p *ProviderConfig) MaliciousThreshold() int {
+	if p.Threshold == nil {
+		return DefaultPolicyThreshold
+	}
+	return *p.Threshold
+}
+
+// APIKeyConfig is an API key with its daily budget of lookups, 0 for
+// unlimited. The name identifies its usage counters; it defaults to a
+// hash of the key.
+type APIKeyConfig struct {
+	Name       string `yaml:"name,omitempty"`
+	Key        string `yaml:"key"`
+	DailyLimit int    `yaml:"daily_limit,omitempty"`
+}
+
+// API key selection strategies
+const (
+	// KeyRoundRobin uses the keys in turn
+	KeyRoundRobin = "round_robin"
+	// KeyLeastUsed uses the key with the fewest requests today
+	KeyLeastUsed = "least_used"
+)
+
+// Reputation aggregation modes
+const (
+	// ReputationMax keeps the highest provider score
+	ReputationMax = "max"
+	// ReputationWeighted averages the provider scores by weight
+	ReputationWeighted = "weighted"
+	// ReputationAnyOf gives the highest score as soon as one provider
+	// flags the IP as malicious
+	ReputationAnyOf = "any_of"
+)
+
+// Reputation provider defaults
+const (
+	// DefaultProviderTimeout is the default timeout of reputation API requests
+	DefaultProviderTimeout = 10 * time.Second
+	// DefaultFailureThreshold is the default number of consecutive
+	// failures that opens the circuit breaker of a provider
+	DefaultFailureThreshold = 5
+	// DefaultBreakerCooldown is how long a circuit breaker stays open
+	DefaultBreakerCooldown = 1 * time.Minute
+)
+
+// RetentionConfig controls how long data is trusted and kept
+type RetentionConfig struct {
+	// RecheckTTL is how long a reputation check is reused before the IP is checked again
+	RecheckTTL time.Duration `yaml:"recheck_ttl,omitempty"`
+	// Records is how long IP records are kept after their last check
+	Records time.Duration `yaml:"records,omitempty"`
+	// Events is how long connection attempts are kept
+	Events time.Duration `yaml:"events,omitempty"`
+	// Payloads is how long payload files are kept
+	Payloads time.Duration `yaml:"payloads,omitempty"`
+	// Interval is the period of the retention job
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+// GeoIPConfig lists the MaxMind DB (.mmdb) files used to locate IPs,
+// checked for changes every ReloadInterval
+type GeoIPConfig struct {
+	Databases      []string      `yaml:"databases,omitempty"`
+	ReloadInterval time.Duration `yaml:"reload_interval,omitempty"`
+}
+
+// PolicyConfig decides what happens to IPs beyond their reputation score.
+// IPs scoring above Threshold are high risk: they are tarpitted and
+// blocked. Unset means DefaultPolicyThreshold, 0 makes every IP with a
+// score high risk.
+type PolicyConfig struct {
+	Threshold *int         `yaml:"threshold,omitempty"`
+	Rules     []PolicyRule `yaml:"rules,omitempty"`
+}
+
+// DefaultPolicyThreshold is the score above which IPs are high risk
+const DefaultPolicyThreshold = 75
+
+// PolicyRule applies an action to the hits matching every criterion it
+// sets. A rule without criteria matches every hit.
+type PolicyRule struct {
+	Name      string   `yaml:"name,omitempty"`
+	Countries []string `yaml:"countries,omitempty"`
+	ASNs      []uint   `yaml:"asns,omitempty"`
+	Listeners []string `yaml:"listeners,omitempty"`
+	Action    string   `yaml:"action"`
+	// Score is added to the score of the IP by the score action, and may
+	// be negative
+	Score int `yaml:"score,omitempty"`
+}
+
+// Policy actions
+const (
+	// PolicyBlock always blocks the IP, even if a provider whitelists it
+	PolicyBlock = "block"
+	// PolicyAllow never blocks, tarpits nor reports the IP
+	PolicyAllow = "allow"
+	// PolicyScore raises or lowers the score of the IP
+	PolicyScore = "score"
+)
+
+// CacheConfig bounds the in-memory cache in front of the database. A
+// negative MaxEntries disables the cache.
+type CacheConfig struct {
+	MaxEntries  int           `yaml:"max_entries,omitempty"`
+	NegativeTTL time.Duration `yaml:"negative_ttl,omitempty"`
+}
+
+// PipelineConfig sizes the worker pool doing reputation lookups and
+// blocking out of the connection handlers
+type PipelineConfig struct {
+	Workers   int `yaml:"workers,omitempty"`
+	QueueSize int `yaml:"queue_size,omitempty"`
+}
+
+// Pipeline defaults
+const (
+	DefaultPipelineWorkers   = 4
+	DefaultPipelineQueueSize = 1000
+)
+
+// Retention defaults
+const (
+	DefaultRecheckTTL        = 1 * time.Hour
+	DefaultRetention         = 30 * 24 * time.Hour
+	DefaultRetentionInterval = 10 * time.Minute
+)
+
+// ProxyProtocolConfig enables PROXY protocol v1/v2 on the detection listener
+type ProxyProtocolConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	AllowedSources []string `yaml:"allowed_sources"`
+}
+
+// ListenerConfig describes a detection listener
+type ListenerConfig struct {
+	Name          string              `yaml:"name,omitempty"`
+	Address       string              `yaml:"address"`
+	Protocol      string              `yaml:"protocol,omitempty"`
+	TLS           ListenerTLSConfig   `yaml:"tls,omitempty"`
+	Response      string              `yaml:"response,omitempty"`
+	StatusCode    int                 `yaml:"status_code,omitempty"`
+	ProxyProtocol ProxyProtocolConfig `yaml:"proxy_protocol,omitempty"`
+}
+
+// ListenerTLSConfig holds the certificate of an HTTPS listener. A
+// self-signed certificate is generated when both files are empty.
+type ListenerTLSConfig struct {
+	Cert  string   `yaml:"cert,omitempty"`
+	Key   string   `yaml:"key,omitempty"`
+	Hosts []string `yaml:"hosts,omitempty"`
+}
+
+// TLSFingerprint adjusts the score of IPs whose ClientHello matches a
+// known JA3 hash or JA4 fingerprint. Score is added to the reputation
+// score, which is then clamped to 0-100.
+type TLSFingerprint struct {
+	Name  string `yaml:"name,omitempty"`
+	JA3   string `yaml:"ja3,omitempty"`
+	JA4   string `yaml:"ja4,omitempty"`
+	Score int    `yaml:"score"`
+}
+
+// DefaultListenAddr is the address of the listener used when none is configured
+const DefaultListenAddr = ":8888"
+
+// Listener protocols
+const (
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+	ProtocolTCP   = "tcp"
+)
+
+// Listener response policies
+const (
+	// ResponseAuto tarpits high-risk IPs and drops the others
+	ResponseAuto = "auto"
+	// ResponseTarpit always tarpits
+	ResponseTarpit = "tarpit"
+	// ResponseDrop always closes the connection
+	ResponseDrop = "drop"
+	// ResponseStatus answers with an empty HTTP response using StatusCode
+	ResponseStatus = "status"
+)
+
+// AbuseIPConfig is the legacy AbuseIPDB section. Its provider fields are
+// turned into an abuseipdb reputation provider at load time.
 type AbuseIPConfig struct {
-	APIKey string `yaml:"api_key"`
+	ProviderConfig `yaml:",inline"`
+	Report         AbuseIPReportConfig `yaml:"report,omitempty"`
 }
 
+// AbuseIPReportConfig enables reporting the detected IPs to AbuseIPDB
+type AbuseIPReportConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// APIKey defaults to the key of the first abuseipdb reputation
+	// provider, which abuseip.api_key configures
+	APIKey string `yaml:"api_key,omitempty"`
+	URL    string `yaml:"url,omitempty"`
+	// Template builds the report comment with text/template
+	Template string `yaml:"template,omitempty"`
+	// MinScore skips IPs scoring below it. Unset means
+	// DefaultReportMinScore, 0 reports every IP.
+	MinScore *int `yaml:"min_score,omitempty"`
+	// DailyLimit is the number of reports sent per UTC day
+	DailyLimit int `yaml:"daily_limit,omitempty"`
+	// Cooldown is the delay between two reports of an IP, at least the
+	// 15 minutes enforced by AbuseIPDB
+	Cooldown time.Duration `yaml:"cooldown,omitempty"`
+	// Timeout defaults to the timeout of that provider
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// AbuseIPDB report defaults
+const (
+	// DefaultReportMinScore only reports IPs already reported by others,
+	// rather than every client reaching a listener
+	DefaultReportMinScore   = 25
+	DefaultReportDailyLimit = 1000
+	DefaultReportCooldown   = 15 * time.Minute
+	DefaultReportTemplate   = `Unsolicited {{.Listener}} connection: {{.Method}} {{.Path}}{{if .UserAgent}} (User-Agent: {{.UserAgent}}){{end}}`
+)
+
 type RateLimitConfig struct {
 	RequestsPerMinute int  `yaml:"requests_per_minute"`
 	Enabled           bool `yaml:"enabled"`
 }
 
+// DatabaseConfig selects the storage backend: sqlite (default, uses
+// Path), postgres (uses DSN) or memory
 type DatabaseConfig struct {
-	Path string `yaml:"path"`
+	Driver string `yaml:"driver,omitempty"`
+	Path   string `yaml:"path"`
+	DSN    string `yaml:"dsn,omitempty"`
 }
 
 type PayloadConfig struct {
@@ -55,8 +378,23 @@ type PayloadConfig struct {
 }
 
 type DashboardConfig struct {
-	Enabled bool   `yaml:"enabled"`
-	Port    string `yaml:"port"`
+	Enabled bool                `yaml:"enabled"`
+	Port    string              `yaml:"port"`
+	TLSCert string              `yaml:"tls_cert,omitempty"`
+	TLSKey  string              `yaml:"tls_key,omitempty"`
+	Auth    DashboardAuthConfig `yaml:"auth,omitempty"`
+}
+
+// DashboardAuthConfig lists the credentials accepted by the dashboard
+type DashboardAuthConfig struct {
+	Users  []DashboardUser `yaml:"users,omitempty"`
+	Tokens []string        `yaml:"tokens,omitempty"`
+}
+
+// DashboardUser is a basic auth user with a bcrypt password hash
+type DashboardUser struct {
+	Username     string `yaml:"username"`
+	PasswordHash string `yaml:"password_hash"`
 }
 
 func LoadConfiguration(path string) (*Configuration, error) {
@@ -90,15 +428,84 @@ func LoadConfiguration(path string) (*Configuration, error) {
 		conf.Payload.Directory = "./payloads"
 	}
 
+	// Without listeners, keep the historical single HTTP listener
+	if len(conf.Listeners) == 0 {
+		conf.Listeners = []ListenerConfig{{
+			Name:    "default",
+			Address: DefaultListenAddr,
+		}}
+	}
+
+	for i := range conf.Listeners {
+		if err := conf.Listeners[i].setDefaults(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, fp := range conf.TLSFingerprints {
+		if fp.JA3 == "" && fp.JA4 == "" {
+			return nil, fmt.Errorf("tls_fingerprints[%d]: ja3 or ja4 is required", i)
+		}
+	}
+
+	if err := conf.ClientIP.setDefaults(); err != nil {
+		return nil, err
+	}
+
+	if conf.Blocking.ReconcileInterval == 0 {
+		conf.Blocking.ReconcileInterval = 5 * time.Minute
+	}
+
+	if err := conf.Retention.setDefaults(); err != nil {
+		return nil, err
+	}
+
+	if err := conf.Reputation.setDefaults(conf.AbuseIP); err != nil {
+		return nil, err
+	}
+
+	if err := conf.Policy.setDefaults(conf.Listeners); err != nil {
+		return nil, err
+	}
+	for i := range conf.Reputation.Providers {
+		conf.Reputation.Providers[i].Threshold = conf.Policy.Threshold
+	}
+
+	if err := conf.AbuseIP.Report.setDefaults(conf.Reputation.Providers); err != nil {
+		return nil, err
+	}
+
+	if conf.Pipeline.Workers == 0 {
+		conf.Pipeline.Workers = DefaultPipelineWorkers
+	}
+	if conf.Pipeline.QueueSize == 0 {
+		conf.Pipeline.QueueSize = DefaultPipelineQueueSize
+	}
+	if conf.Pipeline.Workers < 0 || conf.Pipeline.QueueSize < 0 {
+		return nil, fmt.Errorf("pipeline: workers and queue_size must be positive")
+	}
+
 	if conf.Dashboard.Port == "" {
 		conf.Dashboard.Port = ":8080"
 	}
 
+	if (conf.Dashboard.TLSCert == "") != (conf.Dashboard.TLSKey == "") {
+		return nil, fmt.Errorf("dashboard: tls_cert and tls_key must be set together")
+	}
+
+	// Legacy top-level unifi entries are converted to firewall backends
+	for _, unifi := range conf.Unifi {
+		conf.Firewalls = append(conf.Firewalls, FirewallConfig{
+			Type:  "unifi",
+			Unifi: unifi,
+		})
+	}
+
 	defaultTemplate := `{{.Emoji}} *Accès direct par IP détecté*
 
 🌐 *IP:* {{.IP}}
-🌍 *Pays:* {{.Country}}
-📊 *Score AbuseIPDB:* {{.Score}}/100 ({{.Severity}})
+🌍 *Pays:* {{.Country}}{{if .City}} ({{.City}}){{end}}
+📊 *Score:* {{.Score}}/100 ({{.Severity}})
 🛡️ *Bloqué:* {{.Blocked}}
 📂 *Path:* {{.Path}}`
 
@@ -110,3 +517,279 @@ func LoadConfiguration(path string) (*Configuration, error) {
 
 	return &conf, nil
 }
+
+func (r *RetentionConfig) setDefaults() error {
+	if r.RecheckTTL == 0 {
+		r.RecheckTTL = DefaultRecheckTTL
+	}
+	if r.Records == 0 {
+		r.Records = DefaultRetention
+	}
+	if r.Events == 0 {
+		r.Events = DefaultRetention
+	}
+	if r.Payloads == 0 {
+		r.Payloads = DefaultRetention
+	}
+	if r.Interval == 0 {
+		r.Interval = DefaultRetentionInterval
+	}
+
+	if r.RecheckTTL < 0 || r.Records < 0 || r.Events < 0 || r.Payloads < 0 || r.Interval < 0 {
+		return fmt.Errorf("retention: durations must be positive")
+	}
+	if r.Records < r.RecheckTTL {
+		return fmt.Errorf("retention: records (%s) must not be shorter than recheck_ttl (%s)", r.Records, r.RecheckTTL)
+	}
+
+	return nil
+}
+
+// setDefaults validates the providers and fills in their defaults. The
+// legacy abuseip.api_key adds an AbuseIPDB provider if none is listed.
+func (r *ReputationConfig) setDefaults(abuseIP AbuseIPConfig) error {
+	switch r.Mode {
+	case "":
+		r.Mode = ReputationMax
+	case ReputationMax, ReputationWeighted, ReputationAnyOf:
+	default:
+		return fmt.Errorf("reputation: unknown mode %q", r.Mode)
+	}
+
+	if abuseIP.Type != "" && abuseIP.Type != "abuseipdb" {
+		return fmt.Errorf("abuseip: type must be abuseipdb, use reputation.providers for %q", abuseIP.Type)
+	}
+	if (abuseIP.APIKey != "" || len(abuseIP.APIKeys) > 0) && !slices.ContainsFunc(r.Providers, func(p ProviderConfig) bool { return p.Type == "abuseipdb" }) {
+		legacy := abuseIP.ProviderConfig
+		legacy.Type = "abuseipdb"
+		r.Providers = append([]ProviderConfig{legacy}, r.Providers...)
+	}
+
+	if len(r.Providers) == 0 {
+		return fmt.Errorf("reputation: no provider configured, set abuseip.api_key or reputation.providers")
+	}
+
+	names := make(map[string]bool, len(r.Providers))
+	for i := range r.Providers {
+		p := &r.Providers[i]
+		if p.Type == "" {
+			return fmt.Errorf("reputation.providers[%d]: type is required", i)
+		}
+		if p.Name == "" {
+			p.Name = p.Type
+		}
+		if names[p.Name] {
+			return fmt.Errorf("reputation.providers[%d]: duplicate name %q", i, p.Name)
+		}
+		names[p.Name] = true
+
+		if p.Weight == 0 {
+			p.Weight = 1
+		}
+		if p.Weight < 0 {
+			return fmt.Errorf("reputation.providers[%d]: weight must be positive", i)
+		}
+		if p.Timeout == 0 {
+			p.Timeout = DefaultProviderTimeout
+		}
+		if p.FailureThreshold == 0 {
+			p.FailureThreshold = DefaultFailureThreshold
+		}
+		if p.BreakerCooldown == 0 {
+			p.BreakerCooldown = DefaultBreakerCooldown
+		}
+		if err := p.setKeyDefaults(); err != nil {
+			return fmt.Errorf("reputation.providers[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// setKeyDefaults merges api_key into api_keys and validates the keys
+func (p *ProviderConfig) setKeyDefaults() error {
+	switch p.KeySelection {
+	case "":
+		p.KeySelection = KeyRoundRobin
+	case KeyRoundRobin, KeyLeastUsed:
+	default:
+		return fmt.Errorf("unknown key_selection %q", p.KeySelection)
+	}
+
+	if len(p.APIKeys) == 0 {
+		return nil
+	}
+	if p.APIKey != "" && !slices.ContainsFunc(p.APIKeys, func(k APIKeyConfig) bool { return k.Key == p.APIKey }) {
+		p.APIKeys = append([]APIKeyConfig{{Key: p.APIKey}}, p.APIKeys...)
+	}
+
+	names := make(map[string]bool, len(p.APIKeys))
+	for i := range p.APIKeys {
+		k := &p.APIKeys[i]
+		if k.Key == "" {
+			return fmt.Errorf("api_keys[%d]: key is required", i)
+		}
+		if k.DailyLimit < 0 {
+			return fmt.Errorf("api_keys[%d]: daily_limit must be positive", i)
+		}
+		if k.Name != "" && names[k.Name] {
+			return fmt.Errorf("api_keys[%d]: duplicate name %q", i, k.Name)
+		}
+		names[k.Name] = true
+	}
+
+	return nil
+}
+
+// setDefaults fills in the report defaults. The API key and timeout are
+// taken from the first abuseipdb provider, which the abuseip section
+// configures, when not set.
+func (r *AbuseIPReportConfig) setDefaults(providers []ProviderConfig) error {
+	if !r.Enabled {
+		return nil
+	}
+
+	lookup := ProviderConfig{}
+	if i := slices.IndexFunc(providers, func(p ProviderConfig) bool { return p.Type == "abuseipdb" }); i >= 0 {
+		lookup = providers[i]
+	}
+	if r.APIKey == "" {
+		r.APIKey = lookup.APIKey
+		if len(lookup.APIKeys) > 0 {
+			r.APIKey = lookup.APIKeys[0].Key
+		}
+		if r.URL == "" {
+			r.URL = lookup.URL
+		}
+	}
+	if r.APIKey == "" {
+		return fmt.Errorf("abuseip.report: api_key is required")
+	}
+
+	if r.Template == "" {
+		r.Template = DefaultReportTemplate
+	}
+	if r.MinScore == nil {
+		minScore := DefaultReportMinScore
+		r.MinScore = &minScore
+	}
+	if *r.MinScore < 0 || *r.MinScore > 100 {
+		return fmt.Errorf("abuseip.report: min_score must be between 0 and 100")
+	}
+	if r.DailyLimit == 0 {
+		r.DailyLimit = DefaultReportDailyLimit
+	}
+	if r.DailyLimit < 0 {
+		return fmt.Errorf("abuseip.report: daily_limit must be positive")
+	}
+	if r.Cooldown == 0 {
+		r.Cooldown = DefaultReportCooldown
+	}
+	if r.Cooldown < DefaultReportCooldown {
+		return fmt.Errorf("abuseip.report: cooldown must be at least %s", DefaultReportCooldown)
+	}
+	if r.Timeout == 0 {
+		r.Timeout = lookup.Timeout
+	}
+	if r.Timeout == 0 {
+		r.Timeout = DefaultProviderTimeout
+	}
+
+	return nil
+}
+
+// setDefaults validates the client address header, X-Forwarded-For by
+// default
+func (c *ClientIPConfig) setDefaults() error {
+	c.Header = strings.ToLower(c.Header)
+	switch c.Header {
+	case "":
+		c.Header = HeaderXForwardedFor
+	case HeaderXForwardedFor, HeaderForwarded, HeaderXRealIP, HeaderCFConnectingIP:
+	default:
+		return fmt.Errorf("client_ip: unknown header %q", c.Header)
+	}
+	return nil
+}
+
+func (p *PolicyConfig) setDefaults(listeners []ListenerConfig) error {
+	if p.Threshold == nil {
+		threshold := DefaultPolicyThreshold
+		p.Threshold = &threshold
+	}
+	if *p.Threshold < 0 || *p.Threshold >= 100 {
+		return fmt.Errorf("policy: threshold must be between 0 and 99")
+	}
+
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		switch r.Action {
+		case PolicyBlock, PolicyAllow:
+		case PolicyScore:
+			if r.Score == 0 {
+				return fmt.Errorf("policy rule %q: score is required by the score action", r.Name)
+			}
+		default:
+			return fmt.Errorf("policy rule %q: unknown action %q", r.Name, r.Action)
+		}
+
+		for j, country := range r.Countries {
+			if len(country) != 2 {
+				return fmt.Errorf("policy rule %q: invalid country code %q", r.Name, country)
+			}
+			r.Countries[j] = strings.ToUpper(country)
+		}
+
+		for _, name := range r.Listeners {
+			if !slices.ContainsFunc(listeners, func(l ListenerConfig) bool { return l.Name == name }) {
+				return fmt.Errorf("policy rule %q: unknown listener %q", r.Name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *ListenerConfig) setDefaults() error {
+	if l.Address == "" {
+		return fmt.Errorf("listener %q: address is required", l.Name)
+	}
+
+	if l.Name == "" {
+		l.Name = l.Address
+	}
+
+	if l.Protocol == "" {
+		l.Protocol = ProtocolHTTP
+	}
+
+	switch l.Protocol {
+	case ProtocolHTTP, ProtocolHTTPS, ProtocolTCP:
+	default:
+		return fmt.Errorf("listener %q: unknown protocol %q", l.Name, l.Protocol)
+	}
+
+	if (l.TLS.Cert == "") != (l.TLS.Key == "") {
+		return fmt.Errorf("listener %q: tls cert and key must be set together", l.Name)
+	}
+
+	if l.Response == "" {
+		l.Response = ResponseAuto
+	}
+
+	switch l.Response {
+	case ResponseAuto, ResponseTarpit, ResponseDrop, ResponseStatus:
+	default:
+		return fmt.Errorf("listener %q: unknown response %q", l.Name, l.Response)
+	}
+
+	if l.StatusCode == 0 {
+		l.StatusCode = 404
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// loadConfig loads a configuration from YAML
+func loadConfig(t *testing.T, content string) (*Configuration, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return LoadConfiguration(path)
+}
+
+func TestReportMinScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    int
+		wantErr bool
+	}{
+		{name: "default", yaml: "", want: DefaultReportMinScore},
+		{name: "zero reports every IP", yaml: "min_score: 0", want: 0},
+		{name: "custom", yaml: "min_score: 60", want: 60},
+		{name: "negative", yaml: "min_score: -1", wantErr: true},
+		{name: "above 100", yaml: "min_score: 101", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := loadConfig(t, "abuseip:\n  api_key: key\n  report:\n    enabled: true\n    "+tt.yaml+"\n")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("LoadConfiguration() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfiguration() error = %v", err)
+			}
+			if got := *conf.AbuseIP.Report.MinScore; got != tt.want {
+				t.Errorf("min_score = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAbuseIPSectionMapsToProvider(t *testing.T) {
+	conf, err := loadConfig(t, `abuseip:
+  api_key: first
+  api_keys:
+    - key: second
+      daily_limit: 1000
+  key_selection: least_used
+  max_age_in_days: 7
+  timeout: 3s
+  failure_threshold: 2
+  breaker_cooldown: 5m
+  report:
+    enabled: true
+`)
+	if err != nil {
+		t.Fatalf("LoadConfiguration() error = %v", err)
+	}
+
+	if len(conf.Reputation.Providers) != 1 {
+		t.Fatalf("providers = %+v, want the abuseip section only", conf.Reputation.Providers)
+	}
+	p := conf.Reputation.Providers[0]
+	if p.Type != "abuseipdb" || p.Name != "abuseipdb" {
+		t.Errorf("provider = %s/%s, want abuseipdb", p.Type, p.Name)
+	}
+	if len(p.APIKeys) != 2 || p.APIKeys[0].Key != "first" || p.APIKeys[1].Key != "second" || p.APIKeys[1].DailyLimit != 1000 {
+		t.Errorf("api_keys = %+v, want first then second", p.APIKeys)
+	}
+	if p.KeySelection != KeyLeastUsed || p.MaxAgeInDays != 7 || p.Timeout != 3*time.Second ||
+		p.FailureThreshold != 2 || p.BreakerCooldown != 5*time.Minute {
+		t.Errorf("provider = %+v, want the abuseip options", p)
+	}
+
+	report := conf.AbuseIP.Report
+	if report.APIKey != "first" || report.Timeout != 3*time.Second {
+		t.Errorf("report key and timeout = %q, %s, want those of the provider", report.APIKey, report.Timeout)
+	}
+}
+
+func TestAbuseIPSectionIgnoredWithProvider(t *testing.T) {
+	conf, err := loadConfig(t, `abuseip:
+  api_key: legacy
+reputation:
+  providers:
+    - type: abuseipdb
+      api_key: listed
+`)
+	if err != nil {
+		t.Fatalf("LoadConfiguration() error = %v", err)
+	}
+	if len(conf.Reputation.Providers) != 1 || conf.Reputation.Providers[0].APIKey != "listed" {
+		t.Errorf("providers = %+v, want the listed provider only", conf.Reputation.Providers)
+	}
+}
+
+func TestAbuseIPSectionRejectsOtherTypes(t *testing.T) {
+	if _, err := loadConfig(t, "abuseip:\n  type: greynoise\n  api_key: key\n"); err == nil {
+		t.Error("LoadConfiguration() succeeded with abuseip.type greynoise")
+	}
+}
+
+func TestPolicyThreshold(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    int
+		wantErr bool
+	}{
+		{name: "default", yaml: "", want: DefaultPolicyThreshold},
+		{name: "zero", yaml: "policy:\n  threshold: 0\n", want: 0},
+		{name: "custom", yaml: "policy:\n  threshold: 99\n", want: 99},
+		{name: "negative", yaml: "policy:\n  threshold: -1\n", wantErr: true},
+		{name: "100", yaml: "policy:\n  threshold: 100\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := loadConfig(t, "abuseip:\n  api_key: key\n"+tt.yaml)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("LoadConfiguration() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfiguration() error = %v", err)
+			}
+			if got := *conf.Policy.Threshold; got != tt.want {
+				t.Errorf("threshold = %d, want %d", got, tt.want)
+			}
+			for _, p := range conf.Reputation.Providers {
+				if got := p.MaliciousThreshold(); got != tt.want {
+					t.Errorf("provider %s threshold = %d, want %d", p.Name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestClientIPHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    string
+		wantErr bool
+	}{
+		{name: "default", yaml: "", want: HeaderXForwardedFor},
+		{name: "forwarded", yaml: "client_ip:\n  header: Forwarded\n", want: HeaderForwarded},
+		{name: "cloudflare", yaml: "client_ip:\n  header: cf-connecting-ip\n", want: HeaderCFConnectingIP},
+		{name: "unknown", yaml: "client_ip:\n  header: x-client-ip\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := loadConfig(t, "abuseip:\n  api_key: key\n"+tt.yaml)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("LoadConfiguration() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfiguration() error = %v", err)
+			}
+			if conf.ClientIP.Header != tt.want {
+				t.Errorf("header = %q, want %q", conf.ClientIP.Header, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package dashboard
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authenticator checks dashboard credentials. It accepts bcrypt protected
+// basic auth users and static bearer tokens.
+type authenticator struct {
+	users  map[string][]byte
+	tokens [][]byte
+
+	// bcrypt is slow on purpose, so successful logins are remembered to
+	// keep the dashboard polling cheap
+	mu       sync.RWMutex
+	verified map[string][sha256.Size]byte
+}
+
+func newAuthenticator(cfg *config.DashboardConfig) *authenticator {
+	a := &authenticator{
+		users:    make(map[string][]byte),
+		verified: make(map[string][sha256.Size]byte),
+	}
+
+	for _, user := range cfg.Auth.Users {
+		a.users[user.Username] = []byte(user.PasswordHash)
+	}
+	for _, token := range cfg.Auth.Tokens {
+		if token != "" {
+			a.tokens = append(a.tokens, []byte(token))
+		}
+	}
+
+	return a
+}
+
+// enabled reports whether any credential is configured
+func (a *authenticator) enabled() bool {
+	return len(a.users) > 0 || len(a.tokens) > 0
+}
+
+// authenticate reports whether the request carries valid credentials
+func (a *authenticator) authenticate(r *http.Request) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return a.checkToken(token)
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		return a.checkPassword(username, password)
+	}
+
+	return false
+}
+
+func (a *authenticator) checkToken(token string) bool {
+	valid := false
+	for _, expected := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), expected) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func (a *authenticator) checkPassword(username, password string) bool {
+	hash, exists := a.users[username]
+	if !exists {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(password))
+
+	a.mu.RLock()
+	cached, ok := a.verified[username]
+	a.mu.RUnlock()
+	if ok && subtle.ConstantTimeCompare(cached[:], sum[:]) == 1 {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+
+	a.mu.Lock()
+	a.verified[username] = sum
+	a.mu.Unlock()
+
+	return true
+}
+
+// middleware enforces authentication on every route when credentials are
+// configured
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.enabled() && !a.authenticate(r) {
+			if len(a.users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="GateKeeper", charset="UTF-8"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAuth rejects actions when authentication is not configured, so
+// that the firewalls can never be modified anonymously
+func (a *authenticator) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.enabled() {
+			http.Error(w, "Actions are disabled: no dashboard credentials configured", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,90 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+// recordingActions records the IPs blocked through the dashboard and
+// keeps an allowlist
+type recordingActions struct {
+	blocked []string
+	allowed []string
+}
+
+func (a *recordingActions) BlockIP(ip string) error {
+	a.blocked = append(a.blocked, ip)
+	return nil
+}
+
+func (a *recordingActions) AllowIP(ip string) error {
+	a.allowed = append(a.allowed, ip)
+	return nil
+}
+
+func (a *recordingActions) DisallowIP(ip string) error {
+	a.allowed = slices.DeleteFunc(a.allowed, func(allowed string) bool { return allowed == ip })
+	return nil
+}
+
+func (a *recordingActions) UnblockIP(string) error { return nil }
+func (a *recordingActions) DeleteIP(string) error  { return nil }
+func (a *recordingActions) AllowedIPs() []string   { return a.allowed }
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		name          string
+		origin        string
+		secFetchSite  string
+		authenticated bool
+		wantStatus    int
+	}{
+		{name: "same origin", secFetchSite: "same-origin", origin: "https://dashboard.example", authenticated: true, wantStatus: http.StatusOK},
+		{name: "typed by the user", secFetchSite: "none", authenticated: true, wantStatus: http.StatusOK},
+		{name: "cross site", secFetchSite: "cross-site", origin: "https://evil.example", authenticated: true, wantStatus: http.StatusForbidden},
+		{name: "same site", secFetchSite: "same-site", origin: "https://other.dashboard.example", authenticated: true, wantStatus: http.StatusForbidden},
+		{name: "cross origin without fetch metadata", origin: "https://evil.example", authenticated: true, wantStatus: http.StatusForbidden},
+		{name: "null origin", origin: "null", authenticated: true, wantStatus: http.StatusForbidden},
+		{name: "same origin without fetch metadata", origin: "https://dashboard.example", authenticated: true, wantStatus: http.StatusOK},
+		{name: "not a browser", authenticated: true, wantStatus: http.StatusOK},
+		{name: "anonymous", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions := &recordingActions{}
+			d := NewDashboard(&config.Configuration{
+				Dashboard: config.DashboardConfig{Auth: config.DashboardAuthConfig{Tokens: []string{"secret"}}},
+			}, nil, actions, nil)
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /api/ips/{ip}/block", d.action(d.actions.BlockIP))
+			handler := d.auth.middleware(mux)
+
+			req := httptest.NewRequest(http.MethodPost, "https://dashboard.example/api/ips/192.0.2.1/block", nil)
+			if tt.authenticated {
+				req.Header.Set("Authorization", "Bearer secret")
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.secFetchSite != "" {
+				req.Header.Set("Sec-Fetch-Site", tt.secFetchSite)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if blocked := len(actions.blocked) > 0; blocked != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("action run = %v with status %d", blocked, rec.Code)
+			}
+		})
+	}
+}
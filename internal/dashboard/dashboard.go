@@ -1,27 +1,59 @@
 package dashboard
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"html/template"
 	"log"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"time"
 
+	"github.com/TOomaAh/GateKeeper/internal/abuseip"
+	"github.com/TOomaAh/GateKeeper/internal/cache"
 	"github.com/TOomaAh/GateKeeper/internal/config"
 	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/pipeline"
+	"github.com/TOomaAh/GateKeeper/internal/reputation"
 )
 
+// Actions is implemented by the component able to act on IPs
+type Actions interface {
+	BlockIP(ip string) error
+	UnblockIP(ip string) error
+	DeleteIP(ip string) error
+	AllowIP(ip string) error
+	DisallowIP(ip string) error
+	AllowedIPs() []string
+}
+
+// StatsProvider reports runtime statistics shown by /api/stats
+type StatsProvider interface {
+	RetentionStats() database.RetentionStats
+	CacheStats() *cache.Stats
+	PipelineStats() pipeline.Stats
+	ReputationStats() []reputation.ProviderStats
+}
+
 // Dashboard manages the web dashboard
 type Dashboard struct {
-	config *config.Configuration
-	db     *database.IPDatabase
+	config  *config.Configuration
+	db      database.Store
+	actions Actions
+	stats   StatsProvider
+	auth    *authenticator
 }
 
 // NewDashboard creates a new dashboard instance
-func NewDashboard(cfg *config.Configuration, db *database.IPDatabase) *Dashboard {
+func NewDashboard(cfg *config.Configuration, db database.Store, actions Actions, stats StatsProvider) *Dashboard {
 	return &Dashboard{
-		config: cfg,
-		db:     db,
+		config:  cfg,
+		db:      db,
+		actions: actions,
+		stats:   stats,
+		auth:    newAuthenticator(&cfg.Dashboard),
 	}
 }
 
@@ -31,9 +63,35 @@ func (d *Dashboard) Run() error {
 	mux.HandleFunc("/", d.handleIndex)
 	mux.HandleFunc("/api/stats", d.handleStats)
 	mux.HandleFunc("/api/ips", d.handleIPs)
+	mux.HandleFunc("GET /api/events", d.handleEvents)
+	mux.HandleFunc("GET /api/allowlist", d.handleAllowlist)
+
+	// Actions modifying the firewalls are only available with authentication
+	// and to the dashboard itself
+	mux.HandleFunc("POST /api/ips/{ip}/block", d.action(d.actions.BlockIP))
+	mux.HandleFunc("DELETE /api/ips/{ip}/block", d.action(d.actions.UnblockIP))
+	mux.HandleFunc("DELETE /api/ips/{ip}", d.action(d.actions.DeleteIP))
+	mux.HandleFunc("POST /api/allowlist/{ip}", d.action(d.actions.AllowIP))
+	mux.HandleFunc("DELETE /api/allowlist/{ip}", d.action(d.actions.DisallowIP))
+
+	if !d.auth.enabled() {
+		log.Println("WARNING: dashboard authentication is disabled, anyone reaching it can read attacker data")
+	}
+
+	server := &http.Server{
+		Addr:              d.config.Dashboard.Port,
+		Handler:           d.auth.middleware(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if d.config.Dashboard.TLSCert != "" && d.config.Dashboard.TLSKey != "" {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		log.Printf("Dashboard listening on %s (HTTPS)", d.config.Dashboard.Port)
+		return server.ListenAndServeTLS(d.config.Dashboard.TLSCert, d.config.Dashboard.TLSKey)
+	}
 
 	log.Printf("Dashboard listening on %s", d.config.Dashboard.Port)
-	return http.ListenAndServe(d.config.Dashboard.Port, mux)
+	return server.ListenAndServe()
 }
 
 func (d *Dashboard) handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -42,9 +100,13 @@ func (d *Dashboard) handleIndex(w http.ResponseWriter, r *http.Request) {
 }
 
 type StatsResponse struct {
-	DatabaseStats database.Stats `json:"database_stats"`
-	Uptime        string         `json:"uptime"`
-	Timestamp     string         `json:"timestamp"`
+	DatabaseStats   database.Stats             `json:"database_stats"`
+	RetentionStats  database.RetentionStats    `json:"retention_stats"`
+	CacheStats      *cache.Stats               `json:"cache_stats,omitempty"`
+	PipelineStats   pipeline.Stats             `json:"pipeline_stats"`
+	ReputationStats []reputation.ProviderStats `json:"reputation_stats"`
+	Uptime          string                     `json:"uptime"`
+	Timestamp       string                     `json:"timestamp"`
 }
 
 func (d *Dashboard) handleStats(w http.ResponseWriter, r *http.Request) {
@@ -55,9 +117,13 @@ func (d *Dashboard) handleStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := StatsResponse{
-		DatabaseStats: stats,
-		Uptime:        time.Since(startTime).Round(time.Second).String(),
-		Timestamp:     time.Now().Format(time.RFC3339),
+		DatabaseStats:   stats,
+		RetentionStats:  d.stats.RetentionStats(),
+		CacheStats:      d.stats.CacheStats(),
+		PipelineStats:   d.stats.PipelineStats(),
+		ReputationStats: d.stats.ReputationStats(),
+		Uptime:          time.Since(startTime).Round(time.Second).String(),
+		Timestamp:       time.Now().Format(time.RFC3339),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -65,13 +131,26 @@ func (d *Dashboard) handleStats(w http.ResponseWriter, r *http.Request) {
 }
 
 type IPResponse struct {
-	Address     string `json:"address"`
-	Score       int    `json:"score"`
-	Country     string `json:"country"`
-	Path        string `json:"path"`
-	PayloadPath string `json:"payload_path,omitempty"`
-	BlockedInFW bool   `json:"blocked_in_fw"`
-	Timestamp   string `json:"timestamp"`
+	Address       string `json:"address"`
+	Score         int    `json:"score"`
+	Country       string `json:"country"`
+	City          string `json:"city,omitempty"`
+	ASN           uint   `json:"asn,omitempty"`
+	ASOrg         string `json:"as_org,omitempty"`
+	Path          string `json:"path"`
+	PayloadPath   string `json:"payload_path,omitempty"`
+	BlockedInFW   bool   `json:"blocked_in_fw"`
+	ManualUnblock bool   `json:"manual_unblock,omitempty"`
+	Timestamp     string `json:"timestamp"`
+	TLSServerName string `json:"tls_server_name,omitempty"`
+	JA3           string `json:"ja3,omitempty"`
+	JA4           string `json:"ja4,omitempty"`
+
+	Reputation      []domain.ReputationResult `json:"reputation,omitempty"`
+	Abuse           *domain.AbuseDetails      `json:"abuse,omitempty"`
+	AbuseCategories []string                  `json:"abuse_categories,omitempty"`
+	Whitelisted     bool                      `json:"whitelisted,omitempty"`
+	Allowlisted     bool                      `json:"allowlisted,omitempty"`
 }
 
 func (d *Dashboard) handleIPs(w http.ResponseWriter, r *http.Request) {
@@ -81,17 +160,116 @@ func (d *Dashboard) handleIPs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	allowedIPs := d.actions.AllowedIPs()
+	allowed := make(map[string]bool, len(allowedIPs))
+	for _, ip := range allowedIPs {
+		allowed[ip] = true
+	}
+
 	// Convert to response format with RFC3339 timestamps
-	response := make([]IPResponse, len(ips))
+	response := make([]IPResponse, len(ips), len(ips)+len(allowed))
 	for i, ip := range ips {
 		response[i] = IPResponse{
-			Address:     ip.Address,
-			Score:       int(ip.Score),
-			Country:     ip.Country,
-			Path:        ip.Path,
-			PayloadPath: ip.PayloadPath,
-			BlockedInFW: ip.BlockedInFW,
-			Timestamp:   ip.Timestamp.Format(time.RFC3339),
+			Address:       ip.Address,
+			Score:         int(ip.Score),
+			Country:       ip.Country,
+			City:          ip.City,
+			ASN:           ip.ASN,
+			ASOrg:         ip.ASOrg,
+			Path:          ip.Path,
+			PayloadPath:   ip.PayloadPath,
+			BlockedInFW:   ip.BlockedInFW,
+			ManualUnblock: ip.ManualUnblock,
+			Timestamp:     ip.Timestamp.Format(time.RFC3339),
+			TLSServerName: ip.TLSServerName,
+			JA3:           ip.JA3,
+			JA4:           ip.JA4,
+			Reputation:    ip.Reputation,
+			Abuse:         ip.AbuseDetails(),
+			Whitelisted:   ip.IsWhitelisted(),
+			Allowlisted:   allowed[ip.Address],
+		}
+		if response[i].Abuse != nil {
+			response[i].AbuseCategories = abuseip.CategoryNames(response[i].Abuse.Categories)
+		}
+		delete(allowed, ip.Address)
+	}
+
+	// Allowlisted IPs without a record are listed as well, so that they
+	// can be removed from the allowlist
+	for _, ip := range allowedIPs {
+		if allowed[ip] {
+			response = append(response, IPResponse{Address: ip, Allowlisted: true})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+const (
+	// DefaultEventsLimit is the number of events returned by default
+	DefaultEventsLimit = 100
+	// MaxEventsLimit is the maximum number of events returned at once
+	MaxEventsLimit = 1000
+)
+
+type EventResponse struct {
+	ID          int64  `json:"id"`
+	Timestamp   string `json:"timestamp"`
+	Address     string `json:"address"`
+	Listener    string `json:"listener"`
+	Method      string `json:"method,omitempty"`
+	Host        string `json:"host,omitempty"`
+	Path        string `json:"path"`
+	UserAgent   string `json:"user_agent,omitempty"`
+	HeadersHash string `json:"headers_hash,omitempty"`
+	Action      string `json:"action"`
+}
+
+// handleEvents returns the latest events, optionally filtered with the
+// "ip" query parameter
+func (d *Dashboard) handleEvents(w http.ResponseWriter, r *http.Request) {
+	ip := r.URL.Query().Get("ip")
+	if ip != "" {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			http.Error(w, "Invalid IP address", http.StatusBadRequest)
+			return
+		}
+		ip = addr.Unmap().String()
+	}
+
+	limit := DefaultEventsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, MaxEventsLimit)
+	}
+
+	events, err := d.db.GetEvents(ip, limit)
+	if err != nil {
+		log.Printf("Dashboard events error: %v", err)
+		http.Error(w, "Failed to get events", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]EventResponse, len(events))
+	for i, event := range events {
+		response[i] = EventResponse{
+			ID:          event.ID,
+			Timestamp:   event.Timestamp.Format(time.RFC3339),
+			Address:     event.Address,
+			Listener:    event.Listener,
+			Method:      event.Method,
+			Host:        event.Host,
+			Path:        event.Path,
+			UserAgent:   event.UserAgent,
+			HeadersHash: event.HeadersHash,
+			Action:      event.Action,
 		}
 	}
 
@@ -99,6 +277,49 @@ func (d *Dashboard) handleIPs(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// ActionResponse is returned by the action endpoints
+type ActionResponse struct {
+	Address string `json:"address"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// action protects an action handler against anonymous and cross-site
+// requests
+func (d *Dashboard) action(action func(ip string) error) http.HandlerFunc {
+	return d.auth.requireAuth(sameOrigin(d.handleAction(action)))
+}
+
+// handleAction runs an action on the IP found in the request path
+func (d *Dashboard) handleAction(action func(ip string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		addr, err := netip.ParseAddr(r.PathValue("ip"))
+		if err != nil {
+			http.Error(w, "Invalid IP address", http.StatusBadRequest)
+			return
+		}
+		ip := addr.Unmap().String()
+
+		response := ActionResponse{Address: ip, Status: "ok"}
+		status := http.StatusOK
+		if err := action(ip); err != nil {
+			log.Printf("Dashboard action %s %s failed: %v", r.Method, r.URL.Path, err)
+			response.Status = "error"
+			response.Error = err.Error()
+			status = http.StatusBadGateway
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func (d *Dashboard) handleAllowlist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.actions.AllowedIPs())
+}
+
 var startTime = time.Now()
 
 const dashboardHTML = `<!DOCTYPE html>
@@ -303,6 +524,10 @@ const dashboardHTML = `<!DOCTYPE html>
             background: #44ff44;
             color: #000;
         }
+        .badge-allowlisted {
+            background: #4488ff;
+            color: #fff;
+        }
         .score-high {
             color: #ff4444;
             font-weight: 700;
@@ -315,6 +540,29 @@ const dashboardHTML = `<!DOCTYPE html>
             color: #44ff44;
             font-weight: 500;
         }
+        .actions {
+            white-space: nowrap;
+        }
+        .action-btn {
+            background: #1a1a1a;
+            border: 1px solid #333;
+            border-radius: 8px;
+            color: #ccc;
+            cursor: pointer;
+            font-size: 0.75em;
+            font-weight: 600;
+            margin-right: 4px;
+            padding: 4px 10px;
+            text-transform: uppercase;
+        }
+        .action-btn:hover {
+            border-color: #666;
+            color: #fff;
+        }
+        .action-btn.danger:hover {
+            border-color: #ff4444;
+            color: #ff4444;
+        }
     </style>
 </head>
 <body>
@@ -373,11 +621,12 @@ const dashboardHTML = `<!DOCTYPE html>
                             <th>Path</th>
                             <th>Status</th>
                             <th>Timestamp</th>
+                            <th>Actions</th>
                         </tr>
                     </thead>
                     <tbody id="ip-table-body">
                         <tr>
-                            <td colspan="6" style="text-align:center; color: #666;">Loading...</td>
+                            <td colspan="7" style="text-align:center; color: #666;">Loading...</td>
                         </tr>
                     </tbody>
                 </table>
@@ -435,25 +684,41 @@ const dashboardHTML = `<!DOCTYPE html>
                 .then(data => {
                     const tbody = document.getElementById('ip-table-body');
                     if (!data || data.length === 0) {
-                        tbody.innerHTML = '<tr><td colspan="6" style="text-align:center; color: #666;">No IP entries found</td></tr>';
+                        tbody.innerHTML = '<tr><td colspan="7" style="text-align:center; color: #666;">No IP entries found</td></tr>';
                         return;
                     }
 
                     tbody.innerHTML = data.map(ip => {
-                        const timestamp = new Date(ip.timestamp).toLocaleString();
+                        const timestamp = ip.timestamp ? new Date(ip.timestamp).toLocaleString() : '-';
                         const scoreClass = getScoreClass(ip.score);
                         const statusBadge = ip.blocked_in_fw
                             ? '<span class="badge badge-blocked">Blocked</span>'
-                            : '<span class="badge badge-active">Active</span>';
+                            : ip.allowlisted
+                                ? '<span class="badge badge-allowlisted">Allowlisted</span>'
+                                : ip.whitelisted
+                                    ? '<span class="badge badge-active">Whitelisted</span>'
+                                    : '<span class="badge badge-active">Active</span>';
+                        const address = escapeHTML(ip.address);
+                        const blockButton = ip.blocked_in_fw
+                            ? ` + "`" + `<button class="action-btn" data-action="unblock" data-ip="${address}">Unblock</button>` + "`" + `
+                            : ` + "`" + `<button class="action-btn danger" data-action="block" data-ip="${address}">Block</button>` + "`" + `;
+                        const allowButton = ip.allowlisted
+                            ? ` + "`" + `<button class="action-btn" data-action="disallow" data-ip="${address}">Disallow</button>` + "`" + `
+                            : ` + "`" + `<button class="action-btn" data-action="allow" data-ip="${address}">Allow</button>` + "`" + `;
 
                         return ` + "`" + `
                             <tr>
-                                <td class="ip-address">${ip.address}</td>
-                                <td class="${scoreClass}">${ip.score}</td>
-                                <td>${ip.country || 'Unknown'}</td>
-                                <td style="max-width: 200px; overflow: hidden; text-overflow: ellipsis;">${ip.path}</td>
+                                <td class="ip-address">${address}</td>
+                                <td class="${scoreClass}" title="${escapeHTML(reputationSummary(ip))}">${ip.score}</td>
+                                <td title="${ip.asn ? escapeHTML('AS' + ip.asn + ' ' + (ip.as_org || '')) : ''}">${escapeHTML(ip.country || 'Unknown')}${ip.city ? escapeHTML(', ' + ip.city) : ''}${ip.abuse && ip.abuse.is_tor ? ' (Tor)' : ''}</td>
+                                <td style="max-width: 200px; overflow: hidden; text-overflow: ellipsis;" title="${ip.ja4 ? escapeHTML('SNI: ' + (ip.tls_server_name || '-') + ' | JA4: ' + ip.ja4) : ''}">${escapeHTML(ip.path)}</td>
                                 <td>${statusBadge}</td>
                                 <td>${timestamp}</td>
+                                <td class="actions">
+                                    ${blockButton}
+                                    ${allowButton}
+                                    <button class="action-btn danger" data-action="delete" data-ip="${address}">Delete</button>
+                                </td>
                             </tr>
                         ` + "`" + `;
                     }).join('');
@@ -463,6 +728,74 @@ const dashboardHTML = `<!DOCTYPE html>
                 });
         }
 
+        function reputationSummary(ip) {
+            const lines = (ip.reputation || []).map(r => {
+                if (r.error) return r.provider + ': error (' + r.error + ')';
+                let line = r.provider + ': ' + r.score + (r.malicious ? ' (malicious)' : '');
+                if (r.details) line += ' - ' + r.details;
+                return line;
+            });
+            if (ip.abuse) {
+                if (ip.abuse.isp) lines.push('ISP: ' + ip.abuse.isp + (ip.abuse.domain ? ' (' + ip.abuse.domain + ')' : ''));
+                if (ip.abuse.usage_type) lines.push('Usage: ' + ip.abuse.usage_type);
+                if (ip.abuse.is_tor) lines.push('Tor exit node');
+                if (ip.abuse.last_reported_at) lines.push('Last reported: ' + new Date(ip.abuse.last_reported_at).toLocaleString());
+                if (ip.abuse_categories) lines.push('Categories: ' + ip.abuse_categories.join(', '));
+            }
+            return lines.join('\n');
+        }
+
+        function escapeHTML(value) {
+            const div = document.createElement('div');
+            div.textContent = value;
+            return div.innerHTML.replace(/"/g, '&quot;');
+        }
+
+        const actionRequests = {
+            block: ip => ['POST', '/api/ips/' + encodeURIComponent(ip) + '/block'],
+            unblock: ip => ['DELETE', '/api/ips/' + encodeURIComponent(ip) + '/block'],
+            allow: ip => ['POST', '/api/allowlist/' + encodeURIComponent(ip)],
+            disallow: ip => ['DELETE', '/api/allowlist/' + encodeURIComponent(ip)],
+            delete: ip => ['DELETE', '/api/ips/' + encodeURIComponent(ip)],
+        };
+
+        function runAction(action, ip, retried) {
+            if (action === 'delete' && !retried && !confirm('Delete ' + ip + ' and unblock it everywhere?')) {
+                return;
+            }
+
+            const [method, url] = actionRequests[action](ip);
+            // Without a stored token the browser sends its basic auth credentials
+            const token = localStorage.getItem('gatekeeper-token');
+            const headers = token ? { 'Authorization': 'Bearer ' + token } : {};
+            fetch(url, { method: method, headers: headers })
+                .then(response => {
+                    if (response.status === 401 && !retried) {
+                        const newToken = prompt('API token');
+                        if (newToken) {
+                            localStorage.setItem('gatekeeper-token', newToken);
+                            runAction(action, ip, true);
+                        }
+                        return;
+                    }
+                    if (!response.ok) {
+                        return response.text().then(text => alert('Action failed: ' + text));
+                    }
+                    updateIPTable();
+                    updateStats();
+                })
+                .catch(error => {
+                    console.error('Error running action:', error);
+                });
+        }
+
+        document.getElementById('ip-table-body').addEventListener('click', event => {
+            const button = event.target.closest('button[data-action]');
+            if (button) {
+                runAction(button.dataset.action, button.dataset.ip, false);
+            }
+        });
+
         // Update stats every 5 seconds
         updateStats();
         setInterval(updateStats, 5000);
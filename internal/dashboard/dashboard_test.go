@@ -0,0 +1,76 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+func TestHandleIPsAllowlist(t *testing.T) {
+	db := database.NewMemoryStore(time.Hour)
+	db.Set(&domain.IPInfo{Address: "192.0.2.1", Score: 90})
+	db.Set(&domain.IPInfo{Address: "192.0.2.2", Score: 10})
+
+	actions := &recordingActions{allowed: []string{"192.0.2.2", "198.51.100.7"}}
+	d := NewDashboard(&config.Configuration{}, db, actions, nil)
+
+	rec := httptest.NewRecorder()
+	d.handleIPs(rec, httptest.NewRequest(http.MethodGet, "/api/ips", nil))
+
+	var response []IPResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+
+	got := make(map[string]IPResponse)
+	for _, ip := range response {
+		got[ip.Address] = ip
+	}
+	tests := []struct {
+		ip              string
+		wantAllowlisted bool
+		wantTimestamp   bool
+	}{
+		{ip: "192.0.2.1", wantTimestamp: true},
+		{ip: "192.0.2.2", wantAllowlisted: true, wantTimestamp: true},
+		// Listed without a record, so that it can be disallowed
+		{ip: "198.51.100.7", wantAllowlisted: true},
+	}
+	if len(response) != len(tests) {
+		t.Errorf("%d IPs, want %d", len(response), len(tests))
+	}
+	for _, tt := range tests {
+		ip, ok := got[tt.ip]
+		if !ok {
+			t.Errorf("%s missing", tt.ip)
+			continue
+		}
+		if ip.Allowlisted != tt.wantAllowlisted || (ip.Timestamp != "") != tt.wantTimestamp {
+			t.Errorf("%s = %+v, want allowlisted %t", tt.ip, ip, tt.wantAllowlisted)
+		}
+	}
+}
+
+func TestDisallowAction(t *testing.T) {
+	actions := &recordingActions{allowed: []string{"192.0.2.1", "192.0.2.2"}}
+	d := NewDashboard(&config.Configuration{}, nil, actions, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /api/allowlist/{ip}", d.handleAction(d.actions.DisallowIP))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/allowlist/::ffff:192.0.2.1", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := actions.AllowedIPs(); len(got) != 1 || got[0] != "192.0.2.2" {
+		t.Errorf("allowlist = %v, want the unmapped IP removed", got)
+	}
+}
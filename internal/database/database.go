@@ -10,12 +10,10 @@ import (
 	_ "github.com/glebarez/go-sqlite"
 )
 
-const (
-	// DefaultCleanupInterval to remove old entries
-	DefaultCleanupInterval = 10 * time.Minute
-)
+// sqliteTimeFormat is the format of datetime('now'), in UTC
+const sqliteTimeFormat = "2006-01-02 15:04:05"
 
-// IPDatabase manages IP information persistence in SQLite
+// IPDatabase is the SQLite Store
 type IPDatabase struct {
 	db  *sql.DB
 	ttl time.Duration
@@ -38,7 +36,7 @@ func NewIPDatabase(dbPath string, ttl time.Duration) (*IPDatabase, error) {
 		return nil, fmt.Errorf("failed to configure database: %w", err)
 	}
 
-	if err := createSchema(db); err != nil {
+	if err := migrate(db, sqliteMigrations, inferSQLiteVersion); err != nil {
 		db.Close()
 		return nil, err
 	}
@@ -52,43 +50,57 @@ func NewIPDatabase(dbPath string, ttl time.Duration) (*IPDatabase, error) {
 	return ipDB, nil
 }
 
-func createSchema(db *sql.DB) error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS ip_info (
-		address TEXT PRIMARY KEY,
-		score INTEGER NOT NULL,
-		country TEXT NOT NULL,
-		path TEXT NOT NULL,
-		payload_path TEXT,
-		blocked_in_fw BOOLEAN NOT NULL DEFAULT 0,
-		timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_timestamp ON ip_info(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_score ON ip_info(score);
-	CREATE INDEX IF NOT EXISTS idx_blocked ON ip_info(blocked_in_fw);
+func (db *IPDatabase) Get(ip string) (*domain.IPInfo, bool) {
+	query := `
+		SELECT ` + ipInfoColumns + `
+		FROM ip_info
+		WHERE address = ? AND datetime(timestamp, '+' || ? || ' seconds') > datetime('now')
 	`
 
-	if _, err := db.Exec(schema); err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
+	info, err := scanIPInfo(db.db.QueryRow(query, ip, int(db.ttl.Seconds())))
+	if err == sql.ErrNoRows {
+		return nil, false
 	}
 
-	return nil
+	if err != nil {
+		log.Printf("Database Get error: %v", err)
+		return nil, false
+	}
+
+	return info, true
 }
 
-func (db *IPDatabase) Get(ip string) (*domain.IPInfo, bool) {
-	query := `
-		SELECT address, score, country, path, payload_path, blocked_in_fw, timestamp
-		FROM ip_info
-		WHERE address = ? AND datetime(timestamp, '+' || ? || ' seconds') > datetime('now')
-	`
+func (db *IPDatabase) GetStale(ip string) (*domain.IPInfo, bool) {
+	query := `SELECT ` + ipInfoColumns + ` FROM ip_info WHERE address = ?`
+
+	info, err := scanIPInfo(db.db.QueryRow(query, ip))
+	if err == sql.ErrNoRows {
+		return nil, false
+	}
+
+	if err != nil {
+		log.Printf("Database GetStale error: %v", err)
+		return nil, false
+	}
+
+	return info, true
+}
+
+// ipInfoColumns lists the columns read by scanIPInfo, in order
+const ipInfoColumns = `address, score, country, path, payload_path, blocked_in_fw, timestamp, blocked_at, tls_server_name, ja3, ja4, reputation, city, asn, as_org, manual_unblock`
 
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanIPInfo(row scanner) (*domain.IPInfo, error) {
 	var info domain.IPInfo
 	var timestamp string
-	var payloadPath sql.NullString
+	var payloadPath, blockedAt sql.NullString
+	var reputation string
 
-	err := db.db.QueryRow(query, ip, int(db.ttl.Seconds())).Scan(
+	err := row.Scan(
 		&info.Address,
 		&info.Score,
 		&info.Country,
@@ -96,52 +108,73 @@ func (db *IPDatabase) Get(ip string) (*domain.IPInfo, bool) {
 		&payloadPath,
 		&info.BlockedInFW,
 		&timestamp,
+		&blockedAt,
+		&info.TLSServerName,
+		&info.JA3,
+		&info.JA4,
+		&reputation,
+		&info.City,
+		&info.ASN,
+		&info.ASOrg,
+		&info.ManualUnblock,
 	)
+	if err != nil {
+		return nil, err
+	}
 
-	if err == sql.ErrNoRows {
-		return nil, false
+	info.Reputation = decodeReputation(reputation)
+	info.Timestamp = parseTimestamp(timestamp)
+
+	if payloadPath.Valid {
+		info.PayloadPath = payloadPath.String
 	}
 
-	if err != nil {
-		log.Printf("Database Get error: %v", err)
-		return nil, false
+	if blockedAt.Valid {
+		info.BlockedAt = parseTimestamp(blockedAt.String)
 	}
 
-	// Parse timestamp - try multiple formats
-	var parsedTime time.Time
-	var parseErr error
+	return &info, nil
+}
 
+// parseTimestamp parses a SQLite timestamp, trying multiple formats
+func parseTimestamp(timestamp string) time.Time {
 	// Try RFC3339 format first (ISO8601)
-	parsedTime, parseErr = time.Parse(time.RFC3339, timestamp)
-	if parseErr != nil {
-		// Try SQLite default format
-		parsedTime, parseErr = time.ParseInLocation("2006-01-02 15:04:05", timestamp, time.Local)
-	}
-
-	if parseErr == nil {
-		info.Timestamp = parsedTime
-	} else {
-		log.Printf("Failed to parse timestamp '%s': %v", timestamp, parseErr)
-		info.Timestamp = time.Time{}
+	parsedTime, err := time.Parse(time.RFC3339, timestamp)
+	if err == nil {
+		return parsedTime
 	}
 
-	if payloadPath.Valid {
-		info.PayloadPath = payloadPath.String
+	// Try SQLite default format, which is in UTC
+	parsedTime, err = time.ParseInLocation(sqliteTimeFormat, timestamp, time.UTC)
+	if err == nil {
+		return parsedTime
 	}
 
-	return &info, true
+	log.Printf("Failed to parse timestamp '%s': %v", timestamp, err)
+	return time.Time{}
 }
 
+// Set inserts or replaces the record of an IP. The timestamp is the time
+// of the reputation check carried by info, also on update: writing back a
+// stale entry does not make it fresh again.
 func (db *IPDatabase) Set(info *domain.IPInfo) error {
 	query := `
-		INSERT INTO ip_info (address, score, country, path, payload_path, blocked_in_fw, timestamp, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, datetime('now'), datetime('now'))
+		INSERT INTO ip_info (address, score, country, path, payload_path, blocked_in_fw, tls_server_name, ja3, ja4, reputation, city, asn, as_org, timestamp, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, datetime('now'))
 		ON CONFLICT(address) DO UPDATE SET
 			score = excluded.score,
+			timestamp = excluded.timestamp,
 			country = excluded.country,
 			path = excluded.path,
 			payload_path = excluded.payload_path,
-			blocked_in_fw = excluded.blocked_in_fw,
+			blocked_in_fw = MAX(ip_info.blocked_in_fw, excluded.blocked_in_fw),
+			tls_server_name = excluded.tls_server_name,
+			ja3 = excluded.ja3,
+			ja4 = excluded.ja4,
+			reputation = excluded.reputation,
+			city = excluded.city,
+			asn = excluded.asn,
+			as_org = excluded.as_org,
 			updated_at = datetime('now')
 		WHERE address = excluded.address
 	`
@@ -151,7 +184,8 @@ func (db *IPDatabase) Set(info *domain.IPInfo) error {
 		payloadPath = sql.NullString{String: info.PayloadPath, Valid: true}
 	}
 
-	_, err := db.db.Exec(query, info.Address, info.Score, info.Country, info.Path, payloadPath, info.BlockedInFW)
+	_, err := db.db.Exec(query, info.Address, info.Score, info.Country, info.Path, payloadPath, info.BlockedInFW,
+		info.TLSServerName, info.JA3, info.JA4, encodeReputation(info.Reputation), info.City, info.ASN, info.ASOrg, checkTime(info).UTC().Format(sqliteTimeFormat))
 	if err != nil {
 		return fmt.Errorf("failed to set IP info: %w", err)
 	}
@@ -160,7 +194,15 @@ func (db *IPDatabase) Set(info *domain.IPInfo) error {
 }
 
 func (db *IPDatabase) MarkBlocked(ip string) error {
-	query := `UPDATE ip_info SET blocked_in_fw = 1, updated_at = datetime('now') WHERE address = ?`
+	query := `
+		UPDATE ip_info
+		SET blocked_at = CASE WHEN blocked_in_fw = 1 AND blocked_at IS NOT NULL THEN blocked_at ELSE datetime('now') END,
+			blocked_in_fw = 1,
+			unblocked_at = NULL,
+			manual_unblock = 0,
+			updated_at = datetime('now')
+		WHERE address = ?
+	`
 
 	_, err := db.db.Exec(query, ip)
 	if err != nil {
@@ -170,37 +212,154 @@ func (db *IPDatabase) MarkBlocked(ip string) error {
 	return nil
 }
 
-func (db *IPDatabase) Delete(ip string) error {
-	_, err := db.db.Exec("DELETE FROM ip_info WHERE address = ?", ip)
-	return err
-}
+// MarkUnblocked records that an IP has been removed from the firewalls
+func (db *IPDatabase) MarkUnblocked(ip string) error {
+	query := `
+		UPDATE ip_info
+		SET blocked_in_fw = 0, unblocked_at = datetime('now'), updated_at = datetime('now')
+		WHERE address = ?
+	`
+
+	_, err := db.db.Exec(query, ip)
+	if err != nil {
+		return fmt.Errorf("failed to mark IP as unblocked: %w", err)
+	}
 
-func (db *IPDatabase) cleanupLoop() {
-	ticker := time.NewTicker(DefaultCleanupInterval)
-	defer ticker.Stop()
+	return nil
+}
 
-	for range ticker.C {
-		db.cleanup()
+// MarkManualUnblock records that an IP was unblocked by hand
+func (db *IPDatabase) MarkManualUnblock(ip string) error {
+	if _, err := db.db.Exec("UPDATE ip_info SET manual_unblock = 1, updated_at = datetime('now') WHERE address = ?", ip); err != nil {
+		return fmt.Errorf("failed to mark IP as manually unblocked: %w", err)
 	}
+
+	return nil
 }
 
-func (db *IPDatabase) cleanup() {
+// GetExpiredBlocks returns the blocked IPs whose block is older than duration
+func (db *IPDatabase) GetExpiredBlocks(duration time.Duration) ([]string, error) {
 	query := `
-		DELETE FROM ip_info
-		WHERE datetime(timestamp, '+' || ? || ' seconds') < datetime('now')
+		SELECT address
+		FROM ip_info
+		WHERE blocked_in_fw = 1
+		AND datetime(COALESCE(blocked_at, updated_at), '+' || ? || ' seconds') < datetime('now')
 	`
 
-	result, err := db.db.Exec(query, int(db.ttl.Seconds()))
+	rows, err := db.db.Query(query, int(duration.Seconds()))
 	if err != nil {
-		log.Printf("Cleanup error: %v", err)
-		return
+		return nil, fmt.Errorf("failed to get expired blocks: %w", err)
 	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, fmt.Errorf("failed to scan expired block: %w", err)
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, rows.Err()
+}
+
+// GetBlockStates returns the recorded firewall state of every known IP
+func (db *IPDatabase) GetBlockStates() (map[string]BlockState, error) {
+	rows, err := db.db.Query("SELECT address, blocked_in_fw, unblocked_at IS NOT NULL, blocked_at FROM ip_info")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block states: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]BlockState)
+	for rows.Next() {
+		var ip string
+		var state BlockState
+		var blockedAt sql.NullString
+		if err := rows.Scan(&ip, &state.Blocked, &state.Unblocked, &blockedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan block state: %w", err)
+		}
+		if blockedAt.Valid {
+			state.BlockedAt = parseTimestamp(blockedAt.String)
+		}
+		states[ip] = state
+	}
+
+	return states, rows.Err()
+}
+
+// GetBlockedIPs returns the addresses flagged as blocked in the firewall
+func (db *IPDatabase) GetBlockedIPs() ([]string, error) {
+	rows, err := db.db.Query("SELECT address FROM ip_info WHERE blocked_in_fw = 1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blocked IPs: %w", err)
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, fmt.Errorf("failed to scan blocked IP: %w", err)
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, rows.Err()
+}
+
+func (db *IPDatabase) Delete(ip string) error {
+	_, err := db.db.Exec("DELETE FROM ip_info WHERE address = ?", ip)
+	return err
+}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected > 0 {
-		log.Printf("Cleaned up %d expired IP entries from database", rowsAffected)
+// PruneRecords deletes the records last checked before the given time.
+// Records of IPs still blocked in the firewall are kept until the
+// reconciler unblocks them.
+func (db *IPDatabase) PruneRecords(before time.Time) (int64, error) {
+	result, err := db.db.Exec(
+		"DELETE FROM ip_info WHERE timestamp < ? AND blocked_in_fw = 0",
+		before.UTC().Format(sqliteTimeFormat),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune records: %w", err)
+	}
+
+	pruned, _ := result.RowsAffected()
+	if pruned > 0 {
 		db.db.Exec("PRAGMA optimize")
 	}
+	return pruned, nil
+}
+
+// GetPayloadPaths returns the payload files referenced by records
+func (db *IPDatabase) GetPayloadPaths() ([]string, error) {
+	rows, err := db.db.Query("SELECT payload_path FROM ip_info WHERE payload_path IS NOT NULL AND payload_path != ''")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payload paths: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("failed to scan payload path: %w", err)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, rows.Err()
+}
+
+// ClearPayloadPath removes the references to a deleted payload file
+func (db *IPDatabase) ClearPayloadPath(path string) error {
+	if _, err := db.db.Exec("UPDATE ip_info SET payload_path = NULL, updated_at = datetime('now') WHERE payload_path = ?", path); err != nil {
+		return fmt.Errorf("failed to clear payload path: %w", err)
+	}
+
+	return nil
 }
 
 func (db *IPDatabase) GetStats() (Stats, error) {
@@ -226,6 +385,11 @@ func (db *IPDatabase) GetStats() (Stats, error) {
 		return stats, err
 	}
 
+	err = db.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&stats.TotalEvents)
+	if err != nil {
+		return stats, err
+	}
+
 	err = db.db.QueryRow("SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()").Scan(&stats.DBSize)
 	if err != nil {
 		return stats, err
@@ -234,18 +398,10 @@ func (db *IPDatabase) GetStats() (Stats, error) {
 	return stats, nil
 }
 
-// Stats contains database statistics
-type Stats struct {
-	TotalEntries   int64
-	ActiveEntries  int64
-	BlockedEntries int64
-	DBSize         int64
-}
-
 // GetAllIPs returns all IP entries from the database
 func (db *IPDatabase) GetAllIPs() ([]*domain.IPInfo, error) {
 	query := `
-		SELECT address, score, country, path, payload_path, blocked_in_fw, timestamp
+		SELECT ` + ipInfoColumns + `
 		FROM ip_info
 		ORDER BY timestamp DESC
 		LIMIT 100
@@ -259,46 +415,12 @@ func (db *IPDatabase) GetAllIPs() ([]*domain.IPInfo, error) {
 
 	var ips []*domain.IPInfo
 	for rows.Next() {
-		var info domain.IPInfo
-		var timestamp string
-		var payloadPath sql.NullString
-
-		err := rows.Scan(
-			&info.Address,
-			&info.Score,
-			&info.Country,
-			&info.Path,
-			&payloadPath,
-			&info.BlockedInFW,
-			&timestamp,
-		)
+		info, err := scanIPInfo(rows)
 		if err != nil {
 			continue
 		}
 
-		// Parse timestamp - try multiple formats
-		var parsedTime time.Time
-		var parseErr error
-
-		// Try RFC3339 format first (ISO8601)
-		parsedTime, parseErr = time.Parse(time.RFC3339, timestamp)
-		if parseErr != nil {
-			// Try SQLite default format
-			parsedTime, parseErr = time.ParseInLocation("2006-01-02 15:04:05", timestamp, time.Local)
-		}
-
-		if parseErr == nil {
-			info.Timestamp = parsedTime
-		} else {
-			log.Printf("Failed to parse timestamp '%s': %v", timestamp, parseErr)
-			info.Timestamp = time.Time{}
-		}
-
-		if payloadPath.Valid {
-			info.PayloadPath = payloadPath.String
-		}
-
-		ips = append(ips, &info)
+		ips = append(ips, info)
 	}
 
 	return ips, nil
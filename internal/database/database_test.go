@@ -0,0 +1,98 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+func newTestDatabase(t *testing.T, ttl time.Duration) *IPDatabase {
+	t.Helper()
+
+	db, err := NewIPDatabase(filepath.Join(t.TempDir(), "gatekeeper.db"), ttl)
+	if err != nil {
+		t.Fatalf("NewIPDatabase() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSetStoresUTC(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	local := time.Local
+	time.Local = newYork
+	t.Cleanup(func() { time.Local = local })
+
+	db := newTestDatabase(t, time.Hour)
+	checked := time.Now().Truncate(time.Second)
+	if err := db.Set(&domain.IPInfo{Address: "192.0.2.1", Score: 80, Country: "US", Timestamp: checked}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	var stored string
+	if err := db.db.QueryRow("SELECT CAST(timestamp AS TEXT) FROM ip_info WHERE address = ?", "192.0.2.1").Scan(&stored); err != nil {
+		t.Fatalf("failed to read timestamp: %v", err)
+	}
+	if want := checked.UTC().Format(sqliteTimeFormat); stored != want {
+		t.Errorf("stored timestamp = %q, want %q", stored, want)
+	}
+
+	info, ok := db.Get("192.0.2.1")
+	if !ok {
+		t.Fatal("fresh entry not returned by Get")
+	}
+	if !info.Timestamp.Equal(checked) {
+		t.Errorf("timestamp = %v, want %v", info.Timestamp, checked)
+	}
+
+	pruned, err := db.PruneRecords(time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("PruneRecords() error = %v", err)
+	}
+	if pruned != 0 {
+		t.Errorf("PruneRecords() pruned %d fresh record(s)", pruned)
+	}
+}
+
+func TestSetUpdatesCheckTime(t *testing.T) {
+	db := newTestDatabase(t, time.Hour)
+	old := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+
+	stale := &domain.IPInfo{Address: "192.0.2.1", Score: 80, Country: "FR", Timestamp: old}
+	if err := db.Set(stale); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, ok := db.Get("192.0.2.1"); ok {
+		t.Fatal("entry checked 2h ago returned with a 1h TTL")
+	}
+
+	// Writing the stale entry back, e.g. to flag it, keeps it stale
+	stale.BlockedInFW = true
+	if err := db.Set(stale); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, ok := db.Get("192.0.2.1"); ok {
+		t.Error("rewriting a stale entry made it fresh")
+	}
+
+	// A new check replaces the timestamp of the existing row
+	checked := time.Now().Truncate(time.Second)
+	if err := db.Set(&domain.IPInfo{Address: "192.0.2.1", Score: 20, Country: "FR", Timestamp: checked}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	info, ok := db.Get("192.0.2.1")
+	if !ok {
+		t.Fatal("entry not fresh after a new check")
+	}
+	if !info.Timestamp.Equal(checked) || info.Score != 20 {
+		t.Errorf("entry = %+v, want score 20 checked at %v", info, checked)
+	}
+	if !info.BlockedInFW {
+		t.Error("blocked flag cleared by the update")
+	}
+}
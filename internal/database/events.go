@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// Events are linked to ip_info by address. They are kept after the IP
+// record expires so that the history outlives the reputation cache.
+
+// eventTimeFormat is used for event timestamps. It sorts lexicographically,
+// so that retention can use the timestamp index.
+const eventTimeFormat = time.RFC3339
+
+// AddEvents records connection attempts in a single transaction
+func (db *IPDatabase) AddEvents(events []*domain.Event) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to add events: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO events (timestamp, address, listener, method, host, path, user_agent, headers_hash, action)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add events: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, event := range events {
+		if event.Timestamp.IsZero() {
+			event.Timestamp = time.Now()
+		}
+
+		result, err := stmt.Exec(
+			event.Timestamp.UTC().Format(eventTimeFormat),
+			event.Address,
+			event.Listener,
+			event.Method,
+			event.Host,
+			event.Path,
+			event.UserAgent,
+			event.HeadersHash,
+			event.Action,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add event: %w", err)
+		}
+		event.ID, _ = result.LastInsertId()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to add events: %w", err)
+	}
+	return nil
+}
+
+// GetEvents returns the most recent events, optionally for a single IP
+func (db *IPDatabase) GetEvents(ip string, limit int) ([]*domain.Event, error) {
+	query := `
+		SELECT id, timestamp, address, listener, method, host, path, user_agent, headers_hash, action
+		FROM events
+		WHERE ? = '' OR address = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+
+	rows, err := db.db.Query(query, ip, ip, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*domain.Event
+	for rows.Next() {
+		var event domain.Event
+		var timestamp string
+
+		err := rows.Scan(
+			&event.ID,
+			&timestamp,
+			&event.Address,
+			&event.Listener,
+			&event.Method,
+			&event.Host,
+			&event.Path,
+			&event.UserAgent,
+			&event.HeadersHash,
+			&event.Action,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+
+		event.Timestamp = parseTimestamp(timestamp)
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+// PruneEvents deletes the events older than the given time
+func (db *IPDatabase) PruneEvents(before time.Time) (int64, error) {
+	result, err := db.db.Exec("DELETE FROM events WHERE timestamp < ?", before.UTC().Format(eventTimeFormat))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,328 @@
+package database
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// MemoryStore is a Store keeping everything in memory. It is meant for
+// tests and throwaway setups: nothing survives a restart.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[string]*memoryEntry
+	events  []*domain.Event
+	nextID  int64
+	usage   map[string]int
+}
+
+type memoryEntry struct {
+	info        domain.IPInfo
+	updatedAt   time.Time
+	unblockedAt time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		ttl:     ttl,
+		entries: make(map[string]*memoryEntry),
+		usage:   make(map[string]int),
+	}
+}
+
+func (s *MemoryStore) Get(ip string) (*domain.IPInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[ip]
+	if !ok || time.Since(entry.info.Timestamp) > s.ttl {
+		return nil, false
+	}
+
+	info := entry.info
+	return &info, true
+}
+
+func (s *MemoryStore) GetStale(ip string) (*domain.IPInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[ip]
+	if !ok {
+		return nil, false
+	}
+
+	info := entry.info
+	return &info, true
+}
+
+func (s *MemoryStore) Set(info *domain.IPInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[info.Address]
+	if !ok {
+		entry = &memoryEntry{}
+		s.entries[info.Address] = entry
+	}
+
+	blocked, blockedAt, manual := entry.info.BlockedInFW, entry.info.BlockedAt, entry.info.ManualUnblock
+	entry.info = *info
+	entry.info.Timestamp = checkTime(info)
+	entry.info.BlockedInFW = blocked || info.BlockedInFW
+	entry.info.BlockedAt = blockedAt
+	entry.info.ManualUnblock = manual
+	entry.updatedAt = time.Now()
+
+	return nil
+}
+
+func (s *MemoryStore) Delete(ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, ip)
+	return nil
+}
+
+func (s *MemoryStore) PruneRecords(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pruned int64
+	for ip, entry := range s.entries {
+		if !entry.info.BlockedInFW && entry.info.Timestamp.Before(before) {
+			delete(s.entries, ip)
+			pruned++
+		}
+	}
+
+	return pruned, nil
+}
+
+func (s *MemoryStore) GetPayloadPaths() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var paths []string
+	for _, entry := range s.entries {
+		if entry.info.PayloadPath != "" {
+			paths = append(paths, entry.info.PayloadPath)
+		}
+	}
+
+	return paths, nil
+}
+
+// ClearPayloadPath removes the references to a deleted payload file
+func (s *MemoryStore) ClearPayloadPath(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range s.entries {
+		if entry.info.PayloadPath == path {
+			entry.info.PayloadPath = ""
+			entry.updatedAt = time.Now()
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) MarkBlocked(ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[ip]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	if !entry.info.BlockedInFW || entry.info.BlockedAt.IsZero() {
+		entry.info.BlockedAt = now
+	}
+	entry.info.BlockedInFW = true
+	entry.info.ManualUnblock = false
+	entry.unblockedAt = time.Time{}
+	entry.updatedAt = now
+
+	return nil
+}
+
+func (s *MemoryStore) MarkUnblocked(ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[ip]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	entry.info.BlockedInFW = false
+	entry.unblockedAt = now
+	entry.updatedAt = now
+
+	return nil
+}
+
+func (s *MemoryStore) MarkManualUnblock(ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[ip]; ok {
+		entry.info.ManualUnblock = true
+		entry.updatedAt = time.Now()
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetExpiredBlocks(duration time.Duration) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ips []string
+	for ip, entry := range s.entries {
+		if !entry.info.BlockedInFW {
+			continue
+		}
+
+		blockedAt := entry.info.BlockedAt
+		if blockedAt.IsZero() {
+			blockedAt = entry.updatedAt
+		}
+		if time.Since(blockedAt) > duration {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips, nil
+}
+
+func (s *MemoryStore) GetBlockedIPs() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ips []string
+	for ip, entry := range s.entries {
+		if entry.info.BlockedInFW {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips, nil
+}
+
+func (s *MemoryStore) GetBlockStates() (map[string]BlockState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	states := make(map[string]BlockState, len(s.entries))
+	for ip, entry := range s.entries {
+		states[ip] = BlockState{
+			Blocked:   entry.info.BlockedInFW,
+			Unblocked: !entry.unblockedAt.IsZero(),
+			BlockedAt: entry.info.BlockedAt,
+		}
+	}
+
+	return states, nil
+}
+
+func (s *MemoryStore) GetAllIPs() ([]*domain.IPInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ips := make([]*domain.IPInfo, 0, len(s.entries))
+	for _, entry := range s.entries {
+		info := entry.info
+		ips = append(ips, &info)
+	}
+
+	slices.SortFunc(ips, func(a, b *domain.IPInfo) int {
+		return b.Timestamp.Compare(a.Timestamp)
+	})
+
+	return ips[:min(len(ips), 100)], nil
+}
+
+func (s *MemoryStore) GetStats() (Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := Stats{
+		TotalEntries: int64(len(s.entries)),
+		TotalEvents:  int64(len(s.events)),
+	}
+	for _, entry := range s.entries {
+		if time.Since(entry.info.Timestamp) <= s.ttl {
+			stats.ActiveEntries++
+		}
+		if entry.info.BlockedInFW {
+			stats.BlockedEntries++
+		}
+	}
+
+	return stats, nil
+}
+
+func (s *MemoryStore) AddEvents(events []*domain.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, event := range events {
+		if event.Timestamp.IsZero() {
+			event.Timestamp = time.Now()
+		}
+
+		s.nextID++
+		event.ID = s.nextID
+
+		stored := *event
+		s.events = append(s.events, &stored)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetEvents(ip string, limit int) ([]*domain.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []*domain.Event
+	for i := len(s.events) - 1; i >= 0 && len(events) < limit; i-- {
+		if ip == "" || s.events[i].Address == ip {
+			event := *s.events[i]
+			events = append(events, &event)
+		}
+	}
+
+	return events, nil
+}
+
+func (s *MemoryStore) PruneEvents(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.events[:0]
+	for _, event := range s.events {
+		if !event.Timestamp.Before(before) {
+			kept = append(kept, event)
+		}
+	}
+
+	pruned := int64(len(s.events) - len(kept))
+	clear(s.events[len(kept):])
+	s.events = kept
+
+	return pruned, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,252 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// version of GateKeeper
+var ErrSchemaTooNew = errors.New("database: schema is newer than supported")
+
+// migration upgrades the schema from version-1 to version
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// sqliteMigrations lists every SQLite schema version in order. Never edit
+// a released migration: append a new one instead, and keep the version
+// numbers in step with postgresMigrations.
+var sqliteMigrations = []migration{
+	{
+		version:     1,
+		description: "initial schema",
+		statements: []string{
+			`CREATE TABLE ip_info (
+				address TEXT PRIMARY KEY,
+				score INTEGER NOT NULL,
+				country TEXT NOT NULL,
+				path TEXT NOT NULL,
+				payload_path TEXT,
+				blocked_in_fw BOOLEAN NOT NULL DEFAULT 0,
+				timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX idx_timestamp ON ip_info(timestamp)`,
+			`CREATE INDEX idx_score ON ip_info(score)`,
+			`CREATE INDEX idx_blocked ON ip_info(blocked_in_fw)`,
+		},
+	},
+	{
+		version:     2,
+		description: "block expiry",
+		statements: []string{
+			`ALTER TABLE ip_info ADD COLUMN blocked_at DATETIME`,
+			`ALTER TABLE ip_info ADD COLUMN unblocked_at DATETIME`,
+		},
+	},
+	{
+		version:     3,
+		description: "TLS fingerprints",
+		statements: []string{
+			`ALTER TABLE ip_info ADD COLUMN tls_server_name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE ip_info ADD COLUMN ja3 TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE ip_info ADD COLUMN ja4 TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     4,
+		description: "events",
+		statements: []string{
+			`CREATE TABLE events (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				timestamp TEXT NOT NULL,
+				address TEXT NOT NULL,
+				listener TEXT NOT NULL,
+				method TEXT NOT NULL DEFAULT '',
+				host TEXT NOT NULL DEFAULT '',
+				path TEXT NOT NULL DEFAULT '',
+				user_agent TEXT NOT NULL DEFAULT '',
+				headers_hash TEXT NOT NULL DEFAULT '',
+				action TEXT NOT NULL
+			)`,
+			`CREATE INDEX idx_events_address ON events(address, timestamp)`,
+			`CREATE INDEX idx_events_timestamp ON events(timestamp)`,
+		},
+	},
+	{
+		version:     5,
+		description: "reputation provider results",
+		statements: []string{
+			`ALTER TABLE ip_info ADD COLUMN reputation TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     6,
+		description: "usage counters",
+		statements: []string{
+			`CREATE TABLE usage_counters (
+				name TEXT NOT NULL,
+				day TEXT NOT NULL,
+				count INTEGER NOT NULL,
+				PRIMARY KEY (name, day)
+			)`,
+		},
+	},
+	{
+		version:     7,
+		description: "GeoIP location",
+		statements: []string{
+			`ALTER TABLE ip_info ADD COLUMN city TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE ip_info ADD COLUMN asn INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE ip_info ADD COLUMN as_org TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     8,
+		description: "manual unblock",
+		statements: []string{
+			`ALTER TABLE ip_info ADD COLUMN manual_unblock INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+}
+
+// latestVersion returns the version reached by a list of migrations
+func latestVersion(migrations []migration) int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate brings the schema to the latest version. Each migration runs in
+// its own transaction together with the update of schema_version. infer
+// detects the version of databases created before versioning, if any.
+// Queries use $n placeholders, understood by both SQLite and PostgreSQL.
+func migrate(db *sql.DB, migrations []migration, infer func(*sql.DB) (int, error)) error {
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	current, err := currentVersion(db, migrations, infer)
+	if err != nil {
+		return err
+	}
+
+	if latest := latestVersion(migrations); current > latest {
+		return fmt.Errorf("%w: database is at version %d, this build supports up to %d",
+			ErrSchemaTooNew, current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return err
+		}
+		log.Printf("Migrated database schema to version %d (%s)", m.version, m.description)
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("migration %d: %w", m.version, err)
+	}
+	defer tx.Rollback()
+
+	for _, statement := range m.statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+
+	if _, err := tx.Exec("INSERT INTO schema_version (version, description) VALUES ($1, $2)", m.version, m.description); err != nil {
+		return fmt.Errorf("migration %d: failed to record version: %w", m.version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// currentVersion returns the version recorded in schema_version. Databases
+// created before versioning have an empty table: their version is inferred
+// from the tables present, and recorded.
+func currentVersion(db *sql.DB, migrations []migration, infer func(*sql.DB) (int, error)) (int, error) {
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version.Valid {
+		return int(version.Int64), nil
+	}
+	if infer == nil {
+		return 0, nil
+	}
+
+	inferred, err := infer(db)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range migrations {
+		if m.version > inferred {
+			break
+		}
+		if _, err := db.Exec("INSERT INTO schema_version (version, description) VALUES ($1, $2)", m.version, m.description); err != nil {
+			return 0, fmt.Errorf("failed to record schema version: %w", err)
+		}
+	}
+
+	if inferred > 0 {
+		log.Printf("Detected unversioned database schema, assuming version %d", inferred)
+	}
+	return inferred, nil
+}
+
+// inferSQLiteVersion detects an unversioned SQLite database. Versioning
+// came with the first schema change, so such a database has the initial
+// schema if it has any.
+func inferSQLiteVersion(db *sql.DB) (int, error) {
+	columns, err := tableColumns(db, "ip_info")
+	if err != nil {
+		return 0, err
+	}
+	if len(columns) == 0 {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// tableColumns returns the column names of a table, or nothing if the
+// table does not exist
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info($1)", table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
+}
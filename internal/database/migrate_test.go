@@ -0,0 +1,153 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// loadFixture creates a SQLite database with the initial schema, as it
+// was before schema_version existed
+func loadFixture(t *testing.T) string {
+	t.Helper()
+
+	schema, err := os.ReadFile(filepath.Join("testdata", "sqlite_v1.sql"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "gatekeeper.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("failed to load fixture: %v", err)
+	}
+	return path
+}
+
+func TestInferSQLiteVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		path func(t *testing.T) string
+		want int
+	}{
+		{name: "empty", path: func(t *testing.T) string { return filepath.Join(t.TempDir(), "gatekeeper.db") }, want: 0},
+		{name: "initial schema", path: loadFixture, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sql.Open("sqlite", tt.path(t))
+			if err != nil {
+				t.Fatalf("sql.Open() error = %v", err)
+			}
+			defer db.Close()
+
+			got, err := inferSQLiteVersion(db)
+			if err != nil {
+				t.Fatalf("inferSQLiteVersion() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("inferSQLiteVersion() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	db, err := NewIPDatabase(loadFixture(t), time.Hour)
+	if err != nil {
+		t.Fatalf("NewIPDatabase() error = %v", err)
+	}
+	defer db.Close()
+
+	checkLatest(t, db.db)
+
+	info, ok := db.GetStale("192.0.2.1")
+	if !ok {
+		t.Fatal("fixture record lost")
+	}
+	if info.Score != 80 || info.Country != "FR" || info.Path != "/admin" || !info.BlockedInFW {
+		t.Errorf("record = %+v", info)
+	}
+	if want := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC); !info.Timestamp.Equal(want) {
+		t.Errorf("timestamp = %v, want %v", info.Timestamp, want)
+	}
+	if info.ManualUnblock || len(info.Reputation) != 0 {
+		t.Errorf("record = %+v, want the new columns empty", info)
+	}
+}
+
+// TestMigrateFromEachVersion migrates databases left at every version by
+// an older build
+func TestMigrateFromEachVersion(t *testing.T) {
+	for version := 1; version <= latestVersion(sqliteMigrations); version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "gatekeeper.db")
+
+			db, err := sql.Open("sqlite", path)
+			if err != nil {
+				t.Fatalf("sql.Open() error = %v", err)
+			}
+			if err := migrate(db, sqliteMigrations[:version], nil); err != nil {
+				t.Fatalf("migrate() to v%d error = %v", version, err)
+			}
+			db.Close()
+
+			store, err := NewIPDatabase(path, time.Hour)
+			if err != nil {
+				t.Fatalf("NewIPDatabase() error = %v", err)
+			}
+			defer store.Close()
+
+			checkLatest(t, store.db)
+		})
+	}
+}
+
+// checkLatest verifies that every migration was recorded once
+func checkLatest(t *testing.T, db *sql.DB) {
+	t.Helper()
+
+	var count, latest int
+	if err := db.QueryRow("SELECT COUNT(*), MAX(version) FROM schema_version").Scan(&count, &latest); err != nil {
+		t.Fatalf("failed to read schema_version: %v", err)
+	}
+	if want := latestVersion(sqliteMigrations); count != want || latest != want {
+		t.Errorf("schema_version has %d versions up to %d, want %d", count, latest, want)
+	}
+}
+
+func TestMigrateEmptyDatabase(t *testing.T) {
+	db, err := NewIPDatabase(filepath.Join(t.TempDir(), "gatekeeper.db"), time.Hour)
+	if err != nil {
+		t.Fatalf("NewIPDatabase() error = %v", err)
+	}
+	defer db.Close()
+
+	checkLatest(t, db.db)
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gatekeeper.db")
+	store, err := NewIPDatabase(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewIPDatabase() error = %v", err)
+	}
+	if _, err := store.db.Exec("INSERT INTO schema_version (version, description) VALUES (999, 'from the future')"); err != nil {
+		t.Fatalf("failed to record version: %v", err)
+	}
+	store.Close()
+
+	if _, err := NewIPDatabase(path, time.Hour); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("NewIPDatabase() error = %v, want ErrSchemaTooNew", err)
+	}
+}
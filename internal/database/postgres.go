@@ -0,0 +1,557 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	_ "github.com/lib/pq"
+)
+
+// postgresMigrations mirrors sqliteMigrations version by version
+var postgresMigrations = []migration{
+	{
+		version:     1,
+		description: "initial schema",
+		statements: []string{
+			`CREATE TABLE ip_info (
+				address TEXT PRIMARY KEY,
+				score INTEGER NOT NULL,
+				country TEXT NOT NULL,
+				path TEXT NOT NULL,
+				payload_path TEXT,
+				blocked_in_fw BOOLEAN NOT NULL DEFAULT FALSE,
+				timestamp TIMESTAMPTZ NOT NULL DEFAULT now(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX idx_timestamp ON ip_info(timestamp)`,
+			`CREATE INDEX idx_score ON ip_info(score)`,
+			`CREATE INDEX idx_blocked ON ip_info(blocked_in_fw)`,
+		},
+	},
+	{
+		version:     2,
+		description: "block expiry",
+		statements: []string{
+			`ALTER TABLE ip_info ADD COLUMN blocked_at TIMESTAMPTZ`,
+			`ALTER TABLE ip_info ADD COLUMN unblocked_at TIMESTAMPTZ`,
+		},
+	},
+	{
+		version:     3,
+		description: "TLS fingerprints",
+		statements: []string{
+			`ALTER TABLE ip_info ADD COLUMN tls_server_name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE ip_info ADD COLUMN ja3 TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE ip_info ADD COLUMN ja4 TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     4,
+		description: "events",
+		statements: []string{
+			`CREATE TABLE events (
+				id BIGSERIAL PRIMARY KEY,
+				timestamp TIMESTAMPTZ NOT NULL,
+				address TEXT NOT NULL,
+				listener TEXT NOT NULL,
+				method TEXT NOT NULL DEFAULT '',
+				host TEXT NOT NULL DEFAULT '',
+				path TEXT NOT NULL DEFAULT '',
+				user_agent TEXT NOT NULL DEFAULT '',
+				headers_hash TEXT NOT NULL DEFAULT '',
+				action TEXT NOT NULL
+			)`,
+			`CREATE INDEX idx_events_address ON events(address, timestamp)`,
+			`CREATE INDEX idx_events_timestamp ON events(timestamp)`,
+		},
+	},
+	{
+		version:     5,
+		description: "reputation provider results",
+		statements: []string{
+			`ALTER TABLE ip_info ADD COLUMN reputation TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     6,
+		description: "usage counters",
+		statements: []string{
+			`CREATE TABLE usage_counters (
+				name TEXT NOT NULL,
+				day TEXT NOT NULL,
+				count INTEGER NOT NULL,
+				PRIMARY KEY (name, day)
+			)`,
+		},
+	},
+	{
+		version:     7,
+		description: "GeoIP location",
+		statements: []string{
+			`ALTER TABLE ip_info ADD COLUMN city TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE ip_info ADD COLUMN asn BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE ip_info ADD COLUMN as_org TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     8,
+		description: "manual unblock",
+		statements: []string{
+			`ALTER TABLE ip_info ADD COLUMN manual_unblock BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	},
+}
+
+// PostgresStore is a Store backed by PostgreSQL, which lets several
+// GateKeeper nodes share their verdicts
+type PostgresStore struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+// NewPostgresStore connects to PostgreSQL and migrates the schema
+func NewPostgresStore(dsn string, ttl time.Duration) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+
+	if err := migratePostgres(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.Println("PostgreSQL database initialized")
+	return &PostgresStore{db: db, ttl: ttl}, nil
+}
+
+// migrationLockID identifies the advisory lock held while migrating the
+// schema. It must never change, or old and new nodes would not exclude
+// each other.
+const migrationLockID = 0x4761746b6565
+
+// migratePostgres migrates the schema under an advisory lock, so that
+// nodes starting together against the same database wait for each other
+// instead of racing on schema_version and the DDL
+func migratePostgres(db *sql.DB) error {
+	ctx := context.Background()
+
+	// Advisory locks belong to a session: keep a connection for the lock
+	// while the migrations run on the others
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to lock schema migrations: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("failed to lock schema migrations: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			log.Printf("Failed to unlock schema migrations: %v", err)
+		}
+	}()
+
+	return migrate(db, postgresMigrations, nil)
+}
+
+func (s *PostgresStore) Get(ip string) (*domain.IPInfo, bool) {
+	query := `
+		SELECT ` + ipInfoColumns + `
+		FROM ip_info
+		WHERE address = $1 AND timestamp > now() - make_interval(secs => $2)
+	`
+
+	info, err := scanPostgresIPInfo(s.db.QueryRow(query, ip, s.ttl.Seconds()))
+	if err == sql.ErrNoRows {
+		return nil, false
+	}
+
+	if err != nil {
+		log.Printf("Database Get error: %v", err)
+		return nil, false
+	}
+
+	return info, true
+}
+
+func (s *PostgresStore) GetStale(ip string) (*domain.IPInfo, bool) {
+	query := `SELECT ` + ipInfoColumns + ` FROM ip_info WHERE address = $1`
+
+	info, err := scanPostgresIPInfo(s.db.QueryRow(query, ip))
+	if err == sql.ErrNoRows {
+		return nil, false
+	}
+
+	if err != nil {
+		log.Printf("Database GetStale error: %v", err)
+		return nil, false
+	}
+
+	return info, true
+}
+
+func scanPostgresIPInfo(row scanner) (*domain.IPInfo, error) {
+	var info domain.IPInfo
+	var payloadPath sql.NullString
+	var blockedAt sql.NullTime
+	var reputation string
+
+	err := row.Scan(
+		&info.Address,
+		&info.Score,
+		&info.Country,
+		&info.Path,
+		&payloadPath,
+		&info.BlockedInFW,
+		&info.Timestamp,
+		&blockedAt,
+		&info.TLSServerName,
+		&info.JA3,
+		&info.JA4,
+		&reputation,
+		&info.City,
+		&info.ASN,
+		&info.ASOrg,
+		&info.ManualUnblock,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	info.Reputation = decodeReputation(reputation)
+	info.PayloadPath = payloadPath.String
+	if blockedAt.Valid {
+		info.BlockedAt = blockedAt.Time
+	}
+
+	return &info, nil
+}
+
+func (s *PostgresStore) Set(info *domain.IPInfo) error {
+	query := `
+		INSERT INTO ip_info (address, score, country, path, payload_path, blocked_in_fw, tls_server_name, ja3, ja4, reputation, city, asn, as_org, timestamp, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, now())
+		ON CONFLICT (address) DO UPDATE SET
+			score = excluded.score,
+			timestamp = excluded.timestamp,
+			country = excluded.country,
+			path = excluded.path,
+			payload_path = excluded.payload_path,
+			blocked_in_fw = ip_info.blocked_in_fw OR excluded.blocked_in_fw,
+			tls_server_name = excluded.tls_server_name,
+			ja3 = excluded.ja3,
+			ja4 = excluded.ja4,
+			reputation = excluded.reputation,
+			city = excluded.city,
+			asn = excluded.asn,
+			as_org = excluded.as_org,
+			updated_at = now()
+	`
+
+	var payloadPath sql.NullString
+	if info.PayloadPath != "" {
+		payloadPath = sql.NullString{String: info.PayloadPath, Valid: true}
+	}
+
+	_, err := s.db.Exec(query, info.Address, int(info.Score), info.Country, info.Path, payloadPath, info.BlockedInFW,
+		info.TLSServerName, info.JA3, info.JA4, encodeReputation(info.Reputation), info.City, int64(info.ASN), info.ASOrg, checkTime(info))
+	if err != nil {
+		return fmt.Errorf("failed to set IP info: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) Delete(ip string) error {
+	_, err := s.db.Exec("DELETE FROM ip_info WHERE address = $1", ip)
+	return err
+}
+
+func (s *PostgresStore) PruneRecords(before time.Time) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM ip_info WHERE timestamp < $1 AND NOT blocked_in_fw", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune records: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *PostgresStore) GetPayloadPaths() ([]string, error) {
+	paths, err := s.queryStrings("SELECT payload_path FROM ip_info WHERE payload_path IS NOT NULL AND payload_path != ''")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payload paths: %w", err)
+	}
+	return paths, nil
+}
+
+// ClearPayloadPath removes the references to a deleted payload file
+func (s *PostgresStore) ClearPayloadPath(path string) error {
+	if _, err := s.db.Exec("UPDATE ip_info SET payload_path = NULL, updated_at = now() WHERE payload_path = $1", path); err != nil {
+		return fmt.Errorf("failed to clear payload path: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) MarkBlocked(ip string) error {
+	query := `
+		UPDATE ip_info
+		SET blocked_at = CASE WHEN blocked_in_fw AND blocked_at IS NOT NULL THEN blocked_at ELSE now() END,
+			blocked_in_fw = TRUE,
+			unblocked_at = NULL,
+			manual_unblock = FALSE,
+			updated_at = now()
+		WHERE address = $1
+	`
+
+	if _, err := s.db.Exec(query, ip); err != nil {
+		return fmt.Errorf("failed to mark IP as blocked: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) MarkUnblocked(ip string) error {
+	query := `
+		UPDATE ip_info
+		SET blocked_in_fw = FALSE, unblocked_at = now(), updated_at = now()
+		WHERE address = $1
+	`
+
+	if _, err := s.db.Exec(query, ip); err != nil {
+		return fmt.Errorf("failed to mark IP as unblocked: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) MarkManualUnblock(ip string) error {
+	if _, err := s.db.Exec("UPDATE ip_info SET manual_unblock = TRUE, updated_at = now() WHERE address = $1", ip); err != nil {
+		return fmt.Errorf("failed to mark IP as manually unblocked: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetExpiredBlocks(duration time.Duration) ([]string, error) {
+	query := `
+		SELECT address
+		FROM ip_info
+		WHERE blocked_in_fw
+		AND COALESCE(blocked_at, updated_at) < now() - make_interval(secs => $1)
+	`
+
+	ips, err := s.queryStrings(query, duration.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expired blocks: %w", err)
+	}
+	return ips, nil
+}
+
+func (s *PostgresStore) GetBlockedIPs() ([]string, error) {
+	ips, err := s.queryStrings("SELECT address FROM ip_info WHERE blocked_in_fw")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blocked IPs: %w", err)
+	}
+	return ips, nil
+}
+
+// queryStrings runs a query returning a single text column
+func (s *PostgresStore) queryStrings(query string, args ...any) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, rows.Err()
+}
+
+func (s *PostgresStore) GetBlockStates() (map[string]BlockState, error) {
+	rows, err := s.db.Query("SELECT address, blocked_in_fw, unblocked_at IS NOT NULL, blocked_at FROM ip_info")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block states: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]BlockState)
+	for rows.Next() {
+		var ip string
+		var state BlockState
+		var blockedAt sql.NullTime
+		if err := rows.Scan(&ip, &state.Blocked, &state.Unblocked, &blockedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan block state: %w", err)
+		}
+		if blockedAt.Valid {
+			state.BlockedAt = blockedAt.Time
+		}
+		states[ip] = state
+	}
+
+	return states, rows.Err()
+}
+
+func (s *PostgresStore) GetAllIPs() ([]*domain.IPInfo, error) {
+	query := `
+		SELECT ` + ipInfoColumns + `
+		FROM ip_info
+		ORDER BY timestamp DESC
+		LIMIT 100
+	`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []*domain.IPInfo
+	for rows.Next() {
+		info, err := scanPostgresIPInfo(rows)
+		if err != nil {
+			continue
+		}
+		ips = append(ips, info)
+	}
+
+	return ips, nil
+}
+
+func (s *PostgresStore) GetStats() (Stats, error) {
+	var stats Stats
+
+	query := `
+		SELECT
+			COUNT(*),
+			COUNT(*) FILTER (WHERE timestamp > now() - make_interval(secs => $1)),
+			COUNT(*) FILTER (WHERE blocked_in_fw),
+			(SELECT COUNT(*) FROM events),
+			pg_database_size(current_database())
+		FROM ip_info
+	`
+
+	err := s.db.QueryRow(query, s.ttl.Seconds()).Scan(
+		&stats.TotalEntries,
+		&stats.ActiveEntries,
+		&stats.BlockedEntries,
+		&stats.TotalEvents,
+		&stats.DBSize,
+	)
+	return stats, err
+}
+
+func (s *PostgresStore) AddEvents(events []*domain.Event) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to add events: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO events (timestamp, address, listener, method, host, path, user_agent, headers_hash, action)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add events: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, event := range events {
+		if event.Timestamp.IsZero() {
+			event.Timestamp = time.Now()
+		}
+
+		err := stmt.QueryRow(
+			event.Timestamp,
+			event.Address,
+			event.Listener,
+			event.Method,
+			event.Host,
+			event.Path,
+			event.UserAgent,
+			event.HeadersHash,
+			event.Action,
+		).Scan(&event.ID)
+		if err != nil {
+			return fmt.Errorf("failed to add event: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to add events: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetEvents(ip string, limit int) ([]*domain.Event, error) {
+	query := `
+		SELECT id, timestamp, address, listener, method, host, path, user_agent, headers_hash, action
+		FROM events
+		WHERE $1 = '' OR address = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`
+
+	rows, err := s.db.Query(query, ip, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*domain.Event
+	for rows.Next() {
+		var event domain.Event
+		err := rows.Scan(
+			&event.ID,
+			&event.Timestamp,
+			&event.Address,
+			&event.Listener,
+			&event.Method,
+			&event.Host,
+			&event.Path,
+			&event.UserAgent,
+			&event.HeadersHash,
+			&event.Action,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *PostgresStore) PruneEvents(before time.Time) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM events WHERE timestamp < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
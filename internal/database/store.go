@@ -0,0 +1,144 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// Storage drivers
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
+// ErrUnknownDriver is returned by Open for an unsupported driver
+var ErrUnknownDriver = errors.New("database: unknown driver")
+
+// Store persists IP verdicts and connection events
+type Store interface {
+	// Get returns the entry of an IP if it was checked within the TTL
+	Get(ip string) (*domain.IPInfo, bool)
+	// GetStale returns the entry of an IP whatever its age, to fall back
+	// on when the reputation providers are unavailable
+	GetStale(ip string) (*domain.IPInfo, bool)
+	// Set creates or updates an entry. Its Timestamp is the time of the
+	// reputation check, now if zero. The blocked flag is never cleared.
+	Set(info *domain.IPInfo) error
+	Delete(ip string) error
+	// PruneRecords deletes the unblocked entries checked before the given time
+	PruneRecords(before time.Time) (int64, error)
+	GetPayloadPaths() ([]string, error)
+	// ClearPayloadPath removes the references to a deleted payload file
+	ClearPayloadPath(path string) error
+
+	// MarkBlocked flags an IP as blocked and clears its manual unblock
+	MarkBlocked(ip string) error
+	MarkUnblocked(ip string) error
+	// MarkManualUnblock records that an IP was unblocked by hand
+	MarkManualUnblock(ip string) error
+	GetExpiredBlocks(duration time.Duration) ([]string, error)
+	GetBlockedIPs() ([]string, error)
+	GetBlockStates() (map[string]BlockState, error)
+
+	GetAllIPs() ([]*domain.IPInfo, error)
+	GetStats() (Stats, error)
+
+	// AddEvents records connection attempts in a single transaction
+	AddEvents(events []*domain.Event) error
+	GetEvents(ip string, limit int) ([]*domain.Event, error)
+	PruneEvents(before time.Time) (int64, error)
+
+	// ConsumeUsage increments the counter of name for the UTC day of t,
+	// unless it already reached limit. It reports whether it did.
+	ConsumeUsage(name string, t time.Time, limit int) (bool, error)
+	// GetUsage returns the counter of name for the UTC day of t
+	GetUsage(name string, t time.Time) (int, error)
+
+	Close() error
+}
+
+// Open opens a store. The source is a file path for SQLite and a
+// connection string for PostgreSQL; it is ignored by the memory store.
+func Open(driver, source string, ttl time.Duration) (Store, error) {
+	switch driver {
+	case "", DriverSQLite:
+		return NewIPDatabase(source, ttl)
+	case DriverPostgres:
+		return NewPostgresStore(source, ttl)
+	case DriverMemory:
+		return NewMemoryStore(ttl), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownDriver, driver)
+	}
+}
+
+// checkTime returns the time of the reputation check of an entry
+func checkTime(info *domain.IPInfo) time.Time {
+	if info.Timestamp.IsZero() {
+		return time.Now()
+	}
+	return info.Timestamp
+}
+
+// encodeReputation serializes the provider results of an entry
+func encodeReputation(results []domain.ReputationResult) string {
+	if len(results) == 0 {
+		return ""
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		log.Printf("Failed to encode reputation results: %v", err)
+		return ""
+	}
+	return string(data)
+}
+
+// decodeReputation parses the provider results stored by encodeReputation
+func decodeReputation(data string) []domain.ReputationResult {
+	if data == "" {
+		return nil
+	}
+
+	var results []domain.ReputationResult
+	if err := json.Unmarshal([]byte(data), &results); err != nil {
+		log.Printf("Failed to decode reputation results: %v", err)
+		return nil
+	}
+	return results
+}
+
+// BlockState describes the firewall state recorded for an IP
+type BlockState struct {
+	// Blocked is true when the IP is flagged as blocked in the firewalls
+	Blocked bool
+	// Unblocked is true when GateKeeper removed the IP from the firewalls
+	Unblocked bool
+	// BlockedAt is when the IP was blocked, zero if unknown
+	BlockedAt time.Time
+}
+
+// Stats contains database statistics
+type Stats struct {
+	TotalEntries   int64
+	ActiveEntries  int64
+	BlockedEntries int64
+	TotalEvents    int64
+	DBSize         int64
+}
+
+// RetentionStats reports what the retention job removed since startup
+type RetentionStats struct {
+	LastRun           time.Time `json:"last_run"`
+	RecordsDeleted    int64     `json:"records_deleted"`
+	EventsDeleted     int64     `json:"events_deleted"`
+	PayloadsExpired   int64     `json:"payloads_expired"`
+	PayloadsOrphaned  int64     `json:"payloads_orphaned"`
+	PayloadBytesFreed int64     `json:"payload_bytes_freed"`
+}
@@ -0,0 +1,492 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// postgresDSNEnv names the variable holding the connection string of a
+// PostgreSQL database to run the store tests against. Its tables are
+// emptied by each test.
+const postgresDSNEnv = "GATEKEEPER_TEST_POSTGRES_DSN"
+
+// storeTTL is the TTL of the stores under test
+const storeTTL = time.Hour
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		db, err := NewIPDatabase(filepath.Join(t.TempDir(), "gatekeeper.db"), storeTTL)
+		if err != nil {
+			t.Fatalf("NewIPDatabase() error = %v", err)
+		}
+		return db
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemoryStore(storeTTL)
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", postgresDSNEnv)
+	}
+
+	testStore(t, func(t *testing.T) Store {
+		s, err := NewPostgresStore(dsn, storeTTL)
+		if err != nil {
+			t.Fatalf("NewPostgresStore() error = %v", err)
+		}
+		if _, err := s.db.Exec("TRUNCATE ip_info, events, usage_counters RESTART IDENTITY"); err != nil {
+			s.Close()
+			t.Fatalf("failed to empty the database: %v", err)
+		}
+		return s
+	})
+}
+
+// testStore runs the behaviour shared by every Store implementation.
+// newStore returns an empty store.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s Store)
+	}{
+		{name: "set and get", test: testSetGet},
+		{name: "ttl", test: testTTL},
+		{name: "set keeps the block state", test: testSetKeepsBlockState},
+		{name: "block lifecycle", test: testBlockLifecycle},
+		{name: "expired blocks", test: testExpiredBlocks},
+		{name: "delete and prune", test: testDeletePrune},
+		{name: "payload paths", test: testPayloadPaths},
+		{name: "all IPs", test: testAllIPs},
+		{name: "stats", test: testStats},
+		{name: "events", test: testEvents},
+		{name: "usage", test: testUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			t.Cleanup(func() { s.Close() })
+			tt.test(t, s)
+		})
+	}
+}
+
+// truncatedNow returns the current time at the precision of every store
+func truncatedNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func mustSet(t *testing.T, s Store, info *domain.IPInfo) {
+	t.Helper()
+
+	if err := s.Set(info); err != nil {
+		t.Fatalf("Set(%s) error = %v", info.Address, err)
+	}
+}
+
+func mustGetStale(t *testing.T, s Store, ip string) *domain.IPInfo {
+	t.Helper()
+
+	info, ok := s.GetStale(ip)
+	if !ok {
+		t.Fatalf("GetStale(%s) found nothing", ip)
+	}
+	return info
+}
+
+func testSetGet(t *testing.T, s Store) {
+	checked := truncatedNow()
+	want := domain.IPInfo{
+		Address:       "192.0.2.1",
+		Score:         80,
+		Country:       "FR",
+		City:          "Paris",
+		ASN:           64496,
+		ASOrg:         "Example Networks",
+		Path:          "/admin",
+		PayloadPath:   "payloads/192.0.2.1.bin",
+		Timestamp:     checked,
+		TLSServerName: "example.com",
+		JA3:           "e7d705a3286e19ea42f587b344ee6865",
+		JA4:           "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		Reputation:    []domain.ReputationResult{{Provider: "abuseipdb", Score: 80, Malicious: true}},
+	}
+	mustSet(t, s, &want)
+
+	for name, get := range map[string]func(string) (*domain.IPInfo, bool){"Get": s.Get, "GetStale": s.GetStale} {
+		got, ok := get("192.0.2.1")
+		if !ok {
+			t.Fatalf("%s() found nothing", name)
+		}
+		if got.Address != want.Address || got.Score != want.Score || got.Country != want.Country ||
+			got.City != want.City || got.ASN != want.ASN || got.ASOrg != want.ASOrg ||
+			got.Path != want.Path || got.PayloadPath != want.PayloadPath ||
+			got.TLSServerName != want.TLSServerName || got.JA3 != want.JA3 || got.JA4 != want.JA4 {
+			t.Errorf("%s() = %+v, want %+v", name, got, want)
+		}
+		if !got.Timestamp.Equal(checked) {
+			t.Errorf("%s() timestamp = %v, want %v", name, got.Timestamp, checked)
+		}
+		if len(got.Reputation) != 1 || got.Reputation[0] != want.Reputation[0] {
+			t.Errorf("%s() reputation = %+v, want %+v", name, got.Reputation, want.Reputation)
+		}
+		if got.BlockedInFW || !got.BlockedAt.IsZero() || got.ManualUnblock {
+			t.Errorf("%s() = %+v, want it unblocked", name, got)
+		}
+	}
+
+	if _, ok := s.Get("192.0.2.2"); ok {
+		t.Error("Get() found an unknown IP")
+	}
+	if _, ok := s.GetStale("192.0.2.2"); ok {
+		t.Error("GetStale() found an unknown IP")
+	}
+
+	// A zero timestamp is the time of the write
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.2", Country: "Unknown"})
+	if got := mustGetStale(t, s, "192.0.2.2"); time.Since(got.Timestamp) > time.Minute {
+		t.Errorf("timestamp = %v, want now", got.Timestamp)
+	}
+}
+
+func testTTL(t *testing.T, s Store) {
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.1", Score: 80, Country: "FR", Timestamp: truncatedNow().Add(-2 * storeTTL)})
+
+	if _, ok := s.Get("192.0.2.1"); ok {
+		t.Error("Get() returned an entry older than the TTL")
+	}
+	if got := mustGetStale(t, s, "192.0.2.1"); got.Score != 80 {
+		t.Errorf("GetStale() = %+v", got)
+	}
+}
+
+func testSetKeepsBlockState(t *testing.T, s Store) {
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.1", Score: 80, Country: "FR"})
+	if err := s.MarkBlocked("192.0.2.1"); err != nil {
+		t.Fatalf("MarkBlocked() error = %v", err)
+	}
+	blockedAt := mustGetStale(t, s, "192.0.2.1").BlockedAt
+
+	// A new check never clears the block, nor moves its time
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.1", Score: 10, Country: "FR", BlockedAt: truncatedNow().Add(time.Hour)})
+	got := mustGetStale(t, s, "192.0.2.1")
+	if got.Score != 10 || !got.BlockedInFW || !got.BlockedAt.Equal(blockedAt) {
+		t.Errorf("entry = %+v, want score 10 blocked at %v", got, blockedAt)
+	}
+
+	if err := s.MarkManualUnblock("192.0.2.1"); err != nil {
+		t.Fatalf("MarkManualUnblock() error = %v", err)
+	}
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.1", Score: 90, Country: "FR"})
+	if got := mustGetStale(t, s, "192.0.2.1"); !got.ManualUnblock {
+		t.Error("manual unblock cleared by Set()")
+	}
+
+	// The flag of a new check is recorded
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.2", Country: "FR", BlockedInFW: true})
+	if got := mustGetStale(t, s, "192.0.2.2"); !got.BlockedInFW {
+		t.Error("blocked flag of a new entry lost")
+	}
+}
+
+func testBlockLifecycle(t *testing.T, s Store) {
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.1", Score: 80, Country: "FR"})
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.2", Score: 10, Country: "FR"})
+
+	checkState := func(want BlockState, wantBlocked []string) {
+		t.Helper()
+
+		states, err := s.GetBlockStates()
+		if err != nil {
+			t.Fatalf("GetBlockStates() error = %v", err)
+		}
+		got := states["192.0.2.1"]
+		if got.Blocked != want.Blocked || got.Unblocked != want.Unblocked || got.BlockedAt.IsZero() != want.BlockedAt.IsZero() {
+			t.Errorf("state = %+v, want %+v", got, want)
+		}
+		if len(states) != 2 || states["192.0.2.2"] != (BlockState{}) {
+			t.Errorf("states = %+v, want 192.0.2.2 untouched", states)
+		}
+
+		blocked, err := s.GetBlockedIPs()
+		if err != nil {
+			t.Fatalf("GetBlockedIPs() error = %v", err)
+		}
+		if !slices.Equal(blocked, wantBlocked) {
+			t.Errorf("GetBlockedIPs() = %v, want %v", blocked, wantBlocked)
+		}
+	}
+
+	checkState(BlockState{}, nil)
+
+	if err := s.MarkBlocked("192.0.2.1"); err != nil {
+		t.Fatalf("MarkBlocked() error = %v", err)
+	}
+	checkState(BlockState{Blocked: true, BlockedAt: truncatedNow()}, []string{"192.0.2.1"})
+	blockedAt := mustGetStale(t, s, "192.0.2.1").BlockedAt
+
+	// Blocking again keeps the time of the first block
+	time.Sleep(1100 * time.Millisecond)
+	if err := s.MarkBlocked("192.0.2.1"); err != nil {
+		t.Fatalf("MarkBlocked() error = %v", err)
+	}
+	if got := mustGetStale(t, s, "192.0.2.1").BlockedAt; !got.Equal(blockedAt) {
+		t.Errorf("blocked at %v, want %v", got, blockedAt)
+	}
+
+	if err := s.MarkUnblocked("192.0.2.1"); err != nil {
+		t.Fatalf("MarkUnblocked() error = %v", err)
+	}
+	if err := s.MarkManualUnblock("192.0.2.1"); err != nil {
+		t.Fatalf("MarkManualUnblock() error = %v", err)
+	}
+	checkState(BlockState{Unblocked: true, BlockedAt: blockedAt}, nil)
+	if got := mustGetStale(t, s, "192.0.2.1"); got.BlockedInFW || !got.ManualUnblock {
+		t.Errorf("entry = %+v, want it manually unblocked", got)
+	}
+
+	// Blocking again starts a new block and clears the manual unblock
+	if err := s.MarkBlocked("192.0.2.1"); err != nil {
+		t.Fatalf("MarkBlocked() error = %v", err)
+	}
+	checkState(BlockState{Blocked: true, BlockedAt: truncatedNow()}, []string{"192.0.2.1"})
+	got := mustGetStale(t, s, "192.0.2.1")
+	if got.ManualUnblock || !got.BlockedAt.After(blockedAt) {
+		t.Errorf("entry = %+v, want a new block after %v", got, blockedAt)
+	}
+
+	// Unknown IPs are not created
+	for name, mark := range map[string]func(string) error{
+		"MarkBlocked":       s.MarkBlocked,
+		"MarkUnblocked":     s.MarkUnblocked,
+		"MarkManualUnblock": s.MarkManualUnblock,
+	} {
+		if err := mark("192.0.2.3"); err != nil {
+			t.Errorf("%s() of an unknown IP error = %v", name, err)
+		}
+	}
+	if _, ok := s.GetStale("192.0.2.3"); ok {
+		t.Error("unknown IP created")
+	}
+}
+
+func testExpiredBlocks(t *testing.T, s Store) {
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.1", Score: 80, Country: "FR"})
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.2", Score: 80, Country: "FR"})
+	if err := s.MarkBlocked("192.0.2.1"); err != nil {
+		t.Fatalf("MarkBlocked() error = %v", err)
+	}
+
+	expired, err := s.GetExpiredBlocks(time.Hour)
+	if err != nil {
+		t.Fatalf("GetExpiredBlocks() error = %v", err)
+	}
+	if len(expired) != 0 {
+		t.Errorf("GetExpiredBlocks(1h) = %v, want none", expired)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	expired, err = s.GetExpiredBlocks(0)
+	if err != nil {
+		t.Fatalf("GetExpiredBlocks() error = %v", err)
+	}
+	if !slices.Equal(expired, []string{"192.0.2.1"}) {
+		t.Errorf("GetExpiredBlocks(0) = %v, want [192.0.2.1]", expired)
+	}
+}
+
+func testDeletePrune(t *testing.T, s Store) {
+	old := truncatedNow().Add(-2 * storeTTL)
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.1", Country: "FR", Timestamp: old})
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.2", Country: "FR", Timestamp: old, BlockedInFW: true})
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.3", Country: "FR"})
+
+	// Blocked entries are kept whatever their age
+	pruned, err := s.PruneRecords(truncatedNow().Add(-storeTTL))
+	if err != nil {
+		t.Fatalf("PruneRecords() error = %v", err)
+	}
+	if pruned != 1 {
+		t.Errorf("PruneRecords() = %d, want 1", pruned)
+	}
+	for ip, want := range map[string]bool{"192.0.2.1": false, "192.0.2.2": true, "192.0.2.3": true} {
+		if _, ok := s.GetStale(ip); ok != want {
+			t.Errorf("GetStale(%s) found = %t, want %t", ip, ok, want)
+		}
+	}
+
+	if err := s.Delete("192.0.2.2"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok := s.GetStale("192.0.2.2"); ok {
+		t.Error("deleted entry still stored")
+	}
+	if err := s.Delete("192.0.2.9"); err != nil {
+		t.Errorf("Delete() of an unknown IP error = %v", err)
+	}
+}
+
+func testPayloadPaths(t *testing.T, s Store) {
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.1", Country: "FR", PayloadPath: "payloads/a.bin"})
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.2", Country: "FR", PayloadPath: "payloads/b.bin"})
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.3", Country: "FR"})
+
+	paths, err := s.GetPayloadPaths()
+	if err != nil {
+		t.Fatalf("GetPayloadPaths() error = %v", err)
+	}
+	slices.Sort(paths)
+	if want := []string{"payloads/a.bin", "payloads/b.bin"}; !slices.Equal(paths, want) {
+		t.Errorf("GetPayloadPaths() = %v, want %v", paths, want)
+	}
+
+	if err := s.ClearPayloadPath("payloads/a.bin"); err != nil {
+		t.Fatalf("ClearPayloadPath() error = %v", err)
+	}
+	if got := mustGetStale(t, s, "192.0.2.1").PayloadPath; got != "" {
+		t.Errorf("payload path = %q, want it cleared", got)
+	}
+	if got := mustGetStale(t, s, "192.0.2.2").PayloadPath; got != "payloads/b.bin" {
+		t.Errorf("payload path = %q, want it kept", got)
+	}
+}
+
+func testAllIPs(t *testing.T, s Store) {
+	checked := truncatedNow()
+	for i, ip := range []string{"192.0.2.2", "192.0.2.1", "192.0.2.3"} {
+		mustSet(t, s, &domain.IPInfo{Address: ip, Country: "FR", Timestamp: checked.Add(time.Duration(i) * time.Minute)})
+	}
+
+	ips, err := s.GetAllIPs()
+	if err != nil {
+		t.Fatalf("GetAllIPs() error = %v", err)
+	}
+	got := make([]string, len(ips))
+	for i, info := range ips {
+		got[i] = info.Address
+	}
+	// Most recently checked first
+	if want := []string{"192.0.2.3", "192.0.2.1", "192.0.2.2"}; !slices.Equal(got, want) {
+		t.Errorf("GetAllIPs() = %v, want %v", got, want)
+	}
+}
+
+func testStats(t *testing.T, s Store) {
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.1", Country: "FR"})
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.2", Country: "FR", BlockedInFW: true})
+	mustSet(t, s, &domain.IPInfo{Address: "192.0.2.3", Country: "FR", Timestamp: truncatedNow().Add(-2 * storeTTL)})
+	if err := s.AddEvents([]*domain.Event{{Address: "192.0.2.1", Listener: "http", Action: domain.ActionTarpit}}); err != nil {
+		t.Fatalf("AddEvents() error = %v", err)
+	}
+
+	stats, err := s.GetStats()
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if stats.TotalEntries != 3 || stats.ActiveEntries != 2 || stats.BlockedEntries != 1 || stats.TotalEvents != 1 {
+		t.Errorf("stats = %+v, want 3 entries, 2 active, 1 blocked and 1 event", stats)
+	}
+}
+
+func testEvents(t *testing.T, s Store) {
+	start := truncatedNow().Add(-time.Hour)
+	events := []*domain.Event{
+		{Timestamp: start, Address: "192.0.2.1", Listener: "http", Method: "GET", Host: "198.51.100.1", Path: "/", UserAgent: "curl/8.0", HeadersHash: "abc", Action: domain.ActionTarpit},
+		{Timestamp: start.Add(time.Minute), Address: "192.0.2.2", Listener: "https", Action: domain.ActionTarpit},
+		{Timestamp: start.Add(2 * time.Minute), Address: "192.0.2.1", Listener: "http", Method: "POST", Path: "/login", Action: domain.ActionTarpit},
+	}
+	if err := s.AddEvents(events); err != nil {
+		t.Fatalf("AddEvents() error = %v", err)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].ID <= events[i-1].ID {
+			t.Fatalf("event IDs = %d then %d, want them increasing", events[i-1].ID, events[i].ID)
+		}
+	}
+
+	// A zero timestamp is the time of the write
+	late := &domain.Event{Address: "192.0.2.3", Listener: "http", Action: domain.ActionTarpit}
+	if err := s.AddEvents([]*domain.Event{late}); err != nil {
+		t.Fatalf("AddEvents() error = %v", err)
+	}
+	if late.Timestamp.IsZero() {
+		t.Error("event timestamp not set")
+	}
+
+	got, err := s.GetEvents("192.0.2.1", 10)
+	if err != nil {
+		t.Fatalf("GetEvents() error = %v", err)
+	}
+	if len(got) != 2 || got[0].ID != events[2].ID || got[1].ID != events[0].ID {
+		t.Fatalf("GetEvents(192.0.2.1) = %+v, want the events of the IP, newest first", got)
+	}
+	first, want := *got[1], *events[0]
+	if !first.Timestamp.Equal(want.Timestamp) {
+		t.Errorf("timestamp = %v, want %v", first.Timestamp, want.Timestamp)
+	}
+	first.Timestamp = want.Timestamp
+	if first != want {
+		t.Errorf("event = %+v, want %+v", first, want)
+	}
+
+	got, err = s.GetEvents("", 2)
+	if err != nil {
+		t.Fatalf("GetEvents() error = %v", err)
+	}
+	if len(got) != 2 || got[0].ID != late.ID || got[1].ID != events[2].ID {
+		t.Errorf("GetEvents(\"\", 2) = %+v, want the 2 latest events", got)
+	}
+
+	pruned, err := s.PruneEvents(start.Add(90 * time.Second))
+	if err != nil {
+		t.Fatalf("PruneEvents() error = %v", err)
+	}
+	if pruned != 2 {
+		t.Errorf("PruneEvents() = %d, want 2", pruned)
+	}
+	if got, _ := s.GetEvents("", 10); len(got) != 2 {
+		t.Errorf("%d events left, want 2", len(got))
+	}
+}
+
+func testUsage(t *testing.T, s Store) {
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, want := range []bool{true, true, false} {
+		ok, err := s.ConsumeUsage("abuseipdb", day, 2)
+		if err != nil {
+			t.Fatalf("ConsumeUsage() error = %v", err)
+		}
+		if ok != want {
+			t.Errorf("ConsumeUsage() #%d = %t, want %t", i+1, ok, want)
+		}
+	}
+
+	// Days are UTC days
+	for _, at := range []time.Time{day, time.Date(2024, 1, 2, 3, 0, 0, 0, time.FixedZone("UTC+5", 5*3600))} {
+		if used, err := s.GetUsage("abuseipdb", at); err != nil || used != 2 {
+			t.Errorf("GetUsage(%v) = %d, %v, want 2", at, used, err)
+		}
+	}
+	if used, err := s.GetUsage("abuseipdb", day.AddDate(0, 0, 1)); err != nil || used != 0 {
+		t.Errorf("GetUsage() of the next day = %d, %v, want 0", used, err)
+	}
+	if used, err := s.GetUsage("otx", day); err != nil || used != 0 {
+		t.Errorf("GetUsage() of another counter = %d, %v, want 0", used, err)
+	}
+
+	if ok, err := s.ConsumeUsage("otx", day, 0); err != nil || ok {
+		t.Errorf("ConsumeUsage() with no budget = %t, %v, want false", ok, err)
+	}
+}
@@ -0,0 +1,21 @@
+-- SQLite schema at version 1, as created before schema_version existed
+
+CREATE TABLE ip_info (
+	address TEXT PRIMARY KEY,
+	score INTEGER NOT NULL,
+	country TEXT NOT NULL,
+	path TEXT NOT NULL,
+	payload_path TEXT,
+	blocked_in_fw BOOLEAN NOT NULL DEFAULT 0,
+	timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_timestamp ON ip_info(timestamp);
+
+CREATE INDEX idx_score ON ip_info(score);
+
+CREATE INDEX idx_blocked ON ip_info(blocked_in_fw);
+
+INSERT INTO ip_info (address, score, country, path, payload_path, blocked_in_fw, timestamp)
+VALUES ('192.0.2.1', 80, 'FR', '/admin', NULL, 1, '2024-01-01 10:00:00');
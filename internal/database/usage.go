@@ -0,0 +1,88 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// usageDay returns the key of the UTC day of t, API quotas being reset at
+// midnight UTC
+func usageDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// consumeUsage implements ConsumeUsage for the SQL stores. The increment
+// and the limit check are a single statement, so that several nodes
+// sharing a database never exceed the limit together.
+func consumeUsage(db *sql.DB, name string, t time.Time, limit int) (bool, error) {
+	if limit <= 0 {
+		return false, nil
+	}
+
+	query := `
+		INSERT INTO usage_counters (name, day, count)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (name, day) DO UPDATE SET count = usage_counters.count + 1
+		WHERE usage_counters.count < $3
+	`
+
+	result, err := db.Exec(query, name, usageDay(t), limit)
+	if err != nil {
+		return false, fmt.Errorf("failed to consume usage: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to consume usage: %w", err)
+	}
+	return affected > 0, nil
+}
+
+func getUsage(db *sql.DB, name string, t time.Time) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT count FROM usage_counters WHERE name = $1 AND day = $2", name, usageDay(t)).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get usage: %w", err)
+	}
+	return count, nil
+}
+
+func (db *IPDatabase) ConsumeUsage(name string, t time.Time, limit int) (bool, error) {
+	return consumeUsage(db.db, name, t, limit)
+}
+
+func (db *IPDatabase) GetUsage(name string, t time.Time) (int, error) {
+	return getUsage(db.db, name, t)
+}
+
+func (s *PostgresStore) ConsumeUsage(name string, t time.Time, limit int) (bool, error) {
+	return consumeUsage(s.db, name, t, limit)
+}
+
+func (s *PostgresStore) GetUsage(name string, t time.Time) (int, error) {
+	return getUsage(s.db, name, t)
+}
+
+func (s *MemoryStore) ConsumeUsage(name string, t time.Time, limit int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := name + "/" + usageDay(t)
+	if s.usage[key] >= limit {
+		return false, nil
+	}
+
+	s.usage[key]++
+	return true, nil
+}
+
+func (s *MemoryStore) GetUsage(name string, t time.Time) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.usage[name+"/"+usageDay(t)], nil
+}
@@ -20,22 +20,151 @@ const (
 )
 
 type IPInfo struct {
+	Address       string
+	Score         IPScore
+	Country       string
+	City          string
+	ASN           uint
+	ASOrg         string
+	Path          string
+	PayloadPath   string
+	BlockedInFW   bool
+	BlockedAt     time.Time
+	Timestamp     time.Time
+	TLSServerName string
+	JA3           string
+	JA4           string
+	Reputation    []ReputationResult
+
+	// ManualUnblock is set when the IP was unblocked by hand: it is not
+	// blocked automatically again until it is blocked by hand
+	ManualUnblock bool
+
+	// Verdict is the outcome of the policy rules for the current hit. It
+	// is computed on every hit and never stored.
+	Verdict Verdict
+}
+
+// Verdict is the decision of the policy rules on a hit
+type Verdict struct {
+	// Threshold is the score above which the IP is high risk, ScoreThreshold if nil
+	Threshold   *IPScore
+	AlwaysBlock bool
+	AlwaysAllow bool
+	// Rules are the names of the matching rules
+	Rules []string
+}
+
+// ReputationResult is the verdict of a single reputation provider on an IP
+type ReputationResult struct {
+	Provider  string  `json:"provider"`
+	Score     IPScore `json:"score"`
+	Malicious bool    `json:"malicious,omitempty"`
+	Country   string  `json:"country,omitempty"`
+	Details   string  `json:"details,omitempty"`
+	Error     string  `json:"error,omitempty"`
+
+	// Whitelisted is set by providers vouching for the IP: it is never
+	// blocked automatically, whatever its score
+	Whitelisted bool          `json:"whitelisted,omitempty"`
+	Abuse       *AbuseDetails `json:"abuse,omitempty"`
+}
+
+// AbuseDetails are the AbuseIPDB details of an IP
+type AbuseDetails struct {
+	ISP            string    `json:"isp,omitempty"`
+	Domain         string    `json:"domain,omitempty"`
+	UsageType      string    `json:"usage_type,omitempty"`
+	IsTor          bool      `json:"is_tor,omitempty"`
+	TotalReports   int       `json:"total_reports"`
+	DistinctUsers  int       `json:"distinct_users"`
+	LastReportedAt time.Time `json:"last_reported_at,omitzero"`
+	Categories     []int     `json:"categories,omitempty"`
+}
+
+// Event is a single connection attempt on a detection listener
+type Event struct {
+	ID          int64
+	Timestamp   time.Time
 	Address     string
-	Score       IPScore
-	Country     string
+	Listener    string
+	Method      string
+	Host        string
 	Path        string
-	PayloadPath string
-	BlockedInFW bool
-	Timestamp   time.Time
+	UserAgent   string
+	HeadersHash string
+	Action      string
 }
 
+// Actions taken on a connection attempt
+const (
+	ActionTarpit      = "tarpit"
+	ActionDrop        = "drop"
+	ActionStatus      = "status"
+	ActionRateLimited = "rate_limited"
+	// ActionHandshakeFailed is a TLS connection closed during the handshake
+	ActionHandshakeFailed = "handshake_failed"
+	// ActionExcluded is an attempt from an excluded or allowlisted IP,
+	// answered with a plain 200 or closed
+	ActionExcluded = "excluded"
+)
+
+// threshold returns the score above which the IP is high risk
+func (i *IPInfo) threshold() IPScore {
+	if i.Verdict.Threshold != nil {
+		return *i.Verdict.Threshold
+	}
+	return ScoreThreshold
+}
+
+// IsHighRisk reports whether the IP must be tarpitted and blocked, either
+// because of its score or of a policy rule
 func (i *IPInfo) IsHighRisk() bool {
-	return i.Score > ScoreThreshold
+	switch {
+	case i.Verdict.AlwaysAllow:
+		return false
+	case i.Verdict.AlwaysBlock:
+		return true
+	}
+	return i.Score > i.threshold()
+}
+
+// IsWhitelisted reports whether a reputation provider vouches for the IP
+func (i *IPInfo) IsWhitelisted() bool {
+	for _, result := range i.Reputation {
+		if result.Whitelisted {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldBlock reports whether the IP must be blocked automatically. A
+// policy block rule prevails over the whitelisting of a provider, a manual
+// unblock over both.
+func (i *IPInfo) ShouldBlock() bool {
+	if i.ManualUnblock {
+		return false
+	}
+	if i.Verdict.AlwaysBlock {
+		return true
+	}
+	return i.IsHighRisk() && !i.IsWhitelisted()
+}
+
+// AbuseDetails returns the AbuseIPDB details of the IP, if any
+func (i *IPInfo) AbuseDetails() *AbuseDetails {
+	for _, result := range i.Reputation {
+		if result.Abuse != nil {
+			return result.Abuse
+		}
+	}
+	return nil
 }
 
 func (i *IPInfo) GetSeverity() Severity {
 	switch {
-	case i.Score > ScoreThreshold:
+	case i.IsHighRisk():
 		return SeverityHigh
 	case i.Score >= ScoreLow:
 		return SeverityMedium
@@ -0,0 +1,115 @@
+package fingerprint
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+const (
+	// MaxRecordSize bounds the number of bytes recorded per connection,
+	// enough for a ClientHello spread over two full TLS records
+	MaxRecordSize = 2 * (5 + 16384)
+)
+
+type contextKey struct{}
+
+// Listener records the first bytes read on each accepted connection so
+// that the ClientHello can be fingerprinted once the TLS server has read it
+type Listener struct {
+	net.Listener
+}
+
+// NewListener wraps a listener
+func NewListener(inner net.Listener) *Listener {
+	return &Listener{Listener: inner}
+}
+
+// Accept waits for the next connection
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn}, nil
+}
+
+// Conn is a connection recording the data it reads until the ClientHello
+// has been parsed
+type Conn struct {
+	net.Conn
+
+	mu    sync.Mutex
+	buf   []byte
+	done  bool
+	hello *ClientHello
+	err   error
+}
+
+// Read reads data from the connection, keeping a copy while recording
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		if !c.done {
+			room := MaxRecordSize - len(c.buf)
+			c.buf = append(c.buf, b[:min(n, room)]...)
+			if len(c.buf) >= MaxRecordSize {
+				c.parse()
+			}
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// ClientHello returns the ClientHello sent by the peer. Recording stops on
+// the first call, which should happen after the TLS handshake.
+func (c *Conn) ClientHello() (*ClientHello, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.done {
+		c.parse()
+	}
+	return c.hello, c.err
+}
+
+func (c *Conn) parse() {
+	c.hello, c.err = Parse(c.buf)
+	c.buf = nil
+	c.done = true
+}
+
+// WithConn stores the connection in the context, for use as
+// http.Server.ConnContext
+func WithConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, contextKey{}, conn)
+}
+
+// FromContext returns the ClientHello of the connection stored by WithConn,
+// or nil if the connection was not accepted by a Listener
+func FromContext(ctx context.Context) *ClientHello {
+	conn, _ := ctx.Value(contextKey{}).(net.Conn)
+	return FromConn(conn)
+}
+
+// FromConn returns the ClientHello of a connection accepted by a Listener,
+// possibly wrapped in a tls.Conn, or nil
+func FromConn(conn net.Conn) *ClientHello {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+
+	recorder, ok := conn.(*Conn)
+	if !ok {
+		return nil
+	}
+
+	hello, err := recorder.ClientHello()
+	if err != nil {
+		return nil
+	}
+	return hello
+}
@@ -0,0 +1,388 @@
+package fingerprint
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	recordTypeHandshake      = 0x16
+	handshakeTypeClientHello = 0x01
+
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+var (
+	// ErrNotClientHello is returned when the data does not start with a TLS ClientHello
+	ErrNotClientHello = errors.New("fingerprint: not a TLS ClientHello")
+	// ErrTruncated is returned when the ClientHello is incomplete or malformed
+	ErrTruncated = errors.New("fingerprint: truncated ClientHello")
+)
+
+// ClientHello holds the ClientHello fields used for fingerprinting. Lists
+// keep the order chosen by the client and include GREASE values.
+type ClientHello struct {
+	Version             uint16
+	CipherSuites        []uint16
+	Extensions          []uint16
+	SupportedGroups     []uint16
+	PointFormats        []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+	ALPN                []string
+	ServerName          string
+}
+
+// Parse parses a ClientHello from raw TLS records, as sent by the client
+// at the start of a connection. The handshake message may span several
+// records.
+func Parse(data []byte) (*ClientHello, error) {
+	var handshake []byte
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, ErrTruncated
+		}
+		if data[0] != recordTypeHandshake {
+			if handshake == nil {
+				return nil, ErrNotClientHello
+			}
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+length {
+			return nil, ErrTruncated
+		}
+		handshake = append(handshake, data[5:5+length]...)
+		data = data[5+length:]
+
+		if len(handshake) >= 4 && len(handshake) >= 4+handshakeLength(handshake) {
+			break
+		}
+	}
+
+	if len(handshake) < 4 || handshake[0] != handshakeTypeClientHello {
+		return nil, ErrNotClientHello
+	}
+
+	length := handshakeLength(handshake)
+	if len(handshake) < 4+length {
+		return nil, ErrTruncated
+	}
+
+	return parseBody(handshake[4 : 4+length])
+}
+
+func handshakeLength(handshake []byte) int {
+	return int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+}
+
+func parseBody(body []byte) (*ClientHello, error) {
+	r := reader(body)
+	hello := &ClientHello{}
+
+	var ok bool
+	if hello.Version, ok = r.uint16(); !ok {
+		return nil, ErrTruncated
+	}
+
+	// Random and legacy session ID
+	if !r.skip(32) {
+		return nil, ErrTruncated
+	}
+	if _, ok := r.vector8(); !ok {
+		return nil, ErrTruncated
+	}
+
+	ciphers, ok := r.vector16()
+	if !ok {
+		return nil, ErrTruncated
+	}
+	hello.CipherSuites = uint16List(ciphers)
+
+	if _, ok := r.vector8(); !ok {
+		return nil, ErrTruncated
+	}
+
+	// Extensions are optional in TLS 1.2 and earlier
+	if len(r) == 0 {
+		return hello, nil
+	}
+
+	extensions, ok := r.vector16()
+	if !ok {
+		return nil, ErrTruncated
+	}
+
+	for len(extensions) > 0 {
+		extType, ok := extensions.uint16()
+		if !ok {
+			return nil, ErrTruncated
+		}
+		extData, ok := extensions.vector16()
+		if !ok {
+			return nil, ErrTruncated
+		}
+
+		hello.Extensions = append(hello.Extensions, extType)
+		hello.parseExtension(extType, extData)
+	}
+
+	return hello, nil
+}
+
+func (h *ClientHello) parseExtension(extType uint16, data reader) {
+	switch extType {
+	case extServerName:
+		list, _ := data.vector16()
+		for len(list) > 0 {
+			nameType, ok := list.uint8()
+			if !ok {
+				return
+			}
+			name, ok := list.vector16()
+			if !ok {
+				return
+			}
+			if nameType == 0 {
+				h.ServerName = string(name)
+				return
+			}
+		}
+	case extSupportedGroups:
+		list, _ := data.vector16()
+		h.SupportedGroups = uint16List(list)
+	case extECPointFormats:
+		list, _ := data.vector8()
+		h.PointFormats = append([]uint8(nil), list...)
+	case extSignatureAlgorithms:
+		list, _ := data.vector16()
+		h.SignatureAlgorithms = uint16List(list)
+	case extALPN:
+		list, _ := data.vector16()
+		for len(list) > 0 {
+			proto, ok := list.vector8()
+			if !ok {
+				return
+			}
+			h.ALPN = append(h.ALPN, string(proto))
+		}
+	case extSupportedVersions:
+		list, _ := data.vector8()
+		h.SupportedVersions = uint16List(list)
+	}
+}
+
+// JA3 returns the JA3 string and its MD5 hash
+func (h *ClientHello) JA3() (string, string) {
+	points := make([]uint16, len(h.PointFormats))
+	for i, point := range h.PointFormats {
+		points[i] = uint16(point)
+	}
+
+	fields := []string{
+		strconv.Itoa(int(h.Version)),
+		joinDecimal(withoutGREASE(h.CipherSuites)),
+		joinDecimal(withoutGREASE(h.Extensions)),
+		joinDecimal(withoutGREASE(h.SupportedGroups)),
+		joinDecimal(points),
+	}
+	ja3 := strings.Join(fields, ",")
+
+	sum := md5.Sum([]byte(ja3))
+	return ja3, hex.EncodeToString(sum[:])
+}
+
+// JA4 returns the JA4 fingerprint of a ClientHello received over TCP
+func (h *ClientHello) JA4() string {
+	ciphers := withoutGREASE(h.CipherSuites)
+	extensions := withoutGREASE(h.Extensions)
+
+	sni := "i"
+	if slices.Contains(extensions, extServerName) {
+		sni = "d"
+	}
+
+	a := fmt.Sprintf("t%s%s%02d%02d%s",
+		h.ja4Version(), sni, min(len(ciphers), 99), min(len(extensions), 99), h.ja4ALPN())
+
+	sortedCiphers := slices.Clone(ciphers)
+	slices.Sort(sortedCiphers)
+	b := truncatedHash(joinHex(sortedCiphers))
+	if len(ciphers) == 0 {
+		b = "000000000000"
+	}
+
+	var sortedExtensions []uint16
+	for _, ext := range extensions {
+		if ext != extServerName && ext != extALPN {
+			sortedExtensions = append(sortedExtensions, ext)
+		}
+	}
+	slices.Sort(sortedExtensions)
+
+	c := "000000000000"
+	if len(sortedExtensions) > 0 {
+		raw := joinHex(sortedExtensions)
+		if algorithms := withoutGREASE(h.SignatureAlgorithms); len(algorithms) > 0 {
+			raw += "_" + joinHex(algorithms)
+		}
+		c = truncatedHash(raw)
+	}
+
+	return a + "_" + b + "_" + c
+}
+
+func (h *ClientHello) ja4Version() string {
+	version := h.Version
+	if supported := withoutGREASE(h.SupportedVersions); len(supported) > 0 {
+		version = slices.Max(supported)
+	}
+
+	switch version {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	case 0xfeff:
+		return "d1"
+	case 0xfefd:
+		return "d2"
+	case 0xfefc:
+		return "d3"
+	default:
+		return "00"
+	}
+}
+
+func (h *ClientHello) ja4ALPN() string {
+	if len(h.ALPN) == 0 || h.ALPN[0] == "" {
+		return "00"
+	}
+
+	alpn := h.ALPN[0]
+	first, last := alpn[0], alpn[len(alpn)-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+
+	encoded := hex.EncodeToString([]byte(alpn))
+	return string([]byte{encoded[0], encoded[len(encoded)-1]})
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isGREASE reports whether v is one of the reserved GREASE values (RFC 8701)
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	result := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func joinDecimal(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(parts, "-")
+}
+
+func joinHex(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func truncatedHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func uint16List(data []byte) []uint16 {
+	values := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		values = append(values, binary.BigEndian.Uint16(data[i:]))
+	}
+	return values
+}
+
+// reader consumes TLS wire format data
+type reader []byte
+
+func (r *reader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+func (r *reader) uint8() (uint8, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	v := (*r)[0]
+	*r = (*r)[1:]
+	return v, true
+}
+
+func (r *reader) uint16() (uint16, bool) {
+	if len(*r) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*r)
+	*r = (*r)[2:]
+	return v, true
+}
+
+func (r *reader) vector8() (reader, bool) {
+	length, ok := r.uint8()
+	if !ok || len(*r) < int(length) {
+		return nil, false
+	}
+	v := (*r)[:length]
+	*r = (*r)[length:]
+	return v, true
+}
+
+func (r *reader) vector16() (reader, bool) {
+	length, ok := r.uint16()
+	if !ok || len(*r) < int(length) {
+		return nil, false
+	}
+	v := (*r)[:length]
+	*r = (*r)[length:]
+	return v, true
+}
@@ -0,0 +1,419 @@
+package fingerprint
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+)
+
+// extension is a raw ClientHello extension
+type extension struct {
+	typ  uint16
+	data []byte
+}
+
+// vector8 and vector16 prefix data with its length
+func vector8(data ...byte) []byte {
+	return append([]byte{byte(len(data))}, data...)
+}
+
+func vector16(data ...byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(data))), data...)
+}
+
+func uint16s(values ...uint16) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+func sniExtension(name string) extension {
+	entry := append([]byte{0}, vector16([]byte(name)...)...)
+	return extension{extServerName, vector16(entry...)}
+}
+
+func alpnExtension(protos ...string) extension {
+	var list []byte
+	for _, proto := range protos {
+		list = append(list, vector8([]byte(proto)...)...)
+	}
+	return extension{extALPN, vector16(list...)}
+}
+
+// handshake builds a ClientHello handshake message
+func handshake(version uint16, ciphers []uint16, extensions []extension) []byte {
+	body := binary.BigEndian.AppendUint16(nil, version)
+	body = append(body, make([]byte, 32)...)             // random
+	body = append(body, vector8(make([]byte, 32)...)...) // session ID
+	body = append(body, vector16(uint16s(ciphers...)...)...)
+	body = append(body, vector8(0)...) // null compression
+
+	if extensions != nil {
+		var exts []byte
+		for _, ext := range extensions {
+			exts = binary.BigEndian.AppendUint16(exts, ext.typ)
+			exts = append(exts, vector16(ext.data...)...)
+		}
+		body = append(body, vector16(exts...)...)
+	}
+
+	return append([]byte{handshakeTypeClientHello, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+// withLength returns a copy of a handshake message cut short, with its
+// length fixed to the bytes left
+func withLength(msg []byte) []byte {
+	msg = slices.Clone(msg)
+	n := len(msg) - 4
+	msg[1], msg[2], msg[3] = byte(n>>16), byte(n>>8), byte(n)
+	return msg
+}
+
+// records splits a handshake message into TLS records of at most size bytes
+func records(msg []byte, size int) []byte {
+	var out []byte
+	for len(msg) > 0 {
+		n := min(size, len(msg))
+		out = append(out, recordTypeHandshake, 0x03, 0x01, byte(n>>8), byte(n))
+		out = append(out, msg[:n]...)
+		msg = msg[n:]
+	}
+	return out
+}
+
+// chromeHello is the Chrome ClientHello of the JA4 documentation by FoxIO
+// (https://github.com/FoxIO-LLC/ja4), with GREASE values as sent by
+// Chrome. Its JA4 is t13d1516h2_8daaf6152771_e5627efa2ab1.
+func chromeHello() []byte {
+	const grease1, grease2, grease3 = 0x2a2a, 0x8a8a, 0xdada
+
+	ciphers := []uint16{grease1, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035}
+	extensions := []extension{
+		{grease2, nil},
+		{0x001b, vector8(0x00, 0x02)}, // compress_certificate
+		sniExtension("www.example.com"),
+		{0x0033, vector16(append(uint16s(grease3, 1), 0)...)}, // key_share
+		alpnExtension("h2", "http/1.1"),
+		{0x4469, vector16(vector8([]byte("h2")...)...)}, // application_settings
+		{0x0017, nil},                                   // extended_master_secret
+		{0x002d, vector8(0x01)},                         // psk_key_exchange_modes
+		{extSignatureAlgorithms, vector16(uint16s(0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601)...)},
+		{0x0005, []byte{0x01, 0x00, 0x00, 0x00, 0x00}}, // status_request
+		{0x0023, nil}, // session_ticket
+		{0x0012, nil}, // signed_certificate_timestamp
+		{extSupportedVersions, vector8(uint16s(grease3, 0x0304, 0x0303)...)},
+		{0xff01, vector8()}, // renegotiation_info
+		{extECPointFormats, vector8(0x00)},
+		{extSupportedGroups, vector16(uint16s(grease3, 0x001d, 0x0017, 0x0018)...)},
+		{0x0015, make([]byte, 16)}, // padding
+		{grease1, []byte{0x00}},
+	}
+	return records(handshake(0x0303, ciphers, extensions), 16384)
+}
+
+func TestParseChrome(t *testing.T) {
+	hello, err := Parse(chromeHello())
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if hello.ServerName != "www.example.com" {
+		t.Errorf("ServerName = %q", hello.ServerName)
+	}
+	if !slices.Equal(hello.ALPN, []string{"h2", "http/1.1"}) {
+		t.Errorf("ALPN = %v", hello.ALPN)
+	}
+	if !slices.Equal(hello.SupportedVersions, []uint16{0xdada, 0x0304, 0x0303}) {
+		t.Errorf("SupportedVersions = %x", hello.SupportedVersions)
+	}
+	if len(hello.Extensions) != 18 || hello.Extensions[0] != 0x8a8a {
+		t.Errorf("Extensions = %x, want the 18 extensions in order, GREASE included", hello.Extensions)
+	}
+
+	if got, want := hello.JA4(), "t13d1516h2_8daaf6152771_e5627efa2ab1"; got != want {
+		t.Errorf("JA4() = %q, want %q", got, want)
+	}
+
+	ja3, _ := hello.JA3()
+	want := "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53," +
+		"27-0-51-16-17513-23-45-13-5-35-18-43-65281-11-10-21,29-23-24,0"
+	if ja3 != want {
+		t.Errorf("JA3() = %q, want %q", ja3, want)
+	}
+}
+
+func TestJA3(t *testing.T) {
+	// Examples of the JA3 documentation by Salesforce
+	// (https://github.com/salesforce/ja3)
+	tests := []struct {
+		name    string
+		hello   []byte
+		ja3     string
+		ja3Hash string
+	}{
+		{
+			name: "with extensions",
+			hello: records(handshake(0x0301,
+				[]uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+				[]extension{
+					sniExtension("example.com"),
+					{extSupportedGroups, vector16(uint16s(23, 24, 25)...)},
+					{extECPointFormats, vector8(0)},
+				}), 16384),
+			ja3:     "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			ja3Hash: "ada70206e40642a3e4461f35503241d5",
+		},
+		{
+			name:    "without extensions",
+			hello:   records(handshake(0x0301, []uint16{4, 5, 10, 9, 100, 98, 3, 6, 19, 18, 99}, nil), 16384),
+			ja3:     "769,4-5-10-9-100-98-3-6-19-18-99,,,",
+			ja3Hash: "de350869b8c85de67a350c8d186f11e6",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello, err := Parse(tt.hello)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			ja3, hash := hello.JA3()
+			if ja3 != tt.ja3 || hash != tt.ja3Hash {
+				t.Errorf("JA3() = %q, %q, want %q, %q", ja3, hash, tt.ja3, tt.ja3Hash)
+			}
+		})
+	}
+}
+
+func TestJA4(t *testing.T) {
+	tests := []struct {
+		name  string
+		hello ClientHello
+		want  string
+	}{
+		{
+			name:  "empty lists",
+			hello: ClientHello{Version: 0x0303},
+			want:  "t12i000000_000000000000_000000000000",
+		},
+		{
+			name:  "version from the ClientHello",
+			hello: ClientHello{Version: 0x0301, CipherSuites: []uint16{0x002f}},
+			want:  "t10i010000_" + truncatedHash("002f") + "_000000000000",
+		},
+		{
+			name: "only SNI and ALPN extensions",
+			hello: ClientHello{
+				Version:    0x0303,
+				Extensions: []uint16{extServerName, extALPN},
+				ALPN:       []string{"http/1.1"},
+			},
+			want: "t12d0002h1_000000000000_000000000000",
+		},
+		{
+			name: "no signature algorithms",
+			hello: ClientHello{
+				Version:    0x0303,
+				Extensions: []uint16{0x0017, 0x000a},
+			},
+			want: "t12i000200_000000000000_" + truncatedHash("000a,0017"),
+		},
+		{
+			name: "GREASE only ALPN and versions",
+			hello: ClientHello{
+				Version:           0x0303,
+				SupportedVersions: []uint16{0x0a0a},
+				ALPN:              []string{""},
+			},
+			want: "t12i000000_000000000000_000000000000",
+		},
+		{
+			name:  "non alphanumeric ALPN",
+			hello: ClientHello{Version: 0x0303, ALPN: []string{"\xab"}},
+			want:  "t12i0000ab_000000000000_000000000000",
+		},
+		{
+			name:  "unknown version",
+			hello: ClientHello{Version: 0x1234},
+			want:  "t00i000000_000000000000_000000000000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hello.JA4(); got != tt.want {
+				t.Errorf("JA4() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSplitRecords(t *testing.T) {
+	whole, err := Parse(chromeHello())
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	msg := chromeHello()[5:]
+	split, err := Parse(records(msg, 100))
+	if err != nil {
+		t.Fatalf("Parse() of a ClientHello over several records error = %v", err)
+	}
+	if split.JA4() != whole.JA4() || split.ServerName != whole.ServerName {
+		t.Errorf("split ClientHello = %s, %q, want %s, %q", split.JA4(), split.ServerName, whole.JA4(), whole.ServerName)
+	}
+
+	// Records following the ClientHello are ignored
+	trailing := append(chromeHello(), 0x14, 0x03, 0x03, 0x00, 0x01, 0x01)
+	if _, err := Parse(trailing); err != nil {
+		t.Errorf("Parse() with a trailing record error = %v", err)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	valid := chromeHello()
+	msg := valid[5:]
+
+	// Lengths of the record and handshake headers are consistent, but
+	// the body is cut before the extensions end
+	cutBody := withLength(msg[:len(msg)-10])
+
+	// The extensions length goes past the end of the body
+	extLength := slices.Clone(msg)
+	extOffset := 4 + 2 + 32 + 33 + 2 + 16*2 + 2
+	binary.BigEndian.PutUint16(extLength[extOffset:], 0xffff)
+
+	// The handshake header says more than the records hold
+	longHandshake := slices.Clone(msg)
+	longHandshake[1] = 0x01
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "empty", data: nil, want: ErrNotClientHello},
+		{name: "partial record header", data: valid[:3], want: ErrTruncated},
+		{name: "truncated record", data: valid[:len(valid)-1], want: ErrTruncated},
+		{name: "application data", data: []byte{0x17, 0x03, 0x03, 0x00, 0x01, 0x00}, want: ErrNotClientHello},
+		{name: "plain HTTP", data: []byte("GET / HTTP/1.1\r\n\r\n"), want: ErrNotClientHello},
+		{name: "server hello", data: records(append([]byte{0x02}, msg[1:]...), 16384), want: ErrNotClientHello},
+		{name: "short handshake header", data: records(msg[:3], 16384), want: ErrNotClientHello},
+		{name: "handshake longer than the records", data: records(longHandshake, 16384), want: ErrTruncated},
+		{name: "body cut in the extensions", data: records(cutBody, 16384), want: ErrTruncated},
+		{name: "extensions length past the end", data: records(extLength, 16384), want: ErrTruncated},
+		{name: "missing cipher suites", data: records(withLength(msg[:4+2+32+33]), 16384), want: ErrTruncated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("Parse() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Every prefix of a valid ClientHello is rejected without panicking
+	for n := range len(valid) {
+		if _, err := Parse(valid[:n]); err == nil {
+			t.Fatalf("Parse() of %d of %d bytes succeeded", n, len(valid))
+		}
+	}
+}
+
+func TestParseMalformedExtensions(t *testing.T) {
+	// Malformed extension contents are ignored, the fingerprint still
+	// lists them
+	hello, err := Parse(records(handshake(0x0303, []uint16{0x1301}, []extension{
+		{extServerName, vector16(0x00, 0x00, 0x10)},
+		{extALPN, vector16(0x05, 'h')},
+		{extSupportedGroups, []byte{0x00}},
+	}), 16384))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if hello.ServerName != "" || len(hello.ALPN) != 0 || len(hello.SupportedGroups) != 0 {
+		t.Errorf("Parse() = %+v, want the malformed values ignored", hello)
+	}
+	if !slices.Equal(hello.Extensions, []uint16{extServerName, extALPN, extSupportedGroups}) {
+		t.Errorf("Extensions = %x", hello.Extensions)
+	}
+}
+
+// TestParseGoClientHello compares the parsed fields of a ClientHello sent
+// by crypto/tls with what the TLS server reads from it
+func TestParseGoClientHello(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	recorder := &Conn{Conn: server}
+	defer recorder.Close()
+
+	infos := make(chan *tls.ClientHelloInfo, 1)
+	go tls.Server(recorder, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			infos <- info
+			return nil, errors.New("stop")
+		},
+	}).Handshake()
+	go tls.Client(client, &tls.Config{ServerName: "gatekeeper.example", NextProtos: []string{"h2", "http/1.1"}}).Handshake()
+
+	info := <-infos
+	hello, err := recorder.ClientHello()
+	if err != nil {
+		t.Fatalf("ClientHello() error = %v", err)
+	}
+
+	if hello.ServerName != info.ServerName || !slices.Equal(hello.ALPN, info.SupportedProtos) {
+		t.Errorf("ServerName, ALPN = %q, %v, want %q, %v", hello.ServerName, hello.ALPN, info.ServerName, info.SupportedProtos)
+	}
+	if !slices.Equal(hello.CipherSuites, info.CipherSuites) {
+		t.Errorf("CipherSuites = %x, want %x", hello.CipherSuites, info.CipherSuites)
+	}
+	if !slices.Equal(hello.SupportedVersions, info.SupportedVersions) {
+		t.Errorf("SupportedVersions = %x, want %x", hello.SupportedVersions, info.SupportedVersions)
+	}
+	if !slices.Equal(hello.PointFormats, info.SupportedPoints) {
+		t.Errorf("PointFormats = %x, want %x", hello.PointFormats, info.SupportedPoints)
+	}
+	if !slices.Equal(hello.Extensions, info.Extensions) {
+		t.Errorf("Extensions = %x, want %x", hello.Extensions, info.Extensions)
+	}
+	groups := make([]uint16, len(info.SupportedCurves))
+	for i, curve := range info.SupportedCurves {
+		groups[i] = uint16(curve)
+	}
+	if !slices.Equal(hello.SupportedGroups, groups) {
+		t.Errorf("SupportedGroups = %x, want %x", hello.SupportedGroups, groups)
+	}
+	algorithms := make([]uint16, len(info.SignatureSchemes))
+	for i, scheme := range info.SignatureSchemes {
+		algorithms[i] = uint16(scheme)
+	}
+	if !slices.Equal(hello.SignatureAlgorithms, algorithms) {
+		t.Errorf("SignatureAlgorithms = %x, want %x", hello.SignatureAlgorithms, algorithms)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(chromeHello())
+	f.Add(records(chromeHello()[5:], 100))
+	f.Add(records(handshake(0x0301, []uint16{4, 5, 10}, nil), 16384))
+	f.Add([]byte("GET / HTTP/1.1\r\n\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		hello, err := Parse(data)
+		if err != nil {
+			if !errors.Is(err, ErrTruncated) && !errors.Is(err, ErrNotClientHello) {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			return
+		}
+		hello.JA3()
+		hello.JA4()
+	})
+}
@@ -0,0 +1,125 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+var (
+	// ErrUnknownBackend is returned when no factory is registered for a backend type
+	ErrUnknownBackend = errors.New("firewall: unknown backend type")
+)
+
+// Blocker is implemented by every firewall backend able to block IPs
+type Blocker interface {
+	// Name returns a human readable identifier for the backend
+	Name() string
+	// Block adds an IP address to the backend block list
+	Block(ip string) error
+	// Unblock removes an IP address from the backend block list
+	Unblock(ip string) error
+	// List returns the IP addresses currently blocked by the backend
+	List() ([]string, error)
+	// Health reports whether the backend is reachable and correctly configured
+	Health() error
+}
+
+// Restorer is implemented by backends whose state does not survive a
+// restart. Restore replaces the backend contents with the given IPs, keyed
+// to the time they were blocked. Backends whose entries expire skip the
+// IPs whose block already timed out.
+type Restorer interface {
+	Restore(blocks map[string]time.Time) error
+}
+
+// Expirer is implemented by backends whose entries time out on their own,
+// such as nftables sets and ipsets. Timeout returns 0 when entries never
+// expire. BlockFor blocks an IP for the given duration instead of the
+// backend timeout.
+type Expirer interface {
+	Timeout() time.Duration
+	BlockFor(ip string, timeout time.Duration) error
+}
+
+// Remaining returns how long an IP blocked at blockedAt stays in a backend
+// whose entries expire after timeout. It is zero or negative once the
+// entry timed out. An unknown block time counts as a fresh block.
+func Remaining(timeout time.Duration, blockedAt time.Time) time.Duration {
+	if blockedAt.IsZero() {
+		return timeout
+	}
+	return timeout - time.Since(blockedAt)
+}
+
+// Factory builds a Blocker from its configuration
+type Factory func(cfg *config.FirewallConfig) (Blocker, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a backend available under the given type name.
+// It panics if the name is empty or already registered.
+func Register(kind string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if kind == "" || factory == nil {
+		panic("firewall: Register called with empty kind or nil factory")
+	}
+	if _, exists := factories[kind]; exists {
+		panic("firewall: Register called twice for backend " + kind)
+	}
+
+	factories[kind] = factory
+}
+
+// Backends returns the sorted list of registered backend types
+func Backends() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	kinds := make([]string, 0, len(factories))
+	for kind := range factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	return kinds
+}
+
+// New builds a single backend from its configuration
+func New(cfg *config.FirewallConfig) (Blocker, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[cfg.Type]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownBackend, cfg.Type)
+	}
+
+	return factory(cfg)
+}
+
+// NewAll builds every configured backend. Backends that fail to initialize
+// are logged and skipped so that a single unreachable firewall does not
+// prevent GateKeeper from starting.
+func NewAll(cfgs []config.FirewallConfig) []Blocker {
+	blockers := make([]Blocker, 0, len(cfgs))
+	for i := range cfgs {
+		blocker, err := New(&cfgs[i])
+		if err != nil {
+			log.Printf("Failed to initialize %s firewall backend: %v", cfgs[i].Type, err)
+			continue
+		}
+		blockers = append(blockers, blocker)
+	}
+	return blockers
+}
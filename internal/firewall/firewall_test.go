@@ -0,0 +1,199 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+// fakeBlocker is a backend whose every operation can be made to fail
+type fakeBlocker struct {
+	*MemoryBlocker
+	err error
+}
+
+func (f *fakeBlocker) Block(ip string) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.MemoryBlocker.Block(ip)
+}
+
+func (f *fakeBlocker) Health() error {
+	return f.err
+}
+
+var errUnreachable = errors.New("unreachable")
+
+func init() {
+	Register("fake", func(cfg *config.FirewallConfig) (Blocker, error) {
+		return &fakeBlocker{MemoryBlocker: NewMemoryBlocker(cfg.Name)}, nil
+	})
+	Register("broken", func(cfg *config.FirewallConfig) (Blocker, error) {
+		return nil, errUnreachable
+	})
+}
+
+func TestBackends(t *testing.T) {
+	kinds := Backends()
+	for _, kind := range []string{"broken", "fake", "memory"} {
+		if !slices.Contains(kinds, kind) {
+			t.Errorf("Backends() = %v, missing %q", kinds, kind)
+		}
+	}
+	if !slices.IsSorted(kinds) {
+		t.Errorf("Backends() = %v, not sorted", kinds)
+	}
+}
+
+func TestNewSelectsBackend(t *testing.T) {
+	tests := []struct {
+		kind    string
+		want    string
+		wantErr error
+	}{
+		{kind: "memory", want: "*firewall.MemoryBlocker"},
+		{kind: "fake", want: "*firewall.fakeBlocker"},
+		{kind: "broken", wantErr: errUnreachable},
+		{kind: "missing", wantErr: ErrUnknownBackend},
+		{kind: "", wantErr: ErrUnknownBackend},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			blocker, err := New(&config.FirewallConfig{Type: tt.kind})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("New(%q) error = %v, want %v", tt.kind, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New(%q) error = %v", tt.kind, err)
+			}
+			if got := fmt.Sprintf("%T", blocker); got != tt.want {
+				t.Errorf("New(%q) = %s, want %s", tt.kind, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAllSkipsFailingBackends(t *testing.T) {
+	blockers := NewAll([]config.FirewallConfig{
+		{Type: "memory", Name: "first"},
+		{Type: "broken"},
+		{Type: "missing"},
+		{Type: "fake", Name: "second"},
+	})
+
+	var names []string
+	for _, b := range blockers {
+		names = append(names, b.Name())
+	}
+	if want := []string{"first", "second"}; !slices.Equal(names, want) {
+		t.Errorf("NewAll() backends = %v, want %v", names, want)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	tests := map[string]func(){
+		"duplicate": func() {
+			Register("memory", func(*config.FirewallConfig) (Blocker, error) { return nil, nil })
+		},
+		"empty kind": func() {
+			Register("", func(*config.FirewallConfig) (Blocker, error) { return nil, nil })
+		},
+		"nil factory": func() { Register("nil", nil) },
+	}
+
+	for name, register := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Register did not panic")
+				}
+			}()
+			register()
+		})
+	}
+}
+
+// testContract checks the behaviour every backend must provide: blocking
+// is idempotent, List reflects Block and Unblock, and unblocking an IP
+// that is not blocked succeeds.
+func testContract(t *testing.T, b Blocker) {
+	t.Helper()
+
+	isBlocked := func(ip string) bool {
+		t.Helper()
+		ips, err := b.List()
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		return slices.Contains(ips, ip)
+	}
+
+	if err := b.Health(); err != nil {
+		t.Fatalf("Health() error = %v", err)
+	}
+	if isBlocked("192.0.2.1") {
+		t.Fatal("192.0.2.1 blocked before Block")
+	}
+
+	for range 2 {
+		if err := b.Block("192.0.2.1"); err != nil {
+			t.Fatalf("Block() error = %v", err)
+		}
+	}
+	if err := b.Block("2001:db8::1"); err != nil {
+		t.Fatalf("Block() error = %v", err)
+	}
+
+	ips, err := b.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(ips) != 2 || !isBlocked("192.0.2.1") || !isBlocked("2001:db8::1") {
+		t.Fatalf("List() = %v, want both IPs once", ips)
+	}
+
+	if err := b.Unblock("192.0.2.1"); err != nil {
+		t.Fatalf("Unblock() error = %v", err)
+	}
+	if isBlocked("192.0.2.1") {
+		t.Error("192.0.2.1 still blocked after Unblock")
+	}
+	if !isBlocked("2001:db8::1") {
+		t.Error("2001:db8::1 unblocked by the Unblock of another IP")
+	}
+
+	if err := b.Unblock("198.51.100.1"); err != nil {
+		t.Errorf("Unblock() of an IP that is not blocked error = %v", err)
+	}
+}
+
+func TestMemoryBlockerContract(t *testing.T) {
+	testContract(t, NewMemoryBlocker(""))
+}
+
+func TestMemoryBlockerName(t *testing.T) {
+	if got := NewMemoryBlocker("").Name(); got != "memory" {
+		t.Errorf("Name() = %q, want memory", got)
+	}
+	if got := NewMemoryBlocker("dry-run").Name(); got != "dry-run" {
+		t.Errorf("Name() = %q, want dry-run", got)
+	}
+}
+
+func TestFakeBlockerFailure(t *testing.T) {
+	b := &fakeBlocker{MemoryBlocker: NewMemoryBlocker("fake"), err: errUnreachable}
+	if err := b.Block("192.0.2.1"); !errors.Is(err, errUnreachable) {
+		t.Errorf("Block() error = %v, want %v", err, errUnreachable)
+	}
+	if ips, _ := b.List(); len(ips) != 0 {
+		t.Errorf("List() = %v after a failed Block", ips)
+	}
+}
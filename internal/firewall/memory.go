@@ -0,0 +1,76 @@
+package firewall
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+func init() {
+	Register("memory", func(cfg *config.FirewallConfig) (Blocker, error) {
+		return NewMemoryBlocker(cfg.Name), nil
+	})
+}
+
+// MemoryBlocker is an in-memory backend. It does not block anything on the
+// network and is meant for dry runs and tests.
+type MemoryBlocker struct {
+	mu      sync.RWMutex
+	name    string
+	blocked map[string]struct{}
+}
+
+// NewMemoryBlocker creates a new in-memory backend
+func NewMemoryBlocker(name string) *MemoryBlocker {
+	if name == "" {
+		name = "memory"
+	}
+
+	return &MemoryBlocker{
+		name:    name,
+		blocked: make(map[string]struct{}),
+	}
+}
+
+// Name returns the backend name
+func (m *MemoryBlocker) Name() string {
+	return m.name
+}
+
+// Block records the IP as blocked
+func (m *MemoryBlocker) Block(ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blocked[ip] = struct{}{}
+	return nil
+}
+
+// Unblock forgets the IP
+func (m *MemoryBlocker) Unblock(ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.blocked, ip)
+	return nil
+}
+
+// List returns the blocked IPs in sorted order
+func (m *MemoryBlocker) List() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ips := make([]string, 0, len(m.blocked))
+	for ip := range m.blocked {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	return ips, nil
+}
+
+// Health always succeeds
+func (m *MemoryBlocker) Health() error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package gatekeeper
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+var (
+	// ErrNoFirewall is returned when a manual block is requested without any firewall backend
+	ErrNoFirewall = errors.New("gatekeeper: no firewall backend configured")
+)
+
+// BlockIP manually blocks an IP on every firewall backend. A known entry
+// is kept as is, even if outdated: only its blocked flag is updated.
+func (g *GateKeeper) BlockIP(ip string) error {
+	if len(g.blockers) == 0 {
+		return ErrNoFirewall
+	}
+
+	ipInfo, exists := g.db.GetStale(ip)
+	if !exists {
+		ipInfo = &domain.IPInfo{
+			Address:   ip,
+			Country:   "Unknown",
+			Timestamp: time.Now(),
+		}
+		if err := g.db.Set(ipInfo); err != nil {
+			return err
+		}
+	}
+
+	// Force the block even if the IP is already flagged, so that a backend
+	// that lost the IP gets it back
+	ipInfo.BlockedInFW = false
+	err := g.blockIP(ipInfo)
+	if !ipInfo.BlockedInFW {
+		return err
+	}
+
+	log.Printf("IP %s manually blocked", ip)
+	return err
+}
+
+// UnblockIP manually unblocks an IP on every firewall backend. The IP is
+// not blocked automatically again until it is blocked by hand.
+func (g *GateKeeper) UnblockIP(ip string) error {
+	log.Printf("IP %s manually unblocked", ip)
+	err := g.unblockIP(ip)
+
+	if markErr := g.db.MarkManualUnblock(ip); markErr != nil {
+		log.Printf("Failed to mark IP as manually unblocked in database: %v", markErr)
+		err = errors.Join(err, markErr)
+	}
+
+	return err
+}
+
+// DeleteIP unblocks an IP and forgets everything known about it
+func (g *GateKeeper) DeleteIP(ip string) error {
+	if err := g.unblockIP(ip); err != nil {
+		return err
+	}
+
+	if err := g.db.Delete(ip); err != nil {
+		return err
+	}
+
+	g.rateLimiter.Reset(ip)
+	log.Printf("IP %s deleted from database", ip)
+	return nil
+}
+
+// AllowIP adds an IP to the runtime allowlist and unblocks it. The
+// allowlist is kept in memory and is lost on restart: permanent
+// exclusions belong in excluded_ips.
+func (g *GateKeeper) AllowIP(ip string) error {
+	g.allowlistMu.Lock()
+	g.allowlist[ip] = struct{}{}
+	g.allowlistMu.Unlock()
+
+	log.Printf("IP %s added to the runtime allowlist", ip)
+	return g.unblockIP(ip)
+}
+
+// DisallowIP removes an IP from the runtime allowlist
+func (g *GateKeeper) DisallowIP(ip string) error {
+	g.allowlistMu.Lock()
+	delete(g.allowlist, ip)
+	g.allowlistMu.Unlock()
+
+	log.Printf("IP %s removed from the runtime allowlist", ip)
+	return nil
+}
+
+// AllowedIPs returns the runtime allowlist in sorted order
+func (g *GateKeeper) AllowedIPs() []string {
+	g.allowlistMu.RLock()
+	defer g.allowlistMu.RUnlock()
+
+	ips := make([]string, 0, len(g.allowlist))
+	for ip := range g.allowlist {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	return ips
+}
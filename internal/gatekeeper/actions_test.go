@@ -0,0 +1,100 @@
+package gatekeeper
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/firewall"
+	"github.com/TOomaAh/GateKeeper/internal/policy"
+)
+
+func newActionsGateKeeper(db database.Store) (*GateKeeper, *firewall.MemoryBlocker) {
+	blocker := firewall.NewMemoryBlocker("")
+	return &GateKeeper{
+		db:       db,
+		policy:   policy.New(config.PolicyConfig{}, nil),
+		blockers: []firewall.Blocker{blocker},
+	}, blocker
+}
+
+func TestBlockIPKeepsStaleEntry(t *testing.T) {
+	db := database.NewMemoryStore(time.Hour)
+	db.Set(&domain.IPInfo{
+		Address:   "192.0.2.1",
+		Score:     42,
+		Country:   "FR",
+		Path:      "/wp-login.php",
+		Timestamp: time.Now().Add(-48 * time.Hour),
+	})
+	g, blocker := newActionsGateKeeper(db)
+
+	if err := g.BlockIP("192.0.2.1"); err != nil {
+		t.Fatalf("BlockIP() error = %v", err)
+	}
+
+	info, ok := db.GetStale("192.0.2.1")
+	if !ok {
+		t.Fatal("entry lost by BlockIP")
+	}
+	if info.Score != 42 || info.Country != "FR" || info.Path != "/wp-login.php" {
+		t.Errorf("entry = %+v, BlockIP must only update the blocked flag", info)
+	}
+	if !info.BlockedInFW || info.BlockedAt.IsZero() {
+		t.Errorf("entry not flagged as blocked: %+v", info)
+	}
+	if ips, _ := blocker.List(); !slices.Equal(ips, []string{"192.0.2.1"}) {
+		t.Errorf("backend = %v", ips)
+	}
+}
+
+func TestBlockIPUnknown(t *testing.T) {
+	db := database.NewMemoryStore(time.Hour)
+	g, _ := newActionsGateKeeper(db)
+
+	if err := g.BlockIP("192.0.2.1"); err != nil {
+		t.Fatalf("BlockIP() error = %v", err)
+	}
+	if info, ok := db.Get("192.0.2.1"); !ok || !info.BlockedInFW {
+		t.Errorf("entry = %+v, %v, want a blocked entry", info, ok)
+	}
+}
+
+func TestManualUnblockIsHonoured(t *testing.T) {
+	db := database.NewMemoryStore(time.Hour)
+	db.Set(&domain.IPInfo{Address: "192.0.2.1", Score: 100, Country: "FR"})
+	g, blocker := newActionsGateKeeper(db)
+	h := &hit{ip: "192.0.2.1", path: "/", listener: &config.ListenerConfig{Name: "http"}}
+
+	// The high score blocks the IP on its next hit
+	g.getOrCreateIPInfo(h)
+	if ips, _ := blocker.List(); len(ips) != 1 {
+		t.Fatalf("backend = %v, want the IP blocked", ips)
+	}
+
+	if err := g.UnblockIP("192.0.2.1"); err != nil {
+		t.Fatalf("UnblockIP() error = %v", err)
+	}
+	info := g.getOrCreateIPInfo(h)
+	if ips, _ := blocker.List(); len(ips) != 0 {
+		t.Fatalf("backend = %v, the manual unblock was undone by a hit", ips)
+	}
+	if !info.ManualUnblock || info.ShouldBlock() {
+		t.Errorf("entry = %+v, want a manual unblock", info)
+	}
+
+	// The override survives a refresh of the entry and ends with a manual block
+	db.Set(&domain.IPInfo{Address: "192.0.2.1", Score: 100, Country: "FR"})
+	if info, _ := db.Get("192.0.2.1"); !info.ManualUnblock {
+		t.Error("manual unblock lost when the entry was updated")
+	}
+	if err := g.BlockIP("192.0.2.1"); err != nil {
+		t.Fatalf("BlockIP() error = %v", err)
+	}
+	if info, _ := db.Get("192.0.2.1"); info.ManualUnblock || !info.BlockedInFW {
+		t.Errorf("entry = %+v after a manual block", info)
+	}
+}
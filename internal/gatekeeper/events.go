@@ -0,0 +1,171 @@
+package gatekeeper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+const (
+	// EventQueueSize bounds the number of events waiting to be written.
+	// Events are dropped when the queue is full.
+	EventQueueSize = 4096
+	// EventBatchSize is the maximum number of events written at once
+	EventBatchSize = 256
+	// EventFlushInterval is how long an event waits for a batch at most
+	EventFlushInterval = time.Second
+	// RateLimitedEventInterval is the minimum interval between two
+	// recorded rate-limited hits of an IP. The others are only counted.
+	RateLimitedEventInterval = time.Minute
+)
+
+// eventWriter records connection attempts in the background, in batches,
+// so that hits never wait for the database
+type eventWriter struct {
+	db      database.Store
+	queue   chan *domain.Event
+	dropped atomic.Int64
+
+	mu          sync.Mutex
+	rateLimited map[string]*rateLimitedSample
+}
+
+// rateLimitedSample tracks the rate-limited hits of an IP
+type rateLimitedSample struct {
+	recorded time.Time
+	skipped  int
+}
+
+func newEventWriter(db database.Store) *eventWriter {
+	w := &eventWriter{
+		db:          db,
+		queue:       make(chan *domain.Event, EventQueueSize),
+		rateLimited: make(map[string]*rateLimitedSample),
+	}
+	go w.run()
+	return w
+}
+
+// add queues an event without blocking. Rate-limited hits are sampled.
+func (w *eventWriter) add(event *domain.Event) {
+	if event.Action == domain.ActionRateLimited && !w.sample(event.Address) {
+		return
+	}
+
+	event.Timestamp = time.Now()
+	select {
+	case w.queue <- event:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// sample reports whether a rate-limited hit of an IP must be recorded:
+// only one per RateLimitedEventInterval is
+func (w *eventWriter) sample(ip string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	s, ok := w.rateLimited[ip]
+	if !ok {
+		w.rateLimited[ip] = &rateLimitedSample{recorded: now}
+		return true
+	}
+
+	if now.Sub(s.recorded) < RateLimitedEventInterval {
+		s.skipped++
+		return false
+	}
+
+	if s.skipped > 0 {
+		log.Printf("%d rate-limited hit(s) of IP %s not recorded", s.skipped, ip)
+	}
+	*s = rateLimitedSample{recorded: now}
+	return true
+}
+
+func (w *eventWriter) run() {
+	ticker := time.NewTicker(EventFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*domain.Event, 0, EventBatchSize)
+	for {
+		select {
+		case event := <-w.queue:
+			batch = append(batch, event)
+			if len(batch) < EventBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			w.expireSamples()
+		}
+
+		if len(batch) > 0 {
+			w.write(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (w *eventWriter) write(batch []*domain.Event) {
+	if err := w.db.AddEvents(batch); err != nil {
+		log.Printf("Failed to record %d event(s): %v", len(batch), err)
+	}
+
+	if dropped := w.dropped.Swap(0); dropped > 0 {
+		log.Printf("Event queue full, %d event(s) not recorded", dropped)
+	}
+}
+
+// expireSamples forgets the IPs no longer rate-limited
+func (w *eventWriter) expireSamples() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for ip, s := range w.rateLimited {
+		if time.Since(s.recorded) < RateLimitedEventInterval {
+			continue
+		}
+		if s.skipped > 0 {
+			log.Printf("%d rate-limited hit(s) of IP %s not recorded", s.skipped, ip)
+		}
+		delete(w.rateLimited, ip)
+	}
+}
+
+// recordEvent queues a connection attempt and the action taken
+func (g *GateKeeper) recordEvent(h *hit, action string) {
+	g.events.add(&domain.Event{
+		Address:     h.ip,
+		Listener:    h.listener.Name,
+		Method:      h.method,
+		Host:        h.host,
+		Path:        h.path,
+		UserAgent:   h.userAgent,
+		HeadersHash: h.headersHash,
+		Action:      action,
+	})
+}
+
+// headersHash identifies the set of header names sent by a client, which
+// tends to be stable for a given tool whatever the request
+func headersHash(header http.Header) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, strings.ToLower(name))
+	}
+	slices.Sort(names)
+
+	sum := sha256.Sum256([]byte(strings.Join(names, ",")))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,140 @@
+package gatekeeper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// batchStore records the size of each batch of events written
+type batchStore struct {
+	*database.MemoryStore
+
+	mu      sync.Mutex
+	batches []int
+}
+
+func (s *batchStore) AddEvents(events []*domain.Event) error {
+	s.mu.Lock()
+	s.batches = append(s.batches, len(events))
+	s.mu.Unlock()
+	return s.MemoryStore.AddEvents(events)
+}
+
+func (s *batchStore) written() (total int, batches []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, n := range s.batches {
+		total += n
+	}
+	return total, append([]int(nil), s.batches...)
+}
+
+func TestEventWriterBatches(t *testing.T) {
+	store := &batchStore{MemoryStore: database.NewMemoryStore(time.Hour)}
+	w := newEventWriter(store)
+
+	const count = EventBatchSize + 44
+	for range count {
+		w.add(&domain.Event{Address: "192.0.2.1", Action: domain.ActionDrop})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	total, batches := store.written()
+	for total < count && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		total, batches = store.written()
+	}
+
+	if total != count {
+		t.Fatalf("events written = %d, want %d", total, count)
+	}
+	if len(batches) < 2 {
+		t.Errorf("batches = %v, want the events split", batches)
+	}
+	for _, n := range batches {
+		if n > EventBatchSize {
+			t.Errorf("batch of %d events, want at most %d", n, EventBatchSize)
+		}
+	}
+}
+
+func TestEventWriterDropsWhenFull(t *testing.T) {
+	// No writer goroutine drains the queue
+	w := &eventWriter{
+		queue:       make(chan *domain.Event, 2),
+		rateLimited: make(map[string]*rateLimitedSample),
+	}
+
+	for range 5 {
+		w.add(&domain.Event{Address: "192.0.2.1", Action: domain.ActionDrop})
+	}
+
+	if len(w.queue) != 2 {
+		t.Errorf("queued = %d, want 2", len(w.queue))
+	}
+	if dropped := w.dropped.Load(); dropped != 3 {
+		t.Errorf("dropped = %d, want 3", dropped)
+	}
+}
+
+func TestEventWriterSamplesRateLimited(t *testing.T) {
+	w := &eventWriter{
+		queue:       make(chan *domain.Event, 10),
+		rateLimited: make(map[string]*rateLimitedSample),
+	}
+
+	for range 3 {
+		w.add(&domain.Event{Address: "192.0.2.1", Action: domain.ActionRateLimited})
+	}
+	w.add(&domain.Event{Address: "192.0.2.2", Action: domain.ActionRateLimited})
+	w.add(&domain.Event{Address: "192.0.2.1", Action: domain.ActionDrop})
+
+	if len(w.queue) != 3 {
+		t.Errorf("queued = %d, want one rate-limited hit per IP and the drop", len(w.queue))
+	}
+	if s := w.rateLimited["192.0.2.1"]; s == nil || s.skipped != 2 {
+		t.Errorf("sample = %+v, want 2 skipped hits", s)
+	}
+
+	// Once the interval elapsed, the next hit is recorded again
+	w.rateLimited["192.0.2.1"].recorded = time.Now().Add(-RateLimitedEventInterval)
+	w.add(&domain.Event{Address: "192.0.2.1", Action: domain.ActionRateLimited})
+	if len(w.queue) != 4 {
+		t.Errorf("queued = %d, want the hit after the interval recorded", len(w.queue))
+	}
+
+	w.rateLimited["192.0.2.2"].recorded = time.Now().Add(-RateLimitedEventInterval)
+	w.expireSamples()
+	if _, ok := w.rateLimited["192.0.2.2"]; ok {
+		t.Error("stale sample kept")
+	}
+	if _, ok := w.rateLimited["192.0.2.1"]; !ok {
+		t.Error("current sample expired")
+	}
+}
+
+func TestExcludedHitIsRecorded(t *testing.T) {
+	g := newTestGateKeeper(t)
+	g.allowlist["192.0.2.1"] = struct{}{}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	req.RemoteAddr = "192.0.2.1:4242"
+	rec := httptest.NewRecorder()
+	g.httpHandler(&config.ListenerConfig{Name: "http"}).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	events := waitEvents(t, g, "192.0.2.1", 1)
+	if len(events) != 1 || events[0].Action != domain.ActionExcluded || events[0].Path != "/admin" {
+		t.Errorf("events = %+v, want the excluded hit", events)
+	}
+}
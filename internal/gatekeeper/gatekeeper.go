@@ -2,32 +2,41 @@ package gatekeeper
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
-	"slices"
-	"strings"
+	"sync"
 	"time"
 
-	"github.com/TOomaAh/GateKeeper/internal/abuseip"
+	"github.com/TOomaAh/GateKeeper/internal/cache"
+	"github.com/TOomaAh/GateKeeper/internal/clientip"
 	"github.com/TOomaAh/GateKeeper/internal/config"
 	"github.com/TOomaAh/GateKeeper/internal/dashboard"
 	"github.com/TOomaAh/GateKeeper/internal/database"
 	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/fingerprint"
+	"github.com/TOomaAh/GateKeeper/internal/firewall"
+	"github.com/TOomaAh/GateKeeper/internal/geoip"
+	"github.com/TOomaAh/GateKeeper/internal/netlist"
 	"github.com/TOomaAh/GateKeeper/internal/notification"
+	"github.com/TOomaAh/GateKeeper/internal/pipeline"
+	"github.com/TOomaAh/GateKeeper/internal/policy"
 	"github.com/TOomaAh/GateKeeper/internal/queue"
 	"github.com/TOomaAh/GateKeeper/internal/ratelimit"
-	"github.com/TOomaAh/GateKeeper/internal/unifi"
+	"github.com/TOomaAh/GateKeeper/internal/reputation"
+
+	// Firewall backends and reputation providers register themselves on import
+	_ "github.com/TOomaAh/GateKeeper/internal/abuseip"
+	_ "github.com/TOomaAh/GateKeeper/internal/blocklist"
+	_ "github.com/TOomaAh/GateKeeper/internal/netfilter"
+	_ "github.com/TOomaAh/GateKeeper/internal/unifi"
 )
 
 const (
-	// DefaultCacheTTL is the default cache TTL (1 hour)
-	DefaultCacheTTL = 1 * time.Hour
-	// DefaultListenAddr is the default listening address
-	DefaultListenAddr = ":8888"
 	// TarpitDuration is the tarpit duration (1 hour)
 	TarpitDuration = 1 * time.Hour
 	// TarpitTickInterval is the byte sending interval for tarpit
@@ -38,33 +47,67 @@ const (
 
 // GateKeeper manages detection and blocking of direct IP access
 type GateKeeper struct {
-	config        *config.Configuration
-	abuseIpClient *abuseip.Client
-	db            *database.IPDatabase
-	unifiClients  []*unifi.Client
-	notifier      *notification.MultiNotifier
-	rateLimiter   *ratelimit.IPRateLimiter
-
-	ipScan *queue.IPQueue
+	config      *config.Configuration
+	reputation  *reputation.Aggregator
+	db          database.Store
+	ipCache     *cache.Store
+	reporter    *reporter
+	geoip       *geoip.Resolver
+	policy      *policy.Engine
+	blockers    []firewall.Blocker
+	notifier    *notification.MultiNotifier
+	rateLimiter *ratelimit.IPRateLimiter
+	events      *eventWriter
+
+	ipScan *queue.IPQueue[*domain.IPInfo]
+	jobs   *pipeline.Pool
+
+	excluded     *netlist.PrefixSet
+	excludedFile *netlist.WatchedFile
+	clientIP     *clientip.Extractor
+
+	allowlistMu sync.RWMutex
+	allowlist   map[string]struct{}
+
+	retentionMu sync.Mutex
+	retention   database.RetentionStats
 }
 
 // NewGateKeeper creates a new GateKeeper instance
 func NewGateKeeper(cfg *config.Configuration) (*GateKeeper, error) {
-	abuseClient, err := abuseip.NewClient(cfg.AbuseIP.APIKey)
+	aggregator, err := reputation.NewAggregator(&cfg.Reputation)
 	if err != nil {
 		return nil, err
 	}
+	log.Printf("Loaded %d reputation provider(s), mode: %s", aggregator.Len(), cfg.Reputation.Mode)
+
+	excluded, err := netlist.Parse(cfg.ExcludedIPs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid excluded_ips: %w", err)
+	}
+
+	trustedProxies, err := netlist.Parse(cfg.ClientIP.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client_ip.trusted_proxies: %w", err)
+	}
+	clientIP := clientip.New(trustedProxies, cfg.ClientIP.Header)
 
-	var unifiClients []*unifi.Client
-	for i := range cfg.Unifi {
-		client := unifi.NewClient(&cfg.Unifi[i])
-		if err := client.Login(); err != nil {
-			log.Printf("Failed to login to UniFi controller %s: %v", cfg.Unifi[i].URL, err)
-		} else {
-			unifiClients = append(unifiClients, client)
+	var excludedFile *netlist.WatchedFile
+	if cfg.ExcludedIPsFile != "" {
+		excludedFile, err = netlist.WatchFile(cfg.ExcludedIPsFile, netlist.DefaultReloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid excluded_ips_file: %w", err)
 		}
+		log.Printf("Loaded %d excluded prefix(es) from %s", excludedFile.Len(), cfg.ExcludedIPsFile)
+	}
+
+	resolver, err := geoip.NewResolver(cfg.GeoIP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid geoip database: %w", err)
 	}
 
+	blockers := firewall.NewAll(cfg.Firewalls)
+
 	notifier := notification.NewMultiNotifier(cfg.Notifications.TelegramNotification)
 
 	var rateLimiter *ratelimit.IPRateLimiter
@@ -77,113 +120,295 @@ func NewGateKeeper(cfg *config.Configuration) (*GateKeeper, error) {
 	} else {
 		rateLimiter = ratelimit.NewDefaultIPRateLimiter()
 	}
+	rateLimiter.SetExtractor(clientIP)
 
-	dbPath := DefaultDBPath
-	if cfg.Database.Path != "" {
-		dbPath = cfg.Database.Path
+	source := cfg.Database.DSN
+	if cfg.Database.Driver == "" || cfg.Database.Driver == database.DriverSQLite {
+		source = DefaultDBPath
+		if cfg.Database.Path != "" {
+			source = cfg.Database.Path
+		}
 	}
 
-	db, err := database.NewIPDatabase(dbPath, DefaultCacheTTL)
+	db, err := database.Open(cfg.Database.Driver, source, cfg.Retention.RecheckTTL)
 	if err != nil {
 		return nil, err
 	}
 
+	var ipCache *cache.Store
+	if cfg.Cache.MaxEntries >= 0 {
+		maxEntries := cfg.Cache.MaxEntries
+		if maxEntries == 0 {
+			maxEntries = cache.DefaultMaxEntries
+		}
+		negativeTTL := cfg.Cache.NegativeTTL
+		if negativeTTL == 0 {
+			negativeTTL = cache.DefaultNegativeTTL
+		}
+
+		ipCache = cache.New(db, cfg.Retention.RecheckTTL, negativeTTL, maxEntries)
+		db = ipCache
+		log.Printf("IP cache enabled: %d entries", maxEntries)
+	}
+
+	restoreBlockers(db, blockers)
+	aggregator.SetUsageStore(db)
+
+	var abuseReporter *reporter
+	if cfg.AbuseIP.Report.Enabled {
+		abuseReporter, err = newReporter(cfg.AbuseIP.Report, db)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("AbuseIPDB reporting enabled: %d reports/day", cfg.AbuseIP.Report.DailyLimit)
+	}
+
 	return &GateKeeper{
-		config:        cfg,
-		abuseIpClient: abuseClient,
-		db:            db,
-		unifiClients:  unifiClients,
-		notifier:      notifier,
-		rateLimiter:   rateLimiter,
-		ipScan:        queue.NewIPQueue(),
+		config:       cfg,
+		reputation:   aggregator,
+		db:           db,
+		ipCache:      ipCache,
+		reporter:     abuseReporter,
+		geoip:        resolver,
+		policy:       policy.New(cfg.Policy, cfg.TLSFingerprints),
+		blockers:     blockers,
+		notifier:     notifier,
+		rateLimiter:  rateLimiter,
+		events:       newEventWriter(db),
+		ipScan:       queue.NewIPQueue[*domain.IPInfo](),
+		jobs:         pipeline.New(cfg.Pipeline.Workers, cfg.Pipeline.QueueSize),
+		allowlist:    make(map[string]struct{}),
+		excluded:     excluded,
+		excludedFile: excludedFile,
+		clientIP:     clientIP,
 	}, nil
 }
 
-func (g *GateKeeper) extractClientIP(r *http.Request) string {
-	ip := r.Header.Get("X-Forwarded-For")
-	if ip == "" {
-		ip = r.RemoteAddr
-	} else {
-		if idx := strings.Index(ip, ","); idx > 0 {
-			ip = ip[:idx]
-		}
-	}
+// restoreBlockers reloads the blocked IPs into backends that lose their
+// state on restart, such as nftables sets and ipsets
+func restoreBlockers(db database.Store, blockers []firewall.Blocker) {
+	var blocks map[string]time.Time
 
-	ip = strings.TrimSpace(ip)
+	for _, blocker := range blockers {
+		restorer, ok := blocker.(firewall.Restorer)
+		if !ok {
+			continue
+		}
 
-	// Remove port if present
-	// IPv4: "192.168.1.1:12345" -> "192.168.1.1"
-	// IPv6: "[::1]:12345" -> "::1"
-	if strings.Contains(ip, ":") {
-		if strings.HasPrefix(ip, "[") {
-			if idx := strings.Index(ip, "]"); idx > 0 {
-				ip = ip[1:idx]
+		if blocks == nil {
+			states, err := db.GetBlockStates()
+			if err != nil {
+				log.Printf("Failed to load blocked IPs from database: %v", err)
+				return
 			}
-		} else {
-			colonCount := strings.Count(ip, ":")
-			if colonCount == 1 {
-				ip = strings.Split(ip, ":")[0]
+
+			blocks = make(map[string]time.Time)
+			for ip, state := range states {
+				if state.Blocked {
+					blocks[ip] = state.BlockedAt
+				}
 			}
 		}
-	}
 
-	return ip
+		if err := restorer.Restore(blocks); err != nil {
+			log.Printf("Failed to restore %s: %v", blocker.Name(), err)
+			continue
+		}
+		log.Printf("Restored %d blocked IP(s) in %s", len(blocks), blocker.Name())
+	}
 }
 
 func (g *GateKeeper) isExcludedIP(ip string) bool {
-	return slices.Contains(g.config.ExcludedIPs, ip)
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		if g.excluded.Contains(addr) {
+			return true
+		}
+		if g.excludedFile != nil && g.excludedFile.Contains(addr) {
+			return true
+		}
+	}
+
+	g.allowlistMu.RLock()
+	defer g.allowlistMu.RUnlock()
+
+	_, allowed := g.allowlist[ip]
+	return allowed
 }
 
-func (g *GateKeeper) handler(w http.ResponseWriter, r *http.Request) {
-	ip := g.extractClientIP(r)
+// isLocalAddress reports whether an IP is private or reserved, and thus
+// has no public reputation
+func isLocalAddress(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return !addr.IsGlobalUnicast() || addr.IsPrivate()
+}
+
+// localIPInfo handles private and reserved addresses. They are neither
+// checked for reputation, nor persisted, nor blocked: their entries
+// only live in the cache.
+func (g *GateKeeper) localIPInfo(h *hit) *domain.IPInfo {
+	var info *domain.IPInfo
+	if g.ipCache != nil {
+		info, _ = g.ipCache.Lookup(h.ip)
+	}
+	if info == nil {
+		info = &domain.IPInfo{
+			Address:   h.ip,
+			Country:   "Private",
+			Timestamp: time.Now(),
+		}
+	}
+
+	info.Path = h.path
+	g.recordTLS(info, h.tls)
+
+	if g.ipCache != nil {
+		g.ipCache.Remember(info)
+	}
+	return info
+}
 
-	mutex := g.ipScan.Get(ip)
-	mutex.Lock()
+// verdict is the outcome of inspecting a connection attempt
+type verdict int
+
+const (
+	verdictInspected verdict = iota
+	verdictExcluded
+	verdictRateLimited
+)
+
+// hit describes a connection attempt on a detection listener
+type hit struct {
+	ip          string
+	method      string
+	host        string
+	path        string
+	userAgent   string
+	headersHash string
+	payload     io.Reader
+	body        []byte
+	listener    *config.ListenerConfig
+	tls         *fingerprint.ClientHello
+}
+
+// inspect decides how to answer a hit. The answer relies on what is
+// already known about the IP; the reputation lookup, blocking and
+// notification run in the pipeline and update the verdict for the
+// next hits.
+func (g *GateKeeper) inspect(h *hit) (verdict, *domain.IPInfo) {
+	ip := h.ip
 
 	if g.isExcludedIP(ip) {
 		log.Printf("IP %s is excluded, allowing access", ip)
-		w.WriteHeader(http.StatusOK)
-		return
+		return verdictExcluded, nil
 	}
 
 	if !g.rateLimiter.Allow(ip) {
 		log.Printf("Rate limit exceeded for IP %s", ip)
-		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-		return
+		return verdictRateLimited, nil
 	}
 
-	path := r.RequestURI
-	log.Printf("Direct IP access detected: IP=%s, Path=%s", ip, path)
+	log.Printf("Direct IP access detected: IP=%s, Listener=%s, Path=%s", ip, h.listener.Name, h.path)
 
-	ipInfo := g.getOrCreateIPInfo(ip, path, r)
+	if isLocalAddress(ip) {
+		ipInfo := g.localIPInfo(h)
+		info := *ipInfo
+		if !g.jobs.Submit("notify "+ip, func() { g.notifier.Notify(&info) }) {
+			log.Printf("Pipeline queue full, skipping notification of IP %s", ip)
+		}
+		return verdictInspected, ipInfo
+	}
 
-	mutex.Unlock()
+	ipInfo := g.provisionalIPInfo(h)
 
-	g.notifier.Notify(ipInfo)
+	// The payload must be read before the connection is answered
+	h.body = g.readPayload(h.payload)
+	if !g.jobs.Submit("inspect "+ip, func() { g.enrich(h) }) {
+		log.Printf("Pipeline queue full, skipping lookup of IP %s", ip)
+	}
 
-	if ipInfo.IsHighRisk() {
-		log.Printf("Tarpitting IP %s (score: %d)", ip, ipInfo.Score)
-		g.tarpit(w, r)
-	} else {
-		log.Printf("Dropping connection from IP %s (score: %d)", ip, ipInfo.Score)
-		g.dropConnection(w)
+	return verdictInspected, ipInfo
+}
+
+// provisionalIPInfo returns the verdict known so far for an IP, without
+// any remote call: its stored entry, or an unscored one, with the policy
+// rules applied
+func (g *GateKeeper) provisionalIPInfo(h *hit) *domain.IPInfo {
+	info, exists := g.db.Get(h.ip)
+	if !exists {
+		info = &domain.IPInfo{
+			Address:   h.ip,
+			Country:   "Unknown",
+			Timestamp: time.Now(),
+		}
+		g.locate(info)
 	}
+
+	info.Path = h.path
+	g.recordTLS(info, h.tls)
+	return g.policy.Apply(info, h.listener.Name)
 }
 
-func (g *GateKeeper) getOrCreateIPInfo(ip, path string, r *http.Request) *domain.IPInfo {
+// enrich runs in the pipeline: it checks the IP, stores and blocks it if
+// needed, then notifies
+func (g *GateKeeper) enrich(h *hit) {
+	// Concurrent hits from a new IP share a single reputation lookup
+	ipInfo, shared, err := g.ipScan.Do(h.ip, func() *domain.IPInfo {
+		return g.getOrCreateIPInfo(h)
+	})
+	if err != nil {
+		log.Printf("Failed to inspect IP %s: %v", h.ip, err)
+		return
+	}
+	if ipInfo == nil {
+		return
+	}
+	if shared {
+		info := *ipInfo
+		info.Path = h.path
+		ipInfo = &info
+	}
+
+	if g.reporter != nil {
+		g.reporter.report(h, ipInfo)
+	}
+
+	g.notifier.Notify(ipInfo)
+}
+
+func (g *GateKeeper) getOrCreateIPInfo(h *hit) *domain.IPInfo {
+	ip, path := h.ip, h.path
+
 	if entry, exists := g.db.Get(ip); exists {
 		log.Printf("IP %s found in database (score: %d, blocked: %v)", ip, entry.Score, entry.BlockedInFW)
 		entry.Path = path
+
+		located := g.locate(entry)
+		if g.recordTLS(entry, h.tls) || located {
+			if err := g.db.Set(entry); err != nil {
+				log.Printf("Failed to save IP to database: %v", err)
+			}
+		}
+
+		entry = g.decide(entry, h)
+
+		// The previous block may have expired while the IP kept scanning
+		if entry.ShouldBlock() && !entry.BlockedInFW && len(g.blockers) > 0 {
+			g.blockIP(entry)
+		}
 		return entry
 	}
 
-	score, country, err := g.abuseIpClient.Check(ip)
+	score, country, results, err := g.reputation.Check(ip)
 	if err != nil {
-		log.Printf("Error checking AbuseIPDB: %v", err)
-		score = 0
+		log.Printf("Error checking reputation of IP %s: %v", ip, err)
+		return g.unavailableIPInfo(h)
+	}
+	log.Printf("Reputation check: IP=%s, Score=%d, Country=%s", ip, score, country)
+	if country == "" {
 		country = "Unknown"
-	} else {
-		log.Printf("AbuseIPDB check: IP=%s, Score=%d, Country=%s", ip, score, country)
 	}
 
 	ipInfo := &domain.IPInfo{
@@ -191,12 +416,19 @@ func (g *GateKeeper) getOrCreateIPInfo(ip, path string, r *http.Request) *domain
 		Score:       score,
 		Country:     country,
 		Path:        path,
+		Reputation:  results,
 		BlockedInFW: false,
 		Timestamp:   time.Now(),
 	}
+	// A manual unblock outlives the refresh of the reputation
+	if stale, exists := g.db.GetStale(ip); exists {
+		ipInfo.ManualUnblock = stale.ManualUnblock
+	}
+	g.locate(ipInfo)
+	g.recordTLS(ipInfo, h.tls)
 
 	if g.config.Payload.Enabled {
-		payloadPath := g.savePayload(ip, r)
+		payloadPath := g.savePayload(ip, h.body)
 		if payloadPath != "" {
 			ipInfo.PayloadPath = payloadPath
 		}
@@ -206,29 +438,61 @@ func (g *GateKeeper) getOrCreateIPInfo(ip, path string, r *http.Request) *domain
 		log.Printf("Failed to save IP to database: %v", err)
 	}
 
-	if ipInfo.IsHighRisk() && len(g.unifiClients) > 0 {
-		g.blockIPInUniFi(ipInfo)
+	ipInfo = g.decide(ipInfo, h)
+	if ipInfo.IsHighRisk() && ipInfo.IsWhitelisted() && !ipInfo.Verdict.AlwaysBlock {
+		log.Printf("IP %s is whitelisted by a reputation provider, not blocking it", ip)
+	}
+
+	if ipInfo.ShouldBlock() && len(g.blockers) > 0 {
+		g.blockIP(ipInfo)
 	}
 
 	return ipInfo
 }
 
-func (g *GateKeeper) savePayload(ip string, r *http.Request) string {
-	if err := os.MkdirAll(g.config.Payload.Directory, 0755); err != nil {
-		log.Printf("Failed to create payload directory: %v", err)
-		return ""
+// unavailableIPInfo answers for an IP that no provider could check, for
+// instance while their quota is exhausted. The last known verdict is used
+// even if outdated; otherwise the lookup is deferred to the next hit and
+// nothing is stored, so that the IP does not keep a score of 0.
+func (g *GateKeeper) unavailableIPInfo(h *hit) *domain.IPInfo {
+	if entry, exists := g.db.GetStale(h.ip); exists {
+		log.Printf("Using stale entry of IP %s checked at %s (score: %d)", h.ip, entry.Timestamp.Format(time.RFC3339), entry.Score)
+		entry.Path = h.path
+		entry = g.decide(entry, h)
+		if entry.ShouldBlock() && !entry.BlockedInFW && len(g.blockers) > 0 {
+			g.blockIP(entry)
+		}
+		return entry
 	}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, int64(g.config.Payload.MaxSize)))
+	log.Printf("Deferring lookup of IP %s to its next hit", h.ip)
+	return g.provisionalIPInfo(h)
+}
+
+// readPayload buffers up to the maximum payload size, so that it can be
+// saved and inspected for reports once the connection is gone
+func (g *GateKeeper) readPayload(payload io.Reader) []byte {
+	if (!g.config.Payload.Enabled && g.reporter == nil) || payload == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(payload, int64(g.config.Payload.MaxSize)))
 	if err != nil {
 		log.Printf("Failed to read request body: %v", err)
-		return ""
 	}
+	return body
+}
 
+func (g *GateKeeper) savePayload(ip string, body []byte) string {
 	if len(body) == 0 {
 		return ""
 	}
 
+	if err := os.MkdirAll(g.config.Payload.Directory, 0755); err != nil {
+		log.Printf("Failed to create payload directory: %v", err)
+		return ""
+	}
+
 	hash := sha256.Sum256(body)
 	timestamp := time.Now().Unix()
 	filename := fmt.Sprintf("%s_%d_%x.bin", ip, timestamp, hash[:8])
@@ -243,76 +507,34 @@ func (g *GateKeeper) savePayload(ip string, r *http.Request) string {
 	return fullPath
 }
 
-func (g *GateKeeper) blockIPInUniFi(ipInfo *domain.IPInfo) {
-	for _, unifiClient := range g.unifiClients {
-		if err := unifiClient.AddIPToFirewall(ipInfo.Address); err != nil {
-			log.Printf("Failed to block IP %s in UniFi: %v", ipInfo.Address, err)
-		} else {
+// blockIP blocks an IP on every backend. It returns the errors of the
+// backends that failed.
+func (g *GateKeeper) blockIP(ipInfo *domain.IPInfo) error {
+	var errs []error
+	for _, blocker := range g.blockers {
+		if err := blocker.Block(ipInfo.Address); err != nil {
+			log.Printf("Failed to block IP %s in %s: %v", ipInfo.Address, blocker.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", blocker.Name(), err))
+			continue
+		}
+		log.Printf("IP %s blocked in %s", ipInfo.Address, blocker.Name())
+
+		if !ipInfo.BlockedInFW {
 			ipInfo.BlockedInFW = true
 			if err := g.db.MarkBlocked(ipInfo.Address); err != nil {
 				log.Printf("Failed to mark IP as blocked in database: %v", err)
 			}
-			log.Printf("IP %s blocked in UniFi firewall", ipInfo.Address)
-		}
-	}
-}
-
-func (g *GateKeeper) tarpit(w http.ResponseWriter, _ *http.Request) {
-	hj, ok := w.(http.Hijacker)
-	if !ok {
-		log.Println("Server doesn't support hijacking, sending normal response")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		return
-	}
-
-	conn, _, err := hj.Hijack()
-	if err != nil {
-		log.Printf("Hijack error: %v", err)
-		return
-	}
-
-	go func() {
-		defer conn.Close()
-		ticker := time.NewTicker(TarpitTickInterval)
-		defer ticker.Stop()
-
-		timeout := time.After(TarpitDuration)
-		for {
-			select {
-			case <-ticker.C:
-				if _, err := conn.Write([]byte{0}); err != nil {
-					return
-				}
-			case <-timeout:
-				return
-			}
 		}
-	}()
-}
-
-func (g *GateKeeper) dropConnection(w http.ResponseWriter) {
-	hj, ok := w.(http.Hijacker)
-	if !ok {
-		log.Println("Server doesn't support hijacking, sending 403")
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-
-	conn, _, err := hj.Hijack()
-	if err != nil {
-		log.Printf("Hijack error: %v", err)
-		w.WriteHeader(http.StatusForbidden)
-		return
 	}
 
-	conn.Close()
+	return errors.Join(errs...)
 }
 
-// Run starts the HTTP server
+// Run starts the detection listeners and the dashboard
 func (g *GateKeeper) Run() error {
 	// Start dashboard if enabled
 	if g.config.Dashboard.Enabled {
-		dash := dashboard.NewDashboard(g.config, g.db)
+		dash := dashboard.NewDashboard(g.config, g.db, g, g)
 		go func() {
 			if err := dash.Run(); err != nil {
 				log.Printf("Dashboard error: %v", err)
@@ -320,12 +542,56 @@ func (g *GateKeeper) Run() error {
 		}()
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", g.handler)
+	go g.reconcileLoop()
+	go g.retentionLoop()
+
+	errCh := make(chan error, len(g.config.Listeners))
+	for i := range g.config.Listeners {
+		lc := &g.config.Listeners[i]
 
-	log.Printf("GateKeeper listening on %s", DefaultListenAddr)
-	log.Printf("Loaded %d UniFi controller(s)", len(g.unifiClients))
+		ln, err := g.listen(lc)
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", lc.Name, err)
+		}
+
+		go func() {
+			errCh <- fmt.Errorf("listener %s: %w", lc.Name, g.serve(lc, ln))
+		}()
+	}
+
+	log.Printf("Loaded %d firewall backend(s)", len(g.blockers))
+	for _, blocker := range g.blockers {
+		if err := blocker.Health(); err != nil {
+			log.Printf("Firewall backend %s is unhealthy: %v", blocker.Name(), err)
+		}
+	}
+	if g.config.Blocking.Duration > 0 {
+		log.Printf("Blocked IPs expire after %s", g.config.Blocking.Duration)
+	}
 	log.Printf("Loaded %d Telegram notification(s)", len(g.config.Notifications.TelegramNotification))
 
-	return http.ListenAndServe(DefaultListenAddr, mux)
+	err := <-errCh
+	// Complete the lookups and notifications already queued
+	g.jobs.Close()
+	return err
+}
+
+// PipelineStats returns the worker pool counters
+func (g *GateKeeper) PipelineStats() pipeline.Stats {
+	return g.jobs.Stats()
+}
+
+// ReputationStats returns the circuit breaker state and quota of the
+// reputation providers
+func (g *GateKeeper) ReputationStats() []reputation.ProviderStats {
+	return g.reputation.Stats()
+}
+
+// CacheStats returns the IP cache counters, or nil if the cache is disabled
+func (g *GateKeeper) CacheStats() *cache.Stats {
+	if g.ipCache == nil {
+		return nil
+	}
+	stats := g.ipCache.Stats()
+	return &stats
 }
@@ -0,0 +1,71 @@
+package gatekeeper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/ratelimit"
+)
+
+// TestEarlyReturnsReleaseTheQueue runs concurrent excluded, rate-limited
+// and known hits: none of them may leave an IP behind in the queue nor
+// block the next hits of the same IP
+func TestEarlyReturnsReleaseTheQueue(t *testing.T) {
+	g := newTestGateKeeper(t)
+	g.rateLimiter = ratelimit.NewIPRateLimiter(1, time.Minute)
+	g.allowlist["192.0.2.1"] = struct{}{}
+	if err := g.db.Set(&domain.IPInfo{Address: "192.0.2.3", Score: 10, Country: "FR", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	lc := &config.ListenerConfig{Name: "http", Response: config.ResponseStatus, StatusCode: http.StatusNotFound}
+	handler := g.httpHandler(lc)
+	request := func(ip string) {
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.RemoteAddr = ip + ":4242"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				request("192.0.2.1")
+			}()
+			go func() {
+				defer wg.Done()
+				request("192.0.2.2")
+			}()
+			go func() {
+				defer wg.Done()
+				g.enrich(&hit{ip: "192.0.2.3", path: "/admin", listener: lc})
+			}()
+		}
+		wg.Wait()
+
+		// The same IPs go through again once the others returned
+		request("192.0.2.1")
+		request("192.0.2.2")
+		g.enrich(&hit{ip: "192.0.2.3", path: "/admin", listener: lc})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("hits still blocked after 10s")
+	}
+
+	if n := g.ipScan.Len(); n != 0 {
+		t.Errorf("queue holds %d IP(s), want 0", n)
+	}
+}
@@ -0,0 +1,313 @@
+package gatekeeper
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/fingerprint"
+	"github.com/TOomaAh/GateKeeper/internal/netlist"
+	"github.com/TOomaAh/GateKeeper/internal/proxyproto"
+	"github.com/TOomaAh/GateKeeper/internal/tlsutil"
+)
+
+const (
+	// TCPReadTimeout bounds the wait for the first bytes on raw TCP listeners
+	TCPReadTimeout = 5 * time.Second
+	// TCPReadSize is the maximum number of bytes read on raw TCP listeners
+	TCPReadSize = 4096
+	// TCPPathMaxLength is the maximum length of the first line kept as path
+	TCPPathMaxLength = 256
+)
+
+// listen opens the socket of a listener, wrapped for PROXY protocol if enabled
+func (g *GateKeeper) listen(lc *config.ListenerConfig) (net.Listener, error) {
+	ln, err := net.Listen("tcp", lc.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if lc.ProxyProtocol.Enabled {
+		allowed, err := netlist.Parse(lc.ProxyProtocol.AllowedSources)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("invalid proxy_protocol.allowed_sources: %w", err)
+		}
+		ln = proxyproto.NewListener(ln, allowed)
+		log.Printf("PROXY protocol enabled on %s for %d source network(s)", lc.Name, allowed.Len())
+	}
+
+	return ln, nil
+}
+
+// serve accepts connections until the listener fails
+func (g *GateKeeper) serve(lc *config.ListenerConfig, ln net.Listener) error {
+	log.Printf("GateKeeper listening on %s (%s, response: %s)", lc.Address, lc.Protocol, lc.Response)
+
+	switch lc.Protocol {
+	case config.ProtocolTCP:
+		return g.serveTCP(lc, ln)
+	case config.ProtocolHTTPS:
+		cert, err := tlsutil.LoadOrGenerate(lc.TLS.Cert, lc.TLS.Key, lc.TLS.Hosts...)
+		if err != nil {
+			return err
+		}
+		// HTTP/2 connections cannot be hijacked to tarpit or drop them
+		server := &http.Server{
+			Handler:     g.httpHandler(lc),
+			ConnContext: fingerprint.WithConn,
+			ConnState:   g.handshakeFailures(lc),
+			TLSConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				NextProtos:   []string{"http/1.1"},
+			},
+			TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+		}
+		return server.ServeTLS(fingerprint.NewListener(ln), "", "")
+	default:
+		server := &http.Server{Handler: g.httpHandler(lc)}
+		return server.Serve(ln)
+	}
+}
+
+func (g *GateKeeper) httpHandler(lc *config.ListenerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := &hit{
+			ip:          g.clientIP.FromRequest(r),
+			method:      r.Method,
+			host:        r.Host,
+			path:        r.RequestURI,
+			userAgent:   r.UserAgent(),
+			headersHash: headersHash(r.Header),
+			payload:     r.Body,
+			listener:    lc,
+			tls:         fingerprint.FromContext(r.Context()),
+		}
+
+		result, ipInfo := g.inspect(h)
+
+		switch result {
+		case verdictExcluded:
+			g.recordEvent(h, domain.ActionExcluded)
+			w.WriteHeader(http.StatusOK)
+			return
+		case verdictRateLimited:
+			g.recordEvent(h, domain.ActionRateLimited)
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		if lc.Response == config.ResponseStatus {
+			log.Printf("Answering IP %s with status %d (score: %d)", h.ip, lc.StatusCode, ipInfo.Score)
+			g.recordEvent(h, domain.ActionStatus)
+			w.WriteHeader(lc.StatusCode)
+			return
+		}
+
+		conn, err := hijack(w)
+		if err != nil {
+			log.Printf("Hijack error: %v", err)
+			g.recordEvent(h, domain.ActionStatus)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		g.recordEvent(h, g.respond(lc, conn, ipInfo))
+	}
+}
+
+// handshakeFailures returns the ConnState hook of an https listener. It
+// records the connections closed before their TLS handshake completed,
+// such as scanners rejecting the certificate: the handler never sees them
+// but their ClientHello was read and can be fingerprinted.
+func (g *GateKeeper) handshakeFailures(lc *config.ListenerConfig) func(net.Conn, http.ConnState) {
+	var served sync.Map
+
+	return func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateActive:
+			served.Store(conn, struct{}{})
+		case http.StateHijacked:
+			served.Delete(conn)
+		case http.StateClosed:
+			if _, ok := served.LoadAndDelete(conn); ok {
+				return
+			}
+			tlsConn, ok := conn.(*tls.Conn)
+			if !ok || tlsConn.ConnectionState().HandshakeComplete {
+				return
+			}
+			g.handleHandshakeFailure(lc, tlsConn)
+		}
+	}
+}
+
+// handleHandshakeFailure records a TLS connection that failed during the
+// handshake like a hit on the raw TCP listeners
+func (g *GateKeeper) handleHandshakeFailure(lc *config.ListenerConfig, conn *tls.Conn) {
+	hello := fingerprint.FromConn(conn)
+	if hello == nil {
+		return
+	}
+
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return
+	}
+	log.Printf("TLS handshake with IP %s on %s failed", host, lc.Name)
+
+	h := &hit{
+		ip:       host,
+		host:     hello.ServerName,
+		path:     "tls://" + hello.ServerName,
+		listener: lc,
+		tls:      hello,
+	}
+
+	switch result, _ := g.inspect(h); result {
+	case verdictExcluded:
+		g.recordEvent(h, domain.ActionExcluded)
+	case verdictRateLimited:
+		g.recordEvent(h, domain.ActionRateLimited)
+	default:
+		g.recordEvent(h, domain.ActionHandshakeFailed)
+	}
+}
+
+func hijack(w http.ResponseWriter) (net.Conn, error) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("server doesn't support hijacking")
+	}
+
+	conn, _, err := hj.Hijack()
+	return conn, err
+}
+
+func (g *GateKeeper) serveTCP(lc *config.ListenerConfig, ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		go g.handleTCP(lc, conn)
+	}
+}
+
+// handleTCP reads the first bytes sent by the peer and handles them like
+// an HTTP request whose path is the first line of data
+func (g *GateKeeper) handleTCP(lc *config.ListenerConfig, conn net.Conn) {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	// Many clients, SSH for instance, wait for the server to talk first:
+	// a timeout without data is still a connection attempt
+	conn.SetReadDeadline(time.Now().Add(TCPReadTimeout))
+	buf := make([]byte, TCPReadSize)
+	n, err := conn.Read(buf)
+	conn.SetReadDeadline(time.Time{})
+	if errors.Is(err, proxyproto.ErrInvalidHeader) {
+		return
+	}
+	data := buf[:n]
+
+	// TLS clients are fingerprinted even though the handshake never completes
+	h := &hit{
+		ip:       host,
+		path:     firstLine(data),
+		payload:  bytes.NewReader(data),
+		listener: lc,
+	}
+	if hello, err := fingerprint.Parse(data); err == nil {
+		h.host = hello.ServerName
+		h.path = "tls://" + hello.ServerName
+		h.tls = hello
+	}
+
+	result, ipInfo := g.inspect(h)
+
+	switch result {
+	case verdictExcluded:
+		g.recordEvent(h, domain.ActionExcluded)
+		conn.Close()
+		return
+	case verdictRateLimited:
+		g.recordEvent(h, domain.ActionRateLimited)
+		conn.Close()
+		return
+	}
+
+	g.recordEvent(h, g.respond(lc, conn, ipInfo))
+}
+
+// firstLine returns a printable version of the first line of data
+func firstLine(data []byte) string {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	line = bytes.TrimRight(line, "\r")
+	if len(line) > TCPPathMaxLength {
+		line = line[:TCPPathMaxLength]
+	}
+
+	quoted := strconv.QuoteToASCII(string(line))
+	return strings.TrimSuffix(strings.TrimPrefix(quoted, `"`), `"`)
+}
+
+// respond applies the response policy of the listener on the connection
+// and returns the action taken
+func (g *GateKeeper) respond(lc *config.ListenerConfig, conn net.Conn, ipInfo *domain.IPInfo) string {
+	tarpit := false
+	switch lc.Response {
+	case config.ResponseTarpit:
+		tarpit = true
+	case config.ResponseAuto:
+		tarpit = ipInfo.IsHighRisk()
+	}
+
+	if tarpit {
+		log.Printf("Tarpitting IP %s (score: %d)", ipInfo.Address, ipInfo.Score)
+		go g.tarpit(conn)
+		return domain.ActionTarpit
+	}
+
+	log.Printf("Dropping connection from IP %s (score: %d)", ipInfo.Address, ipInfo.Score)
+	conn.Close()
+	return domain.ActionDrop
+}
+
+// tarpit keeps the connection open, sending a byte every tick
+func (g *GateKeeper) tarpit(conn net.Conn) {
+	defer conn.Close()
+	ticker := time.NewTicker(TarpitTickInterval)
+	defer ticker.Stop()
+
+	timeout := time.After(TarpitDuration)
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := conn.Write([]byte{0}); err != nil {
+				return
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package gatekeeper
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/clientip"
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/notification"
+	"github.com/TOomaAh/GateKeeper/internal/pipeline"
+	"github.com/TOomaAh/GateKeeper/internal/policy"
+	"github.com/TOomaAh/GateKeeper/internal/queue"
+	"github.com/TOomaAh/GateKeeper/internal/ratelimit"
+)
+
+// newTestGateKeeper returns a GateKeeper backed by a memory store, without
+// any reputation provider nor pipeline worker
+func newTestGateKeeper(t *testing.T) *GateKeeper {
+	t.Helper()
+
+	db := database.NewMemoryStore(time.Hour)
+	return &GateKeeper{
+		config:      &config.Configuration{},
+		db:          db,
+		policy:      policy.New(config.PolicyConfig{}, nil),
+		notifier:    notification.NewMultiNotifier(nil),
+		rateLimiter: ratelimit.NewDefaultIPRateLimiter(),
+		jobs:        pipeline.New(0, 0),
+		events:      newEventWriter(db),
+		ipScan:      queue.NewIPQueue[*domain.IPInfo](),
+		clientIP:    clientip.New(nil, ""),
+		allowlist:   make(map[string]struct{}),
+	}
+}
+
+// serveHTTPS starts an https listener on a random port
+func serveHTTPS(t *testing.T, g *GateKeeper) string {
+	t.Helper()
+
+	lc := &config.ListenerConfig{
+		Name:     "https",
+		Address:  "127.0.0.1:0",
+		Protocol: config.ProtocolHTTPS,
+		Response: config.ResponseDrop,
+		TLS:      config.ListenerTLSConfig{Hosts: []string{"localhost"}},
+	}
+	ln, err := net.Listen("tcp", lc.Address)
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go g.serve(lc, ln)
+	return ln.Addr().String()
+}
+
+// waitEvents polls the events of an IP until count are recorded
+func waitEvents(t *testing.T, g *GateKeeper, ip string, count int) []*domain.Event {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events, err := g.db.GetEvents(ip, 10)
+		if err != nil {
+			t.Fatalf("GetEvents() error = %v", err)
+		}
+		if len(events) >= count || time.Now().After(deadline) {
+			return events
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHTTPSHandshakeFailureIsRecorded(t *testing.T) {
+	g := newTestGateKeeper(t)
+	addr := serveHTTPS(t, g)
+
+	// The self-signed certificate is rejected by the client
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "scanner.example"})
+	if err == nil {
+		conn.Close()
+		t.Fatal("handshake with a self-signed certificate succeeded")
+	}
+
+	events := waitEvents(t, g, "127.0.0.1", 1)
+	if len(events) != 1 {
+		t.Fatalf("events = %d, want 1", len(events))
+	}
+	if e := events[0]; e.Action != domain.ActionHandshakeFailed || e.Path != "tls://scanner.example" || e.Host != "scanner.example" {
+		t.Errorf("event = %+v", e)
+	}
+}
+
+func TestHTTPSRequestIsRecordedOnce(t *testing.T) {
+	g := newTestGateKeeper(t)
+	addr := serveHTTPS(t, g)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true, ServerName: "honeypot.example"},
+	}}
+	// The connection is dropped without an answer
+	if resp, err := client.Get(fmt.Sprintf("https://%s/admin", addr)); err == nil {
+		resp.Body.Close()
+	}
+
+	waitEvents(t, g, "127.0.0.1", 1)
+	// Give a wrongly recorded handshake failure the time to show up
+	time.Sleep(100 * time.Millisecond)
+	events := waitEvents(t, g, "127.0.0.1", 1)
+
+	if len(events) != 1 {
+		t.Fatalf("events = %+v, want a single one", events)
+	}
+	if e := events[0]; e.Action != domain.ActionDrop || e.Path != "/admin" {
+		t.Errorf("event = %+v", e)
+	}
+}
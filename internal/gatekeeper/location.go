@@ -0,0 +1,34 @@
+package gatekeeper
+
+import (
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// locate fills the country, city and ASN of an entry from the GeoIP
+// databases. Their country prevails over the one reported by the
+// reputation providers. It reports whether the entry changed, so that
+// entries stored before a database was added or updated get saved again.
+func (g *GateKeeper) locate(info *domain.IPInfo) bool {
+	if g.geoip == nil {
+		return false
+	}
+
+	loc := g.geoip.Lookup(info.Address)
+	changed := false
+
+	if loc.Country != "" && loc.Country != info.Country {
+		info.Country = loc.Country
+		changed = true
+	}
+	if loc.City != "" && loc.City != info.City {
+		info.City = loc.City
+		changed = true
+	}
+	if loc.ASN != 0 && (loc.ASN != info.ASN || loc.ASOrg != info.ASOrg) {
+		info.ASN = loc.ASN
+		info.ASOrg = loc.ASOrg
+		changed = true
+	}
+
+	return changed
+}
@@ -0,0 +1,19 @@
+package gatekeeper
+
+import (
+	"log"
+	"strings"
+
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// decide applies the policy rules to the entry of a hit before it is
+// blocked and notified. It returns a copy, so that the adjusted score is
+// never stored and rules apply afresh on every hit.
+func (g *GateKeeper) decide(info *domain.IPInfo, h *hit) *domain.IPInfo {
+	checked := g.policy.Apply(info, h.listener.Name)
+	if len(checked.Verdict.Rules) > 0 {
+		log.Printf("IP %s matches policy rule(s) %s, score %d -> %d", info.Address, strings.Join(checked.Verdict.Rules, ", "), info.Score, checked.Score)
+	}
+	return checked
+}
@@ -0,0 +1,171 @@
+package gatekeeper
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/firewall"
+)
+
+// reconcileLoop periodically expires old blocks and repairs drift between
+// the database and the firewall backends
+func (g *GateKeeper) reconcileLoop() {
+	ticker := time.NewTicker(g.config.Blocking.ReconcileInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		g.reconcile()
+	}
+}
+
+func (g *GateKeeper) reconcile() {
+	if g.config.Blocking.Duration > 0 {
+		g.expireBlocks()
+	}
+
+	g.repairDrift()
+}
+
+// expireBlocks unblocks every IP blocked for longer than the block duration
+func (g *GateKeeper) expireBlocks() {
+	expired, err := g.db.GetExpiredBlocks(g.config.Blocking.Duration)
+	if err != nil {
+		log.Printf("Failed to get expired blocks: %v", err)
+		return
+	}
+
+	for _, ip := range expired {
+		log.Printf("Block of IP %s expired after %s", ip, g.config.Blocking.Duration)
+		g.unblockIP(ip)
+	}
+}
+
+// unblockIP removes an IP from every backend and records the unblock. The
+// unblock is recorded even if a backend fails: the next drift repair will
+// retry the removal on that backend.
+func (g *GateKeeper) unblockIP(ip string) error {
+	var errs []error
+	for _, blocker := range g.blockers {
+		if err := blocker.Unblock(ip); err != nil {
+			log.Printf("Failed to unblock IP %s in %s: %v", ip, blocker.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", blocker.Name(), err))
+			continue
+		}
+		log.Printf("IP %s unblocked in %s", ip, blocker.Name())
+	}
+
+	if err := g.db.MarkUnblocked(ip); err != nil {
+		log.Printf("Failed to mark IP as unblocked in database: %v", err)
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// repairDrift compares the blocked_in_fw flags with the real contents of
+// each backend:
+//   - IPs flagged as blocked but missing from a backend are blocked again
+//   - IPs unblocked by GateKeeper but still present are removed
+//   - IPs present in a backend but not flagged are only logged
+//
+// Only the database flag decides what GateKeeper blocks: entries it did
+// not add, unknown IPs included, are left untouched since they may have
+// been added by hand. Backends whose entries expire are not refilled with the
+// IPs they timed out, and IPs timed out in every backend are marked as
+// unblocked so that they can be blocked again.
+func (g *GateKeeper) repairDrift() {
+	if len(g.blockers) == 0 {
+		return
+	}
+
+	states, err := g.db.GetBlockStates()
+	if err != nil {
+		log.Printf("Failed to load block states: %v", err)
+		return
+	}
+
+	for ip, state := range states {
+		if !state.Blocked || !g.timedOut(state.BlockedAt) {
+			continue
+		}
+
+		log.Printf("Block of IP %s timed out in every backend", ip)
+		if err := g.db.MarkUnblocked(ip); err != nil {
+			log.Printf("Failed to mark IP as unblocked in database: %v", err)
+			continue
+		}
+		states[ip] = database.BlockState{Unblocked: true}
+	}
+
+	for _, blocker := range g.blockers {
+		members, err := blocker.List()
+		if err != nil {
+			log.Printf("Failed to list IPs in %s: %v", blocker.Name(), err)
+			continue
+		}
+
+		present := make(map[string]bool, len(members))
+		for _, ip := range members {
+			present[ip] = true
+
+			state, known := states[ip]
+			if !known || state.Blocked {
+				continue
+			}
+
+			if state.Unblocked {
+				log.Printf("IP %s is still present in %s after unblock, removing it", ip, blocker.Name())
+				if err := blocker.Unblock(ip); err != nil {
+					log.Printf("Failed to unblock IP %s in %s: %v", ip, blocker.Name(), err)
+				}
+				continue
+			}
+
+			log.Printf("IP %s is present in %s but not flagged as blocked, leaving it", ip, blocker.Name())
+		}
+
+		for ip, state := range states {
+			if !state.Blocked || present[ip] {
+				continue
+			}
+
+			if err := reblock(blocker, ip, state.BlockedAt); err != nil {
+				log.Printf("Failed to block IP %s in %s: %v", ip, blocker.Name(), err)
+			}
+		}
+	}
+}
+
+// reblock adds back an IP missing from a backend. Backends whose entries
+// expire only get it for the rest of its block, and not at all once the
+// block timed out there.
+func reblock(blocker firewall.Blocker, ip string, blockedAt time.Time) error {
+	expirer, ok := blocker.(firewall.Expirer)
+	if !ok || expirer.Timeout() <= 0 {
+		log.Printf("IP %s is flagged as blocked but missing from %s, blocking it again", ip, blocker.Name())
+		return blocker.Block(ip)
+	}
+
+	remaining := firewall.Remaining(expirer.Timeout(), blockedAt)
+	if remaining <= 0 {
+		return nil
+	}
+
+	log.Printf("IP %s is flagged as blocked but missing from %s, blocking it again for %s", ip, blocker.Name(), remaining.Round(time.Second))
+	return expirer.BlockFor(ip, remaining)
+}
+
+// timedOut reports whether a block started at blockedAt expired in every
+// backend, so that none of them holds the IP any more
+func (g *GateKeeper) timedOut(blockedAt time.Time) bool {
+	for _, blocker := range g.blockers {
+		expirer, ok := blocker.(firewall.Expirer)
+		if !ok || expirer.Timeout() <= 0 || firewall.Remaining(expirer.Timeout(), blockedAt) > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package gatekeeper
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/firewall"
+)
+
+// expiringBlocker is a backend whose entries time out, like a set with a
+// timeout. It records the duration of every block.
+type expiringBlocker struct {
+	*firewall.MemoryBlocker
+	timeout time.Duration
+	blocks  map[string]time.Duration
+}
+
+func newExpiringBlocker(timeout time.Duration) *expiringBlocker {
+	return &expiringBlocker{
+		MemoryBlocker: firewall.NewMemoryBlocker("expiring"),
+		timeout:       timeout,
+		blocks:        make(map[string]time.Duration),
+	}
+}
+
+func (b *expiringBlocker) Timeout() time.Duration {
+	return b.timeout
+}
+
+func (b *expiringBlocker) Block(ip string) error {
+	return b.BlockFor(ip, b.timeout)
+}
+
+func (b *expiringBlocker) BlockFor(ip string, timeout time.Duration) error {
+	b.blocks[ip] = timeout
+	return b.MemoryBlocker.Block(ip)
+}
+
+// stateStore serves fixed block states and records the blocks and unblocks
+type stateStore struct {
+	*database.MemoryStore
+	states    map[string]database.BlockState
+	blocked   []string
+	unblocked []string
+}
+
+func (s *stateStore) MarkBlocked(ip string) error {
+	s.blocked = append(s.blocked, ip)
+	return nil
+}
+
+func (s *stateStore) GetBlockStates() (map[string]database.BlockState, error) {
+	return s.states, nil
+}
+
+func (s *stateStore) MarkUnblocked(ip string) error {
+	s.unblocked = append(s.unblocked, ip)
+	return nil
+}
+
+func TestRepairDriftRespectsTimeout(t *testing.T) {
+	now := time.Now()
+	store := &stateStore{
+		MemoryStore: database.NewMemoryStore(time.Hour),
+		states: map[string]database.BlockState{
+			"192.0.2.1": {Blocked: true, BlockedAt: now.Add(-15 * time.Minute)},
+			"192.0.2.2": {Blocked: true, BlockedAt: now.Add(-2 * time.Hour)},
+		},
+	}
+	expiring := newExpiringBlocker(time.Hour)
+	permanent := firewall.NewMemoryBlocker("permanent")
+	g := &GateKeeper{db: store, blockers: []firewall.Blocker{expiring, permanent}}
+
+	g.repairDrift()
+
+	// The recent block is added back for the rest of its hour, the timed
+	// out one only to the backend without timeout
+	if got := expiring.blocks["192.0.2.1"]; got <= 44*time.Minute || got > 45*time.Minute {
+		t.Errorf("192.0.2.1 blocked again for %s, want about 45m", got)
+	}
+	if _, ok := expiring.blocks["192.0.2.2"]; ok {
+		t.Error("192.0.2.2 blocked again although its block timed out")
+	}
+	if ips, _ := permanent.List(); !slices.Contains(ips, "192.0.2.2") {
+		t.Errorf("permanent backend = %v, want 192.0.2.2 blocked again", ips)
+	}
+	if len(store.unblocked) != 0 {
+		t.Errorf("unblocked %v although a backend still holds them", store.unblocked)
+	}
+}
+
+func TestRepairDriftMarksTimedOutBlocks(t *testing.T) {
+	now := time.Now()
+	store := &stateStore{
+		MemoryStore: database.NewMemoryStore(time.Hour),
+		states: map[string]database.BlockState{
+			"192.0.2.1": {Blocked: true, BlockedAt: now.Add(-15 * time.Minute)},
+			"192.0.2.2": {Blocked: true, BlockedAt: now.Add(-2 * time.Hour)},
+			"192.0.2.3": {Blocked: true},
+		},
+	}
+	expiring := newExpiringBlocker(time.Hour)
+	g := &GateKeeper{db: store, blockers: []firewall.Blocker{expiring}}
+
+	g.repairDrift()
+
+	if want := []string{"192.0.2.2"}; !slices.Equal(store.unblocked, want) {
+		t.Errorf("unblocked = %v, want %v", store.unblocked, want)
+	}
+	// Without a block time the IP is blocked for a full timeout
+	if got := expiring.blocks["192.0.2.3"]; got != time.Hour {
+		t.Errorf("192.0.2.3 blocked again for %s, want 1h", got)
+	}
+	if ips, _ := expiring.List(); slices.Contains(ips, "192.0.2.2") {
+		t.Errorf("backend = %v, timed out 192.0.2.2 added back", ips)
+	}
+}
+
+func TestRepairDriftOnlyRepairsFlaggedIPs(t *testing.T) {
+	store := &stateStore{
+		MemoryStore: database.NewMemoryStore(time.Hour),
+		states: map[string]database.BlockState{
+			"192.0.2.1": {Blocked: true, BlockedAt: time.Now()},
+			"192.0.2.2": {},
+			"192.0.2.3": {Unblocked: true},
+		},
+	}
+	backend := firewall.NewMemoryBlocker("backend")
+	for _, ip := range []string{"192.0.2.2", "192.0.2.3", "198.51.100.1"} {
+		backend.Block(ip)
+	}
+	g := &GateKeeper{db: store, blockers: []firewall.Blocker{backend}}
+
+	g.repairDrift()
+
+	// The flagged IP is blocked again and the unblocked one removed, while
+	// the unflagged and unknown ones are left as they are
+	ips, _ := backend.List()
+	slices.Sort(ips)
+	if want := []string{"192.0.2.1", "192.0.2.2", "198.51.100.1"}; !slices.Equal(ips, want) {
+		t.Errorf("backend = %v, want %v", ips, want)
+	}
+	if len(store.blocked) != 0 {
+		t.Errorf("flagged %v as blocked, want the database left alone", store.blocked)
+	}
+}
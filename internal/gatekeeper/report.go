@@ -0,0 +1,235 @@
+package gatekeeper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/abuseip"
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// reportUsage is the usage counter of the AbuseIPDB reports
+const reportUsage = "abuseipdb_report"
+
+// webAttackPatterns are fragments of paths and payloads that target web
+// applications rather than merely probe for a server
+var webAttackPatterns = []string{
+	"../", "..%2f", "/etc/passwd", "/bin/sh", "cmd.exe", "<script", "union select",
+	"${jndi:", "base64_decode", "eval(", "phpunit", "/cgi-bin/", "/.env", "/.git/",
+	"/vendor/", "/boaform/", "/shell", "wget ", "curl ", "chmod ", ";sh", "|sh",
+	"phpmyadmin", "/actuator/", "/solr/", "allow_url_include", "/hnap1",
+}
+
+// bruteForcePatterns are fragments of login endpoints
+var bruteForcePatterns = []string{
+	"/wp-login.php", "/xmlrpc.php", "/login", "/signin", "/logon", "/auth",
+	"/administrator", "/admin", "/manager/html", "/owa/", "/remote/login",
+}
+
+// reportData contains the data available to the report comment template
+type reportData struct {
+	IP         string
+	Listener   string
+	Method     string
+	Host       string
+	Path       string
+	UserAgent  string
+	Score      int
+	Country    string
+	City       string
+	ASN        uint
+	ASOrg      string
+	Categories string
+}
+
+// reporter reports the detected IPs to AbuseIPDB. Each IP is reported at
+// most once per cooldown, and the daily limit is counted in the database
+// so that it holds across restarts and instances.
+type reporter struct {
+	config   config.AbuseIPReportConfig
+	client   *abuseip.Client
+	template *template.Template
+	db       database.Store
+
+	mu        sync.Mutex
+	reported  map[string]time.Time
+	pending   map[string]struct{}
+	lastPrune time.Time
+}
+
+func newReporter(cfg config.AbuseIPReportConfig, db database.Store) (*reporter, error) {
+	client, err := abuseip.NewClient(cfg.APIKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	client.SetTimeout(cfg.Timeout)
+	if cfg.URL != "" {
+		client.SetURL(cfg.URL)
+	}
+
+	tmpl, err := template.New("report").Parse(cfg.Template)
+	if err != nil {
+		return nil, fmt.Errorf("abuseip.report: invalid template: %w", err)
+	}
+
+	return &reporter{
+		config:   cfg,
+		client:   client,
+		template: tmpl,
+		db:       db,
+		reported: make(map[string]time.Time),
+		pending:  make(map[string]struct{}),
+	}, nil
+}
+
+// report sends a report for a hit, unless the IP is whitelisted or
+// allowed by a policy rule, scores too low, is in cooldown or the daily limit is reached
+func (r *reporter) report(h *hit, info *domain.IPInfo) {
+	if info.IsWhitelisted() || info.Verdict.AlwaysAllow || int(info.Score) < *r.config.MinScore {
+		return
+	}
+
+	now := time.Now()
+	if !r.claim(h.ip, now) {
+		return
+	}
+	reported := false
+	defer func() { r.release(h.ip, reported) }()
+
+	// Reports refused by the API during a backoff must not be counted
+	if err := r.client.CanReport(); err != nil {
+		log.Printf("Not reporting IP %s to AbuseIPDB: %v", h.ip, err)
+		return
+	}
+
+	ok, err := r.db.ConsumeUsage(reportUsage, now, r.config.DailyLimit)
+	if err != nil {
+		log.Printf("Failed to count AbuseIPDB report of IP %s: %v", h.ip, err)
+		return
+	}
+	if !ok {
+		log.Printf("AbuseIPDB daily report limit reached, not reporting IP %s", h.ip)
+		return
+	}
+
+	categories := reportCategories(h)
+	comment, err := r.comment(h, info, categories)
+	if err != nil {
+		log.Printf("Failed to build AbuseIPDB report of IP %s: %v", h.ip, err)
+		return
+	}
+
+	err = r.client.Report(h.ip, categories, comment)
+	switch {
+	case err == nil:
+		reported = true
+		log.Printf("Reported IP %s to AbuseIPDB (categories: %s)", h.ip, strings.Join(abuseip.CategoryNames(categories), ", "))
+	case errors.Is(err, abuseip.ErrReportCooldown):
+		reported = true
+		log.Printf("IP %s was already reported to AbuseIPDB in the last 15 minutes", h.ip)
+	default:
+		log.Printf("Failed to report IP %s to AbuseIPDB: %v", h.ip, err)
+	}
+}
+
+// claim reserves an IP while its report is being sent. It returns false
+// if the IP is in cooldown or already being reported.
+func (r *reporter) claim(ip string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastPrune) > r.config.Cooldown {
+		for addr, at := range r.reported {
+			if now.Sub(at) >= r.config.Cooldown {
+				delete(r.reported, addr)
+			}
+		}
+		r.lastPrune = now
+	}
+
+	if at, ok := r.reported[ip]; ok && now.Sub(at) < r.config.Cooldown {
+		return false
+	}
+	if _, ok := r.pending[ip]; ok {
+		return false
+	}
+
+	r.pending[ip] = struct{}{}
+	return true
+}
+
+// release ends the reservation of an IP. The cooldown only starts once
+// the IP was reported: a report refused or failed can be retried on the
+// next hit.
+func (r *reporter) release(ip string, reported bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.pending, ip)
+	if reported {
+		r.reported[ip] = time.Now()
+	}
+}
+
+// comment renders the report comment, stripped of sensitive data
+func (r *reporter) comment(h *hit, info *domain.IPInfo, categories []int) (string, error) {
+	data := reportData{
+		IP:         h.ip,
+		Listener:   h.listener.Name,
+		Method:     h.method,
+		Host:       h.host,
+		Path:       h.path,
+		UserAgent:  h.userAgent,
+		Score:      int(info.Score),
+		Country:    info.Country,
+		City:       info.City,
+		ASN:        info.ASN,
+		ASOrg:      info.ASOrg,
+		Categories: strings.Join(abuseip.CategoryNames(categories), ", "),
+	}
+
+	var buf bytes.Buffer
+	if err := r.template.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return abuseip.SanitizeComment(buf.String()), nil
+}
+
+// reportCategories derives the AbuseIPDB categories from the path and
+// payload of a hit. Reaching a detection listener is a port scan in
+// itself.
+func reportCategories(h *hit) []int {
+	categories := []int{abuseip.CategoryPortScan}
+
+	target := strings.ToLower(h.path + "\n" + string(h.body))
+	if containsAny(target, webAttackPatterns) {
+		categories = append(categories, abuseip.CategoryWebAppAttack)
+	}
+
+	switch {
+	case bytes.HasPrefix(h.body, []byte("SSH-")):
+		categories = append(categories, abuseip.CategoryBruteForce, abuseip.CategorySSH)
+	case containsAny(strings.ToLower(h.path), bruteForcePatterns):
+		categories = append(categories, abuseip.CategoryBruteForce)
+	}
+
+	return categories
+}
+
+func containsAny(s string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.Contains(s, pattern) {
+			return true
+		}
+	}
+	return false
+}
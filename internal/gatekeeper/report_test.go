@@ -0,0 +1,116 @@
+package gatekeeper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// fakeReportAPI counts the reports received and answers with status
+type fakeReportAPI struct {
+	mu      sync.Mutex
+	status  int
+	reports int
+}
+
+func (f *fakeReportAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reports++
+	w.WriteHeader(f.status)
+	w.Write([]byte(`{"data":{}}`))
+}
+
+func (f *fakeReportAPI) setStatus(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.status = status
+}
+
+func (f *fakeReportAPI) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.reports
+}
+
+func newTestReporter(t *testing.T, minScore *int) (*reporter, *fakeReportAPI) {
+	t.Helper()
+
+	api := &fakeReportAPI{status: http.StatusOK}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	if minScore == nil {
+		defaultMinScore := config.DefaultReportMinScore
+		minScore = &defaultMinScore
+	}
+	cfg := config.AbuseIPReportConfig{
+		Enabled:    true,
+		APIKey:     "key",
+		URL:        srv.URL,
+		Template:   config.DefaultReportTemplate,
+		MinScore:   minScore,
+		DailyLimit: config.DefaultReportDailyLimit,
+		Cooldown:   config.DefaultReportCooldown,
+		Timeout:    time.Second,
+	}
+
+	r, err := newReporter(cfg, database.NewMemoryStore(time.Hour))
+	if err != nil {
+		t.Fatalf("newReporter() error = %v", err)
+	}
+	return r, api
+}
+
+func reportHit(ip string) *hit {
+	return &hit{ip: ip, method: "GET", path: "/admin", listener: &config.ListenerConfig{Name: "http"}}
+}
+
+func TestReportCooldownStartsOnSuccess(t *testing.T) {
+	r, api := newTestReporter(t, nil)
+	info := &domain.IPInfo{Address: "192.0.2.1", Score: 80}
+
+	// Failed reports are retried on the next hit
+	api.setStatus(http.StatusInternalServerError)
+	r.report(reportHit("192.0.2.1"), info)
+	r.report(reportHit("192.0.2.1"), info)
+	if n := api.count(); n != 2 {
+		t.Fatalf("reports sent = %d, want a retry after the failure", n)
+	}
+
+	api.setStatus(http.StatusOK)
+	r.report(reportHit("192.0.2.1"), info)
+	r.report(reportHit("192.0.2.1"), info)
+	if n := api.count(); n != 3 {
+		t.Errorf("reports sent = %d, want none during the cooldown", n)
+	}
+}
+
+func TestReportMinScore(t *testing.T) {
+	r, api := newTestReporter(t, nil)
+
+	r.report(reportHit("192.0.2.1"), &domain.IPInfo{Address: "192.0.2.1", Score: config.DefaultReportMinScore - 1})
+	if n := api.count(); n != 0 {
+		t.Errorf("reports sent = %d, want IPs below the default min_score skipped", n)
+	}
+	r.report(reportHit("192.0.2.2"), &domain.IPInfo{Address: "192.0.2.2", Score: config.DefaultReportMinScore})
+	if n := api.count(); n != 1 {
+		t.Errorf("reports sent = %d, want IPs at min_score reported", n)
+	}
+
+	zero := 0
+	r, api = newTestReporter(t, &zero)
+	r.report(reportHit("192.0.2.1"), &domain.IPInfo{Address: "192.0.2.1"})
+	if n := api.count(); n != 1 {
+		t.Errorf("reports sent = %d, want every IP reported with min_score 0", n)
+	}
+}
@@ -0,0 +1,138 @@
+package gatekeeper
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/database"
+)
+
+const (
+	// OrphanGracePeriod protects payload files written just before their
+	// record is saved from being taken for orphans
+	OrphanGracePeriod = 10 * time.Minute
+)
+
+// retentionLoop periodically deletes expired records, events and payloads
+func (g *GateKeeper) retentionLoop() {
+	ticker := time.NewTicker(g.config.Retention.Interval)
+	defer ticker.Stop()
+
+	for {
+		g.applyRetention()
+		<-ticker.C
+	}
+}
+
+func (g *GateKeeper) applyRetention() {
+	retention := g.config.Retention
+	now := time.Now()
+	var run database.RetentionStats
+
+	records, err := g.db.PruneRecords(now.Add(-retention.Records))
+	if err != nil {
+		log.Printf("Retention error: %v", err)
+	}
+	run.RecordsDeleted = records
+
+	events, err := g.db.PruneEvents(now.Add(-retention.Events))
+	if err != nil {
+		log.Printf("Retention error: %v", err)
+	}
+	run.EventsDeleted = events
+
+	g.prunePayloads(now, &run)
+
+	if run.RecordsDeleted+run.EventsDeleted+run.PayloadsExpired+run.PayloadsOrphaned > 0 {
+		log.Printf("Retention: deleted %d record(s), %d event(s), %d expired and %d orphaned payload(s)",
+			run.RecordsDeleted, run.EventsDeleted, run.PayloadsExpired, run.PayloadsOrphaned)
+	}
+
+	g.retentionMu.Lock()
+	defer g.retentionMu.Unlock()
+
+	g.retention.LastRun = now
+	g.retention.RecordsDeleted += run.RecordsDeleted
+	g.retention.EventsDeleted += run.EventsDeleted
+	g.retention.PayloadsExpired += run.PayloadsExpired
+	g.retention.PayloadsOrphaned += run.PayloadsOrphaned
+	g.retention.PayloadBytesFreed += run.PayloadBytesFreed
+}
+
+// prunePayloads deletes the payload files older than the retention period
+// and those no record refers to anymore
+func (g *GateKeeper) prunePayloads(now time.Time, run *database.RetentionStats) {
+	entries, err := os.ReadDir(g.config.Payload.Directory)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Retention error: failed to read payload directory: %v", err)
+		}
+		return
+	}
+
+	paths, err := g.db.GetPayloadPaths()
+	if err != nil {
+		// Without the references every payload would look orphaned
+		log.Printf("Retention error: %v", err)
+		return
+	}
+
+	// Records may refer to a file by another path than the directory entry
+	referenced := make(map[string][]string, len(paths))
+	for _, path := range paths {
+		name := filepath.Base(path)
+		referenced[name] = append(referenced[name], path)
+	}
+
+	for _, entry := range entries {
+		// Only touch files written by savePayload
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), ".bin") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		age := now.Sub(info.ModTime())
+		references, isReferenced := referenced[entry.Name()]
+
+		expired := age > g.config.Retention.Payloads
+		orphaned := !isReferenced && age > OrphanGracePeriod
+		if !expired && !orphaned {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(g.config.Payload.Directory, entry.Name())); err != nil {
+			log.Printf("Retention error: failed to delete payload: %v", err)
+			continue
+		}
+
+		for _, path := range references {
+			if err := g.db.ClearPayloadPath(path); err != nil {
+				log.Printf("Retention error: %v", err)
+			}
+		}
+
+		run.PayloadBytesFreed += info.Size()
+		if expired {
+			run.PayloadsExpired++
+		} else {
+			run.PayloadsOrphaned++
+		}
+	}
+}
+
+// RetentionStats returns what the retention job removed since startup
+func (g *GateKeeper) RetentionStats() database.RetentionStats {
+	g.retentionMu.Lock()
+	defer g.retentionMu.Unlock()
+
+	return g.retention
+}
@@ -0,0 +1,60 @@
+package gatekeeper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/database"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+func TestRetentionClearsExpiredPayloadPaths(t *testing.T) {
+	dir := t.TempDir()
+	expired := filepath.Join(dir, "expired.bin")
+	recent := filepath.Join(dir, "recent.bin")
+	for _, path := range []string{expired, recent} {
+		if err := os.WriteFile(path, []byte("GET / HTTP/1.1"), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(expired, old, old); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+
+	db := database.NewMemoryStore(time.Hour)
+	for ip, path := range map[string]string{"192.0.2.1": expired, "192.0.2.2": recent} {
+		if err := db.Set(&domain.IPInfo{Address: ip, Score: 80, PayloadPath: path}); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	g := &GateKeeper{
+		config: &config.Configuration{
+			Payload: config.PayloadConfig{Directory: dir},
+			Retention: config.RetentionConfig{
+				Records:  30 * 24 * time.Hour,
+				Events:   30 * 24 * time.Hour,
+				Payloads: 24 * time.Hour,
+			},
+		},
+		db: db,
+	}
+	g.applyRetention()
+
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("expired payload kept: %v", err)
+	}
+	if info, ok := db.GetStale("192.0.2.1"); !ok || info.PayloadPath != "" {
+		t.Errorf("record of the expired payload = %+v, want it kept without payload", info)
+	}
+	if info, ok := db.GetStale("192.0.2.2"); !ok || info.PayloadPath != recent {
+		t.Errorf("record of the recent payload = %+v, want its payload kept", info)
+	}
+	if stats := g.RetentionStats(); stats.PayloadsExpired != 1 {
+		t.Errorf("PayloadsExpired = %d, want 1", stats.PayloadsExpired)
+	}
+}
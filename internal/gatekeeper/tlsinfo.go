@@ -0,0 +1,30 @@
+package gatekeeper
+
+import (
+	"log"
+
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/fingerprint"
+)
+
+// recordTLS stores the SNI and fingerprints of a ClientHello on the entry.
+// It reports whether the entry changed. The tls_fingerprints rules are
+// applied by the policy on every hit, never to the stored score.
+func (g *GateKeeper) recordTLS(info *domain.IPInfo, hello *fingerprint.ClientHello) bool {
+	if hello == nil {
+		return false
+	}
+
+	_, ja3 := hello.JA3()
+	ja4 := hello.JA4()
+	if info.JA3 == ja3 && info.JA4 == ja4 && info.TLSServerName == hello.ServerName {
+		return false
+	}
+
+	info.TLSServerName = hello.ServerName
+	info.JA3 = ja3
+	info.JA4 = ja4
+	log.Printf("TLS ClientHello from IP %s: SNI=%q, JA3=%s, JA4=%s", info.Address, hello.ServerName, ja3, ja4)
+
+	return true
+}
@@ -0,0 +1,53 @@
+package gatekeeper
+
+import (
+	"testing"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+	"github.com/TOomaAh/GateKeeper/internal/fingerprint"
+	"github.com/TOomaAh/GateKeeper/internal/policy"
+)
+
+func TestFingerprintRulesNeverStored(t *testing.T) {
+	hello := func(sni string) *fingerprint.ClientHello {
+		return &fingerprint.ClientHello{
+			Version:      0x0303,
+			CipherSuites: []uint16{0x1301, 0x1302},
+			Extensions:   []uint16{0x0000, 0x002b},
+			ServerName:   sni,
+		}
+	}
+	ja4 := hello("").JA4()
+
+	for _, tt := range []struct {
+		name  string
+		score int
+		want  domain.IPScore
+	}{
+		{name: "raising rule", score: 20, want: 70},
+		{name: "lowering rule", score: -20, want: 30},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateKeeper(t)
+			fingerprints := []config.TLSFingerprint{{Name: "client", JA4: ja4, Score: tt.score}}
+			g.policy = policy.New(config.PolicyConfig{}, fingerprints)
+			if err := g.db.Set(&domain.IPInfo{Address: "192.0.2.1", Score: 50, Country: "FR"}); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			// A client rotating the SNI gets the same adjustment on every hit
+			for _, sni := range []string{"a.example", "b.example", "c.example", "d.example"} {
+				h := &hit{ip: "192.0.2.1", path: "/", listener: &config.ListenerConfig{Name: "https"}, tls: hello(sni)}
+				if info := g.getOrCreateIPInfo(h); info.Score != tt.want {
+					t.Fatalf("score with SNI %s = %d, want %d", sni, info.Score, tt.want)
+				}
+			}
+
+			stored, _ := g.db.Get("192.0.2.1")
+			if stored.Score != 50 || stored.TLSServerName != "d.example" || stored.JA4 != ja4 {
+				t.Errorf("stored entry = score %d, SNI %q, JA4 %q, want the reputation score and the last ClientHello", stored.Score, stored.TLSServerName, stored.JA4)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package geoip
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+// DefaultReloadInterval is how often the databases are checked for changes
+const DefaultReloadInterval = 1 * time.Minute
+
+// Location is what the databases know about an IP
+type Location struct {
+	Country string
+	City    string
+	ASN     uint
+	ASOrg   string
+}
+
+// Resolver locates IPs from local MaxMind DB databases, such as the
+// GeoLite2 and DB-IP Country, City and ASN ones. Each field is taken
+// from the first database providing it. Databases replaced on disk are
+// reloaded; if a reload fails the previous version is kept.
+type Resolver struct {
+	databases []*database
+}
+
+// database is a database file and its current contents
+type database struct {
+	path    string
+	db      atomic.Pointer[mmdb]
+	modTime time.Time
+	size    int64
+}
+
+// NewResolver loads the configured databases. It returns nil if none is
+// configured.
+func NewResolver(cfg config.GeoIPConfig) (*Resolver, error) {
+	if len(cfg.Databases) == 0 {
+		return nil, nil
+	}
+
+	r := &Resolver{}
+	for _, path := range cfg.Databases {
+		d := &database{path: path}
+		if err := d.reload(); err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded GeoIP database %s (%s)", path, d.db.Load().databaseType)
+		r.databases = append(r.databases, d)
+	}
+
+	interval := cfg.ReloadInterval
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+	go r.watchLoop(interval)
+
+	return r, nil
+}
+
+// Lookup locates an IP. Fields unknown to every database are left empty.
+func (r *Resolver) Lookup(ip string) Location {
+	var loc Location
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return loc
+	}
+
+	for _, d := range r.databases {
+		record, err := d.db.Load().lookup(addr)
+		if err != nil {
+			log.Printf("GeoIP lookup of %s in %s failed: %v", ip, d.path, err)
+			continue
+		}
+		if record == nil {
+			continue
+		}
+
+		if loc.Country == "" {
+			loc.Country = field(record, "country", "iso_code")
+		}
+		if loc.Country == "" {
+			loc.Country = field(record, "registered_country", "iso_code")
+		}
+		if loc.City == "" {
+			loc.City = field(record, "city", "names", "en")
+		}
+		if loc.ASN == 0 {
+			loc.ASN = uint(toUint(record["autonomous_system_number"]))
+		}
+		if loc.ASOrg == "" {
+			loc.ASOrg = toString(record["autonomous_system_organization"])
+		}
+	}
+
+	return loc
+}
+
+// field returns the string at a path of nested maps
+func field(record map[string]any, path ...string) string {
+	for _, key := range path[:len(path)-1] {
+		next, ok := record[key].(map[string]any)
+		if !ok {
+			return ""
+		}
+		record = next
+	}
+	return toString(record[path[len(path)-1]])
+}
+
+func (r *Resolver) watchLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, d := range r.databases {
+			info, err := os.Stat(d.path)
+			if err != nil {
+				log.Printf("Failed to stat %s: %v", d.path, err)
+				continue
+			}
+
+			if info.ModTime().Equal(d.modTime) && info.Size() == d.size {
+				continue
+			}
+
+			if err := d.reload(); err != nil {
+				log.Printf("Failed to reload GeoIP database, keeping previous version: %v", err)
+				continue
+			}
+			log.Printf("Reloaded GeoIP database %s", d.path)
+		}
+	}
+}
+
+// reload reads the whole file, so that the database in use is not
+// affected when the file is replaced
+func (d *database) reload() error {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return fmt.Errorf("geoip: cannot stat %s: %w", d.path, err)
+	}
+
+	buf, err := os.ReadFile(d.path)
+	if err != nil {
+		return fmt.Errorf("geoip: cannot read %s: %w", d.path, err)
+	}
+
+	db, err := parseMMDB(buf)
+	if err != nil {
+		return fmt.Errorf("geoip: %s: %w", d.path, err)
+	}
+
+	d.db.Store(db)
+	d.modTime = info.ModTime()
+	d.size = info.Size()
+
+	return nil
+}
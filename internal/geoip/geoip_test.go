@@ -0,0 +1,111 @@
+package geoip
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+// writeDB writes a database built by buildMMDB in dir
+func writeDB(t *testing.T, dir, name string, db testDB) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, buildMMDB(t, db), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestResolverLookup(t *testing.T) {
+	dir := t.TempDir()
+	city := writeDB(t, dir, "city.mmdb", testDB{ipVersion: 6, recordSize: 28, databaseType: "Test-City", records: map[string]any{
+		"192.0.2.0/24": cityRecord("FR", "Paris"),
+		"198.51.100.0/24": map[string]any{
+			"registered_country": map[string]any{"iso_code": "DE"},
+		},
+	}})
+	asn := writeDB(t, dir, "asn.mmdb", testDB{ipVersion: 6, recordSize: 24, databaseType: "Test-ASN", records: map[string]any{
+		"192.0.2.0/23": map[string]any{
+			"autonomous_system_number":       uint64(64496),
+			"autonomous_system_organization": "Example Networks",
+		},
+	}})
+
+	r, err := NewResolver(config.GeoIPConfig{Databases: []string{city, asn}, ReloadInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want Location
+	}{
+		{ip: "192.0.2.1", want: Location{Country: "FR", City: "Paris", ASN: 64496, ASOrg: "Example Networks"}},
+		{ip: "192.0.3.1", want: Location{ASN: 64496, ASOrg: "Example Networks"}},
+		{ip: "198.51.100.1", want: Location{Country: "DE"}},
+		{ip: "203.0.113.1"},
+		{ip: "not an ip"},
+	}
+	for _, tt := range tests {
+		if got := r.Lookup(tt.ip); got != tt.want {
+			t.Errorf("Lookup(%s) = %+v, want %+v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestResolverReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeDB(t, dir, "city.mmdb", testDB{ipVersion: 4, recordSize: 24, databaseType: "Test-City", records: map[string]any{
+		"192.0.2.0/24": cityRecord("FR", "Paris"),
+	}})
+
+	r, err := NewResolver(config.GeoIPConfig{Databases: []string{path}, ReloadInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+	d := r.databases[0]
+
+	// A corrupt file keeps the previous version
+	if err := os.WriteFile(path, []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := d.reload(); err == nil {
+		t.Error("reload() succeeded with a corrupt file")
+	}
+	if got := r.Lookup("192.0.2.1"); got.Country != "FR" {
+		t.Errorf("Lookup() after a failed reload = %+v, want the previous version", got)
+	}
+
+	writeDB(t, dir, "city.mmdb", testDB{ipVersion: 4, recordSize: 24, databaseType: "Test-City", records: map[string]any{
+		"192.0.2.0/24": cityRecord("DE", "Berlin"),
+	}})
+	if err := d.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if got := r.Lookup("192.0.2.1"); got.Country != "DE" {
+		t.Errorf("Lookup() after a reload = %+v, want the new version", got)
+	}
+}
+
+func TestNewResolverErrors(t *testing.T) {
+	if r, err := NewResolver(config.GeoIPConfig{}); r != nil || err != nil {
+		t.Errorf("NewResolver() without database = %v, %v, want nil, nil", r, err)
+	}
+
+	dir := t.TempDir()
+	if _, err := NewResolver(config.GeoIPConfig{Databases: []string{filepath.Join(dir, "missing.mmdb")}}); err == nil {
+		t.Error("NewResolver() succeeded with a missing database")
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.mmdb")
+	if err := os.WriteFile(corrupt, []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := NewResolver(config.GeoIPConfig{Databases: []string{corrupt}}); err == nil {
+		t.Error("NewResolver() succeeded with a corrupt database")
+	}
+}
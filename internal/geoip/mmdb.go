@@ -0,0 +1,390 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+)
+
+// metadataMarker precedes the metadata at the end of a MaxMind DB file
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSectionSeparator is the size of the zeros between the search tree
+// and the data section
+const dataSectionSeparator = 16
+
+// maxMetadataSize is how far from the end of the file the metadata is
+// looked for
+const maxMetadataSize = 128 * 1024
+
+// ErrInvalidDatabase is returned for files that are not valid MaxMind DB
+// databases
+var ErrInvalidDatabase = errors.New("geoip: invalid database")
+
+// Data types of the MaxMind DB format
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// mmdb is a MaxMind DB database loaded in memory, as written by MaxMind,
+// DB-IP and IPinfo. See https://maxmind.github.io/MaxMind-DB/.
+type mmdb struct {
+	buf          []byte
+	data         []byte
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	ipv4Start    uint
+}
+
+// parseMMDB reads the metadata and checks the layout of a database
+func parseMMDB(buf []byte) (*mmdb, error) {
+	start := max(0, len(buf)-maxMetadataSize)
+	idx := bytes.LastIndex(buf[start:], metadataMarker)
+	if idx < 0 {
+		return nil, fmt.Errorf("%w: metadata not found", ErrInvalidDatabase)
+	}
+	metaStart := start + idx + len(metadataMarker)
+
+	d := decoder{buf: buf[metaStart:]}
+	value, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidDatabase, err)
+	}
+	meta, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	db := &mmdb{
+		buf:          buf,
+		nodeCount:    uint(toUint(meta["node_count"])),
+		recordSize:   uint(toUint(meta["record_size"])),
+		ipVersion:    uint(toUint(meta["ip_version"])),
+		databaseType: toString(meta["database_type"]),
+	}
+
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("%w: unsupported IP version %d", ErrInvalidDatabase, db.ipVersion)
+	}
+
+	// Checked before computing the tree size, which a corrupt node count
+	// would overflow
+	if db.nodeCount > uint(start+idx)*4/db.recordSize {
+		return nil, fmt.Errorf("%w: search tree larger than the file", ErrInvalidDatabase)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(start+idx) {
+		return nil, fmt.Errorf("%w: search tree larger than the file", ErrInvalidDatabase)
+	}
+	db.data = buf[treeSize+dataSectionSeparator : start+idx]
+
+	// IPv4 addresses live under ::/96 in IPv6 databases
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			if node, err = db.record(node, 0); err != nil {
+				return nil, err
+			}
+		}
+		db.ipv4Start = node
+	}
+
+	return db, nil
+}
+
+// lookup returns the record of an address, or nil if it is not listed
+func (db *mmdb) lookup(addr netip.Addr) (map[string]any, error) {
+	addr = addr.Unmap()
+
+	node := uint(0)
+	if addr.Is4() && db.ipVersion == 6 {
+		node = db.ipv4Start
+	} else if addr.Is6() && db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	ip := addr.AsSlice()
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-i%8)) & 1
+		var err error
+		if node, err = db.record(node, bit); err != nil {
+			return nil, err
+		}
+	}
+
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, fmt.Errorf("%w: search tree too deep", ErrInvalidDatabase)
+	}
+
+	offset := node - db.nodeCount - dataSectionSeparator
+	if offset >= uint(len(db.data)) {
+		return nil, fmt.Errorf("%w: record outside of the data section", ErrInvalidDatabase)
+	}
+
+	d := decoder{buf: db.data}
+	value, _, err := d.decode(offset)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]any)
+	return record, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node
+func (db *mmdb) record(node, bit uint) (uint, error) {
+	nodeSize := db.recordSize / 4
+	if node >= db.nodeCount || (node+1)*nodeSize > uint(len(db.buf)) {
+		return 0, fmt.Errorf("%w: node %d outside of the search tree", ErrInvalidDatabase, node)
+	}
+	b := db.buf[node*nodeSize : (node+1)*nodeSize]
+
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:])), nil
+	}
+}
+
+// decoder decodes the data section of a database
+type decoder struct {
+	buf    []byte
+	values int
+}
+
+const (
+	// maxDepth bounds the nesting of maps and arrays of corrupted databases
+	maxDepth = 32
+	// maxValues bounds the values decoded at once, since pointers let a
+	// small corrupted database expand into a huge record
+	maxValues = 1 << 16
+)
+
+// decode decodes the value at offset and returns the offset following it
+func (d *decoder) decode(offset uint) (any, uint, error) {
+	return d.decodeDepth(offset, 0)
+}
+
+func (d *decoder) decodeDepth(offset uint, depth int) (any, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, fmt.Errorf("%w: data nested too deeply", ErrInvalidDatabase)
+	}
+	d.values++
+	if d.values > maxValues {
+		return nil, 0, fmt.Errorf("%w: too many values", ErrInvalidDatabase)
+	}
+
+	kind, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if kind == typePointer {
+		pointer, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decodeDepth(pointer, depth+1)
+		return value, next, err
+	}
+
+	// Each entry takes at least one byte per key and value, which bounds
+	// the sizes read from corrupted databases
+	remaining := uint(len(d.buf)) - offset
+	switch kind {
+	case typeMap:
+		if size > remaining/2 {
+			return nil, 0, fmt.Errorf("%w: map of %d entries past the end of the data", ErrInvalidDatabase, size)
+		}
+		m := make(map[string]any, size)
+		for range size {
+			var key, value any
+			key, offset, err = d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, offset, err = d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[toString(key)] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		if size > remaining {
+			return nil, 0, fmt.Errorf("%w: array of %d values past the end of the data", ErrInvalidDatabase, size)
+		}
+		a := make([]any, 0, size)
+		for range size {
+			var value any
+			value, offset, err = d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, fmt.Errorf("%w: value past the end of the data", ErrInvalidDatabase)
+	}
+	b := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch kind {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes, typeUint128:
+		return bytes.Clone(b), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w: invalid double size %d", ErrInvalidDatabase, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w: invalid float size %d", ErrInvalidDatabase, size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("%w: invalid integer size %d", ErrInvalidDatabase, size)
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("%w: invalid integer size %d", ErrInvalidDatabase, size)
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), next, nil
+	default:
+		return nil, 0, fmt.Errorf("%w: unsupported data type %d", ErrInvalidDatabase, kind)
+	}
+}
+
+// control reads the control byte of a value: its type and size
+func (d *decoder) control(offset uint) (int, uint, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, fmt.Errorf("%w: value past the end of the data", ErrInvalidDatabase)
+	}
+	ctrl := d.buf[offset]
+	offset++
+
+	kind := int(ctrl >> 5)
+	if kind == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, fmt.Errorf("%w: value past the end of the data", ErrInvalidDatabase)
+		}
+		kind = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	// The size bits of pointers are decoded by pointer
+	if kind == typePointer {
+		return kind, uint(ctrl & 0x1F), offset, nil
+	}
+
+	size := uint(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return 0, 0, 0, fmt.Errorf("%w: value past the end of the data", ErrInvalidDatabase)
+		}
+		var v uint
+		for _, c := range d.buf[offset : offset+n] {
+			v = v<<8 | uint(c)
+		}
+		offset += n
+
+		switch size {
+		case 29:
+			size = 29 + v
+		case 30:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+	}
+
+	return kind, size, offset, nil
+}
+
+// pointer decodes a pointer from the size bits of its control byte and
+// the bytes following it
+func (d *decoder) pointer(bits, offset uint) (uint, uint, error) {
+	n := (bits>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, fmt.Errorf("%w: pointer past the end of the data", ErrInvalidDatabase)
+	}
+
+	var v uint
+	if n < 4 {
+		v = bits & 0x7
+	}
+	for _, c := range d.buf[offset : offset+n] {
+		v = v<<8 | uint(c)
+	}
+
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+
+	return v, offset + n, nil
+}
+
+func toUint(v any) uint64 {
+	n, _ := v.(uint64)
+	return n
+}
+
+func toString(v any) string {
+	s, _ := v.(string)
+	return s
+}
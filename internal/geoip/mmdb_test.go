@@ -0,0 +1,512 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testDB describes a database built by buildMMDB
+type testDB struct {
+	ipVersion    uint
+	recordSize   uint
+	databaseType string
+	// records maps prefixes to their data. IPv4 prefixes go under ::/96
+	// in IPv6 databases. Prefixes must not overlap.
+	records map[string]any
+}
+
+// treeNode is a node of the search tree being built
+type treeNode struct {
+	children [2]*treeNode
+	data     []byte
+}
+
+// buildMMDB writes a database in the MaxMind DB format
+func buildMMDB(t testing.TB, db testDB) []byte {
+	t.Helper()
+
+	root := &treeNode{}
+	prefixes := make([]string, 0, len(db.records))
+	for prefix := range db.records {
+		prefixes = append(prefixes, prefix)
+	}
+	slices.Sort(prefixes)
+
+	for _, s := range prefixes {
+		prefix := netip.MustParsePrefix(s)
+		ip := prefix.Addr().AsSlice()
+		bits := prefix.Bits()
+		if prefix.Addr().Is4() && db.ipVersion == 6 {
+			ip = append(make([]byte, 12), ip...)
+			bits += 96
+		}
+
+		node := root
+		for i := range bits {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &treeNode{}
+			}
+			node = node.children[bit]
+		}
+		node.data = encodeValue(db.records[s])
+	}
+
+	// Number the inner nodes breadth first and lay out the data section
+	var nodes []*treeNode
+	ids := make(map[*treeNode]uint)
+	for queue := []*treeNode{root}; len(queue) > 0; queue = queue[1:] {
+		node := queue[0]
+		ids[node] = uint(len(nodes))
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			if child != nil && child.data == nil {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	var data []byte
+	offsets := make(map[*treeNode]uint)
+	for _, node := range nodes {
+		for _, child := range node.children {
+			if child != nil && child.data != nil {
+				offsets[child] = uint(len(data))
+				data = append(data, child.data...)
+			}
+		}
+	}
+
+	nodeCount := uint(len(nodes))
+	var buf []byte
+	for _, node := range nodes {
+		var records [2]uint
+		for bit, child := range node.children {
+			switch {
+			case child == nil:
+				records[bit] = nodeCount
+			case child.data != nil:
+				records[bit] = nodeCount + dataSectionSeparator + offsets[child]
+			default:
+				records[bit] = ids[child]
+			}
+		}
+		buf = append(buf, encodeNode(db.recordSize, records)...)
+	}
+
+	buf = append(buf, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	buf = append(buf, encodeValue(map[string]any{
+		"node_count":                  uint64(nodeCount),
+		"record_size":                 uint64(db.recordSize),
+		"ip_version":                  uint64(db.ipVersion),
+		"database_type":               db.databaseType,
+		"binary_format_major_version": uint64(2),
+		"binary_format_minor_version": uint64(0),
+		"languages":                   []any{"en"},
+	})...)
+	return buf
+}
+
+func encodeNode(recordSize uint, records [2]uint) []byte {
+	left, right := records[0], records[1]
+	switch recordSize {
+	case 24:
+		return []byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)}
+	case 28:
+		return []byte{
+			byte(left >> 16), byte(left >> 8), byte(left),
+			byte(left>>20)&0xF0 | byte(right>>24)&0x0F,
+			byte(right >> 16), byte(right >> 8), byte(right),
+		}
+	default:
+		return binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, uint32(left)), uint32(right))
+	}
+}
+
+// encodeControl writes the control byte and size of a value
+func encodeControl(kind int, size int) []byte {
+	var ctrl []byte
+	switch {
+	case size < 29:
+		ctrl = []byte{byte(size)}
+	case size < 285:
+		ctrl = []byte{29, byte(size - 29)}
+	case size < 65821:
+		ctrl = []byte{30, byte((size - 285) >> 8), byte(size - 285)}
+	default:
+		size -= 65821
+		ctrl = []byte{31, byte(size >> 16), byte(size >> 8), byte(size)}
+	}
+
+	if kind <= typeMap {
+		ctrl[0] |= byte(kind) << 5
+		return ctrl
+	}
+	return append([]byte{ctrl[0], byte(kind - 7)}, ctrl[1:]...)
+}
+
+// encodeValue encodes strings, unsigned integers, floats, booleans, maps
+// and arrays
+func encodeValue(v any) []byte {
+	switch v := v.(type) {
+	case string:
+		return append(encodeControl(typeString, len(v)), v...)
+	case uint64:
+		b := binary.BigEndian.AppendUint64(nil, v)
+		b = bytes.TrimLeft(b, "\x00")
+		kind := typeUint32
+		if len(b) > 4 {
+			kind = typeUint64
+		}
+		return append(encodeControl(kind, len(b)), b...)
+	case float64:
+		return binary.BigEndian.AppendUint64(encodeControl(typeDouble, 8), math.Float64bits(v))
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		return encodeControl(typeBool, size)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		b := encodeControl(typeMap, len(v))
+		for _, key := range keys {
+			b = append(b, encodeValue(key)...)
+			b = append(b, encodeValue(v[key])...)
+		}
+		return b
+	case []any:
+		b := encodeControl(typeArray, len(v))
+		for _, value := range v {
+			b = append(b, encodeValue(value)...)
+		}
+		return b
+	default:
+		panic("unsupported test value")
+	}
+}
+
+func cityRecord(country, city string) map[string]any {
+	return map[string]any{
+		"country": map[string]any{"iso_code": country},
+		"city":    map[string]any{"names": map[string]any{"en": city, "fr": city}},
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, ipVersion := range []uint{4, 6} {
+		for _, recordSize := range []uint{24, 28, 32} {
+			records := map[string]any{
+				"192.0.2.0/24":    cityRecord("FR", "Paris"),
+				"198.51.100.7/32": cityRecord("DE", "Berlin"),
+			}
+			if ipVersion == 6 {
+				records["2001:db8::/32"] = cityRecord("JP", "Tokyo")
+			}
+
+			db, err := parseMMDB(buildMMDB(t, testDB{ipVersion: ipVersion, recordSize: recordSize, databaseType: "Test-City", records: records}))
+			if err != nil {
+				t.Fatalf("IPv%d, %d bits: parseMMDB() error = %v", ipVersion, recordSize, err)
+			}
+			if db.databaseType != "Test-City" {
+				t.Errorf("databaseType = %q", db.databaseType)
+			}
+
+			tests := []struct {
+				ip      string
+				country string
+			}{
+				{ip: "192.0.2.1", country: "FR"},
+				{ip: "192.0.2.255", country: "FR"},
+				{ip: "::ffff:192.0.2.1", country: "FR"},
+				{ip: "198.51.100.7", country: "DE"},
+				{ip: "198.51.100.8"},
+				{ip: "203.0.113.1"},
+				{ip: "2001:db8::1", country: map[uint]string{4: "", 6: "JP"}[ipVersion]},
+				{ip: "2001:db9::1"},
+			}
+			for _, tt := range tests {
+				record, err := db.lookup(netip.MustParseAddr(tt.ip))
+				if err != nil {
+					t.Fatalf("IPv%d, %d bits: lookup(%s) error = %v", ipVersion, recordSize, tt.ip, err)
+				}
+				if got := field(record, "country", "iso_code"); got != tt.country {
+					t.Errorf("IPv%d, %d bits: lookup(%s) country = %q, want %q", ipVersion, recordSize, tt.ip, got, tt.country)
+				}
+			}
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	long := string(bytes.Repeat([]byte("a"), 70000))
+	tests := []struct {
+		name string
+		data []byte
+		want any
+	}{
+		{name: "string", data: encodeValue("Paris"), want: "Paris"},
+		{name: "29 bytes string", data: encodeValue(long[:29]), want: long[:29]},
+		{name: "300 bytes string", data: encodeValue(long[:300]), want: long[:300]},
+		{name: "70000 bytes string", data: encodeValue(long), want: long},
+		{name: "uint16", data: []byte{0xA2, 0x01, 0x02}, want: uint64(0x0102)},
+		{name: "uint32", data: encodeValue(uint64(13335)), want: uint64(13335)},
+		{name: "uint64", data: encodeValue(uint64(1) << 40), want: uint64(1) << 40},
+		{name: "empty uint32", data: []byte{0xC0}, want: uint64(0)},
+		{name: "int32", data: []byte{0x04, 0x01, 0xFF, 0xFF, 0xFF, 0xFE}, want: int64(-2)},
+		{name: "double", data: encodeValue(48.8566), want: 48.8566},
+		{name: "float", data: []byte{0x04, 0x08, 0x3F, 0xC0, 0x00, 0x00}, want: 1.5},
+		{name: "true", data: encodeValue(true), want: true},
+		{name: "false", data: encodeValue(false), want: false},
+		{name: "bytes", data: []byte{0x82, 0xDE, 0xAD}, want: []byte{0xDE, 0xAD}},
+		{name: "array", data: encodeValue([]any{"en", "fr"}), want: []any{"en", "fr"}},
+		{
+			name: "map",
+			data: encodeValue(map[string]any{"iso_code": "FR", "geoname_id": uint64(3017382)}),
+			want: map[string]any{"iso_code": "FR", "geoname_id": uint64(3017382)},
+		},
+		{
+			// Array of a string and a pointer to it
+			name: "pointer",
+			data: append(encodeControl(typeArray, 2), append(encodeValue("FR"), 0x20, 0x02)...),
+			want: []any{"FR", "FR"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := decoder{buf: tt.data}
+			got, next, err := d.decode(0)
+			if err != nil {
+				t.Fatalf("decode() error = %v", err)
+			}
+			if !equal(got, tt.want) {
+				t.Errorf("decode() = %#v, want %#v", got, tt.want)
+			}
+			if next != uint(len(tt.data)) {
+				t.Errorf("decode() next offset = %d, want %d", next, len(tt.data))
+			}
+		})
+	}
+}
+
+// equal compares decoded values
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			if !equal(value, b[key]) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		return ok && slices.EqualFunc(a, b, equal)
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	default:
+		return a == b
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	// A pointer to itself, and 4 levels of arrays of 16 pointers to the
+	// next level, which would expand into 16^4 strings
+	loop := []byte{0x20, 0x00}
+	var bomb []byte
+	for range 4 {
+		next := byte(len(bomb) + 2 + 16*2)
+		bomb = append(bomb, encodeControl(typeArray, 16)...)
+		for range 16 {
+			bomb = append(bomb, 0x20, next)
+		}
+	}
+	bomb = append(bomb, encodeValue("FR")...)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "string past the end", data: []byte{0x45, 'a', 'b'}},
+		{name: "size past the end", data: []byte{0x5F, 0xFF}},
+		{name: "map claiming 16M entries", data: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x41, 'a'}},
+		{name: "array claiming 16M values", data: []byte{0x1F, 0x04, 0xFF, 0xFF, 0xFF, 0x41, 'a'}},
+		{name: "map entry past the end", data: []byte{0xE1, 0x41, 'a'}},
+		{name: "pointer past the end", data: []byte{0x28}},
+		{name: "pointer outside of the data", data: []byte{0x27, 0xFF}},
+		{name: "pointer loop", data: loop},
+		{name: "pointer expansion", data: bomb},
+		{name: "invalid double", data: []byte{0x64, 0, 0, 0, 0}},
+		{name: "invalid float", data: []byte{0x02, 0x08, 0, 0}},
+		{name: "invalid uint64", data: []byte{0x09, 0x02, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{name: "invalid int32", data: []byte{0x05, 0x01, 1, 2, 3, 4, 5}},
+		{name: "container type", data: []byte{0x00, 0x05}},
+		{name: "unknown type", data: []byte{0x00, 0x20}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := decoder{buf: tt.data}
+			if value, _, err := d.decode(0); !errors.Is(err, ErrInvalidDatabase) {
+				t.Errorf("decode() = %v, %v, want ErrInvalidDatabase", value, err)
+			}
+		})
+	}
+}
+
+// withMetadata replaces the metadata of a database
+func withMetadata(buf []byte, meta map[string]any) []byte {
+	idx := bytes.LastIndex(buf, metadataMarker)
+	return append(append(slices.Clone(buf[:idx]), metadataMarker...), encodeValue(meta)...)
+}
+
+func TestParseMMDBCorrupt(t *testing.T) {
+	valid := buildMMDB(t, testDB{ipVersion: 6, recordSize: 28, databaseType: "Test-City", records: map[string]any{
+		"192.0.2.0/24": cityRecord("FR", "Paris"),
+	}})
+	meta := func(nodeCount, recordSize, ipVersion uint64) map[string]any {
+		return map[string]any{"node_count": nodeCount, "record_size": recordSize, "ip_version": ipVersion}
+	}
+	idx := bytes.LastIndex(valid, metadataMarker)
+
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{name: "empty", buf: nil},
+		{name: "no metadata", buf: valid[:idx]},
+		{name: "metadata not a map", buf: append(slices.Clone(valid[:idx+len(metadataMarker)]), encodeValue("metadata")...)},
+		{name: "truncated metadata", buf: valid[:len(valid)-3]},
+		{name: "record size", buf: withMetadata(valid, meta(100, 20, 6))},
+		{name: "IP version", buf: withMetadata(valid, meta(100, 28, 5))},
+		{name: "tree larger than the file", buf: withMetadata(valid, meta(1000, 28, 6))},
+		{name: "node count overflowing the tree size", buf: withMetadata(valid, meta(1<<62, 32, 6))},
+		{name: "truncated tree", buf: append(slices.Clone(valid[:100]), valid[idx:]...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseMMDB(tt.buf); !errors.Is(err, ErrInvalidDatabase) {
+				t.Errorf("parseMMDB() error = %v, want ErrInvalidDatabase", err)
+			}
+		})
+	}
+}
+
+func TestLookupCorrupt(t *testing.T) {
+	buf := buildMMDB(t, testDB{ipVersion: 4, recordSize: 24, databaseType: "Test-City", records: map[string]any{
+		"192.0.2.0/24": cityRecord("FR", "Paris"),
+	}})
+	db, err := parseMMDB(buf)
+	if err != nil {
+		t.Fatalf("parseMMDB() error = %v", err)
+	}
+
+	// Point the right record of the root past the data section
+	corrupt := *db
+	corrupt.buf = slices.Clone(buf)
+	copy(corrupt.buf[3:6], []byte{0xFF, 0xFF, 0xFF})
+	if _, err := corrupt.lookup(netip.MustParseAddr("192.0.2.1")); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("lookup() with a record past the data error = %v, want ErrInvalidDatabase", err)
+	}
+
+	// A node count larger than the tree left in the buffer
+	corrupt = *db
+	corrupt.nodeCount = 1 << 20
+	if _, err := corrupt.lookup(netip.MustParseAddr("192.0.2.1")); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("lookup() with a truncated tree error = %v, want ErrInvalidDatabase", err)
+	}
+}
+
+// seedDatabases returns the databases built for the tests and the MaxMind
+// DB files of testdata, such as MaxMind's test databases
+func seedDatabases(t testing.TB) [][]byte {
+	var seeds [][]byte
+	for _, ipVersion := range []uint{4, 6} {
+		for _, recordSize := range []uint{24, 28, 32} {
+			seeds = append(seeds, buildMMDB(t, testDB{ipVersion: ipVersion, recordSize: recordSize, databaseType: "Test-City", records: map[string]any{
+				"192.0.2.0/24": cityRecord("FR", "Paris"),
+				"198.51.100.7/32": map[string]any{
+					"autonomous_system_number":       uint64(64496),
+					"autonomous_system_organization": "Example",
+					"location":                       map[string]any{"latitude": 48.8566, "longitude": 2.3522},
+				},
+			}}))
+		}
+	}
+
+	paths, _ := filepath.Glob(filepath.Join("testdata", "*.mmdb"))
+	for _, path := range paths {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		seeds = append(seeds, buf)
+	}
+	return seeds
+}
+
+// fuzzAddrs are looked up in every database parsed by the fuzz target
+var fuzzAddrs = []netip.Addr{
+	netip.MustParseAddr("192.0.2.1"),
+	netip.MustParseAddr("198.51.100.7"),
+	netip.MustParseAddr("81.2.69.142"),
+	netip.MustParseAddr("1.128.0.0"),
+	netip.MustParseAddr("2001:db8::1"),
+	netip.MustParseAddr("::"),
+	netip.MustParseAddr("255.255.255.255"),
+}
+
+func FuzzParseMMDB(f *testing.F) {
+	for _, seed := range seedDatabases(f) {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		db, err := parseMMDB(buf)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidDatabase) {
+				t.Fatalf("parseMMDB() error = %v, want ErrInvalidDatabase", err)
+			}
+			return
+		}
+		for _, addr := range fuzzAddrs {
+			if _, err := db.lookup(addr); err != nil && !errors.Is(err, ErrInvalidDatabase) {
+				t.Fatalf("lookup(%s) error = %v, want ErrInvalidDatabase", addr, err)
+			}
+		}
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(encodeValue(cityRecord("FR", "Paris")))
+	f.Add(append(encodeControl(typeArray, 2), append(encodeValue("FR"), 0x20, 0x02)...))
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		d := decoder{buf: buf}
+		if _, _, err := d.decode(0); err != nil && !errors.Is(err, ErrInvalidDatabase) {
+			t.Fatalf("decode() error = %v, want ErrInvalidDatabase", err)
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package netfilter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/firewall"
+)
+
+func init() {
+	firewall.Register("ipset", func(cfg *config.FirewallConfig) (firewall.Blocker, error) {
+		return NewIPSet(cfg, ExecRunner{})
+	})
+}
+
+// IPSet blocks IPs by adding them to ipset sets, for hosts using iptables.
+// The sets must be referenced by an iptables rule, for example:
+//
+//	iptables -I INPUT -m set --match-set gatekeeper_v4 src -j DROP
+type IPSet struct {
+	runner  Runner
+	name    string
+	sets    sets
+	timeout time.Duration
+}
+
+// NewIPSet creates an ipset backend. When cfg.Set.Create is true the sets
+// are created if they do not exist.
+func NewIPSet(cfg *config.FirewallConfig, runner Runner) (*IPSet, error) {
+	s := &IPSet{
+		runner:  runner,
+		name:    cfg.Name,
+		sets:    sets{v4: cfg.Set.IPv4, v6: cfg.Set.IPv6},
+		timeout: cfg.Set.Timeout,
+	}
+
+	if s.sets.v4 == "" && s.sets.v6 == "" {
+		s.sets = sets{v4: DefaultSetV4, v6: DefaultSetV6}
+	}
+	if s.name == "" {
+		s.name = "ipset " + strings.Join(s.sets.names(), ",")
+	}
+
+	if cfg.Set.Create {
+		if err := s.create(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *IPSet) create() error {
+	families := map[string]string{s.sets.v4: "inet", s.sets.v6: "inet6"}
+	for _, set := range s.sets.names() {
+		// A default timeout of 0 keeps elements forever unless they are
+		// added with their own timeout
+		if _, err := s.runner.Run(nil, "ipset", "create", set, "hash:ip", "family", families[set], "timeout", "0", "-exist"); err != nil {
+			return fmt.Errorf("netfilter: failed to create ipset %s: %w", set, err)
+		}
+	}
+
+	log.Printf("ipset sets %s ready", strings.Join(s.sets.names(), ", "))
+	return nil
+}
+
+// Name returns the backend name
+func (s *IPSet) Name() string {
+	return s.name
+}
+
+// Block adds the IP to the set matching its family
+func (s *IPSet) Block(ip string) error {
+	return s.BlockFor(ip, s.timeout)
+}
+
+// BlockFor adds the IP to the set matching its family with the given
+// timeout, 0 meaning forever
+func (s *IPSet) BlockFor(ip string, timeout time.Duration) error {
+	set, addr, err := s.sets.forIP(ip)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"add", set, addr.String()}, timeoutArgs(timeout)...)
+	args = append(args, "-exist")
+	if _, err := s.runner.Run(nil, "ipset", args...); err != nil {
+		return fmt.Errorf("netfilter: failed to add %s to %s: %w", ip, set, err)
+	}
+
+	return nil
+}
+
+// Unblock removes the IP from the set matching its family
+func (s *IPSet) Unblock(ip string) error {
+	set, addr, err := s.sets.forIP(ip)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.runner.Run(nil, "ipset", "del", set, addr.String(), "-exist"); err != nil {
+		return fmt.Errorf("netfilter: failed to remove %s from %s: %w", ip, set, err)
+	}
+
+	return nil
+}
+
+// List returns the IPs of every configured set
+func (s *IPSet) List() ([]string, error) {
+	var ips []string
+	for _, set := range s.sets.names() {
+		out, err := s.runner.Run(nil, "ipset", "list", set)
+		if err != nil {
+			return nil, fmt.Errorf("netfilter: failed to list ipset %s: %w", set, err)
+		}
+		ips = append(ips, parseIPSetMembers(out)...)
+	}
+	return ips, nil
+}
+
+// Health checks that every configured set exists
+func (s *IPSet) Health() error {
+	for _, set := range s.sets.names() {
+		if _, err := s.runner.Run(nil, "ipset", "list", set, "-terse"); err != nil {
+			return fmt.Errorf("netfilter: ipset %s unavailable: %w", set, err)
+		}
+	}
+	return nil
+}
+
+// Timeout returns the timeout of the set entries
+func (s *IPSet) Timeout() time.Duration {
+	return s.timeout
+}
+
+// Restore replaces the contents of the sets with the given IPs. With a
+// timeout, IPs are added for the rest of their block and timed out ones
+// are skipped.
+func (s *IPSet) Restore(blocks map[string]time.Time) error {
+	var script strings.Builder
+	for _, set := range s.sets.names() {
+		fmt.Fprintf(&script, "flush %s\n", set)
+	}
+
+	for _, ip := range slices.Sorted(maps.Keys(blocks)) {
+		set, addr, err := s.sets.forIP(ip)
+		if err != nil {
+			log.Printf("Skipping %s while restoring ipset: %v", ip, err)
+			continue
+		}
+
+		timeout := s.timeout
+		if timeout > 0 {
+			if timeout = firewall.Remaining(timeout, blocks[ip]); timeout <= 0 {
+				continue
+			}
+		}
+		line := append([]string{"add", set, addr.String()}, timeoutArgs(timeout)...)
+		script.WriteString(strings.Join(line, " ") + "\n")
+	}
+
+	if _, err := s.runner.Run([]byte(script.String()), "ipset", "restore", "-exist"); err != nil {
+		return fmt.Errorf("netfilter: failed to restore ipset: %w", err)
+	}
+
+	return nil
+}
+
+func timeoutArgs(timeout time.Duration) []string {
+	if seconds := timeoutSeconds(timeout); seconds > 0 {
+		return []string{"timeout", strconv.FormatInt(seconds, 10)}
+	}
+	return nil
+}
+
+// parseIPSetMembers extracts the members from `ipset list` output, where
+// each line after "Members:" looks like "1.2.3.4 timeout 3599"
+func parseIPSetMembers(out []byte) []string {
+	var ips []string
+	inMembers := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "Members:" {
+			inMembers = true
+			continue
+		}
+		if !inMembers || line == "" {
+			continue
+		}
+		ips = append(ips, strings.Fields(line)[0])
+	}
+
+	return ips
+}
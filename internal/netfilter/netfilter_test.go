@@ -0,0 +1,393 @@
+package netfilter
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+// call is a command run through fakeRunner
+type call struct {
+	stdin string
+	cmd   string
+}
+
+// fakeRunner records the commands and answers them from outputs, keyed by
+// command line. Commands listed in failures fail.
+type fakeRunner struct {
+	calls    []call
+	outputs  map[string]string
+	failures map[string]bool
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{outputs: make(map[string]string), failures: make(map[string]bool)}
+}
+
+func (f *fakeRunner) Run(stdin []byte, name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	f.calls = append(f.calls, call{stdin: string(stdin), cmd: cmd})
+	if f.failures[cmd] {
+		return nil, errors.New("exit status 1")
+	}
+	return []byte(f.outputs[cmd]), nil
+}
+
+func (f *fakeRunner) commands() []string {
+	cmds := make([]string, len(f.calls))
+	for i, c := range f.calls {
+		cmds[i] = c.cmd
+	}
+	return cmds
+}
+
+func assertCommands(t *testing.T, runner *fakeRunner, want ...string) {
+	t.Helper()
+	if got := runner.commands(); !slices.Equal(got, want) {
+		t.Errorf("commands =\n\t%s\nwant\n\t%s", strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+	}
+}
+
+func nftConfig(set config.SetConfig) *config.FirewallConfig {
+	return &config.FirewallConfig{Type: "nftables", Set: set}
+}
+
+func TestNftCreate(t *testing.T) {
+	runner := newFakeRunner()
+	n, err := NewNftSet(nftConfig(config.SetConfig{Create: true}), runner)
+	if err != nil {
+		t.Fatalf("NewNftSet() error = %v", err)
+	}
+
+	assertCommands(t, runner, "nft -f -")
+	want := `add table inet gatekeeper
+add set inet gatekeeper gatekeeper_v4 { type ipv4_addr; flags timeout; }
+add set inet gatekeeper gatekeeper_v6 { type ipv6_addr; flags timeout; }
+add chain inet gatekeeper input { type filter hook input priority -10; policy accept; }
+flush chain inet gatekeeper input
+add rule inet gatekeeper input ip saddr @gatekeeper_v4 drop
+add rule inet gatekeeper input ip6 saddr @gatekeeper_v6 drop
+`
+	if got := runner.calls[0].stdin; got != want {
+		t.Errorf("script =\n%s\nwant\n%s", got, want)
+	}
+	if got := n.Name(); got != "nftables inet gatekeeper" {
+		t.Errorf("Name() = %q", got)
+	}
+}
+
+func TestNftCreateOnlyV4(t *testing.T) {
+	runner := newFakeRunner()
+	_, err := NewNftSet(nftConfig(config.SetConfig{Family: "ip", Table: "gk", IPv4: "bad", Create: true}), runner)
+	if err != nil {
+		t.Fatalf("NewNftSet() error = %v", err)
+	}
+
+	script := runner.calls[0].stdin
+	if strings.Contains(script, "ipv6_addr") || strings.Contains(script, "ip6 saddr") {
+		t.Errorf("script creates an IPv6 set without one configured:\n%s", script)
+	}
+	if !strings.Contains(script, "add rule ip gk input ip saddr @bad drop\n") {
+		t.Errorf("script misses the drop rule:\n%s", script)
+	}
+}
+
+func TestNftCreateFailure(t *testing.T) {
+	runner := newFakeRunner()
+	runner.failures["nft -f -"] = true
+	if _, err := NewNftSet(nftConfig(config.SetConfig{Create: true}), runner); err == nil {
+		t.Fatal("NewNftSet() succeeded although nft failed")
+	}
+}
+
+func TestNftBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ip      string
+		want    string
+		wantErr bool
+	}{
+		{name: "ipv4", ip: "192.0.2.1", want: "nft add element inet gatekeeper gatekeeper_v4 { 192.0.2.1 }"},
+		{name: "ipv6", ip: "2001:db8::1", want: "nft add element inet gatekeeper gatekeeper_v6 { 2001:db8::1 }"},
+		{name: "mapped ipv4", ip: "::ffff:192.0.2.1", want: "nft add element inet gatekeeper gatekeeper_v4 { 192.0.2.1 }"},
+		{name: "timeout", timeout: 90 * time.Minute, ip: "192.0.2.1", want: "nft add element inet gatekeeper gatekeeper_v4 { 192.0.2.1 timeout 5400s }"},
+		{name: "rounded timeout", timeout: 1500 * time.Millisecond, ip: "192.0.2.1", want: "nft add element inet gatekeeper gatekeeper_v4 { 192.0.2.1 timeout 2s }"},
+		{name: "invalid", ip: "not-an-ip", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := newFakeRunner()
+			n, _ := NewNftSet(nftConfig(config.SetConfig{Timeout: tt.timeout}), runner)
+
+			err := n.Block(tt.ip)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Block() succeeded")
+				}
+				assertCommands(t, runner)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Block() error = %v", err)
+			}
+			assertCommands(t, runner, tt.want)
+		})
+	}
+}
+
+func TestNftBlockWithoutFamilySet(t *testing.T) {
+	runner := newFakeRunner()
+	n, _ := NewNftSet(nftConfig(config.SetConfig{IPv4: "v4only"}), runner)
+
+	if err := n.Block("2001:db8::1"); err == nil {
+		t.Fatal("Block() of an IPv6 address without an IPv6 set succeeded")
+	}
+	assertCommands(t, runner)
+}
+
+const nftListV4 = `{"nftables": [{"metainfo": {"json_schema_version": 1}}, {"set": {"family": "inet", "name": "gatekeeper_v4", "table": "gatekeeper", "type": "ipv4_addr", "flags": ["timeout"], "elem": ["192.0.2.1", {"elem": {"val": "192.0.2.2", "timeout": 3600, "expires": 3599}}]}}]}`
+
+const nftListV6 = `{"nftables": [{"set": {"family": "inet", "name": "gatekeeper_v6", "table": "gatekeeper", "type": "ipv6_addr"}}]}`
+
+func TestNftList(t *testing.T) {
+	runner := newFakeRunner()
+	runner.outputs["nft -j list set inet gatekeeper gatekeeper_v4"] = nftListV4
+	runner.outputs["nft -j list set inet gatekeeper gatekeeper_v6"] = nftListV6
+	n, _ := NewNftSet(nftConfig(config.SetConfig{}), runner)
+
+	ips, err := n.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if want := []string{"192.0.2.1", "192.0.2.2"}; !slices.Equal(ips, want) {
+		t.Errorf("List() = %v, want %v", ips, want)
+	}
+}
+
+func TestNftUnblock(t *testing.T) {
+	runner := newFakeRunner()
+	runner.outputs["nft -j list set inet gatekeeper gatekeeper_v4"] = nftListV4
+	n, _ := NewNftSet(nftConfig(config.SetConfig{}), runner)
+
+	if err := n.Unblock("192.0.2.2"); err != nil {
+		t.Fatalf("Unblock() error = %v", err)
+	}
+	// Deleting a missing element fails in nft, so absent IPs are skipped
+	if err := n.Unblock("192.0.2.9"); err != nil {
+		t.Fatalf("Unblock() of an absent IP error = %v", err)
+	}
+
+	assertCommands(t, runner,
+		"nft -j list set inet gatekeeper gatekeeper_v4",
+		"nft delete element inet gatekeeper gatekeeper_v4 { 192.0.2.2 }",
+		"nft -j list set inet gatekeeper gatekeeper_v4",
+	)
+}
+
+func TestNftHealth(t *testing.T) {
+	runner := newFakeRunner()
+	runner.outputs["nft -j list set inet gatekeeper gatekeeper_v4"] = nftListV4
+	runner.failures["nft -j list set inet gatekeeper gatekeeper_v6"] = true
+	n, _ := NewNftSet(nftConfig(config.SetConfig{}), runner)
+
+	if err := n.Health(); err == nil {
+		t.Fatal("Health() succeeded although a set is missing")
+	}
+}
+
+func TestNftRestore(t *testing.T) {
+	runner := newFakeRunner()
+	n, _ := NewNftSet(nftConfig(config.SetConfig{Timeout: time.Hour}), runner)
+
+	now := time.Now()
+	blocks := map[string]time.Time{
+		"192.0.2.1":   {},
+		"192.0.2.2":   now.Add(-15 * time.Minute),
+		"192.0.2.3":   now.Add(-2 * time.Hour),
+		"bogus":       now,
+		"2001:db8::1": now.Add(-30 * time.Minute),
+	}
+	if err := n.Restore(blocks); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	// Blocks are restored for their remaining time, expired ones are not
+	assertCommands(t, runner, "nft -f -")
+	want := `flush set inet gatekeeper gatekeeper_v4
+flush set inet gatekeeper gatekeeper_v6
+add element inet gatekeeper gatekeeper_v4 { 192.0.2.1 timeout 3600s }
+add element inet gatekeeper gatekeeper_v4 { 192.0.2.2 timeout 2700s }
+add element inet gatekeeper gatekeeper_v6 { 2001:db8::1 timeout 1800s }
+`
+	if got := runner.calls[0].stdin; got != want {
+		t.Errorf("script =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNftRestoreWithoutTimeout(t *testing.T) {
+	runner := newFakeRunner()
+	n, _ := NewNftSet(nftConfig(config.SetConfig{IPv4: "v4"}), runner)
+
+	if err := n.Restore(map[string]time.Time{"192.0.2.1": time.Now().Add(-48 * time.Hour)}); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	want := "flush set inet gatekeeper v4\nadd element inet gatekeeper v4 { 192.0.2.1 }\n"
+	if got := runner.calls[0].stdin; got != want {
+		t.Errorf("script =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNftBlockFor(t *testing.T) {
+	runner := newFakeRunner()
+	n, _ := NewNftSet(nftConfig(config.SetConfig{Timeout: time.Hour}), runner)
+
+	if got := n.Timeout(); got != time.Hour {
+		t.Errorf("Timeout() = %s, want 1h", got)
+	}
+	if err := n.BlockFor("192.0.2.1", 100*time.Millisecond); err != nil {
+		t.Fatalf("BlockFor() error = %v", err)
+	}
+	// A fraction of a second left must not turn into a permanent block
+	assertCommands(t, runner, "nft add element inet gatekeeper gatekeeper_v4 { 192.0.2.1 timeout 1s }")
+}
+
+func TestParseNftSetInvalid(t *testing.T) {
+	if _, err := parseNftSet([]byte("Error: No such file or directory")); err == nil {
+		t.Fatal("parseNftSet() accepted invalid output")
+	}
+}
+
+func ipsetConfig(set config.SetConfig) *config.FirewallConfig {
+	return &config.FirewallConfig{Type: "ipset", Set: set}
+}
+
+func TestIPSetCreate(t *testing.T) {
+	runner := newFakeRunner()
+	s, err := NewIPSet(ipsetConfig(config.SetConfig{Create: true}), runner)
+	if err != nil {
+		t.Fatalf("NewIPSet() error = %v", err)
+	}
+
+	assertCommands(t, runner,
+		"ipset create gatekeeper_v4 hash:ip family inet timeout 0 -exist",
+		"ipset create gatekeeper_v6 hash:ip family inet6 timeout 0 -exist",
+	)
+	if got := s.Name(); got != "ipset gatekeeper_v4,gatekeeper_v6" {
+		t.Errorf("Name() = %q", got)
+	}
+}
+
+func TestIPSetBlockUnblock(t *testing.T) {
+	runner := newFakeRunner()
+	s, _ := NewIPSet(ipsetConfig(config.SetConfig{Timeout: 24 * time.Hour}), runner)
+
+	if err := s.Block("192.0.2.1"); err != nil {
+		t.Fatalf("Block() error = %v", err)
+	}
+	if err := s.Block("2001:db8::1"); err != nil {
+		t.Fatalf("Block() error = %v", err)
+	}
+	if err := s.Unblock("192.0.2.1"); err != nil {
+		t.Fatalf("Unblock() error = %v", err)
+	}
+
+	assertCommands(t, runner,
+		"ipset add gatekeeper_v4 192.0.2.1 timeout 86400 -exist",
+		"ipset add gatekeeper_v6 2001:db8::1 timeout 86400 -exist",
+		"ipset del gatekeeper_v4 192.0.2.1 -exist",
+	)
+}
+
+func TestIPSetBlockFor(t *testing.T) {
+	runner := newFakeRunner()
+	s, _ := NewIPSet(ipsetConfig(config.SetConfig{Timeout: time.Hour}), runner)
+
+	if err := s.BlockFor("192.0.2.1", 90*time.Second); err != nil {
+		t.Fatalf("BlockFor() error = %v", err)
+	}
+	assertCommands(t, runner, "ipset add gatekeeper_v4 192.0.2.1 timeout 90 -exist")
+}
+
+func TestIPSetBlockWithoutTimeout(t *testing.T) {
+	runner := newFakeRunner()
+	s, _ := NewIPSet(ipsetConfig(config.SetConfig{}), runner)
+
+	if err := s.Block("192.0.2.1"); err != nil {
+		t.Fatalf("Block() error = %v", err)
+	}
+	assertCommands(t, runner, "ipset add gatekeeper_v4 192.0.2.1 -exist")
+}
+
+const ipsetListV4 = `Name: gatekeeper_v4
+Type: hash:ip
+Revision: 4
+Header: family inet hashsize 1024 maxelem 65536 timeout 0
+Size in memory: 408
+References: 1
+Number of entries: 2
+Members:
+192.0.2.1 timeout 3599
+192.0.2.2 timeout 0
+`
+
+func TestIPSetList(t *testing.T) {
+	runner := newFakeRunner()
+	runner.outputs["ipset list gatekeeper_v4"] = ipsetListV4
+	runner.outputs["ipset list gatekeeper_v6"] = "Name: gatekeeper_v6\nMembers:\n"
+	s, _ := NewIPSet(ipsetConfig(config.SetConfig{}), runner)
+
+	ips, err := s.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if want := []string{"192.0.2.1", "192.0.2.2"}; !slices.Equal(ips, want) {
+		t.Errorf("List() = %v, want %v", ips, want)
+	}
+}
+
+func TestIPSetHealth(t *testing.T) {
+	runner := newFakeRunner()
+	s, _ := NewIPSet(ipsetConfig(config.SetConfig{}), runner)
+
+	if err := s.Health(); err != nil {
+		t.Fatalf("Health() error = %v", err)
+	}
+	assertCommands(t, runner, "ipset list gatekeeper_v4 -terse", "ipset list gatekeeper_v6 -terse")
+
+	runner.failures["ipset list gatekeeper_v6 -terse"] = true
+	if err := s.Health(); err == nil {
+		t.Fatal("Health() succeeded although a set is missing")
+	}
+}
+
+func TestIPSetRestore(t *testing.T) {
+	runner := newFakeRunner()
+	s, _ := NewIPSet(ipsetConfig(config.SetConfig{Timeout: 30 * time.Minute}), runner)
+
+	now := time.Now()
+	blocks := map[string]time.Time{
+		"192.0.2.1":   now.Add(-10 * time.Minute),
+		"192.0.2.2":   now.Add(-time.Hour),
+		"2001:db8::1": {},
+	}
+	if err := s.Restore(blocks); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	assertCommands(t, runner, "ipset restore -exist")
+	want := `flush gatekeeper_v4
+flush gatekeeper_v6
+add gatekeeper_v4 192.0.2.1 timeout 1200
+add gatekeeper_v6 2001:db8::1 timeout 1800
+`
+	if got := runner.calls[0].stdin; got != want {
+		t.Errorf("script =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,255 @@
+package netfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/firewall"
+)
+
+func init() {
+	firewall.Register("nftables", func(cfg *config.FirewallConfig) (firewall.Blocker, error) {
+		return NewNftSet(cfg, ExecRunner{})
+	})
+}
+
+// NftSet blocks IPs by adding them to named nftables sets
+type NftSet struct {
+	runner  Runner
+	name    string
+	family  string
+	table   string
+	sets    sets
+	timeout time.Duration
+}
+
+// NewNftSet creates an nftables backend. When cfg.Set.Create is true the
+// table, the sets and a drop chain are created if they do not exist.
+func NewNftSet(cfg *config.FirewallConfig, runner Runner) (*NftSet, error) {
+	n := &NftSet{
+		runner:  runner,
+		name:    cfg.Name,
+		family:  cfg.Set.Family,
+		table:   cfg.Set.Table,
+		sets:    sets{v4: cfg.Set.IPv4, v6: cfg.Set.IPv6},
+		timeout: cfg.Set.Timeout,
+	}
+
+	if n.family == "" {
+		n.family = DefaultFamily
+	}
+	if n.table == "" {
+		n.table = DefaultTable
+	}
+	if n.sets.v4 == "" && n.sets.v6 == "" {
+		n.sets = sets{v4: DefaultSetV4, v6: DefaultSetV6}
+	}
+	if n.name == "" {
+		n.name = fmt.Sprintf("nftables %s %s", n.family, n.table)
+	}
+
+	if cfg.Set.Create {
+		if err := n.create(); err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+func (n *NftSet) create() error {
+	var script strings.Builder
+	fmt.Fprintf(&script, "add table %s %s\n", n.family, n.table)
+	if n.sets.v4 != "" {
+		fmt.Fprintf(&script, "add set %s %s %s { type ipv4_addr; flags timeout; }\n", n.family, n.table, n.sets.v4)
+	}
+	if n.sets.v6 != "" {
+		fmt.Fprintf(&script, "add set %s %s %s { type ipv6_addr; flags timeout; }\n", n.family, n.table, n.sets.v6)
+	}
+	fmt.Fprintf(&script, "add chain %s %s input { type filter hook input priority -10; policy accept; }\n", n.family, n.table)
+	fmt.Fprintf(&script, "flush chain %s %s input\n", n.family, n.table)
+	if n.sets.v4 != "" {
+		fmt.Fprintf(&script, "add rule %s %s input ip saddr @%s drop\n", n.family, n.table, n.sets.v4)
+	}
+	if n.sets.v6 != "" {
+		fmt.Fprintf(&script, "add rule %s %s input ip6 saddr @%s drop\n", n.family, n.table, n.sets.v6)
+	}
+
+	if _, err := n.runner.Run([]byte(script.String()), "nft", "-f", "-"); err != nil {
+		return fmt.Errorf("netfilter: failed to create nftables sets: %w", err)
+	}
+
+	log.Printf("nftables table %s %s ready", n.family, n.table)
+	return nil
+}
+
+// Name returns the backend name
+func (n *NftSet) Name() string {
+	return n.name
+}
+
+// Block adds the IP to the set matching its family
+func (n *NftSet) Block(ip string) error {
+	return n.BlockFor(ip, n.timeout)
+}
+
+// BlockFor adds the IP to the set matching its family with the given
+// timeout, 0 meaning forever
+func (n *NftSet) BlockFor(ip string, timeout time.Duration) error {
+	set, addr, err := n.sets.forIP(ip)
+	if err != nil {
+		return err
+	}
+
+	element := fmt.Sprintf("{ %s }", n.element(addr.String(), timeout))
+	if _, err := n.runner.Run(nil, "nft", "add", "element", n.family, n.table, set, element); err != nil {
+		return fmt.Errorf("netfilter: failed to add %s to %s: %w", ip, set, err)
+	}
+
+	return nil
+}
+
+// Unblock removes the IP from the set matching its family
+func (n *NftSet) Unblock(ip string) error {
+	set, addr, err := n.sets.forIP(ip)
+	if err != nil {
+		return err
+	}
+
+	members, err := n.listSet(set)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(members, addr.String()) {
+		return nil
+	}
+
+	element := fmt.Sprintf("{ %s }", addr.String())
+	if _, err := n.runner.Run(nil, "nft", "delete", "element", n.family, n.table, set, element); err != nil {
+		return fmt.Errorf("netfilter: failed to remove %s from %s: %w", ip, set, err)
+	}
+
+	return nil
+}
+
+// List returns the IPs of every configured set
+func (n *NftSet) List() ([]string, error) {
+	var ips []string
+	for _, set := range n.sets.names() {
+		members, err := n.listSet(set)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, members...)
+	}
+	return ips, nil
+}
+
+// Health checks that every configured set exists
+func (n *NftSet) Health() error {
+	_, err := n.List()
+	return err
+}
+
+// Timeout returns the timeout of the set elements
+func (n *NftSet) Timeout() time.Duration {
+	return n.timeout
+}
+
+// Restore replaces the contents of the sets with the given IPs. It is used
+// at startup since nftables sets do not survive a reboot. With a timeout,
+// IPs are added for the rest of their block and timed out ones are skipped.
+func (n *NftSet) Restore(blocks map[string]time.Time) error {
+	var script strings.Builder
+	for _, set := range n.sets.names() {
+		fmt.Fprintf(&script, "flush set %s %s %s\n", n.family, n.table, set)
+	}
+
+	for _, ip := range slices.Sorted(maps.Keys(blocks)) {
+		set, addr, err := n.sets.forIP(ip)
+		if err != nil {
+			log.Printf("Skipping %s while restoring nftables sets: %v", ip, err)
+			continue
+		}
+
+		timeout := n.timeout
+		if timeout > 0 {
+			if timeout = firewall.Remaining(timeout, blocks[ip]); timeout <= 0 {
+				continue
+			}
+		}
+		fmt.Fprintf(&script, "add element %s %s %s { %s }\n", n.family, n.table, set, n.element(addr.String(), timeout))
+	}
+
+	if _, err := n.runner.Run([]byte(script.String()), "nft", "-f", "-"); err != nil {
+		return fmt.Errorf("netfilter: failed to restore nftables sets: %w", err)
+	}
+
+	return nil
+}
+
+func (n *NftSet) element(ip string, timeout time.Duration) string {
+	if seconds := timeoutSeconds(timeout); seconds > 0 {
+		return fmt.Sprintf("%s timeout %ds", ip, seconds)
+	}
+	return ip
+}
+
+func (n *NftSet) listSet(set string) ([]string, error) {
+	out, err := n.runner.Run(nil, "nft", "-j", "list", "set", n.family, n.table, set)
+	if err != nil {
+		return nil, fmt.Errorf("netfilter: failed to list set %s: %w", set, err)
+	}
+
+	return parseNftSet(out)
+}
+
+// nftOutput is the subset of the `nft -j list set` output used by GateKeeper
+type nftOutput struct {
+	Nftables []struct {
+		Set *struct {
+			Elem []json.RawMessage `json:"elem"`
+		} `json:"set"`
+	} `json:"nftables"`
+}
+
+func parseNftSet(out []byte) ([]string, error) {
+	var result nftOutput
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("netfilter: failed to parse nft output: %w", err)
+	}
+
+	var ips []string
+	for _, item := range result.Nftables {
+		if item.Set == nil {
+			continue
+		}
+
+		for _, raw := range item.Set.Elem {
+			// Elements without timeout are plain strings, the others are
+			// objects of the form {"elem": {"val": "1.2.3.4", "timeout": 60}}
+			var ip string
+			if err := json.Unmarshal(raw, &ip); err == nil {
+				ips = append(ips, ip)
+				continue
+			}
+
+			var elem struct {
+				Elem struct {
+					Val string `json:"val"`
+				} `json:"elem"`
+			}
+			if err := json.Unmarshal(raw, &elem); err == nil && elem.Elem.Val != "" {
+				ips = append(ips, elem.Elem.Val)
+			}
+		}
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,89 @@
+package netfilter
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultFamily is the nftables family used when none is configured
+	DefaultFamily = "inet"
+	// DefaultTable is the nftables table used when none is configured
+	DefaultTable = "gatekeeper"
+	// DefaultSetV4 is the IPv4 set name used when none is configured
+	DefaultSetV4 = "gatekeeper_v4"
+	// DefaultSetV6 is the IPv6 set name used when none is configured
+	DefaultSetV6 = "gatekeeper_v6"
+)
+
+// Runner executes an external command and returns its combined output
+type Runner interface {
+	Run(stdin []byte, name string, args ...string) ([]byte, error)
+}
+
+// ExecRunner runs commands with os/exec
+type ExecRunner struct{}
+
+// Run executes the command, feeding stdin to it when not nil
+func (ExecRunner) Run(stdin []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	return out, nil
+}
+
+// sets holds the IPv4 and IPv6 set names of a backend
+type sets struct {
+	v4 string
+	v6 string
+}
+
+// forIP returns the set matching the family of ip
+func (s sets) forIP(ip string) (string, netip.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", netip.Addr{}, fmt.Errorf("netfilter: invalid IP %q: %w", ip, err)
+	}
+	addr = addr.Unmap()
+
+	name := s.v6
+	if addr.Is4() {
+		name = s.v4
+	}
+	if name == "" {
+		return "", netip.Addr{}, fmt.Errorf("netfilter: no set configured for %s", ip)
+	}
+
+	return name, addr, nil
+}
+
+// names returns the configured set names
+func (s sets) names() []string {
+	var names []string
+	for _, name := range []string{s.v4, s.v6} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func timeoutSeconds(timeout time.Duration) int64 {
+	if timeout <= 0 {
+		return 0
+	}
+	// Rounded up so that a remaining fraction of a second is not taken
+	// for "no timeout"
+	return int64((timeout + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,172 @@
+package netlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// PrefixSet is a set of IP prefixes stored in a binary trie, one per
+// address family. Lookups cost at most one step per address bit whatever
+// the number of prefixes. A PrefixSet is not safe for concurrent writes;
+// build it first, then share it read-only.
+type PrefixSet struct {
+	v4  *node
+	v6  *node
+	len int
+}
+
+type node struct {
+	children [2]*node
+	terminal bool
+}
+
+// NewPrefixSet creates an empty set
+func NewPrefixSet() *PrefixSet {
+	return &PrefixSet{v4: &node{}, v6: &node{}}
+}
+
+// ParsePrefix parses a single address or a CIDR prefix. Single addresses
+// become /32 or /128 prefixes and IPv4-mapped IPv6 addresses are unmapped.
+func ParsePrefix(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("netlist: invalid prefix %q: %w", entry, err)
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("netlist: invalid address %q: %w", entry, err)
+	}
+	addr = addr.Unmap().WithZone("")
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Parse builds a set from a list of addresses and prefixes
+func Parse(entries []string) (*PrefixSet, error) {
+	set := NewPrefixSet()
+	for _, entry := range entries {
+		prefix, err := ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		set.Add(prefix)
+	}
+	return set, nil
+}
+
+// ParseReader builds a set from one address or prefix per line. Empty
+// lines and comments starting with '#' or ';' are ignored.
+func ParseReader(r io.Reader) (*PrefixSet, error) {
+	set := NewPrefixSet()
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := scanner.Text()
+		if idx := strings.IndexAny(line, "#;"); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		prefix, err := ParsePrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		set.Add(prefix)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("netlist: failed to read list: %w", err)
+	}
+
+	return set, nil
+}
+
+// Add inserts a prefix in the set
+func (s *PrefixSet) Add(prefix netip.Prefix) {
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+
+	current := s.root(addr)
+	bytes := addr.AsSlice()
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := bitAt(bytes, i)
+		if current.children[bit] == nil {
+			current.children[bit] = &node{}
+		}
+		current = current.children[bit]
+	}
+
+	if !current.terminal {
+		current.terminal = true
+		s.len++
+	}
+}
+
+// Contains reports whether the address belongs to one of the prefixes
+func (s *PrefixSet) Contains(addr netip.Addr) bool {
+	if s == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+
+	current := s.root(addr)
+	bytes := addr.AsSlice()
+	for i := 0; i < addr.BitLen(); i++ {
+		if current.terminal {
+			return true
+		}
+
+		current = current.children[bitAt(bytes, i)]
+		if current == nil {
+			return false
+		}
+	}
+
+	return current.terminal
+}
+
+// ContainsString parses ip and reports whether it belongs to the set
+func (s *PrefixSet) ContainsString(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return s.Contains(addr)
+}
+
+// Len returns the number of distinct prefixes in the set
+func (s *PrefixSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return s.len
+}
+
+func (s *PrefixSet) root(addr netip.Addr) *node {
+	if addr.Is4() {
+		return s.v4
+	}
+	return s.v6
+}
+
+func bitAt(bytes []byte, i int) int {
+	return int(bytes[i/8]>>(7-uint(i%8))) & 1
+}
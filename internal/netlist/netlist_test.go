@@ -0,0 +1,143 @@
+package netlist
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{entry: "192.0.2.1", want: "192.0.2.1/32"},
+		{entry: "2001:db8::1", want: "2001:db8::1/128"},
+		{entry: " 192.0.2.0/24 ", want: "192.0.2.0/24"},
+		// Host bits are masked
+		{entry: "192.0.2.77/24", want: "192.0.2.0/24"},
+		{entry: "0.0.0.0/0", want: "0.0.0.0/0"},
+		{entry: "::/0", want: "::/0"},
+		// IPv4-mapped IPv6 addresses and prefixes are unmapped
+		{entry: "::ffff:192.0.2.1", want: "192.0.2.1/32"},
+		{entry: "::ffff:192.0.2.0/120", want: "192.0.2.0/24"},
+		{entry: "::ffff:0.0.0.0/96", want: "0.0.0.0/0"},
+		{entry: "fe80::1%eth0", want: "fe80::1/128"},
+		{entry: "192.0.2.0/33", wantErr: true},
+		{entry: "192.0.2", wantErr: true},
+		{entry: "example.com", wantErr: true},
+		{entry: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			got, err := ParsePrefix(tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePrefix(%q) error = %v, wantErr %t", tt.entry, err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("ParsePrefix(%q) = %s, want %s", tt.entry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		ip      string
+		want    bool
+	}{
+		{name: "ipv4 /0", entries: []string{"0.0.0.0/0"}, ip: "203.0.113.7", want: true},
+		{name: "ipv4 /0 and ipv6", entries: []string{"0.0.0.0/0"}, ip: "2001:db8::1", want: false},
+		{name: "ipv6 /0", entries: []string{"::/0"}, ip: "2001:db8::1", want: true},
+		{name: "ipv6 /0 and ipv4", entries: []string{"::/0"}, ip: "203.0.113.7", want: false},
+		{name: "/32", entries: []string{"192.0.2.1"}, ip: "192.0.2.1", want: true},
+		{name: "/32 neighbour", entries: []string{"192.0.2.1/32"}, ip: "192.0.2.0", want: false},
+		{name: "/128", entries: []string{"2001:db8::1"}, ip: "2001:db8::1", want: true},
+		{name: "/128 neighbour", entries: []string{"2001:db8::1/128"}, ip: "2001:db8::", want: false},
+		{name: "inside a prefix", entries: []string{"10.0.0.0/8"}, ip: "10.255.255.255", want: true},
+		{name: "outside a prefix", entries: []string{"10.0.0.0/8"}, ip: "11.0.0.0", want: false},
+		{name: "odd length", entries: []string{"192.0.2.128/25"}, ip: "192.0.2.127", want: false},
+		{name: "nested prefixes", entries: []string{"192.0.2.1", "192.0.0.0/16"}, ip: "192.0.3.1", want: true},
+		// Lookups unmap IPv4-mapped IPv6 addresses
+		{name: "mapped lookup", entries: []string{"192.0.2.0/24"}, ip: "::ffff:192.0.2.1", want: true},
+		{name: "mapped entry", entries: []string{"::ffff:192.0.2.1"}, ip: "192.0.2.1", want: true},
+		{name: "empty set", ip: "192.0.2.1", want: false},
+		{name: "invalid address", entries: []string{"0.0.0.0/0"}, ip: "not an ip", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := Parse(tt.entries)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := set.ContainsString(tt.ip); got != tt.want {
+				t.Errorf("ContainsString(%s) = %t, want %t", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilSet(t *testing.T) {
+	var set *PrefixSet
+	if set.Contains(netip.MustParseAddr("192.0.2.1")) || set.Len() != 0 {
+		t.Error("nil set is not empty")
+	}
+}
+
+func TestLen(t *testing.T) {
+	set, err := Parse([]string{"192.0.2.1", "192.0.2.1/32", "::ffff:192.0.2.1", "192.0.2.0/24", "192.0.2.9/24", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	// Duplicates are counted once
+	if got := set.Len(); got != 3 {
+		t.Errorf("Len() = %d, want 3", got)
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantLen int
+		wantErr string
+	}{
+		{
+			name:    "comments",
+			input:   "# Blocked networks\n192.0.2.0/24 # office\n\n  ; legacy\n2001:db8::1;old host\n\t10.0.0.1\t\n",
+			wantLen: 3,
+		},
+		{name: "empty", input: "", wantLen: 0},
+		{name: "only comments", input: "# 192.0.2.1\n; 192.0.2.2\n", wantLen: 0},
+		{name: "invalid line", input: "192.0.2.1\n# comment\nexample.com\n", wantErr: "line 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := ParseReader(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseReader() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseReader() error = %v", err)
+			}
+			if got := set.Len(); got != tt.wantLen {
+				t.Errorf("Len() = %d, want %d", got, tt.wantLen)
+			}
+		})
+	}
+
+	// Commented out entries are not part of the set
+	set, _ := ParseReader(strings.NewReader("192.0.2.1 # 198.51.100.1\n"))
+	if !set.ContainsString("192.0.2.1") || set.ContainsString("198.51.100.1") {
+		t.Error("comment not stripped")
+	}
+}
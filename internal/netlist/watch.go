@@ -0,0 +1,93 @@
+package netlist
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultReloadInterval is how often watched files are checked for changes
+	DefaultReloadInterval = 30 * time.Second
+)
+
+// WatchedFile is a PrefixSet loaded from a file and reloaded whenever the
+// file changes. If a reload fails the previous contents are kept.
+type WatchedFile struct {
+	path    string
+	set     atomic.Pointer[PrefixSet]
+	modTime time.Time
+	size    int64
+}
+
+// WatchFile loads the file and checks it for changes every interval
+func WatchFile(path string, interval time.Duration) (*WatchedFile, error) {
+	w := &WatchedFile{path: path}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+
+	go w.watchLoop(interval)
+
+	return w, nil
+}
+
+// Contains reports whether the address belongs to the current file contents
+func (w *WatchedFile) Contains(addr netip.Addr) bool {
+	return w.set.Load().Contains(addr)
+}
+
+// Len returns the number of prefixes currently loaded
+func (w *WatchedFile) Len() int {
+	return w.set.Load().Len()
+}
+
+func (w *WatchedFile) watchLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		info, err := os.Stat(w.path)
+		if err != nil {
+			log.Printf("Failed to stat %s: %v", w.path, err)
+			continue
+		}
+
+		if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+			continue
+		}
+
+		if err := w.reload(); err != nil {
+			log.Printf("Failed to reload %s, keeping previous entries: %v", w.path, err)
+			continue
+		}
+		log.Printf("Reloaded %d prefix(es) from %s", w.Len(), w.path)
+	}
+}
+
+func (w *WatchedFile) reload() error {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return fmt.Errorf("netlist: cannot open %s: %w", w.path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("netlist: cannot stat %s: %w", w.path, err)
+	}
+
+	set, err := ParseReader(f)
+	if err != nil {
+		return fmt.Errorf("netlist: cannot parse %s: %w", w.path, err)
+	}
+
+	w.set.Store(set)
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package netlist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeList(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeList(t, path, "192.0.2.0/24\n")
+
+	// The loop is left to TestWatchLoop, reloads are run by hand
+	w, err := WatchFile(path, time.Hour)
+	if err != nil {
+		t.Fatalf("WatchFile() error = %v", err)
+	}
+
+	steps := []struct {
+		name     string
+		contents string
+		wantErr  bool
+		in       []string
+		out      []string
+	}{
+		{
+			name:     "new entries",
+			contents: "198.51.100.0/24\n2001:db8::1\n",
+			in:       []string{"198.51.100.7", "2001:db8::1"},
+			out:      []string{"192.0.2.1"},
+		},
+		{
+			// The previous set is kept when the file is broken
+			name:     "parse error",
+			contents: "203.0.113.0/24\nnot an ip\n",
+			wantErr:  true,
+			in:       []string{"198.51.100.7", "2001:db8::1"},
+			out:      []string{"203.0.113.1"},
+		},
+		{
+			name:     "fixed",
+			contents: "203.0.113.0/24\n",
+			in:       []string{"203.0.113.1"},
+			out:      []string{"198.51.100.7"},
+		},
+		{name: "emptied", contents: "# nothing left\n", out: []string{"203.0.113.1"}},
+	}
+
+	for _, step := range steps {
+		writeList(t, path, step.contents)
+		if err := w.reload(); (err != nil) != step.wantErr {
+			t.Fatalf("%s: reload() error = %v, wantErr %t", step.name, err, step.wantErr)
+		}
+		for _, ip := range step.in {
+			if !w.set.Load().ContainsString(ip) {
+				t.Errorf("%s: %s missing", step.name, ip)
+			}
+		}
+		for _, ip := range step.out {
+			if w.set.Load().ContainsString(ip) {
+				t.Errorf("%s: %s still listed", step.name, ip)
+			}
+		}
+	}
+
+	// A missing file keeps the previous set as well
+	os.Remove(path)
+	if err := w.reload(); err == nil {
+		t.Error("reload() of a missing file succeeded")
+	}
+	if w.Len() != 0 {
+		t.Errorf("Len() = %d, want the emptied set kept", w.Len())
+	}
+}
+
+func TestWatchFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "broken.txt")
+	writeList(t, broken, "192.0.2.1\nnot an ip\n")
+
+	for _, path := range []string{broken, filepath.Join(dir, "missing.txt")} {
+		if _, err := WatchFile(path, time.Hour); err == nil {
+			t.Errorf("WatchFile(%s) succeeded", filepath.Base(path))
+		}
+	}
+}
+
+func TestWatchLoop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeList(t, path, "192.0.2.1\n")
+
+	w, err := WatchFile(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("WatchFile() error = %v", err)
+	}
+
+	// The size changes, so the reload does not depend on the mtime resolution
+	writeList(t, path, "192.0.2.1\n198.51.100.0/24\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for w.Len() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %d after the change, want 2", w.Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A broken file is not loaded by the loop either
+	writeList(t, path, "192.0.2.1\n198.51.100.0/24\nnot an ip\n")
+	time.Sleep(100 * time.Millisecond)
+	if w.Len() != 2 || !w.set.Load().ContainsString("198.51.100.7") {
+		t.Errorf("Len() = %d, want the previous set kept", w.Len())
+	}
+}
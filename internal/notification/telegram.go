@@ -6,12 +6,19 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"text/template"
+	"time"
 
+	"github.com/TOomaAh/GateKeeper/internal/abuseip"
 	"github.com/TOomaAh/GateKeeper/internal/config"
 	"github.com/TOomaAh/GateKeeper/internal/domain"
 )
 
+// NotifyTimeout bounds a notification request, so that a slow API cannot
+// hold a pipeline worker
+const NotifyTimeout = 10 * time.Second
+
 // Notifier interface for notification systems
 type Notifier interface {
 	Notify(info *domain.IPInfo) error
@@ -26,13 +33,32 @@ type TelegramNotifier struct {
 
 // TemplateData contains data for the template
 type TemplateData struct {
-	Emoji    string
-	IP       string
-	Country  string
-	Score    int
-	Severity string
-	Blocked  string
-	Path     string
+	Emoji     string
+	IP        string
+	Country   string
+	City      string
+	ASN       uint
+	ASOrg     string
+	Score     int
+	Severity  string
+	Blocked   string
+	Path      string
+	SNI       string
+	JA3       string
+	JA4       string
+	Providers string
+	Policy    string
+
+	// AbuseIPDB details, empty without an AbuseIPDB provider
+	ISP           string
+	Domain        string
+	UsageType     string
+	Tor           bool
+	Whitelisted   bool
+	Reports       int
+	DistinctUsers int
+	LastReported  string
+	Categories    string
 }
 
 // NewTelegramNotifier creates a new Telegram notifier
@@ -43,8 +69,8 @@ func NewTelegramNotifier(cfg config.TelegramNotificationConfig) *TelegramNotifie
 		defaultTemplate := `{{.Emoji}} *Accès direct par IP détecté*
 
 🌐 *IP:* {{.IP}}
-🌍 *Pays:* {{.Country}}
-📊 *Score AbuseIPDB:* {{.Score}}/100 ({{.Severity}})
+🌍 *Pays:* {{.Country}}{{if .City}} ({{.City}}){{end}}
+📊 *Score:* {{.Score}}/100 ({{.Severity}})
 🛡️ *Bloqué:* {{.Blocked}}
 📂 *Path:* {{.Path}}`
 		tmpl, _ = template.New("telegram").Parse(defaultTemplate)
@@ -52,7 +78,7 @@ func NewTelegramNotifier(cfg config.TelegramNotificationConfig) *TelegramNotifie
 
 	return &TelegramNotifier{
 		config:   cfg,
-		client:   &http.Client{},
+		client:   &http.Client{Timeout: NotifyTimeout},
 		template: tmpl,
 	}
 }
@@ -100,14 +126,36 @@ func (t *TelegramNotifier) formatMessage(info *domain.IPInfo) (string, error) {
 	}
 
 	data := TemplateData{
-		Emoji:    emoji,
-		IP:       fmt.Sprintf("`%s`", info.Address),
-		Country:  info.Country,
-		Score:    int(info.Score),
-		Severity: severity.String(),
-		Blocked:  blockedStatus,
-		Path:     info.Path,
+		Emoji:     emoji,
+		IP:        fmt.Sprintf("`%s`", info.Address),
+		Country:   info.Country,
+		City:      info.City,
+		ASN:       info.ASN,
+		ASOrg:     info.ASOrg,
+		Score:     int(info.Score),
+		Severity:  severity.String(),
+		Blocked:   blockedStatus,
+		Path:      info.Path,
+		SNI:       info.TLSServerName,
+		JA3:       info.JA3,
+		JA4:       info.JA4,
+		Providers: providersSummary(info.Reputation),
+		Policy:    strings.Join(info.Verdict.Rules, ", "),
+	}
+
+	if abuse := info.AbuseDetails(); abuse != nil {
+		data.ISP = abuse.ISP
+		data.Domain = abuse.Domain
+		data.UsageType = abuse.UsageType
+		data.Tor = abuse.IsTor
+		data.Reports = abuse.TotalReports
+		data.DistinctUsers = abuse.DistinctUsers
+		data.Categories = strings.Join(abuseip.CategoryNames(abuse.Categories), ", ")
+		if !abuse.LastReportedAt.IsZero() {
+			data.LastReported = abuse.LastReportedAt.Format(time.RFC3339)
+		}
 	}
+	data.Whitelisted = info.IsWhitelisted()
 
 	var buf bytes.Buffer
 	if err := t.template.Execute(&buf, data); err != nil {
@@ -117,6 +165,19 @@ func (t *TelegramNotifier) formatMessage(info *domain.IPInfo) (string, error) {
 	return buf.String(), nil
 }
 
+// providersSummary formats the provider results, e.g. "abuseipdb 100, greynoise 50"
+func providersSummary(results []domain.ReputationResult) string {
+	parts := make([]string, 0, len(results))
+	for _, result := range results {
+		if result.Error != "" {
+			parts = append(parts, result.Provider+" error")
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s %d", result.Provider, result.Score))
+	}
+	return strings.Join(parts, ", ")
+}
+
 // MultiNotifier sends notifications to multiple destinations
 type MultiNotifier struct {
 	notifiers []Notifier
@@ -131,13 +192,12 @@ func NewMultiNotifier(configs []config.TelegramNotificationConfig) *MultiNotifie
 	return &MultiNotifier{notifiers: notifiers}
 }
 
-// Notify sends a notification to all notifiers
+// Notify sends a notification to all notifiers, one after the other. It
+// blocks until they are sent, callers run it in the pipeline.
 func (m *MultiNotifier) Notify(info *domain.IPInfo) {
 	for _, notifier := range m.notifiers {
-		go func(n Notifier) {
-			if err := n.Notify(info); err != nil {
-				log.Printf("Notification error: %v", err)
-			}
-		}(notifier)
+		if err := notifier.Notify(info); err != nil {
+			log.Printf("Notification error: %v", err)
+		}
 	}
 }
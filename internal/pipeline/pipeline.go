@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Pool runs jobs on a fixed number of workers, fed by a bounded queue.
+// Submitting never blocks: when the queue is full the job is dropped and
+// counted, so that a burst of work cannot stall the callers.
+type Pool struct {
+	jobs    chan job
+	workers int
+	wg      sync.WaitGroup
+
+	mu        sync.Mutex
+	closed    bool
+	stats     Stats
+	started   uint64
+	totalWait time.Duration
+}
+
+type job struct {
+	name   string
+	queued time.Time
+	run    func()
+}
+
+// Stats contains the pool counters, to watch for backpressure
+type Stats struct {
+	Workers       int     `json:"workers"`
+	QueueSize     int     `json:"queue_size"`
+	Queued        int     `json:"queued"`
+	HighWatermark int     `json:"high_watermark"`
+	Running       int     `json:"running"`
+	Submitted     uint64  `json:"submitted"`
+	Completed     uint64  `json:"completed"`
+	Dropped       uint64  `json:"dropped"`
+	AvgWaitMs     float64 `json:"avg_wait_ms"`
+}
+
+// New starts a pool of workers with a queue of queueSize jobs
+func New(workers, queueSize int) *Pool {
+	p := &Pool{
+		jobs:    make(chan job, queueSize),
+		workers: workers,
+	}
+
+	p.wg.Add(workers)
+	for range workers {
+		go p.work()
+	}
+
+	return p
+}
+
+// Submit queues a job. It returns false if the queue is full or the pool
+// is closed, and the job was dropped.
+func (p *Pool) Submit(name string, run func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		p.stats.Dropped++
+		return false
+	}
+
+	select {
+	case p.jobs <- job{name: name, queued: time.Now(), run: run}:
+		p.stats.Submitted++
+		p.stats.HighWatermark = max(p.stats.HighWatermark, len(p.jobs))
+		return true
+	default:
+		p.stats.Dropped++
+		return false
+	}
+}
+
+// Close stops accepting jobs and waits for the queued ones to complete
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+// Stats returns the pool counters
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.stats
+	stats.Workers = p.workers
+	stats.QueueSize = cap(p.jobs)
+	stats.Queued = len(p.jobs)
+	if p.started > 0 {
+		stats.AvgWaitMs = float64(p.totalWait.Microseconds()) / 1000 / float64(p.started)
+	}
+	return stats
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+
+	for j := range p.jobs {
+		p.mu.Lock()
+		p.stats.Running++
+		p.started++
+		p.totalWait += time.Since(j.queued)
+		p.mu.Unlock()
+
+		p.run(j)
+
+		p.mu.Lock()
+		p.stats.Running--
+		p.stats.Completed++
+		p.mu.Unlock()
+	}
+}
+
+// run executes a job, keeping the worker alive if it panics
+func (p *Pool) run(j job) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Pipeline job %s panicked: %v", j.name, r)
+		}
+	}()
+
+	j.run()
+}
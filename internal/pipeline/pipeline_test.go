@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubmitDropsWhenFull(t *testing.T) {
+	p := New(1, 1)
+	defer p.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	if !p.Submit("blocking", func() {
+		close(started)
+		<-release
+	}) {
+		t.Fatal("Submit() dropped the first job")
+	}
+	<-started
+
+	if !p.Submit("queued", func() {}) {
+		t.Fatal("Submit() dropped a job with room in the queue")
+	}
+	if p.Submit("dropped", func() { t.Error("dropped job ran") }) {
+		t.Fatal("Submit() queued a job in a full queue")
+	}
+
+	stats := p.Stats()
+	want := Stats{Workers: 1, QueueSize: 1, Queued: 1, HighWatermark: 1, Running: 1, Submitted: 2, Dropped: 1}
+	stats.AvgWaitMs = 0
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	p.Close()
+
+	stats = p.Stats()
+	if stats.Completed != 2 || stats.Queued != 0 || stats.Running != 0 {
+		t.Errorf("stats = %+v, want both jobs completed", stats)
+	}
+	// The queued job waited for the blocking one
+	if stats.AvgWaitMs < 2.5 {
+		t.Errorf("average wait = %.2fms, want at least 2.5ms", stats.AvgWaitMs)
+	}
+}
+
+func TestCloseDrains(t *testing.T) {
+	p := New(2, 100)
+
+	var done atomic.Int32
+	for range 100 {
+		if !p.Submit("job", func() {
+			time.Sleep(time.Millisecond)
+			done.Add(1)
+		}) {
+			t.Fatal("Submit() dropped a job")
+		}
+	}
+	p.Close()
+
+	if got := done.Load(); got != 100 {
+		t.Errorf("%d jobs completed before Close() returned, want 100", got)
+	}
+
+	if p.Submit("late", func() { t.Error("job ran after Close()") }) {
+		t.Error("Submit() accepted a job after Close()")
+	}
+	stats := p.Stats()
+	if stats.Submitted != 100 || stats.Completed != 100 || stats.Dropped != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// Closing twice is harmless
+	p.Close()
+}
+
+func TestPanickingJob(t *testing.T) {
+	p := New(1, 10)
+
+	var ran atomic.Bool
+	p.Submit("panic", func() { panic("boom") })
+	p.Submit("next", func() { ran.Store(true) })
+	p.Close()
+
+	if !ran.Load() {
+		t.Error("worker died with the panicking job")
+	}
+	if completed := p.Stats().Completed; completed != 2 {
+		t.Errorf("%d jobs completed, want 2", completed)
+	}
+}
@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"slices"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// Engine applies the policy rules to the hits. Block and allow rules are
+// exclusive: the first matching one wins. Score rules and the TLS
+// fingerprints matching the last ClientHello of the IP add up.
+type Engine struct {
+	threshold    domain.IPScore
+	rules        []config.PolicyRule
+	fingerprints []config.TLSFingerprint
+}
+
+// New builds the engine of a validated policy configuration and of the
+// tls_fingerprints rules
+func New(cfg config.PolicyConfig, fingerprints []config.TLSFingerprint) *Engine {
+	threshold := domain.ScoreThreshold
+	if cfg.Threshold != nil {
+		threshold = domain.IPScore(*cfg.Threshold)
+	}
+
+	return &Engine{
+		threshold:    threshold,
+		rules:        cfg.Rules,
+		fingerprints: fingerprints,
+	}
+}
+
+// Apply returns a copy of the entry of an IP hitting a listener, with its
+// score adjusted and its verdict set by the matching rules. The entry
+// itself is left untouched, so that adjustments are not stored.
+func (e *Engine) Apply(info *domain.IPInfo, listener string) *domain.IPInfo {
+	checked := *info
+	checked.Verdict = domain.Verdict{Threshold: &e.threshold}
+
+	score := int(info.Score)
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if !matches(rule, info, listener) {
+			continue
+		}
+
+		switch rule.Action {
+		case config.PolicyBlock, config.PolicyAllow:
+			if checked.Verdict.AlwaysBlock || checked.Verdict.AlwaysAllow {
+				continue
+			}
+			checked.Verdict.AlwaysBlock = rule.Action == config.PolicyBlock
+			checked.Verdict.AlwaysAllow = rule.Action == config.PolicyAllow
+		case config.PolicyScore:
+			score += rule.Score
+		}
+		checked.Verdict.Rules = append(checked.Verdict.Rules, rule.Name)
+	}
+
+	for i := range e.fingerprints {
+		fp := &e.fingerprints[i]
+		if !matchesFingerprint(fp, info) {
+			continue
+		}
+		score += fp.Score
+		name := fp.Name
+		if name == "" {
+			name = "tls fingerprint"
+		}
+		checked.Verdict.Rules = append(checked.Verdict.Rules, name)
+	}
+
+	checked.Score = domain.IPScore(min(max(score, 0), 100))
+	return &checked
+}
+
+// matches reports whether a hit meets every criterion of a rule
+func matches(rule *config.PolicyRule, info *domain.IPInfo, listener string) bool {
+	if len(rule.Countries) > 0 && !slices.Contains(rule.Countries, info.Country) {
+		return false
+	}
+	if len(rule.ASNs) > 0 && !slices.Contains(rule.ASNs, info.ASN) {
+		return false
+	}
+	if len(rule.Listeners) > 0 && !slices.Contains(rule.Listeners, listener) {
+		return false
+	}
+	return true
+}
+
+// matchesFingerprint reports whether the last ClientHello of the IP has
+// every fingerprint set by the rule
+func matchesFingerprint(fp *config.TLSFingerprint, info *domain.IPInfo) bool {
+	if info.JA3 == "" && info.JA4 == "" {
+		return false
+	}
+	return (fp.JA3 == "" || fp.JA3 == info.JA3) && (fp.JA4 == "" || fp.JA4 == info.JA4)
+}
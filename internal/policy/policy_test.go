@@ -0,0 +1,119 @@
+package policy
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+func TestThreshold(t *testing.T) {
+	threshold := func(v int) *int { return &v }
+
+	tests := []struct {
+		name      string
+		threshold *int
+		score     domain.IPScore
+		want      bool
+	}{
+		{name: "unset, below", score: domain.ScoreThreshold, want: false},
+		{name: "unset, above", score: domain.ScoreThreshold + 1, want: true},
+		{name: "zero, score 0", threshold: threshold(0), score: 0, want: false},
+		{name: "zero, any score", threshold: threshold(0), score: 1, want: true},
+		{name: "custom, below", threshold: threshold(50), score: 50, want: false},
+		{name: "custom, above", threshold: threshold(50), score: 51, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New(config.PolicyConfig{Threshold: tt.threshold}, nil)
+			checked := e.Apply(&domain.IPInfo{Address: "192.0.2.1", Score: tt.score}, "http")
+			if got := checked.IsHighRisk(); got != tt.want {
+				t.Errorf("IsHighRisk() = %v with score %d, want %v", got, tt.score, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	e := New(config.PolicyConfig{Rules: []config.PolicyRule{
+		{Name: "trusted", ASNs: []uint{64496}, Action: config.PolicyAllow},
+		{Name: "blocked", Countries: []string{"XX"}, Action: config.PolicyBlock},
+		{Name: "ssh", Listeners: []string{"ssh"}, Action: config.PolicyScore, Score: 30},
+		{Name: "home", Countries: []string{"FR"}, Action: config.PolicyScore, Score: -50},
+	}}, nil)
+
+	tests := []struct {
+		name     string
+		info     domain.IPInfo
+		listener string
+		score    domain.IPScore
+		highRisk bool
+		rules    []string
+	}{
+		{name: "no rule", info: domain.IPInfo{Score: 80, Country: "DE"}, listener: "http", score: 80, highRisk: true},
+		{name: "allow wins over block", info: domain.IPInfo{Score: 80, Country: "XX", ASN: 64496}, listener: "http", score: 80, rules: []string{"trusted"}},
+		{name: "block", info: domain.IPInfo{Score: 0, Country: "XX"}, listener: "http", highRisk: true, rules: []string{"blocked"}},
+		{name: "scores add up", info: domain.IPInfo{Score: 60, Country: "FR"}, listener: "ssh", score: 40, rules: []string{"ssh", "home"}},
+		{name: "score raised", info: domain.IPInfo{Score: 60, Country: "DE"}, listener: "ssh", score: 90, highRisk: true, rules: []string{"ssh"}},
+		{name: "score clamped", info: domain.IPInfo{Score: 10, Country: "FR"}, listener: "http", score: 0, rules: []string{"home"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := tt.info
+			checked := e.Apply(&info, tt.listener)
+			if checked.Score != tt.score || checked.IsHighRisk() != tt.highRisk || !slices.Equal(checked.Verdict.Rules, tt.rules) {
+				t.Errorf("Apply() = score %d, high risk %v, rules %v, want %d, %v, %v",
+					checked.Score, checked.IsHighRisk(), checked.Verdict.Rules, tt.score, tt.highRisk, tt.rules)
+			}
+			if info.Score != tt.info.Score {
+				t.Errorf("Apply() changed the stored score to %d", info.Score)
+			}
+		})
+	}
+}
+
+func TestFingerprints(t *testing.T) {
+	e := New(config.PolicyConfig{}, []config.TLSFingerprint{
+		{Name: "scanner", JA4: "t13d1516h2_8daaf6152771_e5627efa2ab1", Score: 40},
+		{Name: "browser", JA3: "aaaa", JA4: "t13d1517h2_8daaf6152771_b0da82dd1658", Score: -30},
+		{JA3: "bbbb", Score: 10},
+	})
+
+	tests := []struct {
+		name  string
+		info  domain.IPInfo
+		score domain.IPScore
+		rules []string
+	}{
+		{name: "no ClientHello", info: domain.IPInfo{Score: 50}, score: 50},
+		{name: "ja4", info: domain.IPInfo{Score: 50, JA4: "t13d1516h2_8daaf6152771_e5627efa2ab1"}, score: 90, rules: []string{"scanner"}},
+		{name: "clamped to 100", info: domain.IPInfo{Score: 80, JA4: "t13d1516h2_8daaf6152771_e5627efa2ab1"}, score: 100, rules: []string{"scanner"}},
+		{name: "both required", info: domain.IPInfo{Score: 50, JA4: "t13d1517h2_8daaf6152771_b0da82dd1658"}, score: 50},
+		{name: "both match", info: domain.IPInfo{Score: 50, JA3: "aaaa", JA4: "t13d1517h2_8daaf6152771_b0da82dd1658"}, score: 20, rules: []string{"browser"}},
+		{name: "unnamed", info: domain.IPInfo{Score: 50, JA3: "bbbb"}, score: 60, rules: []string{"tls fingerprint"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := tt.info
+			checked := e.Apply(&info, "https")
+			if checked.Score != tt.score || !slices.Equal(checked.Verdict.Rules, tt.rules) {
+				t.Errorf("Apply() = score %d, rules %v, want %d, %v", checked.Score, checked.Verdict.Rules, tt.score, tt.rules)
+			}
+		})
+	}
+
+	// Applying the rules on every hit never accumulates
+	info := domain.IPInfo{Score: 50, JA4: "t13d1516h2_8daaf6152771_e5627efa2ab1"}
+	for range 3 {
+		if checked := e.Apply(&info, "https"); checked.Score != 90 {
+			t.Fatalf("Apply() score = %d on a repeated hit, want 90", checked.Score)
+		}
+	}
+	if info.Score != 50 {
+		t.Errorf("Apply() changed the stored score to %d", info.Score)
+	}
+}
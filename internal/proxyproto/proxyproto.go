@@ -0,0 +1,258 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/netlist"
+)
+
+const (
+	// DefaultHeaderTimeout bounds the time a peer has to send its header
+	DefaultHeaderTimeout = 5 * time.Second
+
+	// v1MaxLength is the maximum length of a v1 header, CRLF included
+	v1MaxLength = 107
+)
+
+var (
+	// ErrInvalidHeader is returned when a trusted peer sends a malformed header
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY protocol header")
+
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// Listener accepts connections carrying a PROXY protocol v1 or v2 header
+// and exposes the client address announced in the header as RemoteAddr.
+// Only peers in the allowed networks may send a header, and they must do
+// so. Connections from other peers are returned untouched.
+type Listener struct {
+	net.Listener
+	allowed *netlist.PrefixSet
+	timeout time.Duration
+}
+
+// NewListener wraps a listener
+func NewListener(inner net.Listener, allowed *netlist.PrefixSet) *Listener {
+	return &Listener{
+		Listener: inner,
+		allowed:  allowed,
+		timeout:  DefaultHeaderTimeout,
+	}
+}
+
+// Accept waits for the next connection. The header is parsed lazily on the
+// first Read or RemoteAddr call so that a slow peer cannot stall Accept.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	peer, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !l.allowed.Contains(peer.AddrPort().Addr()) {
+		return conn, nil
+	}
+
+	return &Conn{
+		Conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: l.timeout,
+	}, nil
+}
+
+// Conn is a connection whose addresses come from a PROXY protocol header
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+	local  net.Addr
+}
+
+// Read reads data following the header
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address announced by the proxy
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address announced by the proxy
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	c.remote, c.local, c.err = parseHeader(c.reader)
+	if c.err != nil {
+		log.Printf("Rejecting PROXY protocol connection from %s: %v", c.Conn.RemoteAddr(), c.err)
+		c.Conn.Close()
+	}
+}
+
+// parseHeader reads a v1 or v2 header. It returns nil addresses for
+// headers that do not carry any, such as v1 UNKNOWN or v2 LOCAL.
+func parseHeader(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	peek, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+
+	if bytes.Equal(peek, v1Prefix) {
+		return parseV1(r)
+	}
+
+	peek, err = r.Peek(len(v2Signature))
+	if err == nil && bytes.Equal(peek, v2Signature) {
+		return parseV2(r)
+	}
+
+	return nil, nil, fmt.Errorf("%w: missing header", ErrInvalidHeader)
+}
+
+// parseV1 parses a text header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func parseV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: v1 header too long or not CRLF terminated", ErrInvalidHeader)
+	}
+
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: malformed v1 header %q", ErrInvalidHeader, text)
+	}
+
+	src, err := v1Address(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := v1Address(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if (fields[1] == "TCP4") != src.AddrPort().Addr().Is4() {
+		return nil, nil, fmt.Errorf("%w: address family mismatch", ErrInvalidHeader)
+	}
+
+	return src, dst, nil
+}
+
+func v1Address(ip, port string) (*net.TCPAddr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid address %q", ErrInvalidHeader, ip)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrInvalidHeader, port)
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+// parseV2 parses a binary header: a 12 bytes signature, the version and
+// command, the family and protocol, the length of the remaining data and
+// the addresses followed by optional TLVs which are skipped.
+func parseV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+
+	version, command := header[12]>>4, header[12]&0x0F
+	family := header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	if version != 2 {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, version)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+
+	if command == 0x0 {
+		// LOCAL: health checks from the proxy itself
+		return nil, nil, nil
+	}
+	if command != 0x1 {
+		return nil, nil, fmt.Errorf("%w: unsupported command %d", ErrInvalidHeader, command)
+	}
+
+	var size int
+	switch family >> 4 {
+	case 0x1:
+		size = 4
+	case 0x2:
+		size = 16
+	default:
+		// AF_UNSPEC and AF_UNIX carry no usable IP address
+		return nil, nil, nil
+	}
+
+	if len(payload) < 2*size+4 {
+		return nil, nil, fmt.Errorf("%w: address block too short", ErrInvalidHeader)
+	}
+
+	srcIP, _ := netip.AddrFromSlice(payload[:size])
+	dstIP, _ := netip.AddrFromSlice(payload[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(payload[2*size:])
+	dstPort := binary.BigEndian.Uint16(payload[2*size+2:])
+
+	src := net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP.Unmap(), srcPort))
+	dst := net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP.Unmap(), dstPort))
+
+	return src, dst, nil
+}
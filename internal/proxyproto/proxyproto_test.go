@@ -0,0 +1,372 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/netlist"
+)
+
+// v2Header builds a binary header with the given command, family and
+// address block
+func v2Header(version, command, family byte, payload []byte) []byte {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, version<<4|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// v2Addresses builds the address block of a v2 header
+func v2Addresses(src, dst netip.AddrPort) []byte {
+	var payload []byte
+	payload = append(payload, src.Addr().AsSlice()...)
+	payload = append(payload, dst.Addr().AsSlice()...)
+	payload = binary.BigEndian.AppendUint16(payload, src.Port())
+	return binary.BigEndian.AppendUint16(payload, dst.Port())
+}
+
+// tlv builds a type-length-value entry following the addresses
+func tlv(typ byte, value string) []byte {
+	return append(binary.BigEndian.AppendUint16([]byte{typ}, uint16(len(value))), value...)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+var (
+	src4 = netip.MustParseAddrPort("192.0.2.1:56324")
+	dst4 = netip.MustParseAddrPort("198.51.100.1:443")
+	src6 = netip.MustParseAddrPort("[2001:db8::1]:56324")
+	dst6 = netip.MustParseAddrPort("[2001:db8::2]:443")
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		wantSrc string
+		wantDst string
+		wantErr bool
+	}{
+		// v1
+		{
+			name:    "v1 tcp4",
+			input:   []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"),
+			wantSrc: "192.0.2.1:56324",
+			wantDst: "198.51.100.1:443",
+		},
+		{
+			name:    "v1 tcp6",
+			input:   []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			wantSrc: "[2001:db8::1]:56324",
+			wantDst: "[2001:db8::2]:443",
+		},
+		{name: "v1 unknown", input: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 unknown with addresses", input: []byte("PROXY UNKNOWN ffff:f::1 ffff:f::2 1 2\r\n")},
+		{name: "v1 family mismatch", input: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n"), wantErr: true},
+		{name: "v1 unknown protocol", input: []byte("PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n"), wantErr: true},
+		{name: "v1 missing field", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n"), wantErr: true},
+		{name: "v1 invalid address", input: []byte("PROXY TCP4 192.0.2 198.51.100.1 56324 443\r\n"), wantErr: true},
+		{name: "v1 invalid port", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n"), wantErr: true},
+		{name: "v1 LF only", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n"), wantErr: true},
+		{name: "v1 truncated", input: []byte("PROXY TCP4 192.0.2.1 198.51"), wantErr: true},
+		{
+			name:    "v1 oversized",
+			input:   []byte("PROXY TCP6 " + strings.Repeat("f", v1MaxLength) + "\r\n"),
+			wantErr: true,
+		},
+		// v2
+		{
+			name:    "v2 proxy tcp4",
+			input:   v2Header(2, 0x1, 0x11, v2Addresses(src4, dst4)),
+			wantSrc: "192.0.2.1:56324",
+			wantDst: "198.51.100.1:443",
+		},
+		{
+			name:    "v2 proxy tcp6",
+			input:   v2Header(2, 0x1, 0x21, v2Addresses(src6, dst6)),
+			wantSrc: "[2001:db8::1]:56324",
+			wantDst: "[2001:db8::2]:443",
+		},
+		{
+			name:    "v2 proxy ipv4 mapped",
+			input:   v2Header(2, 0x1, 0x21, v2Addresses(netip.MustParseAddrPort("[::ffff:192.0.2.1]:56324"), dst6)),
+			wantSrc: "192.0.2.1:56324",
+			wantDst: "[2001:db8::2]:443",
+		},
+		{
+			name:    "v2 proxy with tlvs",
+			input:   v2Header(2, 0x1, 0x11, concat(v2Addresses(src4, dst4), tlv(0x02, "example.com"), tlv(0x05, "unique-id"))),
+			wantSrc: "192.0.2.1:56324",
+			wantDst: "198.51.100.1:443",
+		},
+		{name: "v2 local", input: v2Header(2, 0x0, 0x00, nil)},
+		{name: "v2 local with tlvs", input: v2Header(2, 0x0, 0x11, concat(v2Addresses(src4, dst4), tlv(0x04, "noop")))},
+		{name: "v2 unspec", input: v2Header(2, 0x1, 0x00, nil)},
+		{name: "v2 unix", input: v2Header(2, 0x1, 0x31, make([]byte, 216))},
+		{name: "v2 version 1", input: v2Header(1, 0x1, 0x11, v2Addresses(src4, dst4)), wantErr: true},
+		{name: "v2 unknown command", input: v2Header(2, 0x2, 0x11, v2Addresses(src4, dst4)), wantErr: true},
+		{name: "v2 short address block", input: v2Header(2, 0x1, 0x21, v2Addresses(src4, dst4)), wantErr: true},
+		{name: "v2 truncated header", input: v2Header(2, 0x1, 0x11, nil)[:14], wantErr: true},
+		{name: "v2 truncated addresses", input: v2Header(2, 0x1, 0x11, v2Addresses(src4, dst4))[:20], wantErr: true},
+		{
+			// The length announces more than the peer sends
+			name:    "v2 oversized",
+			input:   concat(v2Header(2, 0x1, 0x11, nil)[:14], []byte{0xFF, 0xFF}, v2Addresses(src4, dst4)),
+			wantErr: true,
+		},
+		// No header
+		{name: "empty", input: nil, wantErr: true},
+		{name: "http", input: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), wantErr: true},
+		{name: "tls", input: []byte{0x16, 0x03, 0x01, 0x02, 0x00, 0x01, 0x00, 0x01, 0xfc, 0x03, 0x03, 0x00}, wantErr: true},
+		{name: "partial v2 signature", input: v2Signature[:8], wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Data following the header must be left to the application
+			const data = "payload"
+			r := bufio.NewReader(bytes.NewReader(concat(tt.input, []byte(data))))
+			if tt.wantErr {
+				r = bufio.NewReader(bytes.NewReader(tt.input))
+			}
+
+			src, dst, err := parseHeader(r)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidHeader) {
+					t.Fatalf("parseHeader() error = %v, want ErrInvalidHeader", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHeader() error = %v", err)
+			}
+
+			if got := addrString(src); got != tt.wantSrc {
+				t.Errorf("source = %q, want %q", got, tt.wantSrc)
+			}
+			if got := addrString(dst); got != tt.wantDst {
+				t.Errorf("destination = %q, want %q", got, tt.wantDst)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != data {
+				t.Errorf("data after the header = %q, want %q", rest, data)
+			}
+		})
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// listen returns a listener on the loopback trusting the given networks,
+// and a function dialing it
+func listen(t *testing.T, trusted ...string) (*Listener, func() net.Conn) {
+	t.Helper()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	allowed, err := netlist.Parse(trusted)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	l := NewListener(inner, allowed)
+	t.Cleanup(func() { l.Close() })
+
+	return l, func() net.Conn {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+}
+
+// accept accepts a connection, failing the test after a few seconds
+func accept(t *testing.T, l *Listener) net.Conn {
+	t.Helper()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := l.Accept()
+		done <- result{conn, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("Accept() error = %v", r.err)
+		}
+		t.Cleanup(func() { r.conn.Close() })
+		return r.conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept() blocked")
+		return nil
+	}
+}
+
+func TestListener(t *testing.T) {
+	header := "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+
+	tests := []struct {
+		name       string
+		trusted    []string
+		send       string
+		wantRemote string
+		wantLocal  string
+		wantData   string
+		wantErr    bool
+	}{
+		{
+			name:       "trusted peer",
+			trusted:    []string{"127.0.0.0/8"},
+			send:       header + "hello",
+			wantRemote: "192.0.2.1:56324",
+			wantLocal:  "198.51.100.1:443",
+			wantData:   "hello",
+		},
+		{
+			name:       "trusted peer with v2",
+			trusted:    []string{"127.0.0.1"},
+			send:       string(v2Header(2, 0x1, 0x21, concat(v2Addresses(src6, dst6), tlv(0x02, "example.com")))) + "hello",
+			wantRemote: "[2001:db8::1]:56324",
+			wantLocal:  "[2001:db8::2]:443",
+			wantData:   "hello",
+		},
+		{
+			// Health checks keep the address of the proxy
+			name:     "trusted peer with v2 local",
+			trusted:  []string{"127.0.0.1"},
+			send:     string(v2Header(2, 0x0, 0x00, nil)) + "hello",
+			wantData: "hello",
+		},
+		{
+			name:    "trusted peer without header",
+			trusted: []string{"127.0.0.0/8"},
+			send:    "GET / HTTP/1.1\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			// Untrusted peers cannot spoof their address
+			name:     "untrusted peer",
+			trusted:  []string{"192.0.2.0/24"},
+			send:     header + "hello",
+			wantData: header + "hello",
+		},
+		{
+			name:     "untrusted peer without header",
+			send:     "GET / HTTP/1.1\r\n\r\n",
+			wantData: "GET / HTTP/1.1\r\n\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, dial := listen(t, tt.trusted...)
+			client := dial()
+			conn := accept(t, l)
+
+			if _, err := io.WriteString(client, tt.send); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			client.(*net.TCPConn).CloseWrite()
+
+			data, err := io.ReadAll(conn)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidHeader) {
+					t.Fatalf("Read() error = %v, want ErrInvalidHeader", err)
+				}
+				// The connection is closed, the client sees it
+				if _, err := client.Read(make([]byte, 1)); err == nil {
+					t.Error("connection left open")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if string(data) != tt.wantData {
+				t.Errorf("data = %q, want %q", data, tt.wantData)
+			}
+
+			wantRemote, wantLocal := tt.wantRemote, tt.wantLocal
+			if wantRemote == "" {
+				wantRemote = client.LocalAddr().String()
+			}
+			if wantLocal == "" {
+				wantLocal = client.RemoteAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != wantRemote {
+				t.Errorf("RemoteAddr() = %s, want %s", got, wantRemote)
+			}
+			if got := conn.LocalAddr().String(); got != wantLocal {
+				t.Errorf("LocalAddr() = %s, want %s", got, wantLocal)
+			}
+		})
+	}
+}
+
+func TestListenerUntrustedUnwrapped(t *testing.T) {
+	l, dial := listen(t, "192.0.2.0/24")
+	dial()
+
+	if _, ok := accept(t, l).(*Conn); ok {
+		t.Error("untrusted connection wrapped")
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	l, dial := listen(t, "127.0.0.0/8")
+	l.timeout = 50 * time.Millisecond
+
+	// A trusted peer that never sends its header is dropped
+	dial()
+	conn := accept(t, l)
+
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("Read() error = %v, want ErrInvalidHeader", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("header read took %s, want about %s", elapsed, l.timeout)
+	}
+
+	// The deadline only covers the header
+	client := dial()
+	conn = accept(t, l)
+	if _, err := io.WriteString(client, "PROXY UNKNOWN\r\n"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	conn.RemoteAddr()
+
+	time.Sleep(2 * l.timeout)
+	if _, err := io.WriteString(client, "late"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	data := make([]byte, 4)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatalf("Read() after the header error = %v", err)
+	}
+	if string(data) != "late" {
+		t.Errorf("data = %q, want late", data)
+	}
+}
@@ -1,36 +1,84 @@
 package queue
 
-import "sync"
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
 
-type IPQueue struct {
-	mutex  *sync.RWMutex
-	ipScan map[string]*sync.RWMutex
+// ErrPanicked is returned to the callers waiting on a call that panicked
+var ErrPanicked = errors.New("queue: call panicked")
+
+// IPQueue coalesces concurrent work on the same IP: while a call for an
+// IP is in flight, other calls for it wait and share its result instead
+// of running again. Entries are reference counted and freed as soon as
+// the last caller is done, so the queue only holds IPs being processed.
+type IPQueue[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+type call[T any] struct {
+	done  chan struct{}
+	refs  int
+	value T
+	err   error
 }
 
-func NewIPQueue() *IPQueue {
-	return &IPQueue{
-		mutex:  &sync.RWMutex{},
-		ipScan: make(map[string]*sync.RWMutex),
+func NewIPQueue[T any]() *IPQueue[T] {
+	return &IPQueue[T]{
+		calls: make(map[string]*call[T]),
 	}
 }
 
-func (q *IPQueue) Get(key string) *sync.RWMutex {
-	q.mutex.Lock()
-	defer q.mutex.Unlock()
+// Do runs fn for key and returns its result. If a call for key is already
+// in flight, Do waits for it and returns its result instead; shared is
+// then true. If fn panics, the panic goes on in the caller that ran it
+// and the waiting callers get an error wrapping ErrPanicked.
+func (q *IPQueue[T]) Do(key string, fn func() T) (value T, shared bool, err error) {
+	q.mu.Lock()
+	c, inFlight := q.calls[key]
+	if !inFlight {
+		c = &call[T]{done: make(chan struct{})}
+		q.calls[key] = c
+	}
+	c.refs++
+	q.mu.Unlock()
+
+	defer q.release(key, c)
 
-	if value, ok := q.ipScan[key]; ok {
-		return value
+	if inFlight {
+		<-c.done
+		return c.value, true, c.err
 	}
 
-	mutex := &sync.RWMutex{}
-	q.ipScan[key] = mutex
+	// Closing done in a defer releases the waiters even if fn panics
+	defer close(c.done)
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = fmt.Errorf("%w: %v", ErrPanicked, r)
+			panic(r)
+		}
+	}()
+	c.value = fn()
 
-	return mutex
+	return c.value, false, nil
 }
 
-func (q *IPQueue) Remove(key string) {
-	q.mutex.Lock()
-	defer q.mutex.Unlock()
+// Len returns the number of IPs being processed
+func (q *IPQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 
-	delete(q.ipScan, key)
+	return len(q.calls)
+}
+
+func (q *IPQueue[T]) release(key string, c *call[T]) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c.refs--
+	if c.refs == 0 && q.calls[key] == c {
+		delete(q.calls, key)
+	}
 }
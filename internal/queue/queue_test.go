@@ -0,0 +1,190 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitLen polls until the queue holds n IPs
+func waitLen[T any](t *testing.T, q *IPQueue[T], n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %d, want %d", q.Len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDoRunsOnce(t *testing.T) {
+	q := NewIPQueue[int]()
+
+	value, shared, err := q.Do("192.0.2.1", func() int { return 42 })
+	if value != 42 || shared || err != nil {
+		t.Errorf("Do() = %d, %v, %v, want 42, false, nil", value, shared, err)
+	}
+	if q.Len() != 0 {
+		t.Errorf("Len() = %d after the call, want 0", q.Len())
+	}
+}
+
+func TestDoSharesInFlightCall(t *testing.T) {
+	q := NewIPQueue[int]()
+	release := make(chan struct{})
+	var runs atomic.Int32
+
+	fn := func() int {
+		runs.Add(1)
+		<-release
+		return 42
+	}
+
+	type result struct {
+		value  int
+		shared bool
+		err    error
+	}
+	const callers = 10
+	results := make(chan result, callers)
+
+	go func() {
+		value, shared, err := q.Do("192.0.2.1", fn)
+		results <- result{value, shared, err}
+	}()
+	waitLen(t, q, 1)
+
+	var wg sync.WaitGroup
+	for range callers - 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, shared, err := q.Do("192.0.2.1", fn)
+			results <- result{value, shared, err}
+		}()
+	}
+
+	// Let the waiters reach Do before the call completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	sharedCount := 0
+	for range callers {
+		r := <-results
+		if r.value != 42 || r.err != nil {
+			t.Errorf("Do() = %d, %v, want 42, nil", r.value, r.err)
+		}
+		if r.shared {
+			sharedCount++
+		}
+	}
+
+	if runs.Load() != 1 {
+		t.Errorf("fn ran %d times, want 1", runs.Load())
+	}
+	if sharedCount != callers-1 {
+		t.Errorf("%d shared results, want %d", sharedCount, callers-1)
+	}
+	waitLen(t, q, 0)
+}
+
+func TestDoKeysAreIndependent(t *testing.T) {
+	q := NewIPQueue[string]()
+	release := make(chan struct{})
+	done := make(chan string)
+
+	go func() {
+		value, _, _ := q.Do("192.0.2.1", func() string {
+			<-release
+			return "first"
+		})
+		done <- value
+	}()
+	waitLen(t, q, 1)
+
+	// Another IP does not wait for the call in flight
+	value, shared, err := q.Do("192.0.2.2", func() string { return "second" })
+	if value != "second" || shared || err != nil {
+		t.Errorf("Do() = %q, %v, %v, want second, false, nil", value, shared, err)
+	}
+
+	close(release)
+	if value := <-done; value != "first" {
+		t.Errorf("Do() = %q, want first", value)
+	}
+	waitLen(t, q, 0)
+}
+
+func TestDoPanicReachesWaiters(t *testing.T) {
+	q := NewIPQueue[*int]()
+	release := make(chan struct{})
+
+	leader := make(chan any)
+	go func() {
+		defer func() { leader <- recover() }()
+		q.Do("192.0.2.1", func() *int {
+			<-release
+			panic("boom")
+		})
+	}()
+	waitLen(t, q, 1)
+
+	const waiters = 5
+	errs := make(chan error, waiters)
+	for range waiters {
+		go func() {
+			value, shared, err := q.Do("192.0.2.1", func() *int {
+				t.Error("waiter ran fn")
+				return nil
+			})
+			if value != nil || !shared {
+				t.Errorf("Do() = %v, %v, want nil, true", value, shared)
+			}
+			errs <- err
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if r := <-leader; r != "boom" {
+		t.Errorf("leader recovered %v, want the panic", r)
+	}
+	for range waiters {
+		if err := <-errs; !errors.Is(err, ErrPanicked) {
+			t.Errorf("Do() error = %v, want ErrPanicked", err)
+		}
+	}
+	waitLen(t, q, 0)
+
+	// The next call runs again instead of reusing the failure
+	value := 42
+	got, shared, err := q.Do("192.0.2.1", func() *int { return &value })
+	if got != &value || shared || err != nil {
+		t.Errorf("Do() after a panic = %v, %v, %v", got, shared, err)
+	}
+}
+
+func TestDoWaiterArrivingAfterCompletion(t *testing.T) {
+	q := NewIPQueue[int]()
+	runs := 0
+
+	for i := range 3 {
+		value, shared, err := q.Do("192.0.2.1", func() int {
+			runs++
+			return i
+		})
+		if value != i || shared || err != nil {
+			t.Errorf("Do() = %d, %v, %v, want %d, false, nil", value, shared, err, i)
+		}
+	}
+
+	if runs != 3 {
+		t.Errorf("fn ran %d times, want one per sequential call", runs)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/clientip"
 )
 
 const (
@@ -21,6 +23,7 @@ type IPRateLimiter struct {
 	visitors map[string]*visitor
 	rate     int
 	window   time.Duration
+	clientIP *clientip.Extractor
 }
 
 type visitor struct {
@@ -35,6 +38,7 @@ func NewIPRateLimiter(rate int, window time.Duration) *IPRateLimiter {
 		visitors: make(map[string]*visitor),
 		rate:     rate,
 		window:   window,
+		clientIP: clientip.New(nil, ""),
 	}
 
 	go limiter.cleanupLoop()
@@ -47,6 +51,12 @@ func NewDefaultIPRateLimiter() *IPRateLimiter {
 	return NewIPRateLimiter(DefaultRate, DefaultWindow)
 }
 
+// SetExtractor sets how the middleware finds the client address. By
+// default forwarding headers are ignored.
+func (rl *IPRateLimiter) SetExtractor(extractor *clientip.Extractor) {
+	rl.clientIP = extractor
+}
+
 // Allow checks if a request is allowed for a given IP
 func (rl *IPRateLimiter) Allow(ip string) bool {
 	rl.mu.Lock()
@@ -108,7 +118,7 @@ func (rl *IPRateLimiter) cleanup() {
 // Middleware creates an HTTP middleware for rate limiting
 func (rl *IPRateLimiter) Middleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ip := extractIP(r)
+		ip := rl.clientIP.FromRequest(r)
 
 		if !rl.Allow(ip) {
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
@@ -119,24 +129,6 @@ func (rl *IPRateLimiter) Middleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func extractIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		for idx := 0; idx < len(xff); idx++ {
-			if xff[idx] == ',' {
-				return xff[:idx]
-			}
-		}
-		return xff
-	}
-
-	for idx := 0; idx < len(r.RemoteAddr); idx++ {
-		if r.RemoteAddr[idx] == ':' {
-			return r.RemoteAddr[:idx]
-		}
-	}
-	return r.RemoteAddr
-}
-
 // Stats contains statistics about the rate limiter
 type Stats struct {
 	TotalIPs       int
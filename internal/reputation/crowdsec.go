@@ -0,0 +1,97 @@
+package reputation
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// CrowdSecAPIURL is the base URL of the CrowdSec CTI smoke API
+const CrowdSecAPIURL = "https://cti.api.crowdsec.net/v2/smoke/"
+
+func init() {
+	Register("crowdsec", func(cfg *config.ProviderConfig) (Provider, error) {
+		if cfg.APIKey == "" {
+			return nil, errEmptyAPIKey("crowdsec")
+		}
+		return NewCrowdSec(cfg), nil
+	})
+}
+
+// CrowdSec checks IPs against the CrowdSec CTI API, built from the signals
+// of the CrowdSec community
+type CrowdSec struct {
+	name       string
+	apiKey     string
+	url        string
+	httpClient *http.Client
+}
+
+type crowdSecResponse struct {
+	Reputation string `json:"reputation"`
+	Scores     struct {
+		Overall struct {
+			Total int `json:"total"`
+		} `json:"overall"`
+	} `json:"scores"`
+	Location struct {
+		Country string `json:"country"`
+	} `json:"location"`
+	Behaviors []struct {
+		Label string `json:"label"`
+	} `json:"behaviors"`
+}
+
+// NewCrowdSec creates a CrowdSec CTI provider
+func NewCrowdSec(cfg *config.ProviderConfig) *CrowdSec {
+	url := cfg.URL
+	if url == "" {
+		url = CrowdSecAPIURL
+	}
+
+	return &CrowdSec{
+		name:       cfg.Name,
+		apiKey:     cfg.APIKey,
+		url:        strings.TrimSuffix(url, "/") + "/",
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+func (c *CrowdSec) Name() string {
+	return c.name
+}
+
+func (c *CrowdSec) Check(ip string) (domain.ReputationResult, error) {
+	header := http.Header{}
+	header.Set("x-api-key", c.apiKey)
+
+	var resp crowdSecResponse
+	found, err := getJSON(c.httpClient, "crowdsec", c.url+ip, header, &resp)
+	if err != nil {
+		return domain.ReputationResult{}, err
+	}
+	if !found {
+		return domain.ReputationResult{Details: "unknown"}, nil
+	}
+
+	// The overall score goes from 0 to 5
+	score := min(max(resp.Scores.Overall.Total, 0), 5) * 20
+
+	labels := make([]string, 0, len(resp.Behaviors))
+	for _, behavior := range resp.Behaviors {
+		labels = append(labels, behavior.Label)
+	}
+	details := resp.Reputation
+	if len(labels) > 0 {
+		details += ": " + strings.Join(labels, ", ")
+	}
+
+	return domain.ReputationResult{
+		Score:     domain.IPScore(score),
+		Malicious: resp.Reputation == "malicious",
+		Country:   resp.Location.Country,
+		Details:   details,
+	}, nil
+}
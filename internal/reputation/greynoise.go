@@ -0,0 +1,90 @@
+package reputation
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+const (
+	// GreyNoiseAPIURL is the base URL of the GreyNoise community API
+	GreyNoiseAPIURL = "https://api.greynoise.io/v3/community/"
+	// GreyNoiseScannerScore is the score of an unclassified internet scanner
+	GreyNoiseScannerScore domain.IPScore = 50
+)
+
+func init() {
+	Register("greynoise", func(cfg *config.ProviderConfig) (Provider, error) {
+		return NewGreyNoise(cfg), nil
+	})
+}
+
+// GreyNoise checks IPs against the GreyNoise community API, which tells
+// mass scanners apart from benign services. The API key is optional.
+type GreyNoise struct {
+	name       string
+	apiKey     string
+	url        string
+	httpClient *http.Client
+}
+
+type greyNoiseResponse struct {
+	Noise          bool   `json:"noise"`
+	RIOT           bool   `json:"riot"`
+	Classification string `json:"classification"`
+	Name           string `json:"name"`
+}
+
+// NewGreyNoise creates a GreyNoise provider
+func NewGreyNoise(cfg *config.ProviderConfig) *GreyNoise {
+	url := cfg.URL
+	if url == "" {
+		url = GreyNoiseAPIURL
+	}
+
+	return &GreyNoise{
+		name:       cfg.Name,
+		apiKey:     cfg.APIKey,
+		url:        strings.TrimSuffix(url, "/") + "/",
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+func (g *GreyNoise) Name() string {
+	return g.name
+}
+
+func (g *GreyNoise) Check(ip string) (domain.ReputationResult, error) {
+	header := http.Header{}
+	if g.apiKey != "" {
+		header.Set("key", g.apiKey)
+	}
+
+	var resp greyNoiseResponse
+	found, err := getJSON(g.httpClient, "greynoise", g.url+ip, header, &resp)
+	if err != nil {
+		return domain.ReputationResult{}, err
+	}
+	if !found {
+		return domain.ReputationResult{Details: "not observed"}, nil
+	}
+
+	result := domain.ReputationResult{Details: resp.Classification}
+	switch {
+	case resp.Classification == "malicious":
+		result.Score = domain.ScoreHigh
+		result.Malicious = true
+	case resp.RIOT || resp.Classification == "benign":
+		result.Details = "benign"
+	case resp.Noise:
+		result.Score = GreyNoiseScannerScore
+		result.Details = "scanner"
+	}
+	if resp.Name != "" && resp.Name != "unknown" {
+		result.Details += " (" + resp.Name + ")"
+	}
+
+	return result, nil
+}
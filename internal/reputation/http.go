@@ -0,0 +1,53 @@
+package reputation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// MaxResponseSize bounds the size of a reputation API response
+const MaxResponseSize = 1 << 20
+
+// getJSON fetches url and decodes its JSON body into out. It reports false
+// without error when the API does not know the IP (HTTP 404).
+func getJSON(client *http.Client, kind, url string, header http.Header, out any) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("%s: failed to create request: %w", kind, err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%s: API request failed: %w", kind, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s: API returned status %d", kind, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxResponseSize))
+	if err != nil {
+		return false, fmt.Errorf("%s: failed to read response: %w", kind, err)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return false, fmt.Errorf("%s: failed to parse response: %w", kind, err)
+	}
+
+	return true, nil
+}
+
+// errEmptyAPIKey is returned by the factories of providers requiring a key
+func errEmptyAPIKey(kind string) error {
+	return fmt.Errorf("%s: API key is empty", kind)
+}
@@ -0,0 +1,97 @@
+package reputation
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+const (
+	// OTXAPIURL is the base URL of the AlienVault OTX indicators API
+	OTXAPIURL = "https://otx.alienvault.com/api/v1/indicators/"
+	// OTXPulseScore is the score added by each OTX pulse listing an IP
+	OTXPulseScore = 20
+)
+
+func init() {
+	Register("otx", func(cfg *config.ProviderConfig) (Provider, error) {
+		if cfg.APIKey == "" {
+			return nil, errEmptyAPIKey("otx")
+		}
+		return NewOTX(cfg), nil
+	})
+}
+
+// OTX checks IPs against AlienVault OTX: the more threat pulses list an
+// IP, the higher its score
+type OTX struct {
+	name       string
+	apiKey     string
+	url        string
+	threshold  domain.IPScore
+	httpClient *http.Client
+}
+
+type otxResponse struct {
+	CountryCode string `json:"country_code"`
+	PulseInfo   struct {
+		Count int `json:"count"`
+	} `json:"pulse_info"`
+}
+
+// NewOTX creates an AlienVault OTX provider
+func NewOTX(cfg *config.ProviderConfig) *OTX {
+	url := cfg.URL
+	if url == "" {
+		url = OTXAPIURL
+	}
+
+	return &OTX{
+		name:       cfg.Name,
+		apiKey:     cfg.APIKey,
+		url:        strings.TrimSuffix(url, "/") + "/",
+		threshold:  domain.IPScore(cfg.MaliciousThreshold()),
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+func (o *OTX) Name() string {
+	return o.name
+}
+
+func (o *OTX) Check(ip string) (domain.ReputationResult, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return domain.ReputationResult{}, fmt.Errorf("otx: %w", err)
+	}
+	section := "IPv4"
+	if addr.Is6() && !addr.Is4In6() {
+		section = "IPv6"
+	}
+
+	header := http.Header{}
+	header.Set("X-OTX-API-KEY", o.apiKey)
+
+	var resp otxResponse
+	found, err := getJSON(o.httpClient, "otx", o.url+section+"/"+ip+"/general", header, &resp)
+	if err != nil {
+		return domain.ReputationResult{}, err
+	}
+	if !found {
+		return domain.ReputationResult{Details: "unknown"}, nil
+	}
+
+	pulses := resp.PulseInfo.Count
+	score := domain.IPScore(min(pulses*OTXPulseScore, int(domain.ScoreHigh)))
+
+	return domain.ReputationResult{
+		Score:     score,
+		Malicious: score > o.threshold,
+		Country:   resp.CountryCode,
+		Details:   fmt.Sprintf("%d pulse(s)", pulses),
+	}, nil
+}
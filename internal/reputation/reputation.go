@@ -0,0 +1,321 @@
+package reputation
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+var (
+	// ErrUnknownProvider is returned when no factory is registered for a provider type
+	ErrUnknownProvider = errors.New("reputation: unknown provider type")
+	// ErrNoProvider is returned when no provider could be initialized
+	ErrNoProvider = errors.New("reputation: no provider available")
+	// ErrAllFailed is returned when every provider failed to check an IP,
+	// or every remote one while the local ones did not flag it
+	ErrAllFailed = errors.New("reputation: every provider failed")
+	// ErrRateLimited is wrapped by providers whose API quota is exhausted.
+	// These failures do not count against the circuit breaker.
+	ErrRateLimited = errors.New("reputation: provider rate limited")
+	// ErrCircuitOpen is returned for providers skipped by their circuit breaker
+	ErrCircuitOpen = errors.New("reputation: circuit breaker open")
+)
+
+// Provider is implemented by every source of IP reputation
+type Provider interface {
+	// Name returns the configured name of the provider
+	Name() string
+	// Check returns the verdict of the provider on an IP. The Provider
+	// field of the result is filled in by the aggregator.
+	Check(ip string) (domain.ReputationResult, error)
+}
+
+// LocalProvider is implemented by providers answering from local data,
+// such as blocklists. They are queried first: an IP they flag as
+// malicious is not looked up by the remote providers, sparing their quota.
+type LocalProvider interface {
+	Local() bool
+}
+
+// Quota is the quota of an API key. Limit and Remaining are reported by
+// the API, Used and Budget are counted locally for the current UTC day.
+type Quota struct {
+	Key       string    `json:"key"`
+	Limit     int       `json:"limit,omitempty"`
+	Remaining *int      `json:"remaining,omitempty"`
+	ResetAt   time.Time `json:"reset_at,omitzero"`
+	// RetryAt is set while the key backs off after a rate limit
+	RetryAt time.Time `json:"retry_at,omitzero"`
+	// DisabledUntil is set while the key is skipped after being rejected
+	DisabledUntil time.Time `json:"disabled_until,omitzero"`
+	Used          int       `json:"used"`
+	Budget        int       `json:"budget,omitempty"`
+	Exhausted     bool      `json:"exhausted"`
+}
+
+// QuotaProvider is implemented by providers that track the quota of
+// their API keys
+type QuotaProvider interface {
+	Quotas() []Quota
+}
+
+// UsageStore persists daily usage counters. It is implemented by
+// database.Store.
+type UsageStore interface {
+	// ConsumeUsage increments the counter of name for the UTC day of t,
+	// unless it already reached limit. It reports whether it did.
+	ConsumeUsage(name string, t time.Time, limit int) (bool, error)
+	// GetUsage returns the counter of name for the UTC day of t
+	GetUsage(name string, t time.Time) (int, error)
+}
+
+// UsageTracker is implemented by providers that count their API usage
+type UsageTracker interface {
+	SetUsageStore(store UsageStore)
+}
+
+// ProviderStats describes the health of a provider
+type ProviderStats struct {
+	Name      string    `json:"name"`
+	Breaker   string    `json:"breaker"`
+	Failures  int       `json:"consecutive_failures"`
+	OpenUntil time.Time `json:"open_until,omitzero"`
+	Quotas    []Quota   `json:"quotas,omitempty"`
+}
+
+// Factory builds a Provider from its configuration
+type Factory func(cfg *config.ProviderConfig) (Provider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a provider available under the given type name.
+// It panics if the name is empty or already registered.
+func Register(kind string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if kind == "" || factory == nil {
+		panic("reputation: Register called with empty kind or nil factory")
+	}
+	if _, exists := factories[kind]; exists {
+		panic("reputation: Register called twice for provider " + kind)
+	}
+
+	factories[kind] = factory
+}
+
+// Providers returns the sorted list of registered provider types
+func Providers() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	kinds := make([]string, 0, len(factories))
+	for kind := range factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	return kinds
+}
+
+// New builds a single provider from its configuration
+func New(cfg *config.ProviderConfig) (Provider, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[cfg.Type]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, cfg.Type)
+	}
+
+	return factory(cfg)
+}
+
+// Aggregator queries every provider and combines their scores
+type Aggregator struct {
+	mode      string
+	providers []Provider
+	weights   []float64
+	breakers  []*breaker
+	local     []bool
+}
+
+// NewAggregator builds the configured providers. Providers that fail to
+// initialize are logged and skipped, as long as one of them is left.
+func NewAggregator(cfg *config.ReputationConfig) (*Aggregator, error) {
+	a := &Aggregator{mode: cfg.Mode}
+
+	for i := range cfg.Providers {
+		provider, err := New(&cfg.Providers[i])
+		if err != nil {
+			log.Printf("Failed to initialize %s reputation provider: %v", cfg.Providers[i].Name, err)
+			continue
+		}
+		a.providers = append(a.providers, provider)
+		a.weights = append(a.weights, cfg.Providers[i].Weight)
+		a.breakers = append(a.breakers, newBreaker(cfg.Providers[i].FailureThreshold, cfg.Providers[i].BreakerCooldown))
+		lp, ok := provider.(LocalProvider)
+		a.local = append(a.local, ok && lp.Local())
+	}
+
+	if len(a.providers) == 0 {
+		return nil, ErrNoProvider
+	}
+
+	return a, nil
+}
+
+// Len returns the number of providers
+func (a *Aggregator) Len() int {
+	return len(a.providers)
+}
+
+// SetUsageStore persists the usage counters of the providers that keep
+// some, so that their daily budgets survive restarts
+func (a *Aggregator) SetUsageStore(store UsageStore) {
+	for _, provider := range a.providers {
+		if tracker, ok := provider.(UsageTracker); ok {
+			tracker.SetUsageStore(store)
+		}
+	}
+}
+
+// Stats returns the circuit breaker state and quota of each provider
+func (a *Aggregator) Stats() []ProviderStats {
+	now := time.Now()
+	stats := make([]ProviderStats, len(a.providers))
+	for i, provider := range a.providers {
+		state, failures, openUntil := a.breakers[i].state(now)
+		stats[i] = ProviderStats{
+			Name:      provider.Name(),
+			Breaker:   state,
+			Failures:  failures,
+			OpenUntil: openUntil,
+		}
+		if qp, ok := provider.(QuotaProvider); ok {
+			stats[i].Quotas = qp.Quotas()
+		}
+	}
+	return stats
+}
+
+// Check queries the providers in parallel and combines their scores. The
+// results of every provider, including failures, are returned in the
+// configured order. The country is the first one reported. Providers
+// whose circuit breaker is open are skipped, and so are the remote ones
+// when a local provider flags the IP. If every provider failed, or every
+// remote one and no local one flagged the IP, Check returns ErrAllFailed
+// along with the results: a clean local answer alone must not pass for
+// a verdict while the remote providers are rate limited or down.
+func (a *Aggregator) Check(ip string) (domain.IPScore, string, []domain.ReputationResult, error) {
+	results := make([]domain.ReputationResult, len(a.providers))
+	queried := make([]bool, len(a.providers))
+
+	a.query(ip, results, queried, true)
+	if !slices.ContainsFunc(results, func(r domain.ReputationResult) bool { return r.Malicious }) {
+		a.query(ip, results, queried, false)
+	}
+
+	// Leave out the remote providers skipped thanks to a local verdict
+	checked := make([]domain.ReputationResult, 0, len(results))
+	weights := make([]float64, 0, len(results))
+	remote := make([]bool, 0, len(results))
+	for i, result := range results {
+		if queried[i] {
+			checked = append(checked, result)
+			weights = append(weights, a.weights[i])
+			remote = append(remote, !a.local[i])
+		}
+	}
+	results = checked
+
+	var (
+		score, weightedSum, totalWeight float64
+		country                         string
+		malicious, ok                   bool
+		remoteQueried, remoteOK         bool
+	)
+	for i, result := range results {
+		remoteQueried = remoteQueried || remote[i]
+		if result.Error != "" {
+			log.Printf("Reputation provider %s failed for IP %s: %s", result.Provider, ip, result.Error)
+			continue
+		}
+		ok = true
+		remoteOK = remoteOK || remote[i]
+
+		score = max(score, float64(result.Score))
+		weightedSum += weights[i] * float64(result.Score)
+		totalWeight += weights[i]
+		malicious = malicious || result.Malicious
+		if country == "" {
+			country = result.Country
+		}
+	}
+
+	if !ok || (remoteQueried && !remoteOK) {
+		return 0, "", results, ErrAllFailed
+	}
+
+	switch a.mode {
+	case config.ReputationWeighted:
+		score = weightedSum / totalWeight
+	case config.ReputationAnyOf:
+		if malicious {
+			score = float64(domain.ScoreHigh)
+		}
+	}
+
+	return domain.IPScore(score + 0.5), country, results, nil
+}
+
+// query runs the local or the remote providers in parallel
+func (a *Aggregator) query(ip string, results []domain.ReputationResult, queried []bool, local bool) {
+	var wg sync.WaitGroup
+	for i, provider := range a.providers {
+		if a.local[i] != local {
+			continue
+		}
+		queried[i] = true
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result, err := a.check(i, ip)
+			if err != nil {
+				result = domain.ReputationResult{Error: err.Error()}
+			}
+			result.Provider = provider.Name()
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+}
+
+// check queries a provider through its circuit breaker
+func (a *Aggregator) check(i int, ip string) (domain.ReputationResult, error) {
+	b := a.breakers[i]
+	if !b.allow(time.Now()) {
+		return domain.ReputationResult{}, ErrCircuitOpen
+	}
+
+	result, err := a.providers[i].Check(ip)
+	if errors.Is(err, ErrRateLimited) {
+		b.cancel()
+	} else {
+		b.record(err == nil, time.Now())
+	}
+
+	return result, err
+}
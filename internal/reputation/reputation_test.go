@@ -0,0 +1,115 @@
+package reputation
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/domain"
+)
+
+// fakeProvider answers every check with the same result or error
+type fakeProvider struct {
+	name   string
+	local  bool
+	result domain.ReputationResult
+	err    error
+	checks int
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+func (p *fakeProvider) Local() bool  { return p.local }
+
+func (p *fakeProvider) Check(string) (domain.ReputationResult, error) {
+	p.checks++
+	return p.result, p.err
+}
+
+func newTestAggregator(mode string, providers ...*fakeProvider) *Aggregator {
+	a := &Aggregator{mode: mode}
+	for _, p := range providers {
+		a.providers = append(a.providers, p)
+		a.weights = append(a.weights, 1)
+		a.breakers = append(a.breakers, newBreaker(-1, time.Minute))
+		a.local = append(a.local, p.local)
+	}
+	return a
+}
+
+func TestCheckFailures(t *testing.T) {
+	rateLimited := fmt.Errorf("quota exhausted: %w", ErrRateLimited)
+	clean := domain.ReputationResult{Score: 0, Country: "FR"}
+	listed := domain.ReputationResult{Score: 100, Malicious: true}
+
+	tests := []struct {
+		name      string
+		providers []*fakeProvider
+		wantScore domain.IPScore
+		wantErr   error
+	}{
+		{
+			name:      "remote provider answers",
+			providers: []*fakeProvider{{name: "blocklist", local: true, result: clean}, {name: "abuseipdb", result: domain.ReputationResult{Score: 40}}},
+			wantScore: 40,
+		},
+		{
+			name:      "remote provider rate limited",
+			providers: []*fakeProvider{{name: "blocklist", local: true, result: clean}, {name: "abuseipdb", err: rateLimited}},
+			wantErr:   ErrAllFailed,
+		},
+		{
+			name:      "one remote provider left",
+			providers: []*fakeProvider{{name: "blocklist", local: true, result: clean}, {name: "abuseipdb", err: rateLimited}, {name: "greynoise", result: domain.ReputationResult{Score: 50}}},
+			wantScore: 50,
+		},
+		{
+			name:      "local provider flags the IP",
+			providers: []*fakeProvider{{name: "blocklist", local: true, result: listed}, {name: "abuseipdb", err: rateLimited}},
+			wantScore: 100,
+		},
+		{
+			name:      "local providers only",
+			providers: []*fakeProvider{{name: "blocklist", local: true, result: clean}},
+			wantScore: 0,
+		},
+		{
+			name:      "every provider failed",
+			providers: []*fakeProvider{{name: "blocklist", local: true, err: errors.New("unreadable")}, {name: "abuseipdb", err: rateLimited}},
+			wantErr:   ErrAllFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAggregator(config.ReputationMax, tt.providers...)
+
+			score, _, results, err := a.Check("192.0.2.1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Check() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && score != tt.wantScore {
+				t.Errorf("Check() score = %d, want %d", score, tt.wantScore)
+			}
+			if len(results) == 0 {
+				t.Error("Check() returned no results")
+			}
+		})
+	}
+}
+
+func TestCheckSkipsRemoteWhenListed(t *testing.T) {
+	remote := &fakeProvider{name: "abuseipdb", result: domain.ReputationResult{Score: 10}}
+	a := newTestAggregator(config.ReputationMax,
+		&fakeProvider{name: "blocklist", local: true, result: domain.ReputationResult{Score: 100, Malicious: true}},
+		remote,
+	)
+
+	if _, _, results, err := a.Check("192.0.2.1"); err != nil || len(results) != 1 {
+		t.Fatalf("Check() = %+v, %v, want the blocklist result only", results, err)
+	}
+	if remote.checks != 0 {
+		t.Errorf("remote provider queried %d time(s) for a listed IP", remote.checks)
+	}
+}
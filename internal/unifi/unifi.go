@@ -6,10 +6,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"slices"
+	"sync"
 
 	"github.com/TOomaAh/GateKeeper/internal/config"
+	"github.com/TOomaAh/GateKeeper/internal/firewall"
 )
 
 const (
@@ -28,13 +32,29 @@ var (
 	ErrFirewallGroupNotFound = errors.New("unifi: firewall group not found")
 )
 
+func init() {
+	firewall.Register("unifi", func(cfg *config.FirewallConfig) (firewall.Blocker, error) {
+		client := NewClient(&cfg.Unifi)
+		if err := client.Login(); err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
 // Client manages interactions with the UniFi Controller API
 type Client struct {
 	httpClient *http.Client
-	cookie     string
 	username   string
 	password   string
 	baseURL    string
+
+	// mu serialises the read-modify-write of the group members, which
+	// the controller replaces as a whole
+	mu sync.Mutex
+
+	sessionMu sync.Mutex
+	cookie    string
 }
 
 // FirewallGroup represents a UniFi firewall group
@@ -62,6 +82,32 @@ func NewClient(cfg *config.UnifiConfig) *Client {
 
 // Login authenticates the client with the UniFi controller
 func (c *Client) Login() error {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	return c.login()
+}
+
+// relogin renews an expired session, unless another request already did
+func (c *Client) relogin(expired string) error {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	if c.cookie != expired {
+		return nil
+	}
+	log.Printf("UniFi session expired, logging in again to %s", c.baseURL)
+	return c.login()
+}
+
+func (c *Client) session() string {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	return c.cookie
+}
+
+func (c *Client) login() error {
 	loginData := map[string]string{
 		"username": c.username,
 		"password": c.password,
@@ -97,24 +143,33 @@ func (c *Client) Login() error {
 	return ErrAuthenticationFailed
 }
 
+// Name returns the controller URL used to identify this backend
+func (c *Client) Name() string {
+	return "unifi " + c.baseURL
+}
+
+// Block adds an IP address to the WAN_IN firewall group
+func (c *Client) Block(ip string) error {
+	return c.AddIPToFirewall(ip)
+}
+
 // AddIPToFirewall adds an IP address to the WAN_IN firewall group
 func (c *Client) AddIPToFirewall(ip string) error {
-	groups, err := c.getFirewallGroups()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wanGroup, err := c.getWANGroup()
 	if err != nil {
 		return err
 	}
 
-	wanGroup := c.findFirewallGroup(groups, FirewallGroupName)
-	if wanGroup == nil {
-		return ErrFirewallGroupNotFound
-	}
-
 	if c.ipExistsInGroup(wanGroup, ip) {
 		log.Printf("IP %s already exists in %s firewall group", ip, FirewallGroupName)
 		return nil
 	}
 
-	if err := c.updateFirewallGroup(wanGroup, ip); err != nil {
+	members := append(slices.Clone(wanGroup.Members), ip)
+	if err := c.updateFirewallGroup(wanGroup, members); err != nil {
 		return err
 	}
 
@@ -122,16 +177,64 @@ func (c *Client) AddIPToFirewall(ip string) error {
 	return nil
 }
 
-func (c *Client) getFirewallGroups() ([]FirewallGroup, error) {
-	url := fmt.Sprintf("%s/proxy/network/api/s/%s/rest/firewallgroup", c.baseURL, DefaultSite)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// Unblock removes an IP address from the WAN_IN firewall group
+func (c *Client) Unblock(ip string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wanGroup, err := c.getWANGroup()
 	if err != nil {
-		return nil, fmt.Errorf("unifi: failed to create request: %w", err)
+		return err
 	}
 
-	req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: c.cookie})
+	if !c.ipExistsInGroup(wanGroup, ip) {
+		return nil
+	}
 
-	resp, err := c.httpClient.Do(req)
+	members := slices.DeleteFunc(slices.Clone(wanGroup.Members), func(member string) bool {
+		return member == ip
+	})
+	if err := c.updateFirewallGroup(wanGroup, members); err != nil {
+		return err
+	}
+
+	log.Printf("Successfully removed IP %s from UniFi %s firewall group", ip, FirewallGroupName)
+	return nil
+}
+
+// List returns the members of the WAN_IN firewall group
+func (c *Client) List() ([]string, error) {
+	wanGroup, err := c.getWANGroup()
+	if err != nil {
+		return nil, err
+	}
+
+	return wanGroup.Members, nil
+}
+
+// Health checks that the controller answers and that the WAN_IN group exists
+func (c *Client) Health() error {
+	_, err := c.getWANGroup()
+	return err
+}
+
+func (c *Client) getWANGroup() (*FirewallGroup, error) {
+	groups, err := c.getFirewallGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	wanGroup := c.findFirewallGroup(groups, FirewallGroupName)
+	if wanGroup == nil {
+		return nil, ErrFirewallGroupNotFound
+	}
+
+	return wanGroup, nil
+}
+
+func (c *Client) getFirewallGroups() ([]FirewallGroup, error) {
+	url := fmt.Sprintf("%s/proxy/network/api/s/%s/rest/firewallgroup", c.baseURL, DefaultSite)
+	resp, err := c.do(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("unifi: failed to get firewall groups: %w", err)
 	}
@@ -170,11 +273,9 @@ func (c *Client) ipExistsInGroup(group *FirewallGroup, ip string) bool {
 	return false
 }
 
-func (c *Client) updateFirewallGroup(group *FirewallGroup, ip string) error {
-	group.Members = append(group.Members, ip)
-
+func (c *Client) updateFirewallGroup(group *FirewallGroup, members []string) error {
 	updateData := map[string]interface{}{
-		"group_members": group.Members,
+		"group_members": members,
 	}
 
 	data, err := json.Marshal(updateData)
@@ -183,15 +284,7 @@ func (c *Client) updateFirewallGroup(group *FirewallGroup, ip string) error {
 	}
 
 	url := fmt.Sprintf("%s/proxy/network/api/s/%s/rest/firewallgroup/%s", c.baseURL, DefaultSite, group.ID)
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
-	if err != nil {
-		return fmt.Errorf("unifi: failed to create update request: %w", err)
-	}
-
-	req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: c.cookie})
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(http.MethodPut, url, data)
 	if err != nil {
 		return fmt.Errorf("unifi: failed to update firewall group: %w", err)
 	}
@@ -201,5 +294,45 @@ func (c *Client) updateFirewallGroup(group *FirewallGroup, ip string) error {
 		return fmt.Errorf("unifi: update failed with status %d", resp.StatusCode)
 	}
 
+	group.Members = members
 	return nil
 }
+
+// do sends an API request with the session cookie. When the session has
+// expired, it logs in again and retries once.
+func (c *Client) do(method, url string, body []byte) (*http.Response, error) {
+	resp, cookie, err := c.send(method, url, body)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	if err := c.relogin(cookie); err != nil {
+		return nil, err
+	}
+
+	resp, _, err = c.send(method, url, body)
+	return resp, err
+}
+
+// send sends an API request and returns the session cookie it used
+func (c *Client) send(method, url string, body []byte) (*http.Response, string, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("unifi: failed to create request: %w", err)
+	}
+
+	cookie := c.session()
+	req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: cookie})
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	return resp, cookie, err
+}
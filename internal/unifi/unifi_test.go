@@ -0,0 +1,290 @@
+package unifi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/TOomaAh/GateKeeper/internal/config"
+)
+
+// fakeController is an in-memory UniFi controller serving the login and
+// firewall group endpoints
+type fakeController struct {
+	mu       sync.Mutex
+	session  string
+	groups   []FirewallGroup
+	logins   int
+	puts     int
+	password string
+}
+
+func newFakeController(t *testing.T) (*fakeController, *httptest.Server) {
+	t.Helper()
+
+	fc := &fakeController{
+		session:  "session-1",
+		password: "secret",
+		groups: []FirewallGroup{
+			{ID: "lan", Name: "LAN", Members: []string{"10.0.0.0/8"}},
+			{ID: "wan", Name: FirewallGroupName, Members: []string{}},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/auth/login", fc.login)
+	mux.HandleFunc("GET /proxy/network/api/s/default/rest/firewallgroup", fc.list)
+	mux.HandleFunc("PUT /proxy/network/api/s/default/rest/firewallgroup/{id}", fc.update)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return fc, srv
+}
+
+func (fc *fakeController) login(w http.ResponseWriter, r *http.Request) {
+	var creds map[string]string
+	json.NewDecoder(r.Body).Decode(&creds)
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if creds["password"] != fc.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	fc.logins++
+	http.SetCookie(w, &http.Cookie{Name: SessionCookieName, Value: fc.session})
+}
+
+func (fc *fakeController) authorized(r *http.Request) bool {
+	cookie, err := r.Cookie(SessionCookieName)
+	return err == nil && cookie.Value == fc.session
+}
+
+func (fc *fakeController) list(w http.ResponseWriter, r *http.Request) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if !fc.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": fc.groups})
+}
+
+func (fc *fakeController) update(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Members []string `json:"group_members"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if !fc.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	for i := range fc.groups {
+		if fc.groups[i].ID == r.PathValue("id") {
+			fc.groups[i].Members = body.Members
+			fc.puts++
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (fc *fakeController) members(name string) []string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	for _, g := range fc.groups {
+		if g.Name == name {
+			return slices.Clone(g.Members)
+		}
+	}
+	return nil
+}
+
+func newTestClient(t *testing.T, url string) *Client {
+	t.Helper()
+
+	c := NewClient(&config.UnifiConfig{URL: url, Username: "admin", Password: "secret"})
+	if err := c.Login(); err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	return c
+}
+
+func TestBlockUnblockList(t *testing.T) {
+	fc, srv := newFakeController(t)
+	c := newTestClient(t, srv.URL)
+
+	if err := c.Health(); err != nil {
+		t.Fatalf("Health() error = %v", err)
+	}
+
+	for range 2 {
+		if err := c.Block("192.0.2.1"); err != nil {
+			t.Fatalf("Block() error = %v", err)
+		}
+	}
+	if err := c.Block("2001:db8::1"); err != nil {
+		t.Fatalf("Block() error = %v", err)
+	}
+
+	want := []string{"192.0.2.1", "2001:db8::1"}
+	if got := fc.members(FirewallGroupName); !slices.Equal(got, want) {
+		t.Errorf("WAN_IN members = %v, want %v", got, want)
+	}
+	if got, err := c.List(); err != nil || !slices.Equal(got, want) {
+		t.Errorf("List() = %v, %v, want %v", got, err, want)
+	}
+	if fc.puts != 2 {
+		t.Errorf("controller received %d updates, want 2: blocking twice must not update", fc.puts)
+	}
+
+	if err := c.Unblock("192.0.2.1"); err != nil {
+		t.Fatalf("Unblock() error = %v", err)
+	}
+	if err := c.Unblock("198.51.100.1"); err != nil {
+		t.Fatalf("Unblock() of an IP that is not blocked error = %v", err)
+	}
+	if got := fc.members(FirewallGroupName); !slices.Equal(got, []string{"2001:db8::1"}) {
+		t.Errorf("WAN_IN members = %v after Unblock", got)
+	}
+	if got := fc.members("LAN"); !slices.Equal(got, []string{"10.0.0.0/8"}) {
+		t.Errorf("LAN members = %v, other groups must be left alone", got)
+	}
+}
+
+func TestLoginFailure(t *testing.T) {
+	_, srv := newFakeController(t)
+
+	c := NewClient(&config.UnifiConfig{URL: srv.URL, Username: "admin", Password: "wrong"})
+	if err := c.Login(); err == nil {
+		t.Fatal("Login() with a wrong password succeeded")
+	}
+}
+
+func TestMissingGroup(t *testing.T) {
+	fc, srv := newFakeController(t)
+	fc.groups = fc.groups[:1]
+	c := newTestClient(t, srv.URL)
+
+	if err := c.Block("192.0.2.1"); !errors.Is(err, ErrFirewallGroupNotFound) {
+		t.Errorf("Block() error = %v, want %v", err, ErrFirewallGroupNotFound)
+	}
+	if err := c.Health(); !errors.Is(err, ErrFirewallGroupNotFound) {
+		t.Errorf("Health() error = %v, want %v", err, ErrFirewallGroupNotFound)
+	}
+}
+
+func TestConcurrentBlocks(t *testing.T) {
+	fc, srv := newFakeController(t)
+	c := newTestClient(t, srv.URL)
+
+	var want []string
+	var wg sync.WaitGroup
+	for i := range 20 {
+		ip := fmt.Sprintf("192.0.2.%d", i+1)
+		want = append(want, ip)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Block(ip); err != nil {
+				t.Errorf("Block(%s) error = %v", ip, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got := fc.members(FirewallGroupName)
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("WAN_IN members = %v, want every blocked IP", got)
+	}
+
+	for _, ip := range want[:10] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Unblock(ip); err != nil {
+				t.Errorf("Unblock(%s) error = %v", ip, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got = fc.members(FirewallGroupName)
+	slices.Sort(got)
+	if !slices.Equal(got, want[10:]) {
+		t.Errorf("WAN_IN members = %v after unblocking, want %v", got, want[10:])
+	}
+}
+
+// expireSession makes the controller reject the current session cookie
+func (fc *fakeController) expireSession(session string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.session = session
+}
+
+func TestReloginOnExpiredSession(t *testing.T) {
+	fc, srv := newFakeController(t)
+	c := newTestClient(t, srv.URL)
+
+	fc.expireSession("session-2")
+	if err := c.Block("192.0.2.1"); err != nil {
+		t.Fatalf("Block() with an expired session error = %v", err)
+	}
+	if got := fc.members(FirewallGroupName); !slices.Equal(got, []string{"192.0.2.1"}) {
+		t.Errorf("WAN_IN members = %v", got)
+	}
+	if fc.logins != 2 {
+		t.Errorf("logins = %d, want 2", fc.logins)
+	}
+
+	// Concurrent requests hitting the expiry log in once
+	fc.expireSession("session-3")
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.List(); err != nil {
+				t.Errorf("List() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fc.logins != 3 {
+		t.Errorf("logins = %d, want 3", fc.logins)
+	}
+}
+
+func TestReloginFailure(t *testing.T) {
+	fc, srv := newFakeController(t)
+	c := newTestClient(t, srv.URL)
+
+	fc.mu.Lock()
+	fc.session = "session-2"
+	fc.password = "rotated"
+	fc.mu.Unlock()
+
+	if err := c.Block("192.0.2.1"); err == nil {
+		t.Fatal("Block() succeeded although the login failed")
+	}
+	if got := fc.members(FirewallGroupName); len(got) != 0 {
+		t.Errorf("WAN_IN members = %v, want none", got)
+	}
+}